@@ -1,18 +1,25 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"syscall"
 
 	"github.com/claude-code-proxy/proxy/internal/config"
 	"github.com/claude-code-proxy/proxy/internal/daemon"
+	"github.com/claude-code-proxy/proxy/internal/logging"
 	"github.com/claude-code-proxy/proxy/internal/server"
 )
 
 func main() {
+	// Environment before config files are loaded into it, for --watch restarts
+	environ := os.Environ()
+
 	// Parse command and flags
 	debug := false
 	simpleLog := false
+	watch := false
 	command := ""
 
 	if len(os.Args) > 1 {
@@ -23,7 +30,9 @@ func main() {
 				debug = true
 			case "-s", "--simple":
 				simpleLog = true
-			case "stop", "status", "version", "help", "-h", "--help":
+			case "-w", "--watch":
+				watch = true
+			case "stop", "status", "test", "config", "version", "help", "-h", "--help":
 				command = arg
 			}
 		}
@@ -65,6 +74,31 @@ func main() {
 		fmt.Println("📊 Simple log mode enabled - one-line summaries per request")
 	}
 
+	// Restart on config file changes if requested
+	if watch {
+		cfg.WatchConfig = true
+	}
+
+	// One-shot setup check - runs in the foreground and exits
+	if command == "test" {
+		if !server.RunSelfTest(cfg, os.Stdout) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Configuration and learned model caches - also a one-shot foreground command
+	if command == "config" {
+		server.PrintConfig(cfg, os.Stdout)
+		return
+	}
+
+	// Mirror request summaries and errors to a rotating log file if configured
+	if err := logging.Init(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening log file: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Check if already running
 	if daemon.IsRunning() {
 		fmt.Println("Proxy is already running")
@@ -72,7 +106,7 @@ func main() {
 	}
 
 	// Daemonize (run in background)
-	if err := daemon.Start(); err != nil {
+	if err := daemon.Start(daemon.NewPIDInfo(cfg.Port, cfg.OpenAIBaseURL, server.ProxyVersion)); err != nil {
 		fmt.Fprintf(os.Stderr, "Error starting daemon: %v\n", err)
 		os.Exit(1)
 	}
@@ -88,26 +122,51 @@ func main() {
 		}
 	}()
 
+	// Warn about configured models the provider doesn't know about (typos, deprovisioned models)
+	// Also asynchronous - a failed check never blocks startup
+	go func() {
+		if err := cfg.WarnUnavailableModels(); err != nil && cfg.Debug {
+			fmt.Printf("[DEBUG] Failed to check configured models against provider: %v\n", err)
+		}
+	}()
+
 	// Start HTTP server (blocks)
-	if err := server.Start(cfg); err != nil {
+	err = server.Start(cfg)
+	if errors.Is(err, server.ErrRestart) {
+		err = restart(environ)
+	}
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error starting server: %v\n", err)
 		os.Exit(1)
 	}
 }
 
+// restart re-executes the proxy with its original arguments and environment, so
+// values removed from a config file don't linger from the previous load
+func restart(environ []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("restart after config change: %w", err)
+	}
+	return syscall.Exec(exe, os.Args, environ)
+}
+
 func printHelp() {
 	fmt.Println(`Claude Code Proxy - OpenAI API proxy for Claude Code
 
 Usage:
-  claude-code-proxy [-d|--debug] [-s|--simple]  Start the proxy daemon
+  claude-code-proxy [flags]                     Start the proxy daemon
   claude-code-proxy stop                        Stop the proxy daemon
   claude-code-proxy status                      Check if proxy is running
+  claude-code-proxy test                        Check provider reachability, auth and models
+  claude-code-proxy config                      Show the resolved configuration and model caches
   claude-code-proxy version                     Show version
   claude-code-proxy help                        Show this help
 
 Flags:
   -d, --debug     Enable debug mode (logs full requests/responses)
   -s, --simple    Enable simple log mode (one-line summary per request)
+  -w, --watch     Restart when a config file changes (drops in-flight requests)
 
 Configuration:
   Config file locations (checked in order):
@@ -125,6 +184,8 @@ Configuration:
     OPENAI_BASE_URL                 OpenAI API base URL
     HOST                            Server host (default: 0.0.0.0)
     PORT                            Server port (default: 8082)
+    LOG_FILE                        Also write logs to this file (size-rotated)
+    STOP_GRACE_SECONDS              Seconds stop waits before SIGKILL (default: 10, environment only)
 
 Examples:
   # Start proxy
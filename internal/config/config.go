@@ -11,7 +11,10 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -35,41 +38,220 @@ type Config struct {
 	// Optional
 	OpenAIBaseURL   string
 	AnthropicAPIKey string
+	// Chat completions path appended to the base URL (e.g. /v1/chat/completions
+	// for a gateway whose base URL has no /v1)
+	OpenAIChatPath string
+
+	// Equivalent upstream endpoints - each request goes to the lowest-latency one.
+	// When set, OpenAIBaseURL is the first entry (used for provider detection,
+	// auth and the models list); Load rejects entries of different providers.
+	OpenAIBaseURLs []string
+	// Seconds between endpoint latency probes
+	EndpointProbeIntervalSec int
+	// Idle connections to open per endpoint at startup, with DNS caching (0 = off)
+	PrewarmConnections int
+
+	// Request conventions for custom gateways (ProviderUnknown): "openai-compatible"
+	// (stream usage only), "openai", "openrouter" or "none"
+	UnknownProviderDefaults string
 
 	// Model routing (pattern-based if not set)
 	OpusModel   string
 	SonnetModel string
 	HaikuModel  string
 
+	// Per-tier output caps - max_tokens is clamped to these (0 = no cap)
+	MaxTokensHaiku  int
+	MaxTokensSonnet int
+	MaxTokensOpus   int
+
+	// Response block cap - content blocks beyond it are dropped for a notice (0 = no cap)
+	MaxResponseBlocks int
+
+	// Reasoning token budget - a response spending more reasoning tokens logs a
+	// warning (0 = off); with the downgrade on, later requests to that model are
+	// sent one reasoning_effort level lower
+	MaxReasoningTokens       int
+	ReasoningEffortDowngrade bool
+
+	// Fallback model used when the mapped model is not found upstream
+	FallbackModel string
+
 	// Server settings
 	Host string
 	Port string
 
+	// Idle shutdown - exit after this many minutes without requests (0 = never)
+	IdleShutdownMinutes int
+
+	// Concurrency limit - max in-flight /v1/messages requests, others queue (0 = unlimited)
+	MaxConcurrentRequests int
+	// Report queue wait in the X-Proxy-Queue-Ms response header
+	QueueWaitHeader bool
+
 	// Debug logging
 	Debug bool
 
+	// Debug request buffer - number of recent exchanges kept for GET /debug/requests
+	// (only served in debug mode or when ANTHROPIC_API_KEY protects the proxy)
+	DebugBufferSize int
+
+	// Capture - write each request/response pair (secrets redacted) to a file in this dir
+	CaptureDir string
+	// Capture - gzip capture files
+	CaptureCompress bool
+	// Capture - keep at most this many capture files (0 = unlimited)
+	CaptureMaxFiles int
+	// Capture - delete capture files older than this (0 = keep forever)
+	CaptureMaxAge time.Duration
+	// Capture - fraction of successful exchanges written (0.0-1.0); failed ones always are
+	CaptureSampleRate float64
+
 	// Simple logging - one-line summary per request
 	SimpleLog bool
 
+	// Restart when a config file changes (--watch flag, not read from the environment)
+	WatchConfig bool
+
+	// Log file - request summaries and errors are also written here (size-rotated)
+	LogFile string
+	// Log file - size in MB before rotating
+	LogFileMaxMB int
+	// Log file - number of rotated backups to keep
+	LogFileBackups int
+
+	// OTLP metrics export - collector base URL, metrics go to <endpoint>/v1/metrics (empty = off)
+	OTelEndpoint string
+	// OTLP metrics export - interval between exports
+	OTelMetricInterval time.Duration
+	// Tracing - export request spans to <endpoint>/v1/traces (OTelEndpoint, or the
+	// default local collector when unset)
+	EnableTracing bool
+
+	// Sampling penalties forwarded to non-reasoning models (nil = not sent)
+	FrequencyPenalty *float64
+	PresencePenalty  *float64
+	// Temperature range - overrides the provider's valid range that client
+	// temperatures are clamped into (TEMPERATURE_RANGE=min-max, e.g. 0-1; nil = per provider)
+	TemperatureRange *[2]float64
+
+	// Finish reason mapping - provider finish_reason -> Claude stop_reason, applied
+	// before the built-in defaults (e.g. eos=end_turn,max_length=max_tokens)
+	FinishReasonMap map[string]string
+
+	// System prompt templates per provider (SYSTEM_TEMPLATE_OLLAMA, _OPENAI,
+	// _OPENROUTER, _UNKNOWN) - {{system}} is replaced with the client's system text
+	SystemTemplates map[ProviderType]string
+
+	// Tokenizer - encoder for count_tokens estimates (o200k, cl100k, heuristic;
+	// empty = chosen per model and provider)
+	Tokenizer string
+	// Tokenizer - model pattern rules checked before TOKENIZER (e.g. llama=heuristic,gpt-4=cl100k)
+	TokenizerMap []TokenizerRule
+
+	// Force tool mode - for weak models that answer in plain text despite tools:
+	// "required" sets tool_choice=required, "fallback" also injects a plain-text reply tool
+	ForceToolMode string
+	// No-tools models - mapped model name substrings (lowercase) whose requests are
+	// sent without tools or tool_choice, for local models that break on tool definitions
+	NoToolsModels []string
+
+	// Strict request validation - check /v1/messages bodies against the Claude request
+	// schema and reject mismatches with field-level errors
+	StrictRequestValidation bool
+
+	// Tool pair validation - what to do with a tool_result whose tool_use_id matches
+	// no earlier tool_use: "drop" it with a warning, "error" rejects the request (empty = off)
+	ValidateToolPairs string
+	// Thinking budget conflicts - a max_tokens at or below thinking.budget_tokens leaves
+	// no room for the answer: "adjust" raises max_tokens by the budget, "error" rejects
+	// the request (empty = forwarded unchanged)
+	ThinkingBudgetConflict string
+	// Unknown content blocks - what to do with a block type the converter doesn't
+	// know: "drop" it, "stringify" it into the text as JSON (default), or "error"
+	UnknownBlock string
+	// Tool order normalization - move each tool_result right after its tool_use's assistant turn
+	NormalizeToolOrder bool
+
+	// Compact tools - truncate tool descriptions and drop non-essential schema keywords
+	// (also enabled per request by the token-efficient-tools anthropic-beta flag)
+	CompactTools bool
+
+	// Minimal root - "/" returns only name/version/status (hides base URL and routing)
+	MinimalRoot bool
+
 	// Passthrough mode - directly proxy to Anthropic without conversion
 	PassthroughMode bool
 
+	// OpenAI prompt caching - explicit prompt_cache_key (derived from the system prompt if empty)
+	PromptCacheKey string
+
+	// Token count cache - reuse a prompt's count between count_tokens and /v1/messages
+	// for this long (0 = disabled), for at most TokenCountCacheSize prompts
+	TokenCountCacheTTL  time.Duration
+	TokenCountCacheSize int
+
+	// Ollama keep_alive - how long the model stays loaded after a request ("10m", "-1"
+	// keeps it resident, "0" unloads immediately; empty = Ollama's default)
+	OllamaKeepAlive string
+
+	// Circuit breaker - consecutive upstream failures before rejecting requests (0 = disabled)
+	CircuitBreakerThreshold int
+	// Circuit breaker - seconds to reject requests once the breaker opens
+	CircuitBreakerCooldownSec int
+
+	// Streaming - delay between forwarded content deltas in milliseconds (0 = no throttle)
+	StreamThrottleMs int
+	// Streaming - deltas per client flush (1 = flush every delta) and the longest a
+	// batched delta waits before it is flushed anyway
+	StreamFlushBatch      int
+	StreamFlushMaxDelayMs int
+	// Streaming - emit estimated usage in interim message_delta events
+	StreamIncrementalUsage bool
+	// Streaming - send the text content_block_start right after message_start (non-reasoning models)
+	EagerTextBlock bool
+	// Streaming - re-issue the request once when the upstream stream completes without content
+	StreamRetryEmpty bool
+	// Streaming - send an empty text block alongside the tool blocks of a tool-only reply
+	EmptyTextBlockWithTools bool
+	// Thinking as text - put reasoning in the text block between <thinking> tags instead
+	// of thinking blocks, for clients that don't render them (both paths)
+	ThinkingAsText bool
+	// Streaming - which wins when Accept asks for SSE but stream is false ("accept" or "body")
+	StreamPrecedence string
+
 	// OpenRouter-specific (optional, improves rate limits)
 	OpenRouterAppName string
 	OpenRouterAppURL  string
 }
 
+// configLocations returns the .env files Load tries, in priority order.
+// When HOME is unset (some containers and systemd units) the home-relative
+// files are replaced by <user config dir>/claude-code-proxy/proxy.env, and
+// when neither can be resolved only ./.env is tried.
+func configLocations() []string {
+	locations := []string{".env"}
+
+	if home, err := os.UserHomeDir(); err == nil && home != "" {
+		return append(locations,
+			filepath.Join(home, ".claude", "proxy.env"),
+			filepath.Join(home, ".claude-code-proxy"),
+		)
+	}
+
+	if dir, err := os.UserConfigDir(); err == nil && dir != "" {
+		return append(locations, filepath.Join(dir, "claude-code-proxy", "proxy.env"))
+	}
+
+	return locations
+}
+
 // Load reads configuration from environment variables
 // Tries multiple locations: ./.env, ~/.claude/proxy.env, ~/.claude-code-proxy
+// (or the user config dir when HOME is unset, see configLocations)
 func Load() (*Config, error) {
 	// Try loading .env files in priority order
-	locations := []string{
-		".env",
-		filepath.Join(os.Getenv("HOME"), ".claude", "proxy.env"),
-		filepath.Join(os.Getenv("HOME"), ".claude-code-proxy"),
-	}
-
-	for _, loc := range locations {
+	for _, loc := range configLocations() {
 		if _, err := os.Stat(loc); err == nil {
 			// File exists, load it (overload to override existing env vars)
 			if err := godotenv.Overload(loc); err == nil {
@@ -84,24 +266,172 @@ func Load() (*Config, error) {
 		OpenAIAPIKey:    os.Getenv("OPENAI_API_KEY"),
 		OpenAIBaseURL:   getEnvOrDefault("OPENAI_BASE_URL", "https://api.openai.com/v1"),
 		AnthropicAPIKey: os.Getenv("ANTHROPIC_API_KEY"),
+		OpenAIChatPath:  getEnvOrDefault("OPENAI_CHAT_PATH", "/chat/completions"),
 
 		// Pattern-based routing (optional overrides)
 		OpusModel:   os.Getenv("ANTHROPIC_DEFAULT_OPUS_MODEL"),
 		SonnetModel: os.Getenv("ANTHROPIC_DEFAULT_SONNET_MODEL"),
 		HaikuModel:  os.Getenv("ANTHROPIC_DEFAULT_HAIKU_MODEL"),
 
+		// Per-tier max_tokens caps (optional)
+		MaxTokensHaiku:  getEnvAsIntOrDefault("MAX_TOKENS_HAIKU", 0),
+		MaxTokensSonnet: getEnvAsIntOrDefault("MAX_TOKENS_SONNET", 0),
+		MaxTokensOpus:   getEnvAsIntOrDefault("MAX_TOKENS_OPUS", 0),
+
+		MaxResponseBlocks: getEnvAsIntOrDefault("MAX_RESPONSE_BLOCKS", 1000),
+
+		// Reasoning token budget (disabled by default)
+		MaxReasoningTokens:       getEnvAsIntOrDefault("MAX_REASONING_TOKENS", 0),
+		ReasoningEffortDowngrade: getEnvAsBoolOrDefault("REASONING_EFFORT_DOWNGRADE", false),
+
+		// Fallback when the mapped model doesn't exist upstream (optional)
+		FallbackModel: os.Getenv("FALLBACK_MODEL"),
+
 		// Server settings
 		Host: getEnvOrDefault("HOST", "0.0.0.0"),
 		Port: getEnvOrDefault("PORT", "8082"),
 
+		// Idle shutdown (disabled by default)
+		IdleShutdownMinutes: getEnvAsIntOrDefault("IDLE_SHUTDOWN_MINUTES", 0),
+
+		// Concurrency limit (disabled by default)
+		MaxConcurrentRequests: getEnvAsIntOrDefault("MAX_CONCURRENT_REQUESTS", 0),
+		QueueWaitHeader:       getEnvAsBoolOrDefault("QUEUE_WAIT_HEADER", false),
+
+		// Debug request buffer
+		DebugBufferSize: getEnvAsIntOrDefault("DEBUG_BUFFER_SIZE", 20),
+
+		// Request/response capture (optional)
+		CaptureDir:        os.Getenv("CAPTURE_DIR"),
+		CaptureCompress:   getEnvAsBoolOrDefault("CAPTURE_COMPRESS", false),
+		CaptureMaxFiles:   getEnvAsIntOrDefault("CAPTURE_MAX_FILES", 0),
+		CaptureMaxAge:     getEnvAsDurationOrDefault("CAPTURE_MAX_AGE", 0),
+		CaptureSampleRate: getEnvAsFloatOrDefault("CAPTURE_SAMPLE_RATE", 1.0),
+
+		// Log file (optional)
+		LogFile:        os.Getenv("LOG_FILE"),
+		LogFileMaxMB:   getEnvAsIntOrDefault("LOG_FILE_MAX_MB", 10),
+		LogFileBackups: getEnvAsIntOrDefault("LOG_FILE_BACKUPS", 3),
+
+		// OTLP metrics export (optional, standard OpenTelemetry variable names;
+		// the interval is in milliseconds as in the OTel spec)
+		OTelEndpoint:       os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		OTelMetricInterval: time.Duration(getEnvAsIntOrDefault("OTEL_METRIC_EXPORT_INTERVAL", 60000)) * time.Millisecond,
+		EnableTracing:      getEnvAsBoolOrDefault("ENABLE_TRACING", false),
+
+		// Sampling penalties (optional)
+		FrequencyPenalty: getEnvAsFloatPtr("FREQUENCY_PENALTY"),
+		PresencePenalty:  getEnvAsFloatPtr("PRESENCE_PENALTY"),
+
+		// Tool serialization
+		ForceToolMode: os.Getenv("FORCE_TOOL_MODE"),
+		CompactTools:  getEnvAsBoolOrDefault("COMPACT_TOOLS", false),
+
+		NormalizeToolOrder:      getEnvAsBoolOrDefault("NORMALIZE_TOOL_ORDER", false),
+		StrictRequestValidation: getEnvAsBoolOrDefault("STRICT_REQUEST_VALIDATION", false),
+
+		// Root endpoint detail
+		MinimalRoot: getEnvAsBoolOrDefault("MINIMAL_ROOT", false),
+
 		// Passthrough mode
 		PassthroughMode: getEnvAsBoolOrDefault("PASSTHROUGH_MODE", false),
 
+		// OpenAI prompt caching (optional)
+		PromptCacheKey: os.Getenv("PROMPT_CACHE_KEY"),
+
+		// Token count cache (disabled by default)
+		TokenCountCacheTTL:  getEnvAsDurationOrDefault("TOKEN_COUNT_CACHE_TTL", 0),
+		TokenCountCacheSize: getEnvAsIntOrDefault("TOKEN_COUNT_CACHE_SIZE", 256),
+
+		// Ollama model residency (optional)
+		OllamaKeepAlive: os.Getenv("OLLAMA_KEEP_ALIVE"),
+
+		// Circuit breaker (disabled by default)
+		CircuitBreakerThreshold:   getEnvAsIntOrDefault("CIRCUIT_BREAKER_THRESHOLD", 0),
+		CircuitBreakerCooldownSec: getEnvAsIntOrDefault("CIRCUIT_BREAKER_COOLDOWN", 30),
+
+		// Streaming output pacing
+		StreamThrottleMs: getEnvAsIntOrDefault("STREAM_THROTTLE_MS", 0),
+
+		StreamFlushBatch:      getEnvAsIntOrDefault("STREAM_FLUSH_BATCH", 1),
+		StreamFlushMaxDelayMs: getEnvAsIntOrDefault("STREAM_FLUSH_MAX_DELAY_MS", 20),
+
+		StreamPrecedence: getEnvOrDefault("STREAM_PRECEDENCE", "accept"),
+
+		StreamIncrementalUsage:  getEnvAsBoolOrDefault("STREAM_INCREMENTAL_USAGE", false),
+		EagerTextBlock:          getEnvAsBoolOrDefault("EAGER_TEXT_BLOCK", false),
+		StreamRetryEmpty:        getEnvAsBoolOrDefault("STREAM_RETRY_EMPTY", false),
+		EmptyTextBlockWithTools: getEnvAsBoolOrDefault("EMPTY_TEXT_BLOCK_WITH_TOOLS", false),
+		ThinkingAsText:          getEnvAsBoolOrDefault("THINKING_AS_TEXT", false),
+
 		// OpenRouter-specific (optional)
 		OpenRouterAppName: os.Getenv("OPENROUTER_APP_NAME"),
 		OpenRouterAppURL:  os.Getenv("OPENROUTER_APP_URL"),
 	}
 
+	// Multiple equivalent endpoints (comma-separated); the first one is the primary.
+	// Provider detection, auth and the models check go by the first one, so they
+	// must all be the same provider.
+	if urls := splitList(os.Getenv("OPENAI_BASE_URLS")); len(urls) > 0 {
+		cfg.OpenAIBaseURLs = urls
+		cfg.OpenAIBaseURL = urls[0]
+		for _, url := range urls[1:] {
+			if provider, first := detectProvider(url), detectProvider(urls[0]); provider != first {
+				return nil, fmt.Errorf("OPENAI_BASE_URLS must all be the same provider: %s is %s, but %s is %s", urls[0], first, url, provider)
+			}
+		}
+	}
+	cfg.EndpointProbeIntervalSec = getEnvAsIntOrDefault("ENDPOINT_PROBE_INTERVAL", 30)
+	cfg.PrewarmConnections = getEnvAsIntOrDefault("PREWARM_CONNECTIONS", 0)
+
+	// Behavior for custom OpenAI-compatible gateways
+	cfg.UnknownProviderDefaults = getEnvOrDefault("UNKNOWN_PROVIDER_DEFAULTS", UnknownProviderOpenAICompatible)
+	if !validUnknownProviderDefaults[cfg.UnknownProviderDefaults] {
+		fmt.Printf("⚠️  Warning: unknown UNKNOWN_PROVIDER_DEFAULTS %q, using %q\n", cfg.UnknownProviderDefaults, UnknownProviderOpenAICompatible)
+		cfg.UnknownProviderDefaults = UnknownProviderOpenAICompatible
+	}
+
+	// Custom finish reason mapping (comma-separated reason=stop_reason pairs)
+	cfg.FinishReasonMap = parseFinishReasonMap(os.Getenv("FINISH_REASON_MAP"))
+
+	cfg.Tokenizer = strings.ToLower(os.Getenv("TOKENIZER"))
+	if cfg.Tokenizer != "" && !validTokenizers[cfg.Tokenizer] {
+		fmt.Printf("⚠️  Warning: unknown TOKENIZER %q, choosing per model\n", cfg.Tokenizer)
+		cfg.Tokenizer = ""
+	}
+	cfg.TokenizerMap = parseTokenizerMap(os.Getenv("TOKENIZER_MAP"))
+	cfg.TemperatureRange = parseTemperatureRange(os.Getenv("TEMPERATURE_RANGE"))
+	for _, pattern := range splitList(os.Getenv("NO_TOOLS_MODELS")) {
+		cfg.NoToolsModels = append(cfg.NoToolsModels, strings.ToLower(pattern))
+	}
+
+	for _, provider := range []ProviderType{ProviderOpenRouter, ProviderOpenAI, ProviderOllama, ProviderUnknown} {
+		if template := os.Getenv("SYSTEM_TEMPLATE_" + strings.ToUpper(string(provider))); template != "" {
+			if cfg.SystemTemplates == nil {
+				cfg.SystemTemplates = make(map[ProviderType]string)
+			}
+			cfg.SystemTemplates[provider] = template
+		}
+	}
+
+	cfg.ValidateToolPairs = strings.ToLower(os.Getenv("VALIDATE_TOOL_PAIRS"))
+	if cfg.ValidateToolPairs != "" && cfg.ValidateToolPairs != ToolPairsDrop && cfg.ValidateToolPairs != ToolPairsError {
+		fmt.Printf("⚠️  Warning: unknown VALIDATE_TOOL_PAIRS %q, tool pairs are not validated\n", cfg.ValidateToolPairs)
+		cfg.ValidateToolPairs = ""
+	}
+
+	cfg.ThinkingBudgetConflict = strings.ToLower(os.Getenv("THINKING_BUDGET_CONFLICT"))
+	if cfg.ThinkingBudgetConflict != "" && cfg.ThinkingBudgetConflict != ThinkingBudgetAdjust && cfg.ThinkingBudgetConflict != ThinkingBudgetError {
+		fmt.Printf("⚠️  Warning: unknown THINKING_BUDGET_CONFLICT %q, thinking budgets are not checked\n", cfg.ThinkingBudgetConflict)
+		cfg.ThinkingBudgetConflict = ""
+	}
+
+	cfg.UnknownBlock = strings.ToLower(getEnvOrDefault("UNKNOWN_BLOCK", UnknownBlockStringify))
+	if cfg.UnknownBlock != UnknownBlockDrop && cfg.UnknownBlock != UnknownBlockStringify && cfg.UnknownBlock != UnknownBlockError {
+		fmt.Printf("⚠️  Warning: unknown UNKNOWN_BLOCK %q, using %q\n", cfg.UnknownBlock, UnknownBlockStringify)
+		cfg.UnknownBlock = UnknownBlockStringify
+	}
+
 	// Validate required fields
 	// Allow missing API key for Ollama (localhost endpoints)
 	if cfg.OpenAIAPIKey == "" {
@@ -140,9 +470,163 @@ func getEnvAsBoolOrDefault(key string, defaultValue bool) bool {
 	return defaultValue
 }
 
+// getEnvAsFloatPtr returns the parsed float value of key, or nil if unset or invalid
+func getEnvAsFloatPtr(key string) *float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return &floatValue
+		}
+	}
+	return nil
+}
+
+func getEnvAsFloatOrDefault(key string, defaultValue float64) float64 {
+	if value := getEnvAsFloatPtr(key); value != nil {
+		return *value
+	}
+	return defaultValue
+}
+
+func getEnvAsIntOrDefault(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if intValue, err := strconv.Atoi(value); err == nil {
+			return intValue
+		}
+	}
+	return defaultValue
+}
+
+// getEnvAsDurationOrDefault parses a Go duration ("72h", "30m"); a bare number is seconds
+func getEnvAsDurationOrDefault(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+		if secs, err := strconv.Atoi(value); err == nil {
+			return time.Duration(secs) * time.Second
+		}
+	}
+	return defaultValue
+}
+
+// splitList splits a comma-separated value, trimming spaces and dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// claudeStopReasons are the stop_reason values a finish reason may be mapped to
+var claudeStopReasons = map[string]bool{
+	"end_turn":      true,
+	"max_tokens":    true,
+	"stop_sequence": true,
+	"tool_use":      true,
+	"pause_turn":    true,
+	"refusal":       true,
+}
+
+// parseFinishReasonMap parses "reason=stop_reason" pairs, skipping (with a warning)
+// malformed entries and unknown Claude stop reasons
+func parseFinishReasonMap(value string) map[string]string {
+	var mapping map[string]string
+	for _, entry := range splitList(value) {
+		reason, stopReason, ok := strings.Cut(entry, "=")
+		reason, stopReason = strings.TrimSpace(reason), strings.TrimSpace(stopReason)
+		if !ok || reason == "" || !claudeStopReasons[stopReason] {
+			fmt.Printf("⚠️  Warning: ignoring FINISH_REASON_MAP entry %q\n", entry)
+			continue
+		}
+		if mapping == nil {
+			mapping = make(map[string]string)
+		}
+		mapping[reason] = stopReason
+	}
+	return mapping
+}
+
+// THINKING_BUDGET_CONFLICT values
+const (
+	ThinkingBudgetAdjust = "adjust"
+	ThinkingBudgetError  = "error"
+)
+
+// VALIDATE_TOOL_PAIRS values
+const (
+	ToolPairsDrop  = "drop"
+	ToolPairsError = "error"
+)
+
+// UNKNOWN_BLOCK values
+const (
+	UnknownBlockDrop      = "drop"
+	UnknownBlockStringify = "stringify"
+	UnknownBlockError     = "error"
+)
+
+// Built-in tokenizers (TOKENIZER, TOKENIZER_MAP)
+const (
+	TokenizerO200k     = "o200k"
+	TokenizerCL100k    = "cl100k"
+	TokenizerHeuristic = "heuristic"
+)
+
+var validTokenizers = map[string]bool{
+	TokenizerO200k:     true,
+	TokenizerCL100k:    true,
+	TokenizerHeuristic: true,
+}
+
+// TokenizerRule selects a tokenizer for provider models whose name contains Pattern
+type TokenizerRule struct {
+	Pattern   string
+	Tokenizer string
+}
+
+// parseTokenizerMap parses ordered "pattern=tokenizer" rules, skipping (with a
+// warning) malformed entries and unknown tokenizers
+func parseTokenizerMap(value string) []TokenizerRule {
+	var rules []TokenizerRule
+	for _, entry := range splitList(value) {
+		pattern, tokenizer, ok := strings.Cut(entry, "=")
+		pattern, tokenizer = strings.ToLower(strings.TrimSpace(pattern)), strings.ToLower(strings.TrimSpace(tokenizer))
+		if !ok || pattern == "" || !validTokenizers[tokenizer] {
+			fmt.Printf("⚠️  Warning: ignoring TOKENIZER_MAP entry %q\n", entry)
+			continue
+		}
+		rules = append(rules, TokenizerRule{Pattern: pattern, Tokenizer: tokenizer})
+	}
+	return rules
+}
+
+// parseTemperatureRange parses a "min-max" range, warning and returning nil when
+// it is unset or malformed
+func parseTemperatureRange(value string) *[2]float64 {
+	if value == "" {
+		return nil
+	}
+	low, high, ok := strings.Cut(value, "-")
+	minValue, minErr := strconv.ParseFloat(strings.TrimSpace(low), 64)
+	maxValue, maxErr := strconv.ParseFloat(strings.TrimSpace(high), 64)
+	if !ok || minErr != nil || maxErr != nil || minValue > maxValue {
+		fmt.Printf("⚠️  Warning: invalid TEMPERATURE_RANGE %q (want min-max, e.g. 0-1), using the provider's range\n", value)
+		return nil
+	}
+	return &[2]float64{minValue, maxValue}
+}
+
 // DetectProvider identifies the provider type based on base URL
 func (c *Config) DetectProvider() ProviderType {
-	baseURL := strings.ToLower(c.OpenAIBaseURL)
+	return detectProvider(c.OpenAIBaseURL)
+}
+
+// detectProvider identifies the provider type of a base URL
+func detectProvider(baseURL string) ProviderType {
+	baseURL = strings.ToLower(baseURL)
 
 	if strings.Contains(baseURL, "openrouter.ai") {
 		return ProviderOpenRouter
@@ -156,6 +640,38 @@ func (c *Config) DetectProvider() ProviderType {
 	return ProviderUnknown
 }
 
+// UNKNOWN_PROVIDER_DEFAULTS values
+const (
+	UnknownProviderOpenAICompatible  = "openai-compatible" // stream usage tracking only
+	UnknownProviderTreatAsOpenAI     = "openai"
+	UnknownProviderTreatAsOpenRouter = "openrouter"
+	UnknownProviderNone              = "none"
+)
+
+var validUnknownProviderDefaults = map[string]bool{
+	UnknownProviderOpenAICompatible:  true,
+	UnknownProviderTreatAsOpenAI:     true,
+	UnknownProviderTreatAsOpenRouter: true,
+	UnknownProviderNone:              true,
+}
+
+// RequestProvider returns the provider whose request conventions the converter
+// follows. This is DetectProvider, except that a custom gateway (ProviderUnknown)
+// can opt into OpenAI or OpenRouter behavior via UNKNOWN_PROVIDER_DEFAULTS.
+func (c *Config) RequestProvider() ProviderType {
+	provider := c.DetectProvider()
+	if provider != ProviderUnknown {
+		return provider
+	}
+	switch c.UnknownProviderDefaults {
+	case UnknownProviderTreatAsOpenAI:
+		return ProviderOpenAI
+	case UnknownProviderTreatAsOpenRouter:
+		return ProviderOpenRouter
+	}
+	return ProviderUnknown
+}
+
 // IsLocalhost returns true if the base URL points to localhost
 func (c *Config) IsLocalhost() bool {
 	baseURL := strings.ToLower(c.OpenAIBaseURL)
@@ -205,6 +721,12 @@ func (c *Config) IsReasoningModel(modelName string) bool {
 	return false
 }
 
+// ReasoningModels returns the reasoning-capable model IDs learned from OpenRouter,
+// sorted, and whether they have been fetched
+func ReasoningModels() ([]string, bool) {
+	return sortedKeys(reasoningCache.models), reasoningCache.populated
+}
+
 // FetchReasoningModels fetches the list of reasoning-capable models from OpenRouter's API.
 // This is called on startup to dynamically detect models that support reasoning,
 // avoiding the need to hardcode model names like deepseek-r1, etc.
@@ -261,3 +783,150 @@ func (c *Config) FetchReasoningModels() error {
 
 	return nil
 }
+
+// ModelListCache stores the model IDs the provider reports via GET /models.
+// It is used to warn about configured models that don't exist upstream.
+type ModelListCache struct {
+	mu        sync.RWMutex
+	models    map[string]bool
+	populated bool
+}
+
+// Global model list cache instance
+var modelListCache = &ModelListCache{
+	models: make(map[string]bool),
+}
+
+// FetchAvailableModels fetches the provider's model list from {OPENAI_BASE_URL}/models
+// (the first of OPENAI_BASE_URLS) and caches it. Sends the API key unless the provider is localhost.
+func (c *Config) FetchAvailableModels() error {
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimSuffix(c.OpenAIBaseURL, "/")+"/models", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if !c.IsLocalhost() && c.OpenAIAPIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.OpenAIAPIKey)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch models: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	// Both OpenAI and OpenRouter return {"data": [{"id": "..."}]}
+	var result struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	modelListCache.mu.Lock()
+	defer modelListCache.mu.Unlock()
+	modelListCache.models = make(map[string]bool, len(result.Data))
+	for _, model := range result.Data {
+		modelListCache.models[model.ID] = true
+	}
+	modelListCache.populated = true
+
+	if c.Debug {
+		fmt.Printf("[DEBUG] Cached %d models from %s\n", len(result.Data), c.OpenAIBaseURL)
+	}
+
+	return nil
+}
+
+// UnavailableConfiguredModels returns a warning for each explicitly configured model
+// that is missing from the cached provider model list. Returns nil if the list
+// hasn't been fetched.
+func (c *Config) UnavailableConfiguredModels() []string {
+	modelListCache.mu.RLock()
+	defer modelListCache.mu.RUnlock()
+
+	if !modelListCache.populated {
+		return nil
+	}
+
+	configured := []struct {
+		setting string
+		model   string
+	}{
+		{"ANTHROPIC_DEFAULT_OPUS_MODEL", c.OpusModel},
+		{"ANTHROPIC_DEFAULT_SONNET_MODEL", c.SonnetModel},
+		{"ANTHROPIC_DEFAULT_HAIKU_MODEL", c.HaikuModel},
+		{"FALLBACK_MODEL", c.FallbackModel},
+	}
+
+	var warnings []string
+	for _, entry := range configured {
+		if entry.model == "" || modelListCache.models[entry.model] {
+			continue
+		}
+		warnings = append(warnings, fmt.Sprintf("%s=%s is not in the provider's model list", entry.setting, entry.model))
+	}
+	return warnings
+}
+
+// IsModelAvailable reports whether a model is in the cached provider model list.
+// The second return value is false when no list has been fetched, in which case
+// availability is unknown.
+func IsModelAvailable(model string) (available bool, known bool) {
+	modelListCache.mu.RLock()
+	defer modelListCache.mu.RUnlock()
+
+	if !modelListCache.populated {
+		return false, false
+	}
+	return modelListCache.models[model], true
+}
+
+// AvailableModels returns the cached provider model list, sorted, and whether it
+// has been fetched
+func AvailableModels() ([]string, bool) {
+	modelListCache.mu.RLock()
+	defer modelListCache.mu.RUnlock()
+	return sortedKeys(modelListCache.models), modelListCache.populated
+}
+
+// sortedKeys returns the keys of a model set in order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WarnUnavailableModels checks the configured models against the provider's model list
+// and prints a warning for each one that doesn't exist. Only OpenRouter and OpenAI
+// Direct are checked, since their /models lists are authoritative.
+func (c *Config) WarnUnavailableModels() error {
+	provider := c.DetectProvider()
+	if provider != ProviderOpenRouter && provider != ProviderOpenAI {
+		return nil
+	}
+
+	if err := c.FetchAvailableModels(); err != nil {
+		return err
+	}
+
+	for _, warning := range c.UnavailableConfiguredModels() {
+		fmt.Printf("⚠️  Warning: %s\n", warning)
+	}
+	return nil
+}
@@ -442,6 +442,25 @@ func TestMissingAPIKeyForOpenAI(t *testing.T) {
 	_ = cfg
 }
 
+// TestMixedProviderBaseURLs tests that OPENAI_BASE_URLS must all be one provider
+func TestMixedProviderBaseURLs(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "test-key")
+
+	t.Setenv("OPENAI_BASE_URLS", "https://eu.example.com/v1,https://us.example.com/v1")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.OpenAIBaseURL != "https://eu.example.com/v1" || len(cfg.OpenAIBaseURLs) != 2 {
+		t.Errorf("OpenAIBaseURL = %q, OpenAIBaseURLs = %v", cfg.OpenAIBaseURL, cfg.OpenAIBaseURLs)
+	}
+
+	t.Setenv("OPENAI_BASE_URLS", "https://api.openai.com/v1,http://localhost:11434/v1")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "http://localhost:11434/v1 is ollama") {
+		t.Errorf("Load() error = %v, want a mixed-provider error", err)
+	}
+}
+
 // TestHostAndPortDefaults tests default host and port values
 func TestHostAndPortDefaults(t *testing.T) {
 	// Save original env
@@ -1035,3 +1054,189 @@ func TestFetchReasoningModels(t *testing.T) {
 		}
 	})
 }
+
+// TestUnavailableConfiguredModels tests warning about configured models missing from the provider's list
+func TestUnavailableConfiguredModels(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/models" {
+			t.Errorf("Expected request to /models, got %q", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data": [{"id": "x-ai/grok-code-fast-1"}, {"id": "google/gemini-2.5-flash"}]}`))
+	}))
+	defer server.Close()
+
+	defer func() {
+		modelListCache = &ModelListCache{models: make(map[string]bool)}
+	}()
+
+	t.Run("no warnings before fetch", func(t *testing.T) {
+		modelListCache = &ModelListCache{models: make(map[string]bool)}
+		cfg := &Config{SonnetModel: "bogus/model"}
+		if warnings := cfg.UnavailableConfiguredModels(); warnings != nil {
+			t.Errorf("Expected no warnings without a model list, got %v", warnings)
+		}
+	})
+
+	t.Run("bogus model produces warning", func(t *testing.T) {
+		cfg := &Config{
+			OpenAIBaseURL: server.URL,
+			SonnetModel:   "x-ai/grok-code-fst-1", // typo
+			HaikuModel:    "google/gemini-2.5-flash",
+		}
+
+		if err := cfg.FetchAvailableModels(); err != nil {
+			t.Fatalf("FetchAvailableModels() error = %v", err)
+		}
+
+		warnings := cfg.UnavailableConfiguredModels()
+		if len(warnings) != 1 {
+			t.Fatalf("Expected 1 warning, got %d: %v", len(warnings), warnings)
+		}
+		if !strings.Contains(warnings[0], "ANTHROPIC_DEFAULT_SONNET_MODEL=x-ai/grok-code-fst-1") {
+			t.Errorf("Warning should name the setting and model, got %q", warnings[0])
+		}
+	})
+
+	t.Run("all models available", func(t *testing.T) {
+		cfg := &Config{
+			OpenAIBaseURL: server.URL,
+			SonnetModel:   "x-ai/grok-code-fast-1",
+		}
+
+		if err := cfg.FetchAvailableModels(); err != nil {
+			t.Fatalf("FetchAvailableModels() error = %v", err)
+		}
+
+		if warnings := cfg.UnavailableConfiguredModels(); len(warnings) != 0 {
+			t.Errorf("Expected no warnings, got %v", warnings)
+		}
+	})
+
+	t.Run("Ollama is not checked", func(t *testing.T) {
+		cfg := &Config{
+			OpenAIBaseURL: "http://localhost:1/v1", // Nothing listening - would fail if fetched
+			SonnetModel:   "qwen2.5:14b",
+		}
+		if err := cfg.WarnUnavailableModels(); err != nil {
+			t.Errorf("WarnUnavailableModels() should skip Ollama, got error %v", err)
+		}
+	})
+}
+
+func TestParseFinishReasonMap(t *testing.T) {
+	mapping := parseFinishReasonMap(" eos=end_turn, max_length = max_tokens,bogus,safety=blocked,=end_turn")
+
+	want := map[string]string{"eos": "end_turn", "max_length": "max_tokens"}
+	if len(mapping) != len(want) {
+		t.Fatalf("parseFinishReasonMap() = %v, want %v", mapping, want)
+	}
+	for reason, stopReason := range want {
+		if mapping[reason] != stopReason {
+			t.Errorf("mapping[%q] = %q, want %q", reason, mapping[reason], stopReason)
+		}
+	}
+
+	if mapping := parseFinishReasonMap(""); mapping != nil {
+		t.Errorf("Expected nil mapping for empty value, got %v", mapping)
+	}
+}
+
+func TestParseTokenizerMap(t *testing.T) {
+	rules := parseTokenizerMap(" Llama=heuristic, gpt-4-turbo = CL100K,bogus,qwen=sentencepiece,=o200k")
+
+	want := []TokenizerRule{{"llama", TokenizerHeuristic}, {"gpt-4-turbo", TokenizerCL100k}}
+	if len(rules) != len(want) {
+		t.Fatalf("parseTokenizerMap() = %v, want %v", rules, want)
+	}
+	for i := range want {
+		if rules[i] != want[i] {
+			t.Errorf("rules[%d] = %v, want %v", i, rules[i], want[i])
+		}
+	}
+}
+
+// TestCacheAccessors tests the read accessors of the learned model caches
+func TestCacheAccessors(t *testing.T) {
+	saved := *reasoningCache
+	defer func() { *reasoningCache = saved }()
+
+	reasoningCache.models = map[string]bool{"openai/o3": true, "deepseek/deepseek-r1": true}
+	reasoningCache.populated = true
+	models, populated := ReasoningModels()
+	if !populated || strings.Join(models, ",") != "deepseek/deepseek-r1,openai/o3" {
+		t.Errorf("ReasoningModels() = %v, %v; want both models sorted", models, populated)
+	}
+
+	modelListCache.mu.Lock()
+	modelListCache.models = map[string]bool{}
+	modelListCache.populated = false
+	modelListCache.mu.Unlock()
+	if models, populated := AvailableModels(); populated || len(models) != 0 {
+		t.Errorf("AvailableModels() = %v, %v; want an unfetched empty list", models, populated)
+	}
+}
+
+// TestParseTemperatureRange tests TEMPERATURE_RANGE parsing
+func TestParseTemperatureRange(t *testing.T) {
+	if got := parseTemperatureRange("0-1"); got == nil || *got != [2]float64{0, 1} {
+		t.Errorf("parseTemperatureRange(\"0-1\") = %v, want [0 1]", got)
+	}
+	for _, value := range []string{"", "1", "a-b", "2-1"} {
+		if got := parseTemperatureRange(value); got != nil {
+			t.Errorf("parseTemperatureRange(%q) = %v, want nil", value, got)
+		}
+	}
+}
+
+// TestLoadWithoutHome tests that an unset HOME falls back to the user config dir
+func TestLoadWithoutHome(t *testing.T) {
+	tempDir := t.TempDir()
+	originalCwd, _ := os.Getwd()
+
+	configDir := filepath.Join(tempDir, "xdg", "claude-code-proxy")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	envFile := filepath.Join(configDir, "proxy.env")
+	if err := os.WriteFile(envFile, []byte("OPENAI_API_KEY=xdg-key"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Run from an empty directory so no ./.env is picked up
+	workDir := filepath.Join(tempDir, "work")
+	os.MkdirAll(workDir, 0755)
+	os.Chdir(workDir)
+	defer os.Chdir(originalCwd)
+
+	t.Setenv("HOME", "")
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(tempDir, "xdg"))
+	t.Setenv("OPENAI_API_KEY", "")
+
+	locations := configLocations()
+	for _, loc := range locations {
+		if strings.HasPrefix(loc, "/.claude") {
+			t.Errorf("configLocations() built a path from an empty HOME: %q", loc)
+		}
+	}
+	if locations[len(locations)-1] != envFile {
+		t.Errorf("configLocations() = %v, want fallback %q", locations, envFile)
+	}
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.OpenAIAPIKey != "xdg-key" {
+		t.Errorf("Expected API key from user config dir, got %q", cfg.OpenAIAPIKey)
+	}
+
+	// Neither HOME nor XDG_CONFIG_HOME: only ./.env is tried
+	t.Setenv("XDG_CONFIG_HOME", "")
+	if got := configLocations(); len(got) != 1 || got[0] != ".env" {
+		t.Errorf("configLocations() without HOME = %v, want [.env]", got)
+	}
+	if _, err := Load(); err != nil {
+		t.Fatalf("Load without HOME failed: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// fileState is what Watch compares between polls
+type fileState struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+func statFile(path string) fileState {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{exists: true, size: info.Size(), modTime: info.ModTime()}
+}
+
+// Locations returns the config files Load considers, in priority order
+// (whether or not they exist), for --watch.
+func Locations() []string {
+	return configLocations()
+}
+
+// Watch polls paths every interval and calls onChange with the first path that
+// was created, modified or removed since the previous poll. Returns when ctx is
+// cancelled. Polling keeps this dependency-free and works for editors that
+// replace files on save.
+func Watch(ctx context.Context, paths []string, interval time.Duration, onChange func(path string)) {
+	states := make(map[string]fileState, len(paths))
+	for _, path := range paths {
+		states[path] = statFile(path)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, path := range paths {
+				state := statFile(path)
+				if state != states[path] {
+					states[path] = state
+					onChange(path)
+					break
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestWatch tests that creating, modifying and removing a watched file each trigger the callback
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "proxy.env")
+	other := filepath.Join(dir, "unrelated.env")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := make(chan string, 10)
+	go Watch(ctx, []string{path}, 10*time.Millisecond, func(p string) { changes <- p })
+
+	expectChange := func(step string) {
+		t.Helper()
+		select {
+		case p := <-changes:
+			if p != path {
+				t.Errorf("%s: onChange(%q), want %q", step, p, path)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s: no change reported", step)
+		}
+	}
+	expectNoChange := func(step string) {
+		t.Helper()
+		select {
+		case p := <-changes:
+			t.Errorf("%s: unexpected onChange(%q)", step, p)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	expectNoChange("idle")
+
+	os.WriteFile(path, []byte("ANTHROPIC_DEFAULT_SONNET_MODEL=a"), 0644)
+	expectChange("create")
+
+	os.WriteFile(path, []byte("ANTHROPIC_DEFAULT_SONNET_MODEL=bb"), 0644)
+	expectChange("modify")
+
+	os.WriteFile(other, []byte("X=1"), 0644)
+	expectNoChange("unwatched file")
+
+	os.Remove(path)
+	expectChange("remove")
+}
@@ -6,11 +6,18 @@
 package converter
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"math"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/claude-code-proxy/proxy/internal/config"
+	"github.com/claude-code-proxy/proxy/internal/logging"
 	"github.com/claude-code-proxy/proxy/pkg/models"
 )
 
@@ -29,31 +36,42 @@ const (
 // Claude supports both string format ("system": "text") and array format with content blocks.
 // This function normalizes both formats to a single string for OpenAI compatibility.
 func extractSystemText(system interface{}) string {
-	if system == nil {
-		return ""
-	}
+	var textParts []string
+	collectSystemText(system, &textParts, 0)
+	return strings.Join(textParts, "\n")
+}
+
+// maxSystemDepth bounds recursion into nested system content
+const maxSystemDepth = 8
 
-	// Handle string format
-	if systemStr, ok := system.(string); ok {
-		return systemStr
+// collectSystemText appends the text found in a system value. Besides the documented
+// shapes (a string, or an array of text blocks with optional cache_control) it
+// tolerates plain strings in the array, untyped blocks, text nested as blocks or
+// arrays, and nil values. Non-text blocks (image, tool_use, ...) are skipped.
+func collectSystemText(value interface{}, textParts *[]string, depth int) {
+	if depth > maxSystemDepth {
+		return
 	}
 
-	// Handle array format
-	if systemArr, ok := system.([]interface{}); ok {
-		var textParts []string
-		for _, block := range systemArr {
-			if blockMap, ok := block.(map[string]interface{}); ok {
-				if blockMap["type"] == "text" {
-					if text, ok := blockMap["text"].(string); ok {
-						textParts = append(textParts, text)
-					}
-				}
-			}
+	switch v := value.(type) {
+	case string:
+		if v != "" {
+			*textParts = append(*textParts, v)
+		}
+	case []interface{}:
+		for _, item := range v {
+			collectSystemText(item, textParts, depth+1)
+		}
+	case map[string]interface{}:
+		if blockType, _ := v["type"].(string); blockType != "" && blockType != "text" {
+			return
+		}
+		if text, ok := v["text"]; ok && text != nil {
+			collectSystemText(text, textParts, depth+1)
+		} else {
+			collectSystemText(v["content"], textParts, depth+1)
 		}
-		return strings.Join(textParts, "\n")
 	}
-
-	return ""
 }
 
 // extractReasoningText extracts text from OpenRouter reasoning_details
@@ -89,21 +107,42 @@ func ConvertRequest(claudeReq models.ClaudeRequest, cfg *config.Config) (*models
 	// Extract system message (can be string or array of content blocks)
 	systemText := extractSystemText(claudeReq.System)
 
-	// Convert messages
-	openaiMessages := convertMessages(claudeReq.Messages, systemText)
+	// Move tool_results next to their tool_use (NORMALIZE_TOOL_ORDER), then reject
+	// the ones that answer no tool_use (VALIDATE_TOOL_PAIRS=error)
+	messages := claudeReq.Messages
+	if cfg.NormalizeToolOrder {
+		messages = normalizeToolOrder(messages)
+	}
+	if cfg.ValidateToolPairs == config.ToolPairsError {
+		if orphans := findOrphanedToolResults(messages); len(orphans) > 0 {
+			return nil, orphans[0]
+		}
+	}
+	if cfg.UnknownBlock == config.UnknownBlockError {
+		if err := findUnknownBlock(messages); err != nil {
+			return nil, err
+		}
+	}
+
+	// Convert messages, with the system text in the provider's template if any
+	openaiMessages := convertMessages(messages, applySystemTemplate(systemText, cfg), cfg)
 
 	// Build OpenAI request
 	openaiReq := &models.OpenAIRequest{
 		Model:       openaiModel,
 		Messages:    openaiMessages,
-		Temperature: claudeReq.Temperature,
+		Temperature: clampTemperature(claudeReq.Temperature, cfg),
 		TopP:        claudeReq.TopP,
 		Stream:      claudeReq.Stream,
+
+		// Claude has no penalties; these come from config (stripped for reasoning models)
+		FrequencyPenalty: cfg.FrequencyPenalty,
+		PresencePenalty:  cfg.PresencePenalty,
 	}
 
 	// Enable usage tracking and reasoning - provider-specific
 	if claudeReq.Stream != nil && *claudeReq.Stream {
-		provider := cfg.DetectProvider()
+		provider := cfg.RequestProvider()
 
 		switch provider {
 		case config.ProviderOpenRouter:
@@ -134,59 +173,161 @@ func ConvertRequest(claudeReq models.ClaudeRequest, cfg *config.Config) (*models
 			if len(claudeReq.Tools) > 0 {
 				openaiReq.ToolChoice = "required"
 			}
+
+		case config.ProviderUnknown:
+			// Custom gateway: stream_options.include_usage is the safe OpenAI-compatible
+			// baseline (UNKNOWN_PROVIDER_DEFAULTS=none sends nothing provider-specific)
+			if cfg.UnknownProviderDefaults != config.UnknownProviderNone {
+				openaiReq.StreamOptions = map[string]interface{}{
+					"include_usage": true,
+				}
+			}
 		}
 	}
 
-	// Set token limit
-	if claudeReq.MaxTokens > 0 {
-		// Reasoning models (o1, o3, o4, gpt-5) require max_completion_tokens
-		// instead of the legacy max_tokens parameter.
-		// Uses dynamic detection from OpenRouter API for reasoning models.
-		if cfg.IsReasoningModel(openaiModel) {
-			openaiReq.MaxCompletionTokens = claudeReq.MaxTokens
-		} else {
-			openaiReq.MaxTokens = claudeReq.MaxTokens
+	// Provider-side caching: a stable conversation key improves cache hits on Claude
+	// Code's large, unchanging system prompt across the turns of a session
+	switch cfg.RequestProvider() {
+	case config.ProviderOpenAI:
+		openaiReq.PromptCacheKey = conversationID(claudeReq.ConversationID, systemText, cfg)
+	case config.ProviderOpenRouter:
+		if id := conversationID(claudeReq.ConversationID, systemText, cfg); id != "" {
+			openaiReq.Metadata = map[string]interface{}{"conversation_id": id}
 		}
 	}
 
+	// Ollama model residency between requests (OLLAMA_KEEP_ALIVE)
+	if cfg.OllamaKeepAlive != "" && cfg.RequestProvider() == config.ProviderOllama {
+		openaiReq.KeepAlive = ollamaKeepAlive(cfg.OllamaKeepAlive)
+	}
+
+	// Set token limit, clamped to the tier cap (moved to max_completion_tokens for
+	// reasoning models below)
+	maxTokens, err := thinkingMaxTokens(claudeReq, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if limit := tierMaxTokens(claudeReq.Model, cfg); limit > 0 && (maxTokens == 0 || maxTokens > limit) {
+		maxTokens = limit
+	}
+	if maxTokens > 0 {
+		openaiReq.MaxTokens = maxTokens
+	}
+
+	// Reasoning models (o1, o3, o4, gpt-5) reject several standard parameters.
+	// Uses dynamic detection from OpenRouter API for reasoning models.
+	if cfg.IsReasoningModel(openaiModel) {
+		CleanReasoningRequest(openaiReq)
+	}
+
 	// Convert stop sequences
 	if len(claudeReq.StopSequences) > 0 {
 		openaiReq.Stop = claudeReq.StopSequences
 	}
 
-	// Convert tools (if present)
-	if len(claudeReq.Tools) > 0 {
-		openaiReq.Tools = convertTools(claudeReq.Tools)
+	// Convert tools (if present), unless the model can't take them (NO_TOOLS_MODELS)
+	// Compact serialization is used for the token-efficient-tools beta or COMPACT_TOOLS
+	if pattern := noToolsPattern(openaiModel, cfg); pattern != "" && len(claudeReq.Tools) > 0 {
+		logging.Printf("[%s] [WARN] Not sending %d tools to %s (NO_TOOLS_MODELS %q)\n",
+			time.Now().Format("15:04:05"), len(claudeReq.Tools), openaiModel, pattern)
+		openaiReq.ToolChoice = nil
+	} else if len(claudeReq.Tools) > 0 {
+		compact := cfg.CompactTools || hasBeta(claudeReq.Betas, tokenEfficientToolsBeta)
+		openaiReq.Tools = convertTools(claudeReq.Tools, compact)
+
+		// A breakpoint on a tool caches the tool definitions up to it; only OpenRouter
+		// passes that through (to Anthropic and Gemini models), others don't accept it
+		if cfg.RequestProvider() == config.ProviderOpenRouter {
+			for i, tool := range claudeReq.Tools {
+				openaiReq.Tools[i].CacheControl = tool.CacheControl
+			}
+		}
+
+		// Opt-in forced tool use for models that otherwise ignore tools
+		applyForceToolMode(openaiReq, cfg)
 	}
 
 	return openaiReq, nil
 }
 
+// noToolsPattern returns the NO_TOOLS_MODELS entry matching the provider model
+// (case-insensitive substring), or "" when tools can be sent
+func noToolsPattern(providerModel string, cfg *config.Config) string {
+	model := strings.ToLower(providerModel)
+	for _, pattern := range cfg.NoToolsModels {
+		if strings.Contains(model, pattern) {
+			return pattern
+		}
+	}
+	return ""
+}
+
+// CleanReasoningRequest makes a request safe for a reasoning model. This is the one
+// place for reasoning-model request hygiene:
+//   - the token limit moves from max_tokens to max_completion_tokens
+//   - sampling and logprob parameters the models (or gateways in front of them)
+//     reject are removed: temperature, top_p, presence/frequency penalties,
+//     logprobs and top_logprobs
+func CleanReasoningRequest(req *models.OpenAIRequest) {
+	if req.MaxTokens > 0 {
+		req.MaxCompletionTokens = req.MaxTokens
+		req.MaxTokens = 0
+	}
+
+	req.Temperature = nil
+	req.TopP = nil
+	req.PresencePenalty = nil
+	req.FrequencyPenalty = nil
+	req.Logprobs = nil
+	req.TopLogprobs = nil
+}
+
+// SetTokenParameter moves the token limit to max_completion_tokens (true) or
+// max_tokens (false), overriding the reasoning-model decision for one request
+func SetTokenParameter(req *models.OpenAIRequest, useMaxCompletionTokens bool) {
+	if useMaxCompletionTokens && req.MaxTokens > 0 {
+		req.MaxCompletionTokens = req.MaxTokens
+		req.MaxTokens = 0
+	} else if !useMaxCompletionTokens && req.MaxCompletionTokens > 0 {
+		req.MaxTokens = req.MaxCompletionTokens
+		req.MaxCompletionTokens = 0
+	}
+}
+
+// conversationID returns the client's x-proxy-conversation-id, the configured
+// PROMPT_CACHE_KEY, or a key derived from a hash of the system prompt so identical
+// system prompts share a cache key. Returns "" when none is available.
+func conversationID(clientID, systemText string, cfg *config.Config) string {
+	if clientID != "" {
+		return clientID
+	}
+	if cfg.PromptCacheKey != "" {
+		return cfg.PromptCacheKey
+	}
+	if systemText == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(systemText))
+	return "ccp-" + hex.EncodeToString(sum[:8])
+}
+
 // mapModel maps Claude model names to provider-specific models using pattern matching.
 // It routes haiku/sonnet/opus tiers to appropriate models (gpt-5-mini, gpt-5, etc.)
 // and allows environment variable overrides for routing to alternative providers like
 // Grok, Gemini, or DeepSeek. Non-Claude model names are passed through unchanged.
 func mapModel(claudeModel string, cfg *config.Config) string {
-	modelLower := strings.ToLower(claudeModel)
-
-	// Haiku tier
-	if strings.Contains(modelLower, "haiku") {
+	switch claudeTier(claudeModel) {
+	case "haiku":
 		if cfg.HaikuModel != "" {
 			return cfg.HaikuModel
 		}
 		return DefaultHaikuModel
-	}
-
-	// Sonnet tier
-	if strings.Contains(modelLower, "sonnet") {
+	case "sonnet":
 		if cfg.SonnetModel != "" {
 			return cfg.SonnetModel
 		}
 		return DefaultSonnetModel
-	}
-
-	// Opus tier
-	if strings.Contains(modelLower, "opus") {
+	case "opus":
 		if cfg.OpusModel != "" {
 			return cfg.OpusModel
 		}
@@ -197,6 +338,110 @@ func mapModel(claudeModel string, cfg *config.Config) string {
 	return claudeModel
 }
 
+// claudeTier returns the Claude tier ("haiku", "sonnet" or "opus") named in the
+// model, or "" for non-Claude models
+func claudeTier(claudeModel string) string {
+	modelLower := strings.ToLower(claudeModel)
+	for _, tier := range []string{"haiku", "sonnet", "opus"} {
+		if strings.Contains(modelLower, tier) {
+			return tier
+		}
+	}
+	return ""
+}
+
+// thinkingMaxTokens returns the request's max_tokens, checked against its thinking
+// budget (THINKING_BUDGET_CONFLICT). The budget counts toward max_tokens, so one at
+// least as large leaves the model no room to answer: "adjust" raises max_tokens by
+// the budget, "error" rejects the request.
+func thinkingMaxTokens(claudeReq models.ClaudeRequest, cfg *config.Config) (int, error) {
+	maxTokens := claudeReq.MaxTokens
+	thinking := claudeReq.Thinking
+	if thinking == nil || thinking.Type != "enabled" || maxTokens <= 0 || maxTokens > thinking.BudgetTokens {
+		return maxTokens, nil
+	}
+
+	switch cfg.ThinkingBudgetConflict {
+	case config.ThinkingBudgetError:
+		return 0, fmt.Errorf("max_tokens (%d) must be greater than thinking.budget_tokens (%d), or the model has no room left to answer", maxTokens, thinking.BudgetTokens)
+	case config.ThinkingBudgetAdjust:
+		adjusted := maxTokens + thinking.BudgetTokens
+		logging.Printf("[%s] [WARN] max_tokens %d is within thinking.budget_tokens %d, raising it to %d\n",
+			time.Now().Format("15:04:05"), maxTokens, thinking.BudgetTokens, adjusted)
+		return adjusted, nil
+	}
+	return maxTokens, nil
+}
+
+// applySystemTemplate puts the client's system text into the SYSTEM_TEMPLATE_<PROVIDER>
+// template for the request's provider, e.g. to add explicit tool-use instructions
+// for local models. Without a template the text is returned unchanged.
+func applySystemTemplate(systemText string, cfg *config.Config) string {
+	template, ok := cfg.SystemTemplates[cfg.RequestProvider()]
+	if !ok {
+		return systemText
+	}
+	return strings.TrimSpace(strings.ReplaceAll(template, "{{system}}", systemText))
+}
+
+// ollamaKeepAlive returns the keep_alive value to send: Ollama parses strings as Go
+// durations ("10m"), so bare numbers ("-1", "0", "300") are sent as seconds
+func ollamaKeepAlive(value string) interface{} {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return seconds
+	}
+	return value
+}
+
+// temperatureRanges are the temperatures each provider accepts; unknown gateways
+// get Claude's own 0-1 range, which every OpenAI-compatible backend accepts
+var temperatureRanges = map[config.ProviderType][2]float64{
+	config.ProviderOpenAI:     {0, 2},
+	config.ProviderOpenRouter: {0, 2},
+	config.ProviderOllama:     {0, 2},
+	config.ProviderUnknown:    {0, 1},
+}
+
+// clampTemperature returns the temperature clamped into the provider's valid range
+// (TEMPERATURE_RANGE overrides the table), logging a warning when it changes. The
+// client's value is not modified.
+func clampTemperature(temperature *float64, cfg *config.Config) *float64 {
+	if temperature == nil {
+		return nil
+	}
+	valid, ok := temperatureRanges[cfg.RequestProvider()]
+	if cfg.TemperatureRange != nil {
+		valid, ok = *cfg.TemperatureRange, true
+	}
+	if !ok {
+		return temperature
+	}
+	clamped := math.Min(math.Max(*temperature, valid[0]), valid[1])
+	if clamped == *temperature {
+		return temperature
+	}
+	logging.Printf("[%s] [WARN] Clamping temperature %g into the provider's range %g-%g\n", time.Now().Format("15:04:05"), *temperature, valid[0], valid[1])
+	return &clamped
+}
+
+// tierMaxTokens returns the MAX_TOKENS_<TIER> cap for the Claude model (0 = no cap)
+func tierMaxTokens(claudeModel string, cfg *config.Config) int {
+	switch claudeTier(claudeModel) {
+	case "haiku":
+		return cfg.MaxTokensHaiku
+	case "sonnet":
+		return cfg.MaxTokensSonnet
+	case "opus":
+		return cfg.MaxTokensOpus
+	}
+	return 0
+}
+
+// MapModel returns the provider model that a Claude model name is routed to
+func MapModel(claudeModel string, cfg *config.Config) string {
+	return mapModel(claudeModel, cfg)
+}
+
 // convertMessages converts Claude messages to OpenAI format.
 //
 // Handles three content types:
@@ -206,8 +451,25 @@ func mapModel(claudeModel string, cfg *config.Config) string {
 //
 // The function maintains the conversation flow while translating Claude's content block
 // structure to OpenAI's message format, ensuring tool call IDs are preserved for correlation.
-func convertMessages(claudeMessages []models.ClaudeMessage, system string) []models.OpenAIMessage {
+//
+// Assistant turns that contain only thinking blocks are handled per provider: OpenRouter
+// receives the reasoning via reasoning_details (reasoning continuity), other providers get
+// an empty assistant message only where dropping the turn would break role alternation.
+//
+// For OpenRouter, which passes prompt caching through to Anthropic and Gemini models,
+// cache_control breakpoints on text and tool_result blocks are kept on the matching
+// content part of the converted message (see cachedContent).
+//
+// Block types the converter doesn't know are added to the text as JSON
+// (UNKNOWN_BLOCK=stringify, the default) or left out (UNKNOWN_BLOCK=drop).
+func convertMessages(claudeMessages []models.ClaudeMessage, system string, cfg *config.Config) []models.OpenAIMessage {
 	openaiMessages := []models.OpenAIMessage{}
+	forwardCacheControl := cfg.RequestProvider() == config.ProviderOpenRouter
+
+	// Orphaned tool_results (VALIDATE_TOOL_PAIRS=drop) are removed before conversion
+	if cfg.ValidateToolPairs == config.ToolPairsDrop {
+		claudeMessages = dropOrphanedToolResults(claudeMessages, findOrphanedToolResults(claudeMessages))
+	}
 
 	// Add system message if present
 	if system != "" {
@@ -218,21 +480,30 @@ func convertMessages(claudeMessages []models.ClaudeMessage, system string) []mod
 	}
 
 	// Convert each Claude message
-	for _, msg := range claudeMessages {
+	for i, msg := range claudeMessages {
 		// Handle content (can be string or array of blocks)
 		switch content := msg.Content.(type) {
 		case string:
-			// Simple text message
+			// Simple text message (an OpenAI-shaped turn may add tool calls)
+			var textContent interface{} = content
+			if content == "" && len(msg.ToolCalls) > 0 {
+				textContent = nil
+			}
 			openaiMessages = append(openaiMessages, models.OpenAIMessage{
-				Role:    msg.Role,
-				Content: content,
+				Role:      msg.Role,
+				Content:   textContent,
+				ToolCalls: msg.ToolCalls,
 			})
 
 		case []interface{}:
 			// Handle complex content blocks
 			var textParts []string
-			var toolCalls []models.OpenAIToolCall
+			var textCacheControl []interface{} // cache_control of each text part (nil = unmarked)
+			textCached := false
+			var thinkingParts []string
+			toolCalls := append([]models.OpenAIToolCall(nil), msg.ToolCalls...)
 			var hasToolResult bool
+			lastToolMessage := -1 // index of the last tool message converted from this message
 
 			// First pass: check if this is a tool result message
 			for _, block := range content {
@@ -254,6 +525,14 @@ func convertMessages(claudeMessages []models.ClaudeMessage, system string) []mod
 						// Extract text content
 						if text, ok := blockMap["text"].(string); ok {
 							textParts = append(textParts, text)
+							textCacheControl = append(textCacheControl, blockMap["cache_control"])
+							textCached = textCached || blockMap["cache_control"] != nil
+						}
+
+					case "thinking":
+						// Keep thinking text in case this turn has nothing else to carry
+						if thinking, ok := blockMap["thinking"].(string); ok && thinking != "" {
+							thinkingParts = append(thinkingParts, thinking)
 						}
 
 					case "tool_use":
@@ -262,20 +541,12 @@ func convertMessages(claudeMessages []models.ClaudeMessage, system string) []mod
 						toolName, _ := blockMap["name"].(string)
 						toolInput := blockMap["input"]
 
-						// Marshal input to JSON string
-						var inputJSON string
-						if toolInput != nil {
-							if inputBytes, err := json.Marshal(toolInput); err == nil {
-								inputJSON = string(inputBytes)
-							}
-						}
-
 						toolCall := models.OpenAIToolCall{
 							ID:   toolUseID,
 							Type: "function",
 						}
 						toolCall.Function.Name = toolName
-						toolCall.Function.Arguments = inputJSON
+						toolCall.Function.Arguments = toolInputJSON(toolInput)
 						toolCalls = append(toolCalls, toolCall)
 
 					case "tool_result":
@@ -301,25 +572,89 @@ func convertMessages(claudeMessages []models.ClaudeMessage, system string) []mod
 							toolContent = strings.Join(contentParts, "\n")
 						}
 
+						var toolMessageContent interface{} = toolContent
+						if cacheControl := toolResultCacheControl(blockMap); forwardCacheControl && cacheControl != nil {
+							toolMessageContent = cachedContent([]string{toolContent}, []interface{}{cacheControl})
+						}
 						openaiMessages = append(openaiMessages, models.OpenAIMessage{
 							Role:       "tool",
-							Content:    toolContent,
+							Content:    toolMessageContent,
 							ToolCallID: toolUseID,
 						})
+						lastToolMessage = len(openaiMessages) - 1
+
+					default:
+						// Block types unknown to the converter are kept as JSON text unless
+						// UNKNOWN_BLOCK=drop ("error" rejected them in ConvertRequest)
+						if !isKnownBlockType(blockType) && cfg.UnknownBlock != config.UnknownBlockDrop {
+							textParts = append(textParts, stringifyBlock(blockMap))
+							textCacheControl = append(textCacheControl, nil)
+						}
 					}
 				}
 			}
 
+			// The text of a tool_result message isn't forwarded; a breakpoint on it moves
+			// to the last tool message so the cached prefix still ends with this turn
+			if hasToolResult && forwardCacheControl && textCached && lastToolMessage >= 0 {
+				if toolContent, ok := openaiMessages[lastToolMessage].Content.(string); ok {
+					openaiMessages[lastToolMessage].Content = cachedContent([]string{toolContent}, []interface{}{lastCacheControl(textCacheControl)})
+				}
+			}
+
 			// Add assistant message with text and/or tool calls
 			if len(textParts) > 0 || len(toolCalls) > 0 {
 				if !hasToolResult {
-					textContent := strings.Join(textParts, "\n")
+					var textContent interface{} = strings.Join(textParts, "\n")
+					if forwardCacheControl && textCached {
+						textContent = cachedContent(textParts, textCacheControl)
+					}
 					openaiMessages = append(openaiMessages, models.OpenAIMessage{
 						Role:      msg.Role,
 						Content:   textContent,
 						ToolCalls: toolCalls,
 					})
 				}
+			} else if msg.Role == "assistant" && !hasToolResult && len(thinkingParts) > 0 {
+				// Thinking-only assistant turn
+				if cfg.RequestProvider() == config.ProviderOpenRouter {
+					// OpenRouter accepts prior reasoning back for reasoning continuity
+					reasoningDetails := make([]interface{}, len(thinkingParts))
+					for j, thinking := range thinkingParts {
+						reasoningDetails[j] = map[string]interface{}{
+							"type": "reasoning.text",
+							"text": thinking,
+						}
+					}
+					openaiMessages = append(openaiMessages, models.OpenAIMessage{
+						Role:             "assistant",
+						Content:          "",
+						ReasoningDetails: reasoningDetails,
+					})
+				} else if breaksRoleAlternation(openaiMessages, claudeMessages, i) {
+					// Other providers can't take reasoning input - keep an empty turn so
+					// two user messages don't end up adjacent
+					openaiMessages = append(openaiMessages, models.OpenAIMessage{
+						Role:    "assistant",
+						Content: "",
+					})
+				}
+			}
+
+		case nil:
+			// OpenAI-shaped history: {"role":"assistant","content":null,"tool_calls":[...]}.
+			// Without tool calls the turn is empty, which providers reject; it is dropped,
+			// or kept with empty content where dropping would break role alternation.
+			if len(msg.ToolCalls) > 0 {
+				openaiMessages = append(openaiMessages, models.OpenAIMessage{
+					Role:      msg.Role,
+					ToolCalls: msg.ToolCalls,
+				})
+			} else if breaksRoleAlternation(openaiMessages, claudeMessages, i) {
+				openaiMessages = append(openaiMessages, models.OpenAIMessage{
+					Role:    msg.Role,
+					Content: "",
+				})
 			}
 
 		default:
@@ -334,9 +669,61 @@ func convertMessages(claudeMessages []models.ClaudeMessage, system string) []mod
 	return openaiMessages
 }
 
+// cachedContent builds a content part array, one text part per entry, carrying the
+// cache_control breakpoints (nil entries are unmarked). Plain string content has
+// nowhere to put a breakpoint.
+func cachedContent(texts []string, cacheControl []interface{}) []interface{} {
+	parts := make([]interface{}, len(texts))
+	for i, text := range texts {
+		part := map[string]interface{}{"type": "text", "text": text}
+		if cacheControl[i] != nil {
+			part["cache_control"] = cacheControl[i]
+		}
+		parts[i] = part
+	}
+	return parts
+}
+
+// toolResultCacheControl returns the breakpoint of a tool_result block, set on the
+// block itself or on one of its content blocks
+func toolResultCacheControl(blockMap map[string]interface{}) interface{} {
+	if cacheControl := blockMap["cache_control"]; cacheControl != nil {
+		return cacheControl
+	}
+	var cacheControl interface{}
+	if items, ok := blockMap["content"].([]interface{}); ok {
+		for _, item := range items {
+			if itemMap, ok := item.(map[string]interface{}); ok && itemMap["cache_control"] != nil {
+				cacheControl = itemMap["cache_control"]
+			}
+		}
+	}
+	return cacheControl
+}
+
+// lastCacheControl returns the last non-nil breakpoint
+func lastCacheControl(cacheControl []interface{}) interface{} {
+	for i := len(cacheControl) - 1; i >= 0; i-- {
+		if cacheControl[i] != nil {
+			return cacheControl[i]
+		}
+	}
+	return nil
+}
+
+// breaksRoleAlternation reports whether dropping the Claude message at index i would
+// leave two user messages adjacent in the converted conversation.
+func breaksRoleAlternation(converted []models.OpenAIMessage, claudeMessages []models.ClaudeMessage, i int) bool {
+	if len(converted) == 0 || converted[len(converted)-1].Role != "user" {
+		return false
+	}
+	return i+1 < len(claudeMessages) && claudeMessages[i+1].Role == "user"
+}
+
 // convertTools converts Claude tool definitions to OpenAI function calling format.
 // Maps tool name, description, and input_schema to OpenAI's function structure.
-func convertTools(claudeTools []models.Tool) []models.OpenAITool {
+// In compact mode descriptions are truncated and non-essential schema keywords dropped.
+func convertTools(claudeTools []models.Tool, compact bool) []models.OpenAITool {
 	openaiTools := make([]models.OpenAITool, len(claudeTools))
 
 	for i, tool := range claudeTools {
@@ -346,13 +733,166 @@ func convertTools(claudeTools []models.Tool) []models.OpenAITool {
 		openaiTools[i].Function.Name = tool.Name
 		openaiTools[i].Function.Description = tool.Description
 		openaiTools[i].Function.Parameters = tool.InputSchema
+
+		if compact {
+			openaiTools[i].Function.Description = truncateDescription(tool.Description)
+			openaiTools[i].Function.Parameters = compactSchema(tool.InputSchema)
+		}
 	}
 
 	return openaiTools
 }
 
+// FallbackToolName is the reply tool injected by FORCE_TOOL_MODE=fallback. Calls to it
+// are converted back to text, so clients never see it.
+const FallbackToolName = "proxy_reply_text"
+
+// applyForceToolMode sets tool_choice=required (FORCE_TOOL_MODE=required|fallback) and,
+// for fallback, adds a reply tool so a forced model can still answer in plain text.
+// Must be called after tools are converted.
+func applyForceToolMode(openaiReq *models.OpenAIRequest, cfg *config.Config) {
+	switch cfg.ForceToolMode {
+	case "required":
+		openaiReq.ToolChoice = "required"
+	case "fallback":
+		openaiReq.ToolChoice = "required"
+
+		fallback := models.OpenAITool{Type: "function"}
+		fallback.Function.Name = FallbackToolName
+		fallback.Function.Description = "Reply to the user with plain text. Use this only when none of the other tools apply."
+		fallback.Function.Parameters = map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"text": map[string]interface{}{
+					"type":        "string",
+					"description": "The reply to show the user",
+				},
+			},
+			"required": []interface{}{"text"},
+		}
+		openaiReq.Tools = append(openaiReq.Tools, fallback)
+	}
+}
+
+// FallbackToolText extracts the reply text from the arguments of a FallbackToolName call
+func FallbackToolText(arguments string) string {
+	var args struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return arguments
+	}
+	return args.Text
+}
+
+// tokenEfficientToolsBeta is the anthropic-beta flag prefix that enables compact tools
+const tokenEfficientToolsBeta = "token-efficient-tools"
+
+// compactDescriptionMax caps tool and property descriptions in compact mode
+const compactDescriptionMax = 200
+
+// compactDroppedSchemaKeys are JSON Schema keywords that don't affect how a model
+// calls the tool and are dropped in compact mode
+var compactDroppedSchemaKeys = map[string]bool{
+	"$schema":  true,
+	"$id":      true,
+	"title":    true,
+	"examples": true,
+	"$comment": true,
+}
+
+// hasBeta reports whether any of the anthropic-beta flags starts with prefix
+// (beta names carry a date suffix, e.g. token-efficient-tools-2025-02-19)
+func hasBeta(betas []string, prefix string) bool {
+	for _, beta := range betas {
+		if strings.HasPrefix(strings.TrimSpace(beta), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// truncateDescription shortens a description to compactDescriptionMax bytes,
+// cutting at the last word boundary and keeping valid UTF-8
+func truncateDescription(description string) string {
+	if len(description) <= compactDescriptionMax {
+		return description
+	}
+	cut := strings.ToValidUTF8(description[:compactDescriptionMax], "")
+	if idx := strings.LastIndexAny(cut, " \n\t"); idx > compactDescriptionMax/2 {
+		cut = cut[:idx]
+	}
+	return strings.TrimSpace(cut) + "..."
+}
+
+// Keywords whose value holds subschemas: one subschema or a list of them
+// (subschemaKeys), or a map of names to subschemas (subschemaMapKeys). compactSchema
+// only recurses into these; the value of any other keyword, such as default, enum
+// or const, is data and copied as is.
+var (
+	subschemaKeys = map[string]bool{
+		"items": true, "prefixItems": true, "additionalItems": true, "contains": true,
+		"additionalProperties": true, "propertyNames": true, "not": true,
+		"anyOf": true, "oneOf": true, "allOf": true, "if": true, "then": true, "else": true,
+		"unevaluatedItems": true, "unevaluatedProperties": true, "contentSchema": true,
+	}
+	subschemaMapKeys = map[string]bool{
+		"properties": true, "patternProperties": true, "$defs": true, "definitions": true,
+		"dependentSchemas": true, "dependencies": true,
+	}
+)
+
+// compactSchema returns a copy of a JSON schema without non-essential keywords and
+// with nested descriptions truncated. The original schema is not modified.
+func compactSchema(schema interface{}) interface{} {
+	s, ok := schema.(map[string]interface{})
+	if !ok {
+		// Boolean schemas
+		return schema
+	}
+	compacted := make(map[string]interface{}, len(s))
+	for key, value := range s {
+		switch {
+		case compactDroppedSchemaKeys[key]:
+		case key == "description":
+			if desc, ok := value.(string); ok {
+				value = truncateDescription(desc)
+			}
+			compacted[key] = value
+		case subschemaKeys[key]:
+			compacted[key] = compactSubschemas(value)
+		case subschemaMapKeys[key]:
+			// Property and definition names are data, not keywords - only compact their schemas
+			if named, ok := value.(map[string]interface{}); ok {
+				compactedNamed := make(map[string]interface{}, len(named))
+				for name, sub := range named {
+					compactedNamed[name] = compactSubschemas(sub)
+				}
+				value = compactedNamed
+			}
+			compacted[key] = value
+		default:
+			compacted[key] = value
+		}
+	}
+	return compacted
+}
+
+// compactSubschemas compacts a keyword value holding one subschema or a list of them
+func compactSubschemas(value interface{}) interface{} {
+	list, ok := value.([]interface{})
+	if !ok {
+		return compactSchema(value)
+	}
+	compacted := make([]interface{}, len(list))
+	for i, item := range list {
+		compacted[i] = compactSchema(item)
+	}
+	return compacted
+}
+
 // ConvertResponse converts an OpenAI response to Claude format
-func ConvertResponse(openaiResp *models.OpenAIResponse, requestedModel string) (*models.ClaudeResponse, error) {
+func ConvertResponse(openaiResp *models.OpenAIResponse, requestedModel string, cfg *config.Config) (*models.ClaudeResponse, error) {
 	if len(openaiResp.Choices) == 0 {
 		return nil, fmt.Errorf("no choices in OpenAI response")
 	}
@@ -378,36 +918,95 @@ func ConvertResponse(openaiResp *models.OpenAIResponse, requestedModel string) (
 		}
 	}
 
-	// Handle text content
-	if choice.Message.Content != nil {
-		if contentStr, ok := choice.Message.Content.(string); ok && contentStr != "" {
-			contentBlocks = append(contentBlocks, models.ContentBlock{
-				Type: "text",
-				Text: contentStr,
-			})
+	// Ollama reasoning: a "thinking" (native API) or "reasoning" (compat endpoint)
+	// field, or inline <think> tags at the start of the content (Ollama and unknown
+	// gateways only, see NewThinkTagParser). OpenRouter sends
+	// message.reasoning alongside reasoning_details, so these only count when
+	// reasoning_details produced no thinking block.
+	contentStr := ExtractResponseText(choice.Message.Content)
+	ollamaThinking := ""
+	if len(contentBlocks) == 0 {
+		ollamaThinking = choice.Message.Thinking
+		if ollamaThinking == "" {
+			ollamaThinking = choice.Message.Reasoning
+		}
+		if ollamaThinking == "" && usesThinkTags(cfg) {
+			ollamaThinking, contentStr = splitThinkTags(contentStr)
 		}
 	}
+	if ollamaThinking != "" {
+		contentBlocks = append(contentBlocks, models.ContentBlock{
+			Type:     "thinking",
+			Thinking: ollamaThinking,
+		})
+	}
+
+	// Handle text content (string or array of content parts)
+	if contentStr != "" {
+		contentBlocks = append(contentBlocks, models.ContentBlock{
+			Type: "text",
+			Text: contentStr,
+		})
+	}
 
 	// Handle tool calls (convert to tool_use blocks)
+	// The injected fallback reply tool becomes a plain text block
 	for _, toolCall := range choice.Message.ToolCalls {
+		if toolCall.Function.Name == FallbackToolName {
+			if text := FallbackToolText(toolCall.Function.Arguments); text != "" {
+				contentBlocks = append(contentBlocks, models.ContentBlock{
+					Type: "text",
+					Text: text,
+				})
+			}
+			continue
+		}
+		toolUseID := toolCall.ID
+		if toolUseID == "" {
+			toolUseID = NewToolUseID() // some providers omit tool call IDs
+		}
 		contentBlocks = append(contentBlocks, models.ContentBlock{
 			Type:  "tool_use",
-			ID:    toolCall.ID,
+			ID:    toolUseID,
 			Name:  toolCall.Function.Name,
-			Input: toolCall.Function.Arguments, // OpenAI sends as JSON string
+			Input: parseToolArguments(toolCall.Function.Arguments), // OpenAI sends as JSON string
 		})
 	}
 
+	if cfg.ThinkingAsText {
+		contentBlocks = thinkingAsText(contentBlocks)
+	}
+	contentBlocks = capResponseBlocks(contentBlocks, cfg.MaxResponseBlocks)
+
+	// tool_use blocks left after the cap (and the fallback reply tool)
+	toolUseBlocks := 0
+	for _, block := range contentBlocks {
+		if block.Type == "tool_use" {
+			toolUseBlocks++
+		}
+	}
+
 	// Convert finish reason
 	var stopReason *string
 	if choice.FinishReason != nil {
-		reason := convertFinishReason(*choice.FinishReason)
+		reason := ConvertFinishReason(*choice.FinishReason, cfg.FinishReasonMap)
+		// tool_use needs a tool_use block: either only the fallback reply tool was
+		// called, MAX_RESPONSE_BLOCKS dropped every call, or the provider reported
+		// tool_calls without sending any
+		if reason == "tool_use" && toolUseBlocks == 0 {
+			if len(choice.Message.ToolCalls) == 0 {
+				logging.Printf("[%s] [WARN] Provider finished with %q but sent no tool calls, using end_turn\n",
+					time.Now().Format("15:04:05"), *choice.FinishReason)
+			}
+			reason = "end_turn"
+		}
 		stopReason = &reason
 	}
 
-	// Build Claude response
+	// Build Claude response. The ID is the proxy's own: clients expect msg_ IDs,
+	// and the upstream ID is only used for correlation (X-Upstream-Message-Id)
 	claudeResp := &models.ClaudeResponse{
-		ID:         openaiResp.ID,
+		ID:         NewMessageID(),
 		Type:       "message",
 		Role:       "assistant",
 		Content:    contentBlocks,
@@ -422,18 +1021,133 @@ func ConvertResponse(openaiResp *models.OpenAIResponse, requestedModel string) (
 	return claudeResp, nil
 }
 
-// convertFinishReason maps OpenAI finish reasons to Claude format
-func convertFinishReason(openaiReason string) string {
-	switch openaiReason {
-	case "stop":
-		return "end_turn"
-	case "length":
-		return "max_tokens"
-	case "tool_calls":
-		return "tool_use"
-	case "content_filter":
-		return "end_turn" // Claude doesn't have exact equivalent
-	default:
-		return "end_turn"
+// capResponseBlocks keeps the first blocks of a response with more than
+// MAX_RESPONSE_BLOCKS, replacing the rest with a text notice as the last block
+func capResponseBlocks(blocks []models.ContentBlock, maxBlocks int) []models.ContentBlock {
+	if maxBlocks <= 0 || len(blocks) <= maxBlocks {
+		return blocks
+	}
+	omitted := len(blocks) - maxBlocks + 1
+	logging.Printf("[%s] [WARN] Response has %d content blocks, dropping %d beyond MAX_RESPONSE_BLOCKS\n",
+		time.Now().Format("15:04:05"), len(blocks), omitted)
+	return append(blocks[:maxBlocks-1:maxBlocks-1], models.ContentBlock{
+		Type: "text",
+		Text: OmittedBlocksNotice(omitted, maxBlocks),
+	})
+}
+
+// OmittedBlocksNotice is the text sent in place of the content blocks dropped
+// beyond MAX_RESPONSE_BLOCKS
+func OmittedBlocksNotice(omitted, maxBlocks int) string {
+	return fmt.Sprintf("[%d more content blocks omitted by the proxy (MAX_RESPONSE_BLOCKS=%d)]", omitted, maxBlocks)
+}
+
+// NewMessageID returns a random Claude-style message ID
+func NewMessageID() string {
+	var b [12]byte
+	_, _ = rand.Read(b[:])
+	return "msg_" + hex.EncodeToString(b[:])
+}
+
+// NewToolUseID returns a random Claude-style tool_use ID, for tool calls the
+// provider sent without one
+func NewToolUseID() string {
+	var b [12]byte
+	_, _ = rand.Read(b[:])
+	return "toolu_" + hex.EncodeToString(b[:])
+}
+
+// parseToolArguments decodes OpenAI's JSON-string tool arguments into the object
+// Claude expects as tool_use input. Numbers are kept as json.Number so large
+// integers and precise decimals reach the client exactly. Arguments that aren't
+// a complete JSON object (e.g. truncated by max_tokens) are returned as the raw
+// string so nothing is lost.
+func parseToolArguments(arguments string) interface{} {
+	if strings.TrimSpace(arguments) == "" {
+		return map[string]interface{}{}
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(arguments))
+	decoder.UseNumber()
+
+	var input map[string]interface{}
+	if err := decoder.Decode(&input); err != nil || decoder.More() {
+		return arguments
+	}
+	return input
+}
+
+// toolInputJSON encodes a tool_use input as OpenAI function arguments. Some
+// clients send the input already serialized as a JSON string; that is passed
+// through as-is instead of being re-encoded into a quoted string.
+func toolInputJSON(input interface{}) string {
+	if input == nil {
+		return ""
+	}
+	if str, ok := input.(string); ok && json.Valid([]byte(str)) {
+		return str
+	}
+	inputBytes, err := json.Marshal(input)
+	if err != nil {
+		return ""
+	}
+	return string(inputBytes)
+}
+
+// ExtractResponseText extracts text from an OpenAI message content, which may be a
+// plain string or an array of content parts. Text parts ("text" / "output_text") are
+// concatenated; refusals are kept as text so they aren't lost. Non-text output parts
+// (images, audio) have no Claude response equivalent and are replaced by a short
+// placeholder so the client knows something was omitted. Also used for streaming
+// content deltas, which some providers send in the same array form.
+func ExtractResponseText(content interface{}) string {
+	switch c := content.(type) {
+	case string:
+		return c
+	case []interface{}:
+		var sb strings.Builder
+		for _, part := range c {
+			partMap, ok := part.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			partType, _ := partMap["type"].(string)
+			switch partType {
+			case "text", "output_text":
+				if text, ok := partMap["text"].(string); ok {
+					sb.WriteString(text)
+				}
+			case "refusal":
+				if refusal, ok := partMap["refusal"].(string); ok {
+					sb.WriteString(refusal)
+				}
+			case "image_url", "output_image", "image", "audio", "output_audio":
+				sb.WriteString(fmt.Sprintf("[%s output omitted]", partType))
+			}
+		}
+		return sb.String()
+	}
+	return ""
+}
+
+// defaultFinishReasons maps OpenAI finish reasons to Claude stop reasons.
+// Anything not listed (including content_filter, which has no exact Claude
+// equivalent) becomes end_turn.
+var defaultFinishReasons = map[string]string{
+	"stop":          "end_turn",
+	"length":        "max_tokens",
+	"tool_calls":    "tool_use",
+	"function_call": "tool_use", // legacy function calling
+}
+
+// ConvertFinishReason maps an OpenAI finish reason to a Claude stop reason.
+// Entries in custom (FINISH_REASON_MAP) take precedence over the defaults.
+func ConvertFinishReason(openaiReason string, custom map[string]string) string {
+	if reason, ok := custom[openaiReason]; ok {
+		return reason
+	}
+	if reason, ok := defaultFinishReasons[openaiReason]; ok {
+		return reason
 	}
+	return "end_turn"
 }
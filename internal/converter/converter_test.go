@@ -1,6 +1,10 @@
 package converter
 
 import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/claude-code-proxy/proxy/internal/config"
@@ -66,6 +70,64 @@ func TestExtractSystemText(t *testing.T) {
 			},
 			expected: "First part\nSecond part",
 		},
+		{
+			name: "text block with cache_control",
+			system: []interface{}{
+				map[string]interface{}{
+					"type":          "text",
+					"text":          "Cached prompt",
+					"cache_control": map[string]interface{}{"type": "ephemeral"},
+				},
+			},
+			expected: "Cached prompt",
+		},
+		{
+			name: "block missing text and nil values",
+			system: []interface{}{
+				map[string]interface{}{"type": "text"},
+				map[string]interface{}{"type": "text", "text": nil},
+				nil,
+				map[string]interface{}{"type": nil, "text": "Kept"},
+				map[string]interface{}{"type": "text", "text": 42},
+			},
+			expected: "Kept",
+		},
+		{
+			name: "plain strings and untyped blocks in array",
+			system: []interface{}{
+				"First",
+				map[string]interface{}{"text": "Second"},
+			},
+			expected: "First\nSecond",
+		},
+		{
+			name: "nested structures",
+			system: []interface{}{
+				[]interface{}{
+					map[string]interface{}{"type": "text", "text": "Nested array"},
+				},
+				map[string]interface{}{
+					"type": "text",
+					"text": map[string]interface{}{"type": "text", "text": "Nested block"},
+				},
+				map[string]interface{}{
+					"type":    "text",
+					"content": []interface{}{map[string]interface{}{"type": "text", "text": "Nested content"}},
+				},
+				map[string]interface{}{"type": "tool_use", "name": "bash", "input": map[string]interface{}{"text": "skip"}},
+			},
+			expected: "Nested array\nNested block\nNested content",
+		},
+		{
+			name:     "unexpected type",
+			system:   map[string]interface{}{"type": "text", "text": "Single block"},
+			expected: "Single block",
+		},
+		{
+			name:     "number",
+			system:   3.14,
+			expected: "",
+		},
 	}
 
 	for _, tt := range tests {
@@ -76,6 +138,16 @@ func TestExtractSystemText(t *testing.T) {
 			}
 		})
 	}
+
+	t.Run("deep nesting does not recurse unbounded", func(t *testing.T) {
+		var system interface{} = "too deep"
+		for i := 0; i < 10000; i++ {
+			system = []interface{}{system}
+		}
+		if result := extractSystemText(system); result != "" {
+			t.Errorf("extractSystemText() = %q, want text beyond the depth limit dropped", result)
+		}
+	})
 }
 
 // TestMapModel tests model routing logic
@@ -219,8 +291,9 @@ func TestConvertRequest(t *testing.T) {
 			t.Errorf("MaxCompletionTokens = %d, want 1000", openaiReq.MaxCompletionTokens)
 		}
 
-		if *openaiReq.Temperature != temp {
-			t.Errorf("Temperature = %f, want %f", *openaiReq.Temperature, temp)
+		// gpt-5 is a reasoning model - temperature is not supported and must be dropped
+		if openaiReq.Temperature != nil {
+			t.Errorf("Temperature = %f, want nil for reasoning model", *openaiReq.Temperature)
 		}
 	})
 
@@ -333,13 +406,13 @@ func TestConvertResponse(t *testing.T) {
 			},
 		}
 
-		claudeResp, err := ConvertResponse(openaiResp, "claude-sonnet-4-20250514")
+		claudeResp, err := ConvertResponse(openaiResp, "claude-sonnet-4-20250514", &config.Config{})
 		if err != nil {
 			t.Fatalf("ConvertResponse() error = %v", err)
 		}
 
-		if claudeResp.ID != "chatcmpl-123" {
-			t.Errorf("ID = %q, want %q", claudeResp.ID, "chatcmpl-123")
+		if !strings.HasPrefix(claudeResp.ID, "msg_") {
+			t.Errorf("ID = %q, want a proxy-generated msg_ ID", claudeResp.ID)
 		}
 
 		if claudeResp.Model != "claude-sonnet-4-20250514" {
@@ -408,7 +481,7 @@ func TestConvertResponse(t *testing.T) {
 			},
 		}
 
-		claudeResp, err := ConvertResponse(openaiResp, "claude-sonnet-4-20250514")
+		claudeResp, err := ConvertResponse(openaiResp, "claude-sonnet-4-20250514", &config.Config{})
 		if err != nil {
 			t.Fatalf("ConvertResponse() error = %v", err)
 		}
@@ -437,46 +510,78 @@ func TestConvertResponse(t *testing.T) {
 
 // TestConvertFinishReason tests finish reason mapping
 func TestConvertFinishReason(t *testing.T) {
-	tests := []struct {
+	type finishReasonCase struct {
 		openaiReason string
 		claudeReason string
-	}{
-		{"stop", "end_turn"},
-		{"length", "max_tokens"},
-		{"tool_calls", "tool_use"},
-		{"content_filter", "end_turn"},
-		{"unknown", "end_turn"},
 	}
-
-	for _, tt := range tests {
-		t.Run(tt.openaiReason, func(t *testing.T) {
-			// Create a mock response to test the conversion
-			openaiResp := &models.OpenAIResponse{
-				ID: "test",
-				Choices: []models.OpenAIChoice{
-					{
-						Index: 0,
-						Message: models.OpenAIMessage{
-							Role:    "assistant",
-							Content: "test",
+	run := func(t *testing.T, cfg *config.Config, tests []finishReasonCase) {
+		for _, tt := range tests {
+			t.Run(tt.openaiReason, func(t *testing.T) {
+				// Create a mock response to test the conversion
+				openaiResp := &models.OpenAIResponse{
+					ID: "test",
+					Choices: []models.OpenAIChoice{
+						{
+							Index: 0,
+							Message: models.OpenAIMessage{
+								Role:    "assistant",
+								Content: "test",
+							},
+							FinishReason: &tt.openaiReason,
 						},
-						FinishReason: &tt.openaiReason,
 					},
-				},
-				Usage: models.OpenAIUsage{},
-			}
+					Usage: models.OpenAIUsage{},
+				}
+				// tool_use is only reported alongside a tool call
+				if tt.claudeReason == "tool_use" {
+					toolCall := models.OpenAIToolCall{ID: "call_1", Type: "function"}
+					toolCall.Function.Name = "get_weather"
+					toolCall.Function.Arguments = `{}`
+					openaiResp.Choices[0].Message.ToolCalls = []models.OpenAIToolCall{toolCall}
+				}
+
+				claudeResp, err := ConvertResponse(openaiResp, "test-model", cfg)
+				if err != nil {
+					t.Fatalf("ConvertResponse() error = %v", err)
+				}
+
+				if *claudeResp.StopReason != tt.claudeReason {
+					t.Errorf("finish reason %q mapped to %q, want %q",
+						tt.openaiReason, *claudeResp.StopReason, tt.claudeReason)
+				}
+			})
+		}
+	}
 
-			claudeResp, err := ConvertResponse(openaiResp, "test-model")
-			if err != nil {
-				t.Fatalf("ConvertResponse() error = %v", err)
-			}
+	t.Run("defaults", func(t *testing.T) {
+		run(t, &config.Config{}, []finishReasonCase{
+			{"stop", "end_turn"},
+			{"length", "max_tokens"},
+			{"tool_calls", "tool_use"},
+			{"function_call", "tool_use"},
+			{"content_filter", "end_turn"},
+			{"unknown", "end_turn"},
+		})
+	})
 
-			if *claudeResp.StopReason != tt.claudeReason {
-				t.Errorf("finish reason %q mapped to %q, want %q",
-					tt.openaiReason, *claudeResp.StopReason, tt.claudeReason)
-			}
+	// Custom FINISH_REASON_MAP entries, including an override of a default
+	t.Run("FINISH_REASON_MAP", func(t *testing.T) {
+		cfg := &config.Config{
+			FinishReasonMap: map[string]string{
+				"eos":            "end_turn",
+				"max_length":     "max_tokens",
+				"safety":         "refusal",
+				"content_filter": "refusal",
+			},
+		}
+		run(t, cfg, []finishReasonCase{
+			{"eos", "end_turn"},
+			{"max_length", "max_tokens"},
+			{"safety", "refusal"},
+			{"content_filter", "refusal"},
+			{"stop", "end_turn"},
 		})
-	}
+	})
 }
 
 // TestConvertMessagesWithComplexContent tests message conversion with arrays
@@ -498,7 +603,7 @@ func TestConvertMessagesWithComplexContent(t *testing.T) {
 			},
 		}
 
-		result := convertMessages(messages, "")
+		result := convertMessages(messages, "", &config.Config{})
 
 		if len(result) != 1 {
 			t.Fatalf("Expected 1 message, got %d", len(result))
@@ -529,7 +634,7 @@ func TestConvertMessagesWithComplexContent(t *testing.T) {
 			},
 		}
 
-		result := convertMessages(messages, "")
+		result := convertMessages(messages, "", &config.Config{})
 
 		if len(result) != 1 {
 			t.Fatalf("Expected 1 message, got %d", len(result))
@@ -570,7 +675,7 @@ func TestConvertMessagesWithComplexContent(t *testing.T) {
 			},
 		}
 
-		result := convertMessages(messages, "")
+		result := convertMessages(messages, "", &config.Config{})
 
 		if len(result) != 1 {
 			t.Fatalf("Expected 1 message, got %d", len(result))
@@ -623,7 +728,7 @@ func TestConvertMessagesWithComplexContent(t *testing.T) {
 			},
 		}
 
-		result := convertMessages(messages, "")
+		result := convertMessages(messages, "", &config.Config{})
 
 		if len(result) != 1 {
 			t.Fatalf("Expected 1 message, got %d", len(result))
@@ -679,7 +784,7 @@ func TestConvertMessagesWithComplexContent(t *testing.T) {
 			},
 		}
 
-		result := convertMessages(messages, "")
+		result := convertMessages(messages, "", &config.Config{})
 
 		if len(result) != 2 {
 			t.Fatalf("Expected 2 messages, got %d", len(result))
@@ -742,7 +847,7 @@ func TestConvertMessagesWithComplexContent(t *testing.T) {
 			},
 		}
 
-		result := convertMessages(messages, "")
+		result := convertMessages(messages, "", &config.Config{})
 
 		if len(result) != 1 {
 			t.Fatalf("Expected 1 message, got %d", len(result))
@@ -762,6 +867,1074 @@ func TestConvertMessagesWithComplexContent(t *testing.T) {
 	})
 }
 
+// TestConvertThinkingOnlyAssistantTurn tests history with an assistant turn that only has thinking
+func TestConvertThinkingOnlyAssistantTurn(t *testing.T) {
+	messages := []models.ClaudeMessage{
+		{Role: "user", Content: "What is 2+2?"},
+		{
+			Role: "assistant",
+			Content: []interface{}{
+				map[string]interface{}{
+					"type":      "thinking",
+					"thinking":  "The user wants simple arithmetic.",
+					"signature": "sig",
+				},
+			},
+		},
+		{Role: "user", Content: "Please answer."},
+	}
+
+	t.Run("OpenRouter carries reasoning", func(t *testing.T) {
+		cfg := &config.Config{OpenAIBaseURL: "https://openrouter.ai/api/v1"}
+		result := convertMessages(messages, "", cfg)
+
+		if len(result) != 3 {
+			t.Fatalf("Expected 3 messages, got %d", len(result))
+		}
+
+		assistant := result[1]
+		if assistant.Role != "assistant" {
+			t.Fatalf("Role = %q, want %q", assistant.Role, "assistant")
+		}
+		if len(assistant.ReasoningDetails) != 1 {
+			t.Fatalf("Expected 1 reasoning detail, got %d", len(assistant.ReasoningDetails))
+		}
+		detail := assistant.ReasoningDetails[0].(map[string]interface{})
+		if detail["type"] != "reasoning.text" || detail["text"] != "The user wants simple arithmetic." {
+			t.Errorf("Unexpected reasoning detail: %v", detail)
+		}
+	})
+
+	t.Run("other providers keep role alternation", func(t *testing.T) {
+		cfg := &config.Config{OpenAIBaseURL: "https://api.openai.com/v1"}
+		result := convertMessages(messages, "", cfg)
+
+		if len(result) != 3 {
+			t.Fatalf("Expected 3 messages, got %d", len(result))
+		}
+
+		roles := []string{result[0].Role, result[1].Role, result[2].Role}
+		if roles[0] != "user" || roles[1] != "assistant" || roles[2] != "user" {
+			t.Errorf("Roles = %v, want [user assistant user]", roles)
+		}
+		if content, ok := result[1].Content.(string); !ok || content != "" {
+			t.Errorf("Placeholder content = %v, want empty string", result[1].Content)
+		}
+		if len(result[1].ReasoningDetails) != 0 {
+			t.Error("Non-OpenRouter providers should not receive reasoning_details")
+		}
+	})
+
+	t.Run("other providers skip when alternation is unaffected", func(t *testing.T) {
+		cfg := &config.Config{OpenAIBaseURL: "https://api.openai.com/v1"}
+		trailing := messages[:2] // Thinking-only turn is last
+		result := convertMessages(trailing, "", cfg)
+
+		if len(result) != 1 {
+			t.Fatalf("Expected thinking-only turn to be dropped, got %d messages", len(result))
+		}
+	})
+}
+
+// TestConvertResponseArrayContent tests responses whose content is an array of parts
+func TestConvertResponseArrayContent(t *testing.T) {
+	finishReason := "stop"
+	newResponse := func(content interface{}) *models.OpenAIResponse {
+		return &models.OpenAIResponse{
+			ID: "chatcmpl-arr",
+			Choices: []models.OpenAIChoice{
+				{
+					Message:      models.OpenAIMessage{Role: "assistant", Content: content},
+					FinishReason: &finishReason,
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		content  interface{}
+		expected string
+	}{
+		{
+			name: "text parts concatenated",
+			content: []interface{}{
+				map[string]interface{}{"type": "text", "text": "Hello, "},
+				map[string]interface{}{"type": "output_text", "text": "world!"},
+			},
+			expected: "Hello, world!",
+		},
+		{
+			name: "refusal kept",
+			content: []interface{}{
+				map[string]interface{}{"type": "refusal", "refusal": "I can't help with that."},
+			},
+			expected: "I can't help with that.",
+		},
+		{
+			name: "image part replaced by placeholder",
+			content: []interface{}{
+				map[string]interface{}{"type": "text", "text": "Here is the chart: "},
+				map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "data:image/png;base64,AAA"}},
+			},
+			expected: "Here is the chart: [image_url output omitted]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claudeResp, err := ConvertResponse(newResponse(tt.content), "claude-sonnet-4", &config.Config{})
+			if err != nil {
+				t.Fatalf("ConvertResponse() error = %v", err)
+			}
+
+			if len(claudeResp.Content) != 1 {
+				t.Fatalf("Content length = %d, want 1", len(claudeResp.Content))
+			}
+			if claudeResp.Content[0].Type != "text" || claudeResp.Content[0].Text != tt.expected {
+				t.Errorf("Content = %+v, want text %q", claudeResp.Content[0], tt.expected)
+			}
+		})
+	}
+
+	t.Run("empty array produces no block", func(t *testing.T) {
+		claudeResp, err := ConvertResponse(newResponse([]interface{}{}), "claude-sonnet-4", &config.Config{})
+		if err != nil {
+			t.Fatalf("ConvertResponse() error = %v", err)
+		}
+		if len(claudeResp.Content) != 0 {
+			t.Errorf("Expected no content blocks, got %d", len(claudeResp.Content))
+		}
+	})
+}
+
+// TestConvertResponseMaxTokensDuringToolCall tests that a truncated tool call is kept with max_tokens
+func TestConvertResponseMaxTokensDuringToolCall(t *testing.T) {
+	finishReason := "length"
+	toolCall := models.OpenAIToolCall{ID: "call_1", Type: "function"}
+	toolCall.Function.Name = "write_file"
+	toolCall.Function.Arguments = `{"path":"main.go","content":"package ma`
+
+	resp := &models.OpenAIResponse{
+		ID: "chatcmpl-len",
+		Choices: []models.OpenAIChoice{
+			{
+				Message:      models.OpenAIMessage{Role: "assistant", ToolCalls: []models.OpenAIToolCall{toolCall}},
+				FinishReason: &finishReason,
+			},
+		},
+	}
+
+	claudeResp, err := ConvertResponse(resp, "claude-sonnet-4", &config.Config{})
+	if err != nil {
+		t.Fatalf("ConvertResponse() error = %v", err)
+	}
+
+	if claudeResp.StopReason == nil || *claudeResp.StopReason != "max_tokens" {
+		t.Errorf("StopReason = %v, want max_tokens", claudeResp.StopReason)
+	}
+	if len(claudeResp.Content) != 1 || claudeResp.Content[0].Type != "tool_use" {
+		t.Fatalf("Expected the partial tool_use block to be preserved, got %+v", claudeResp.Content)
+	}
+	if claudeResp.Content[0].ID != "call_1" || claudeResp.Content[0].Input != toolCall.Function.Arguments {
+		t.Errorf("Partial tool_use not preserved: %+v", claudeResp.Content[0])
+	}
+}
+
+// TestCompactToolSerialization tests that compact tools use fewer tokens than full serialization
+func TestCompactToolSerialization(t *testing.T) {
+	longDescription := strings.Repeat("Reads a file from the local filesystem and returns its contents with line numbers. ", 12)
+	tools := []models.Tool{
+		{
+			Name:        "Read",
+			Description: longDescription,
+			InputSchema: map[string]interface{}{
+				"$schema": "http://json-schema.org/draft-07/schema#",
+				"type":    "object",
+				"title":   "ReadInput",
+				"properties": map[string]interface{}{
+					"file_path": map[string]interface{}{
+						"type":        "string",
+						"title":       "File Path",
+						"description": longDescription,
+						"examples":    []interface{}{"/tmp/a.go", "/tmp/b.go"},
+					},
+					"title": map[string]interface{}{"type": "string"}, // property named like a keyword
+				},
+				"required": []interface{}{"file_path"},
+			},
+		},
+	}
+
+	tokens := func(openaiTools []models.OpenAITool) int {
+		raw, err := json.Marshal(openaiTools)
+		if err != nil {
+			t.Fatalf("Failed to marshal tools: %v", err)
+		}
+		return estimateTextTokens(string(raw))
+	}
+
+	full := convertTools(tools, false)
+	compact := convertTools(tools, true)
+
+	if full[0].Function.Description != longDescription {
+		t.Error("Full serialization should keep the description unchanged")
+	}
+	if fullTokens, compactTokens := tokens(full), tokens(compact); compactTokens >= fullTokens/2 {
+		t.Errorf("Compact tools = %d tokens, want well under full = %d", compactTokens, fullTokens)
+	}
+
+	if len(compact[0].Function.Description) > compactDescriptionMax+3 {
+		t.Errorf("Compact description length = %d, want <= %d", len(compact[0].Function.Description), compactDescriptionMax+3)
+	}
+	schema := compact[0].Function.Parameters.(map[string]interface{})
+	if _, ok := schema["$schema"]; ok {
+		t.Error("Compact schema should drop $schema")
+	}
+	props := schema["properties"].(map[string]interface{})
+	if _, ok := props["title"]; !ok {
+		t.Error("Compact schema must keep a property named \"title\"")
+	}
+	if _, ok := props["file_path"].(map[string]interface{})["examples"]; ok {
+		t.Error("Compact schema should drop nested examples")
+	}
+	if _, ok := tools[0].InputSchema.(map[string]interface{})["$schema"]; !ok {
+		t.Error("Compacting must not modify the original schema")
+	}
+
+	t.Run("data-valued keywords are kept verbatim", func(t *testing.T) {
+		defaultValue := map[string]interface{}{
+			"title":       "Untitled",
+			"description": longDescription,
+			"examples":    []interface{}{"a"},
+		}
+		schema := compactSchema(map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"options": map[string]interface{}{
+					"type":    "object",
+					"title":   "Options",
+					"default": defaultValue,
+				},
+				"mode": map[string]interface{}{
+					"enum":  []interface{}{map[string]interface{}{"title": "fast"}, "slow"},
+					"const": map[string]interface{}{"$comment": "kept"},
+				},
+			},
+			"items": []interface{}{map[string]interface{}{"type": "string", "title": "First"}},
+			"anyOf": []interface{}{map[string]interface{}{"title": "Variant", "type": "null"}},
+		}).(map[string]interface{})
+
+		props := schema["properties"].(map[string]interface{})
+		options := props["options"].(map[string]interface{})
+		if _, ok := options["title"]; ok {
+			t.Error("Compact schema should drop the property's title")
+		}
+		if !reflect.DeepEqual(options["default"], defaultValue) {
+			t.Errorf("default = %v, want it unchanged", options["default"])
+		}
+		mode := props["mode"].(map[string]interface{})
+		if !reflect.DeepEqual(mode["enum"], []interface{}{map[string]interface{}{"title": "fast"}, "slow"}) {
+			t.Errorf("enum = %v, want it unchanged", mode["enum"])
+		}
+		if !reflect.DeepEqual(mode["const"], map[string]interface{}{"$comment": "kept"}) {
+			t.Errorf("const = %v, want it unchanged", mode["const"])
+		}
+		for _, key := range []string{"items", "anyOf"} {
+			if _, ok := schema[key].([]interface{})[0].(map[string]interface{})["title"]; ok {
+				t.Errorf("Compact schema should drop titles in %s subschemas", key)
+			}
+		}
+	})
+
+	t.Run("enabled by anthropic-beta flag", func(t *testing.T) {
+		req := models.ClaudeRequest{
+			Model:     "claude-sonnet-4",
+			MaxTokens: 100,
+			Messages:  []models.ClaudeMessage{{Role: "user", Content: "hi"}},
+			Tools:     tools,
+			Betas:     []string{"interleaved-thinking-2025-05-14", " token-efficient-tools-2025-02-19"},
+		}
+		openaiReq, err := ConvertRequest(req, &config.Config{})
+		if err != nil {
+			t.Fatalf("ConvertRequest() error = %v", err)
+		}
+		if openaiReq.Tools[0].Function.Description == longDescription {
+			t.Error("token-efficient-tools beta should enable compact tools")
+		}
+
+		req.Betas = nil
+		openaiReq, _ = ConvertRequest(req, &config.Config{})
+		if openaiReq.Tools[0].Function.Description != longDescription {
+			t.Error("Tools should be fully serialized by default")
+		}
+	})
+}
+
+// TestForceToolMode tests that FORCE_TOOL_MODE only applies when enabled and tools are present
+func TestForceToolMode(t *testing.T) {
+	tools := []models.Tool{{Name: "Read", InputSchema: map[string]interface{}{"type": "object"}}}
+
+	tests := []struct {
+		name             string
+		mode             string
+		tools            []models.Tool
+		wantToolChoice   interface{}
+		wantToolCount    int
+		wantFallbackTool bool
+	}{
+		{"disabled", "", tools, nil, 1, false},
+		{"required", "required", tools, "required", 1, false},
+		{"fallback", "fallback", tools, "required", 2, true},
+		{"required without tools", "required", nil, nil, 0, false},
+		{"fallback without tools", "fallback", nil, nil, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := models.ClaudeRequest{
+				Model:     "claude-sonnet-4",
+				MaxTokens: 100,
+				Messages:  []models.ClaudeMessage{{Role: "user", Content: "hi"}},
+				Tools:     tt.tools,
+			}
+			openaiReq, err := ConvertRequest(req, &config.Config{OpenAIBaseURL: "https://api.openai.com/v1", ForceToolMode: tt.mode})
+			if err != nil {
+				t.Fatalf("ConvertRequest() error = %v", err)
+			}
+
+			if openaiReq.ToolChoice != tt.wantToolChoice {
+				t.Errorf("ToolChoice = %v, want %v", openaiReq.ToolChoice, tt.wantToolChoice)
+			}
+			if len(openaiReq.Tools) != tt.wantToolCount {
+				t.Fatalf("Tool count = %d, want %d", len(openaiReq.Tools), tt.wantToolCount)
+			}
+			hasFallback := tt.wantToolCount > 0 && openaiReq.Tools[len(openaiReq.Tools)-1].Function.Name == FallbackToolName
+			if hasFallback != tt.wantFallbackTool {
+				t.Errorf("Fallback tool injected = %v, want %v", hasFallback, tt.wantFallbackTool)
+			}
+		})
+	}
+}
+
+// TestNoToolsModels tests that NO_TOOLS_MODELS strips tools for listed models only
+func TestNoToolsModels(t *testing.T) {
+	stream := true
+	req := models.ClaudeRequest{
+		Model:     "claude-3-5-haiku",
+		MaxTokens: 100,
+		Stream:    &stream,
+		Messages:  []models.ClaudeMessage{{Role: "user", Content: "List the files"}},
+		Tools:     []models.Tool{{Name: "ls", InputSchema: map[string]interface{}{"type": "object"}}},
+	}
+
+	tests := []struct {
+		model     string
+		wantTools int
+	}{
+		{"tinyllama:1.1b", 0},
+		{"qwen2.5-coder:7b", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			cfg := &config.Config{OpenAIBaseURL: "http://localhost:11434/v1", HaikuModel: tt.model, NoToolsModels: []string{"tinyllama"}}
+			openaiReq, err := ConvertRequest(req, cfg)
+			if err != nil {
+				t.Fatalf("ConvertRequest() error = %v", err)
+			}
+			if len(openaiReq.Tools) != tt.wantTools {
+				t.Errorf("Tool count = %d, want %d", len(openaiReq.Tools), tt.wantTools)
+			}
+			if tt.wantTools == 0 && openaiReq.ToolChoice != nil {
+				t.Errorf("ToolChoice = %v, want none without tools", openaiReq.ToolChoice)
+			}
+		})
+	}
+}
+
+// TestConvertResponseFallbackTool tests that fallback reply tool calls become plain text
+func TestConvertResponseFallbackTool(t *testing.T) {
+	finishReason := "tool_calls"
+	toolCall := models.OpenAIToolCall{ID: "call_1", Type: "function"}
+	toolCall.Function.Name = FallbackToolName
+	toolCall.Function.Arguments = `{"text":"The tests pass."}`
+
+	resp := &models.OpenAIResponse{
+		ID: "chatcmpl-fb",
+		Choices: []models.OpenAIChoice{
+			{
+				Message:      models.OpenAIMessage{Role: "assistant", ToolCalls: []models.OpenAIToolCall{toolCall}},
+				FinishReason: &finishReason,
+			},
+		},
+	}
+
+	claudeResp, err := ConvertResponse(resp, "claude-sonnet-4", &config.Config{})
+	if err != nil {
+		t.Fatalf("ConvertResponse() error = %v", err)
+	}
+	if len(claudeResp.Content) != 1 || claudeResp.Content[0].Type != "text" || claudeResp.Content[0].Text != "The tests pass." {
+		t.Errorf("Expected a single text block, got %+v", claudeResp.Content)
+	}
+	if claudeResp.StopReason == nil || *claudeResp.StopReason != "end_turn" {
+		t.Errorf("StopReason = %v, want end_turn", claudeResp.StopReason)
+	}
+}
+
+// TestConvertResponseToolCallsWithoutTools tests that finish_reason tool_calls without
+// any tool call is reported as end_turn
+func TestConvertResponseToolCallsWithoutTools(t *testing.T) {
+	finishReason := "tool_calls"
+	resp := &models.OpenAIResponse{
+		ID: "chatcmpl-quirk",
+		Choices: []models.OpenAIChoice{
+			{
+				Message:      models.OpenAIMessage{Role: "assistant", Content: "Let me check."},
+				FinishReason: &finishReason,
+			},
+		},
+	}
+
+	claudeResp, err := ConvertResponse(resp, "claude-sonnet-4", &config.Config{})
+	if err != nil {
+		t.Fatalf("ConvertResponse() error = %v", err)
+	}
+	if len(claudeResp.Content) != 1 || claudeResp.Content[0].Type != "text" {
+		t.Errorf("Expected a single text block, got %+v", claudeResp.Content)
+	}
+	if claudeResp.StopReason == nil || *claudeResp.StopReason != "end_turn" {
+		t.Errorf("StopReason = %v, want end_turn", claudeResp.StopReason)
+	}
+}
+
+// TestConvertResponseMissingToolCallIDs tests that tool calls without an ID get unique tool_use IDs
+func TestConvertResponseMissingToolCallIDs(t *testing.T) {
+	finishReason := "tool_calls"
+	var toolCalls []models.OpenAIToolCall
+	for _, name := range []string{"read_file", "list_dir"} {
+		toolCall := models.OpenAIToolCall{Type: "function"}
+		toolCall.Function.Name = name
+		toolCall.Function.Arguments = `{}`
+		toolCalls = append(toolCalls, toolCall)
+	}
+	resp := &models.OpenAIResponse{
+		ID: "chatcmpl-noid",
+		Choices: []models.OpenAIChoice{
+			{
+				Message:      models.OpenAIMessage{Role: "assistant", ToolCalls: toolCalls},
+				FinishReason: &finishReason,
+			},
+		},
+	}
+
+	claudeResp, err := ConvertResponse(resp, "claude-sonnet-4", &config.Config{})
+	if err != nil {
+		t.Fatalf("ConvertResponse() error = %v", err)
+	}
+	if len(claudeResp.Content) != 2 {
+		t.Fatalf("Expected 2 tool_use blocks, got %+v", claudeResp.Content)
+	}
+	first, second := claudeResp.Content[0].ID, claudeResp.Content[1].ID
+	if !strings.HasPrefix(first, "toolu_") || !strings.HasPrefix(second, "toolu_") || first == second {
+		t.Errorf("Expected unique toolu_ IDs, got %q and %q", first, second)
+	}
+}
+
+// TestThinkingBudgetConflict tests THINKING_BUDGET_CONFLICT for a budget above max_tokens
+func TestThinkingBudgetConflict(t *testing.T) {
+	req := models.ClaudeRequest{
+		Model:     "claude-sonnet-4",
+		MaxTokens: 1000,
+		Thinking:  &models.Thinking{Type: "enabled", BudgetTokens: 4000},
+		Messages:  []models.ClaudeMessage{{Role: "user", Content: "Think hard"}},
+	}
+
+	tests := []struct {
+		mode          string
+		wantMaxTokens int
+		wantErr       bool
+	}{
+		{mode: "", wantMaxTokens: 1000},
+		{mode: config.ThinkingBudgetAdjust, wantMaxTokens: 5000},
+		{mode: config.ThinkingBudgetError, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run("mode "+tt.mode, func(t *testing.T) {
+			openaiReq, err := ConvertRequest(req, &config.Config{ThinkingBudgetConflict: tt.mode})
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "thinking.budget_tokens (4000)") {
+					t.Errorf("ConvertRequest() error = %v, want one naming the budget", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ConvertRequest() error = %v", err)
+			}
+			// gpt-5 is a reasoning model, so the limit is sent as max_completion_tokens
+			if openaiReq.MaxCompletionTokens != tt.wantMaxTokens {
+				t.Errorf("MaxCompletionTokens = %d, want %d", openaiReq.MaxCompletionTokens, tt.wantMaxTokens)
+			}
+		})
+	}
+
+	t.Run("budget below max_tokens is unchanged", func(t *testing.T) {
+		fits := req
+		fits.Thinking = &models.Thinking{Type: "enabled", BudgetTokens: 500}
+		openaiReq, err := ConvertRequest(fits, &config.Config{ThinkingBudgetConflict: config.ThinkingBudgetError})
+		if err != nil {
+			t.Fatalf("ConvertRequest() error = %v", err)
+		}
+		if openaiReq.MaxCompletionTokens != 1000 {
+			t.Errorf("MaxCompletionTokens = %d, want 1000", openaiReq.MaxCompletionTokens)
+		}
+	})
+}
+
+// TestConvertResponseToolArgumentNumbers tests that tool argument numbers round-trip exactly
+func TestConvertResponseToolArgumentNumbers(t *testing.T) {
+	finishReason := "tool_calls"
+	toolCall := models.OpenAIToolCall{ID: "call_1", Type: "function"}
+	toolCall.Function.Name = "get_order"
+	toolCall.Function.Arguments = `{"order_id":1234567890123456789,"amount":0.1000000000000000055511151231257827,"nested":{"ids":[9007199254740993]}}`
+
+	resp := &models.OpenAIResponse{
+		ID: "chatcmpl-num",
+		Choices: []models.OpenAIChoice{
+			{
+				Message:      models.OpenAIMessage{Role: "assistant", ToolCalls: []models.OpenAIToolCall{toolCall}},
+				FinishReason: &finishReason,
+			},
+		},
+	}
+
+	claudeResp, err := ConvertResponse(resp, "claude-sonnet-4", &config.Config{})
+	if err != nil {
+		t.Fatalf("ConvertResponse() error = %v", err)
+	}
+
+	input, ok := claudeResp.Content[0].Input.(map[string]interface{})
+	if !ok {
+		t.Fatalf("Input = %T, want a JSON object", claudeResp.Content[0].Input)
+	}
+
+	// Marshal as the client would receive it and check the digits survived
+	raw, err := json.Marshal(claudeResp.Content[0])
+	if err != nil {
+		t.Fatalf("Failed to marshal block: %v", err)
+	}
+	for _, want := range []string{"1234567890123456789", "0.1000000000000000055511151231257827", "9007199254740993"} {
+		if !strings.Contains(string(raw), want) {
+			t.Errorf("Marshaled input %s lost precision of %s", raw, want)
+		}
+	}
+	if strings.Contains(string(raw), "e+18") {
+		t.Errorf("Large integer was converted to float: %s", raw)
+	}
+	if input["order_id"] != json.Number("1234567890123456789") {
+		t.Errorf("order_id = %v (%T), want json.Number", input["order_id"], input["order_id"])
+	}
+
+	t.Run("empty arguments", func(t *testing.T) {
+		if input, ok := parseToolArguments("").(map[string]interface{}); !ok || len(input) != 0 {
+			t.Errorf("Empty arguments should become an empty object, got %v", input)
+		}
+	})
+}
+
+// TestCleanReasoningRequest tests that parameters reasoning models reject are never sent
+func TestCleanReasoningRequest(t *testing.T) {
+	temp, topP, penalty, topLogprobs, logprobs := 0.7, 0.9, 0.5, 5, true
+
+	tests := []struct {
+		name      string
+		model     string
+		reasoning bool
+	}{
+		{"o3", "o3", true},
+		{"gpt-5", "gpt-5", true},
+		{"non-reasoning model keeps parameters", "gpt-4o", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &models.OpenAIRequest{
+				Model:            tt.model,
+				MaxTokens:        1000,
+				Temperature:      &temp,
+				TopP:             &topP,
+				PresencePenalty:  &penalty,
+				FrequencyPenalty: &penalty,
+				Logprobs:         &logprobs,
+				TopLogprobs:      &topLogprobs,
+			}
+			if (&config.Config{}).IsReasoningModel(tt.model) != tt.reasoning {
+				t.Fatalf("IsReasoningModel(%q) mismatch", tt.model)
+			}
+			if tt.reasoning {
+				CleanReasoningRequest(req)
+			}
+
+			raw, err := json.Marshal(req)
+			if err != nil {
+				t.Fatalf("Failed to marshal request: %v", err)
+			}
+			for _, field := range []string{"temperature", "top_p", "presence_penalty", "frequency_penalty", "logprobs", "top_logprobs", `"max_tokens"`} {
+				present := strings.Contains(string(raw), field)
+				if tt.reasoning && present {
+					t.Errorf("%s should be absent for %s: %s", field, tt.model, raw)
+				}
+				if !tt.reasoning && !present {
+					t.Errorf("%s should be kept for %s: %s", field, tt.model, raw)
+				}
+			}
+			if tt.reasoning && req.MaxCompletionTokens != 1000 {
+				t.Errorf("MaxCompletionTokens = %d, want 1000", req.MaxCompletionTokens)
+			}
+		})
+	}
+
+	t.Run("applied by ConvertRequest", func(t *testing.T) {
+		openaiReq, err := ConvertRequest(models.ClaudeRequest{
+			Model:       "claude-opus-4",
+			MaxTokens:   500,
+			Messages:    []models.ClaudeMessage{{Role: "user", Content: "hi"}},
+			Temperature: &temp,
+			TopP:        &topP,
+		}, &config.Config{OpusModel: "o3"})
+		if err != nil {
+			t.Fatalf("ConvertRequest() error = %v", err)
+		}
+		if openaiReq.Temperature != nil || openaiReq.TopP != nil || openaiReq.MaxTokens != 0 || openaiReq.MaxCompletionTokens != 500 {
+			t.Errorf("Reasoning request not cleaned: %+v", openaiReq)
+		}
+	})
+}
+
+// TestPenaltyPassthrough tests that configured penalties reach non-reasoning models only
+func TestPenaltyPassthrough(t *testing.T) {
+	frequency, presence := 0.4, -0.2
+
+	tests := []struct {
+		name        string
+		model       string
+		wantForward bool
+	}{
+		{"gpt-4o", "gpt-4o", true},
+		{"gpt-5", "gpt-5", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{SonnetModel: tt.model, FrequencyPenalty: &frequency, PresencePenalty: &presence}
+			openaiReq, err := ConvertRequest(models.ClaudeRequest{
+				Model:     "claude-sonnet-4",
+				MaxTokens: 100,
+				Messages:  []models.ClaudeMessage{{Role: "user", Content: "hi"}},
+			}, cfg)
+			if err != nil {
+				t.Fatalf("ConvertRequest() error = %v", err)
+			}
+
+			if !tt.wantForward {
+				if openaiReq.FrequencyPenalty != nil || openaiReq.PresencePenalty != nil {
+					t.Errorf("Penalties should be stripped for %s", tt.model)
+				}
+				return
+			}
+			if openaiReq.FrequencyPenalty == nil || *openaiReq.FrequencyPenalty != frequency {
+				t.Errorf("FrequencyPenalty = %v, want %v", openaiReq.FrequencyPenalty, frequency)
+			}
+			if openaiReq.PresencePenalty == nil || *openaiReq.PresencePenalty != presence {
+				t.Errorf("PresencePenalty = %v, want %v", openaiReq.PresencePenalty, presence)
+			}
+		})
+	}
+
+	t.Run("unset penalties are not sent", func(t *testing.T) {
+		openaiReq, _ := ConvertRequest(models.ClaudeRequest{
+			Model:     "claude-sonnet-4",
+			MaxTokens: 100,
+			Messages:  []models.ClaudeMessage{{Role: "user", Content: "hi"}},
+		}, &config.Config{SonnetModel: "gpt-4o"})
+		raw, _ := json.Marshal(openaiReq)
+		if strings.Contains(string(raw), "penalty") {
+			t.Errorf("Unset penalties should be omitted: %s", raw)
+		}
+	})
+}
+
+// TestConvertResponseOllamaThinking tests that Ollama's reasoning representations become thinking blocks
+func TestConvertResponseOllamaThinking(t *testing.T) {
+	tests := []struct {
+		name    string
+		message models.OpenAIMessage
+	}{
+		{"native thinking field", models.OpenAIMessage{Role: "assistant", Content: "The answer is 4.", Thinking: "2+2=4"}},
+		{"compat reasoning field", models.OpenAIMessage{Role: "assistant", Content: "The answer is 4.", Reasoning: "2+2=4"}},
+		{"inline think tags", models.OpenAIMessage{Role: "assistant", Content: "<think>2+2=4</think>\nThe answer is 4."}},
+		// OpenRouter sends the same reasoning in both fields: one thinking block
+		{"reasoning with reasoning_details", models.OpenAIMessage{
+			Role:             "assistant",
+			Content:          "The answer is 4.",
+			Reasoning:        "2+2=4",
+			ReasoningDetails: []interface{}{map[string]interface{}{"type": "reasoning.text", "text": "2+2=4"}},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			finishReason := "stop"
+			openaiResp := &models.OpenAIResponse{
+				ID:      "chatcmpl-ollama",
+				Model:   "qwen3",
+				Choices: []models.OpenAIChoice{{Message: tt.message, FinishReason: &finishReason}},
+			}
+
+			resp, err := ConvertResponse(openaiResp, "claude-sonnet-4", &config.Config{})
+			if err != nil {
+				t.Fatalf("ConvertResponse() error = %v", err)
+			}
+			if len(resp.Content) != 2 {
+				t.Fatalf("Expected thinking and text blocks, got %+v", resp.Content)
+			}
+			if resp.Content[0].Type != "thinking" || resp.Content[0].Thinking != "2+2=4" {
+				t.Errorf("Content[0] = %+v, want thinking block with 2+2=4", resp.Content[0])
+			}
+			if resp.Content[1].Type != "text" || resp.Content[1].Text != "The answer is 4." {
+				t.Errorf("Content[1] = %+v, want text block", resp.Content[1])
+			}
+		})
+	}
+}
+
+// TestConvertResponseOpenAIThinkTagsPassThrough tests that OpenAI content starting with <think> stays text
+func TestConvertResponseOpenAIThinkTagsPassThrough(t *testing.T) {
+	finishReason := "stop"
+	openaiResp := &models.OpenAIResponse{
+		ID:    "chatcmpl-openai",
+		Model: "gpt-5",
+		Choices: []models.OpenAIChoice{{
+			Message:      models.OpenAIMessage{Role: "assistant", Content: "<think>literal</think> answer"},
+			FinishReason: &finishReason,
+		}},
+	}
+
+	resp, err := ConvertResponse(openaiResp, "claude-sonnet-4", &config.Config{OpenAIBaseURL: "https://api.openai.com/v1"})
+	if err != nil {
+		t.Fatalf("ConvertResponse() error = %v", err)
+	}
+	if len(resp.Content) != 1 || resp.Content[0].Type != "text" || resp.Content[0].Text != "<think>literal</think> answer" {
+		t.Errorf("Content = %+v, want the unchanged text block", resp.Content)
+	}
+}
+
+// TestConvertResponseThinkingAsText tests THINKING_AS_TEXT against the default thinking blocks
+func TestConvertResponseThinkingAsText(t *testing.T) {
+	convert := func(message models.OpenAIMessage, cfg *config.Config) []models.ContentBlock {
+		t.Helper()
+		finishReason := "stop"
+		resp, err := ConvertResponse(&models.OpenAIResponse{
+			ID:      "chatcmpl-think",
+			Choices: []models.OpenAIChoice{{Message: message, FinishReason: &finishReason}},
+		}, "claude-sonnet-4", cfg)
+		if err != nil {
+			t.Fatalf("ConvertResponse() error = %v", err)
+		}
+		return resp.Content
+	}
+	message := models.OpenAIMessage{Role: "assistant", Content: "The answer is 4.", Reasoning: "2+2=4"}
+
+	t.Run("default keeps the thinking block", func(t *testing.T) {
+		content := convert(message, &config.Config{})
+		if len(content) != 2 || content[0].Type != "thinking" || content[1].Text != "The answer is 4." {
+			t.Errorf("content = %+v, want thinking and text blocks", content)
+		}
+	})
+
+	t.Run("enabled prepends the reasoning to the text", func(t *testing.T) {
+		content := convert(message, &config.Config{ThinkingAsText: true})
+		want := "<thinking>\n2+2=4\n</thinking>\n\nThe answer is 4."
+		if len(content) != 1 || content[0].Type != "text" || content[0].Text != want {
+			t.Errorf("content = %+v, want one text block %q", content, want)
+		}
+	})
+
+	t.Run("enabled without text adds a text block", func(t *testing.T) {
+		toolOnly := models.OpenAIMessage{Role: "assistant", Reasoning: "Need the files", ToolCalls: []models.OpenAIToolCall{
+			{ID: "call_1", Type: "function"},
+		}}
+		toolOnly.ToolCalls[0].Function.Name = "ls"
+		toolOnly.ToolCalls[0].Function.Arguments = "{}"
+
+		content := convert(toolOnly, &config.Config{ThinkingAsText: true})
+		if len(content) != 2 || content[0].Text != "<thinking>\nNeed the files\n</thinking>" || content[1].Type != "tool_use" {
+			t.Errorf("content = %+v, want a reasoning text block before the tool_use", content)
+		}
+	})
+}
+
+// TestMaxResponseBlocks tests that MAX_RESPONSE_BLOCKS caps the content blocks with a notice
+func TestMaxResponseBlocks(t *testing.T) {
+	finish := "tool_calls"
+	openaiResp := &models.OpenAIResponse{
+		ID: "test",
+		Choices: []models.OpenAIChoice{{
+			Message:      models.OpenAIMessage{Role: "assistant", Content: "Reading files"},
+			FinishReason: &finish,
+		}},
+	}
+	for i := 0; i < 10; i++ {
+		toolCall := models.OpenAIToolCall{ID: fmt.Sprintf("call_%d", i), Type: "function"}
+		toolCall.Function.Name = "read"
+		toolCall.Function.Arguments = `{}`
+		openaiResp.Choices[0].Message.ToolCalls = append(openaiResp.Choices[0].Message.ToolCalls, toolCall)
+	}
+
+	t.Run("over the limit", func(t *testing.T) {
+		claudeResp, err := ConvertResponse(openaiResp, "test-model", &config.Config{MaxResponseBlocks: 4})
+		if err != nil {
+			t.Fatalf("ConvertResponse() error = %v", err)
+		}
+		if len(claudeResp.Content) != 4 {
+			t.Fatalf("Expected 4 content blocks, got %d", len(claudeResp.Content))
+		}
+		if claudeResp.Content[0].Type != "text" || claudeResp.Content[2].Type != "tool_use" || claudeResp.Content[2].ID != "call_1" {
+			t.Errorf("Expected the text and the first tool calls to be kept, got %+v", claudeResp.Content[:3])
+		}
+		if notice := claudeResp.Content[3]; notice.Type != "text" || notice.Text != OmittedBlocksNotice(8, 4) {
+			t.Errorf("Expected the omitted blocks notice last, got %+v", notice)
+		}
+		if *claudeResp.StopReason != "tool_use" {
+			t.Errorf("stop_reason = %q, want tool_use for the kept tool calls", *claudeResp.StopReason)
+		}
+	})
+
+	t.Run("cap drops every tool call", func(t *testing.T) {
+		withThinking := *openaiResp
+		withThinking.Choices = []models.OpenAIChoice{openaiResp.Choices[0]}
+		withThinking.Choices[0].Message.Reasoning = "Which files?"
+		claudeResp, err := ConvertResponse(&withThinking, "test-model", &config.Config{MaxResponseBlocks: 2})
+		if err != nil {
+			t.Fatalf("ConvertResponse() error = %v", err)
+		}
+		var types []string
+		for _, block := range claudeResp.Content {
+			types = append(types, block.Type)
+		}
+		if strings.Join(types, ",") != "thinking,text" {
+			t.Fatalf("content = %v, want thinking and the notice", types)
+		}
+		if *claudeResp.StopReason != "end_turn" {
+			t.Errorf("stop_reason = %q, want end_turn without a tool_use block", *claudeResp.StopReason)
+		}
+	})
+
+	t.Run("no cap", func(t *testing.T) {
+		claudeResp, err := ConvertResponse(openaiResp, "test-model", &config.Config{})
+		if err != nil {
+			t.Fatalf("ConvertResponse() error = %v", err)
+		}
+		if len(claudeResp.Content) != 11 {
+			t.Errorf("Expected all 11 content blocks, got %d", len(claudeResp.Content))
+		}
+	})
+}
+
+// TestNullContentToolCallRoundTrip tests that an upstream tool call reply with null
+// content survives being replayed as history
+func TestNullContentToolCallRoundTrip(t *testing.T) {
+	finish := "tool_calls"
+	toolCall := models.OpenAIToolCall{ID: "call_1", Type: "function"}
+	toolCall.Function.Name = "read_file"
+	toolCall.Function.Arguments = `{"path":"main.go"}`
+	openaiResp := &models.OpenAIResponse{
+		ID: "chatcmpl-1",
+		Choices: []models.OpenAIChoice{{
+			Message:      models.OpenAIMessage{Role: "assistant", Content: nil, ToolCalls: []models.OpenAIToolCall{toolCall}},
+			FinishReason: &finish,
+		}},
+	}
+	claudeResp, err := ConvertResponse(openaiResp, "claude-sonnet-4", &config.Config{})
+	if err != nil {
+		t.Fatalf("ConvertResponse() error = %v", err)
+	}
+
+	// Replay the reply the way the client sends it back: JSON-decoded blocks
+	raw, _ := json.Marshal(claudeResp.Content)
+	var blocks []interface{}
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{OpenAIBaseURL: "https://api.openai.com/v1"}
+	messages := convertMessages([]models.ClaudeMessage{
+		{Role: "user", Content: "Read main.go"},
+		{Role: "assistant", Content: blocks},
+		{Role: "user", Content: []interface{}{
+			map[string]interface{}{"type": "tool_result", "tool_use_id": "call_1", "content": "package main"},
+		}},
+	}, "", cfg)
+
+	if len(messages) != 3 {
+		t.Fatalf("Expected user, assistant and tool messages, got %+v", messages)
+	}
+	assistant := messages[1]
+	if assistant.Role != "assistant" || len(assistant.ToolCalls) != 1 {
+		t.Fatalf("assistant message = %+v, want one tool call", assistant)
+	}
+	if got := assistant.ToolCalls[0]; got.ID != "call_1" || got.Function.Name != "read_file" || got.Function.Arguments != `{"path":"main.go"}` {
+		t.Errorf("tool call = %+v, want the original call", got)
+	}
+	if text, _ := assistant.Content.(string); text != "" {
+		t.Errorf("assistant content = %q, want no text", text)
+	}
+	if messages[2].Role != "tool" || messages[2].ToolCallID != "call_1" {
+		t.Errorf("tool message = %+v, want the result for call_1", messages[2])
+	}
+
+	// A client replaying OpenAI-shaped history sends the turn with null content and
+	// the calls in tool_calls; the calls are kept
+	var history struct {
+		Messages []models.ClaudeMessage `json:"messages"`
+	}
+	err = json.Unmarshal([]byte(`{"messages":[
+		{"role":"user","content":"Read main.go"},
+		{"role":"assistant","content":null,"tool_calls":[{"id":"call_1","type":"function","function":{"name":"read_file","arguments":"{\"path\":\"main.go\"}"}}]},
+		{"role":"user","content":[{"type":"tool_result","tool_use_id":"call_1","content":"package main"}]}
+	]}`), &history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := convertMessages(history.Messages, "", cfg)
+	encoded, _ := json.Marshal(replayed[1])
+	if want := `{"role":"assistant","tool_calls":[{"id":"call_1","type":"function","function":{"name":"read_file","arguments":"{\"path\":\"main.go\"}"}}]}`; string(encoded) != want {
+		t.Errorf("OpenAI-shaped turn = %s, want %s", encoded, want)
+	}
+	if orphans := findOrphanedToolResults(history.Messages); len(orphans) != 0 {
+		t.Errorf("orphaned tool results = %v, want the result to match tool_calls", orphans)
+	}
+
+	// A null turn without tool calls would be an empty assistant message, which
+	// providers reject: it is dropped, or kept with empty content between two
+	// user turns
+	if got := convertMessages([]models.ClaudeMessage{{Role: "user", Content: "hi"}, {Role: "assistant", Content: nil}}, "", cfg); len(got) != 1 {
+		t.Errorf("trailing empty turn = %+v, want it dropped", got)
+	}
+	between := convertMessages([]models.ClaudeMessage{
+		{Role: "user", Content: "hi"},
+		{Role: "assistant", Content: nil},
+		{Role: "user", Content: "hello?"},
+	}, "", cfg)
+	if encoded, _ := json.Marshal(between[1]); len(between) != 3 || string(encoded) != `{"role":"assistant","content":""}` {
+		t.Errorf("empty turn between user turns = %s, want empty content", encoded)
+	}
+}
+
+// TestTierMaxTokensCap tests that MAX_TOKENS_<TIER> clamps max_tokens per Claude tier
+func TestTierMaxTokensCap(t *testing.T) {
+	cfg := &config.Config{
+		OpenAIBaseURL:   "https://api.openai.com/v1",
+		HaikuModel:      "gpt-4o-mini",
+		SonnetModel:     "gpt-4o",
+		OpusModel:       "gpt-5",
+		MaxTokensHaiku:  1024,
+		MaxTokensSonnet: 8192,
+	}
+
+	tests := []struct {
+		name                    string
+		model                   string
+		maxTokens               int
+		wantMaxTokens           int
+		wantMaxCompletionTokens int
+	}{
+		{"haiku clamped", "claude-haiku-4", 4096, 1024, 0},
+		{"haiku under cap unchanged", "claude-haiku-4", 512, 512, 0},
+		{"haiku without max_tokens gets cap", "claude-haiku-4", 0, 1024, 0},
+		{"sonnet clamped", "claude-sonnet-4", 32000, 8192, 0},
+		{"opus uncapped", "claude-opus-4", 32000, 0, 32000}, // gpt-5 is a reasoning model
+		{"non-Claude model uncapped", "gpt-4o", 32000, 32000, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := ConvertRequest(models.ClaudeRequest{
+				Model:     tt.model,
+				MaxTokens: tt.maxTokens,
+				Messages:  []models.ClaudeMessage{{Role: "user", Content: "hi"}},
+			}, cfg)
+			if err != nil {
+				t.Fatalf("ConvertRequest() error = %v", err)
+			}
+			if req.MaxTokens != tt.wantMaxTokens || req.MaxCompletionTokens != tt.wantMaxCompletionTokens {
+				t.Errorf("max_tokens = %d, max_completion_tokens = %d, want %d and %d",
+					req.MaxTokens, req.MaxCompletionTokens, tt.wantMaxTokens, tt.wantMaxCompletionTokens)
+			}
+		})
+	}
+
+	t.Run("cap applies to reasoning model's max_completion_tokens", func(t *testing.T) {
+		capped := *cfg
+		capped.MaxTokensOpus = 16000
+		req, err := ConvertRequest(models.ClaudeRequest{
+			Model:     "claude-opus-4",
+			MaxTokens: 32000,
+			Messages:  []models.ClaudeMessage{{Role: "user", Content: "hi"}},
+		}, &capped)
+		if err != nil {
+			t.Fatalf("ConvertRequest() error = %v", err)
+		}
+		if req.MaxTokens != 0 || req.MaxCompletionTokens != 16000 {
+			t.Errorf("max_tokens = %d, max_completion_tokens = %d, want 0 and 16000", req.MaxTokens, req.MaxCompletionTokens)
+		}
+	})
+}
+
+// TestConvertToolUseStringInput tests that tool_use input sent as a pre-serialized
+// JSON string reaches the upstream as-is instead of double-encoded
+func TestConvertToolUseStringInput(t *testing.T) {
+	tests := []struct {
+		name  string
+		input interface{}
+		want  string
+	}{
+		{"object", map[string]interface{}{"command": "ls -la"}, `{"command":"ls -la"}`},
+		{"pre-serialized JSON string", `{"command":"ls -la","args":["-1"]}`, `{"command":"ls -la","args":["-1"]}`},
+		{"plain string", "ls -la", `"ls -la"`},
+		{"missing", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := models.ClaudeRequest{
+				Model:     "claude-sonnet-4",
+				MaxTokens: 100,
+				Messages: []models.ClaudeMessage{
+					{Role: "user", Content: "list files"},
+					{Role: "assistant", Content: []interface{}{
+						map[string]interface{}{"type": "tool_use", "id": "toolu_1", "name": "bash", "input": tt.input},
+					}},
+				},
+			}
+
+			openaiReq, err := ConvertRequest(req, &config.Config{})
+			if err != nil {
+				t.Fatalf("ConvertRequest() error = %v", err)
+			}
+
+			var toolCalls []models.OpenAIToolCall
+			for _, msg := range openaiReq.Messages {
+				toolCalls = append(toolCalls, msg.ToolCalls...)
+			}
+			if len(toolCalls) != 1 {
+				t.Fatalf("Expected 1 tool call, got %d", len(toolCalls))
+			}
+			if got := toolCalls[0].Function.Arguments; got != tt.want {
+				t.Errorf("Arguments = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
 // Benchmark tests
 func BenchmarkExtractSystemText(b *testing.B) {
 	system := []interface{}{
@@ -824,6 +1997,6 @@ func BenchmarkConvertResponse(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		ConvertResponse(openaiResp, "claude-sonnet-4-20250514")
+		ConvertResponse(openaiResp, "claude-sonnet-4-20250514", &config.Config{})
 	}
 }
@@ -2,6 +2,8 @@ package converter
 
 import (
 	"encoding/json"
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/claude-code-proxy/proxy/internal/config"
@@ -260,7 +262,7 @@ func TestResponseConversionWithReasoning(t *testing.T) {
 			},
 		}
 
-		claudeResp, err := ConvertResponse(openaiResp, "claude-sonnet-4-5-20250805")
+		claudeResp, err := ConvertResponse(openaiResp, "claude-sonnet-4-5-20250805", &config.Config{})
 		if err != nil {
 			t.Fatalf("ConvertResponse() error = %v", err)
 		}
@@ -350,6 +352,463 @@ func TestProviderIsolationInPractice(t *testing.T) {
 	}
 }
 
+// TestPromptCacheKey tests prompt_cache_key forwarding for OpenAI Direct
+func TestPromptCacheKey(t *testing.T) {
+	newRequest := func(system string) models.ClaudeRequest {
+		return models.ClaudeRequest{
+			Model:     "claude-sonnet-4-5-20250805",
+			MaxTokens: 100,
+			System:    system,
+			Messages: []models.ClaudeMessage{
+				{Role: "user", Content: "test"},
+			},
+		}
+	}
+
+	t.Run("configured key forwarded for OpenAI", func(t *testing.T) {
+		cfg := &config.Config{
+			OpenAIBaseURL:  "https://api.openai.com/v1",
+			PromptCacheKey: "my-project",
+		}
+
+		openaiReq, err := ConvertRequest(newRequest("You are Claude Code"), cfg)
+		if err != nil {
+			t.Fatalf("ConvertRequest() error = %v", err)
+		}
+		if openaiReq.PromptCacheKey != "my-project" {
+			t.Errorf("PromptCacheKey = %q, want %q", openaiReq.PromptCacheKey, "my-project")
+		}
+	})
+
+	t.Run("derived key is stable across identical system prompts", func(t *testing.T) {
+		cfg := &config.Config{OpenAIBaseURL: "https://api.openai.com/v1"}
+
+		first, _ := ConvertRequest(newRequest("You are Claude Code"), cfg)
+		second, _ := ConvertRequest(newRequest("You are Claude Code"), cfg)
+		other, _ := ConvertRequest(newRequest("You are a different agent"), cfg)
+
+		if first.PromptCacheKey == "" {
+			t.Fatal("Expected a derived PromptCacheKey")
+		}
+		if first.PromptCacheKey != second.PromptCacheKey {
+			t.Errorf("Derived keys differ for identical system prompts: %q vs %q", first.PromptCacheKey, second.PromptCacheKey)
+		}
+		if first.PromptCacheKey == other.PromptCacheKey {
+			t.Error("Different system prompts should derive different keys")
+		}
+	})
+
+	t.Run("no system prompt and no configured key", func(t *testing.T) {
+		cfg := &config.Config{OpenAIBaseURL: "https://api.openai.com/v1"}
+		openaiReq, _ := ConvertRequest(newRequest(""), cfg)
+		if openaiReq.PromptCacheKey != "" {
+			t.Errorf("PromptCacheKey = %q, want empty", openaiReq.PromptCacheKey)
+		}
+	})
+
+	t.Run("not forwarded for other providers", func(t *testing.T) {
+		for _, baseURL := range []string{"https://openrouter.ai/api/v1", "http://localhost:11434/v1"} {
+			cfg := &config.Config{OpenAIBaseURL: baseURL, PromptCacheKey: "my-project"}
+			openaiReq, _ := ConvertRequest(newRequest("You are Claude Code"), cfg)
+			if openaiReq.PromptCacheKey != "" {
+				t.Errorf("PromptCacheKey should not be set for %s, got %q", baseURL, openaiReq.PromptCacheKey)
+			}
+		}
+	})
+}
+
+// TestConversationID tests that a session keeps one provider cache key across turns
+func TestConversationID(t *testing.T) {
+	turns := func(conversationID string) []models.ClaudeRequest {
+		first := models.ClaudeRequest{
+			Model:          "claude-sonnet-4-5-20250805",
+			MaxTokens:      100,
+			System:         "You are Claude Code",
+			ConversationID: conversationID,
+			Messages:       []models.ClaudeMessage{{Role: "user", Content: "List the files"}},
+		}
+		second := first
+		second.Messages = append(first.Messages,
+			models.ClaudeMessage{Role: "assistant", Content: "a.go b.go"},
+			models.ClaudeMessage{Role: "user", Content: "Open a.go"},
+		)
+		return []models.ClaudeRequest{first, second}
+	}
+
+	tests := []struct {
+		name           string
+		baseURL        string
+		conversationID string
+		key            func(*models.OpenAIRequest) string
+		want           string
+	}{
+		{
+			name:           "client id as OpenAI prompt_cache_key",
+			baseURL:        "https://api.openai.com/v1",
+			conversationID: "session-1",
+			key:            func(r *models.OpenAIRequest) string { return r.PromptCacheKey },
+			want:           "session-1",
+		},
+		{
+			name:           "client id as OpenRouter metadata",
+			baseURL:        "https://openrouter.ai/api/v1",
+			conversationID: "session-1",
+			key: func(r *models.OpenAIRequest) string {
+				id, _ := r.Metadata["conversation_id"].(string)
+				return id
+			},
+			want: "session-1",
+		},
+		{
+			name:    "derived from the system prompt for OpenRouter",
+			baseURL: "https://openrouter.ai/api/v1",
+			key: func(r *models.OpenAIRequest) string {
+				id, _ := r.Metadata["conversation_id"].(string)
+				return id
+			},
+			want: conversationID("", "You are Claude Code", &config.Config{}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{OpenAIBaseURL: tt.baseURL, PromptCacheKey: "configured"}
+			if tt.conversationID == "" {
+				cfg.PromptCacheKey = ""
+			}
+			for i, req := range turns(tt.conversationID) {
+				openaiReq, err := ConvertRequest(req, cfg)
+				if err != nil {
+					t.Fatalf("ConvertRequest() error = %v", err)
+				}
+				if got := tt.key(openaiReq); got != tt.want {
+					t.Errorf("turn %d key = %q, want %q", i+1, got, tt.want)
+				}
+			}
+		})
+	}
+
+	t.Run("not forwarded to other providers", func(t *testing.T) {
+		cfg := &config.Config{OpenAIBaseURL: "http://localhost:11434/v1"}
+		openaiReq, _ := ConvertRequest(turns("session-1")[0], cfg)
+		if openaiReq.PromptCacheKey != "" || openaiReq.Metadata != nil {
+			t.Errorf("Ollama request has cache key %q, metadata %v", openaiReq.PromptCacheKey, openaiReq.Metadata)
+		}
+	})
+}
+
+// TestOllamaKeepAlive tests that OLLAMA_KEEP_ALIVE is forwarded to Ollama only
+func TestOllamaKeepAlive(t *testing.T) {
+	req := models.ClaudeRequest{
+		Model:     "claude-sonnet-4",
+		MaxTokens: 100,
+		Messages:  []models.ClaudeMessage{{Role: "user", Content: "Hello"}},
+	}
+
+	tests := []struct {
+		name      string
+		baseURL   string
+		keepAlive string
+		want      interface{}
+	}{
+		{"duration for Ollama", "http://localhost:11434/v1", "30m", "30m"},
+		{"seconds for Ollama", "http://localhost:11434/v1", "-1", -1},
+		{"unset for Ollama", "http://localhost:11434/v1", "", nil},
+		{"ignored for OpenRouter", "https://openrouter.ai/api/v1", "30m", nil},
+		{"ignored for OpenAI", "https://api.openai.com/v1", "30m", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openaiReq, err := ConvertRequest(req, &config.Config{OpenAIBaseURL: tt.baseURL, OllamaKeepAlive: tt.keepAlive})
+			if err != nil {
+				t.Fatalf("ConvertRequest() error = %v", err)
+			}
+			if openaiReq.KeepAlive != tt.want {
+				t.Errorf("KeepAlive = %#v, want %#v", openaiReq.KeepAlive, tt.want)
+			}
+		})
+	}
+}
+
+// TestCacheControlForwarding tests that cache_control breakpoints land on the right
+// converted message for OpenRouter
+func TestCacheControlForwarding(t *testing.T) {
+	ephemeral := map[string]interface{}{"type": "ephemeral"}
+	openRouter := &config.Config{OpenAIBaseURL: "https://openrouter.ai/api/v1"}
+
+	// markedParts returns the text and cache_control of each part of a message's content
+	markedParts := func(msg models.OpenAIMessage) []string {
+		parts, ok := msg.Content.([]interface{})
+		if !ok {
+			return nil
+		}
+		var marked []string
+		for _, part := range parts {
+			partMap := part.(map[string]interface{})
+			marked = append(marked, fmt.Sprintf("%v:%v", partMap["text"], partMap["cache_control"] != nil))
+		}
+		return marked
+	}
+
+	t.Run("text breakpoint on the final message", func(t *testing.T) {
+		req := models.ClaudeRequest{
+			Model:     "claude-sonnet-4",
+			MaxTokens: 100,
+			Messages: []models.ClaudeMessage{
+				{Role: "user", Content: "First question"},
+				{Role: "assistant", Content: "First answer"},
+				{Role: "user", Content: []interface{}{
+					map[string]interface{}{"type": "text", "text": "Context", "cache_control": ephemeral},
+					map[string]interface{}{"type": "text", "text": "Second question"},
+				}},
+			},
+		}
+		openaiReq, err := ConvertRequest(req, openRouter)
+		if err != nil {
+			t.Fatalf("ConvertRequest() error = %v", err)
+		}
+		last := openaiReq.Messages[len(openaiReq.Messages)-1]
+		if got := markedParts(last); strings.Join(got, " ") != "Context:true Second question:false" {
+			t.Errorf("final message parts = %v, want the breakpoint on Context", got)
+		}
+		for _, msg := range openaiReq.Messages[:len(openaiReq.Messages)-1] {
+			if _, ok := msg.Content.(string); !ok {
+				t.Errorf("unmarked %s message content = %#v, want a string", msg.Role, msg.Content)
+			}
+		}
+
+		plain, _ := ConvertRequest(req, &config.Config{OpenAIBaseURL: "https://api.openai.com/v1"})
+		if content := plain.Messages[len(plain.Messages)-1].Content; content != "Context\nSecond question" {
+			t.Errorf("OpenAI content = %#v, want the joined text", content)
+		}
+	})
+
+	t.Run("breakpoints survive tool reordering", func(t *testing.T) {
+		req := models.ClaudeRequest{
+			Model:     "claude-sonnet-4",
+			MaxTokens: 100,
+			Messages: []models.ClaudeMessage{
+				{Role: "user", Content: "Read both"},
+				{Role: "assistant", Content: []interface{}{
+					map[string]interface{}{"type": "tool_use", "id": "toolu_a", "name": "read", "input": map[string]interface{}{}},
+					map[string]interface{}{"type": "tool_use", "id": "toolu_b", "name": "read", "input": map[string]interface{}{}},
+				}},
+				{Role: "user", Content: "Also check c"},
+				{Role: "assistant", Content: "Will do."},
+				{Role: "user", Content: []interface{}{
+					map[string]interface{}{"type": "tool_result", "tool_use_id": "toolu_b", "content": "B", "cache_control": ephemeral},
+					map[string]interface{}{"type": "tool_result", "tool_use_id": "toolu_a", "content": "A"},
+				}},
+			},
+		}
+		cfg := &config.Config{OpenAIBaseURL: "https://openrouter.ai/api/v1", NormalizeToolOrder: true}
+		openaiReq, err := ConvertRequest(req, cfg)
+		if err != nil {
+			t.Fatalf("ConvertRequest() error = %v", err)
+		}
+		if openaiReq.Messages[2].Role != "tool" {
+			t.Fatalf("messages[2] = %s, want the moved tool results", openaiReq.Messages[2].Role)
+		}
+		for _, msg := range openaiReq.Messages {
+			if msg.Role != "tool" {
+				continue
+			}
+			if got, want := markedParts(msg) != nil, msg.ToolCallID == "toolu_b"; got != want {
+				t.Errorf("tool message %s marked = %v, want %v", msg.ToolCallID, got, want)
+			}
+		}
+	})
+
+	t.Run("text breakpoint in a tool_result message moves to the last tool message", func(t *testing.T) {
+		req := models.ClaudeRequest{
+			Model:     "claude-sonnet-4",
+			MaxTokens: 100,
+			Messages: []models.ClaudeMessage{
+				{Role: "user", Content: "List files"},
+				{Role: "assistant", Content: []interface{}{
+					map[string]interface{}{"type": "tool_use", "id": "toolu_ls", "name": "ls", "input": map[string]interface{}{}},
+				}},
+				{Role: "user", Content: []interface{}{
+					map[string]interface{}{"type": "tool_result", "tool_use_id": "toolu_ls", "content": "a.go"},
+					map[string]interface{}{"type": "text", "text": "Continue", "cache_control": ephemeral},
+				}},
+			},
+		}
+		openaiReq, err := ConvertRequest(req, openRouter)
+		if err != nil {
+			t.Fatalf("ConvertRequest() error = %v", err)
+		}
+		last := openaiReq.Messages[len(openaiReq.Messages)-1]
+		if got := markedParts(last); last.Role != "tool" || strings.Join(got, " ") != "a.go:true" {
+			t.Errorf("last message = %s %v, want the marked tool result", last.Role, got)
+		}
+	})
+
+	t.Run("tool definition breakpoint", func(t *testing.T) {
+		schema := map[string]interface{}{"type": "object"}
+		req := models.ClaudeRequest{
+			Model:     "claude-sonnet-4",
+			MaxTokens: 100,
+			Messages:  []models.ClaudeMessage{{Role: "user", Content: "List files"}},
+			Tools: []models.Tool{
+				{Name: "read", Description: "Read a file", InputSchema: schema},
+				{Name: "ls", Description: "List a directory", InputSchema: schema, CacheControl: ephemeral},
+			},
+		}
+
+		openaiReq, err := ConvertRequest(req, openRouter)
+		if err != nil {
+			t.Fatalf("ConvertRequest() error = %v", err)
+		}
+		body, _ := json.Marshal(openaiReq.Tools)
+		if got, want := string(body), `"name":"ls","description":"List a directory","parameters":{"type":"object"}},"cache_control":{"type":"ephemeral"}}]`; !strings.HasSuffix(got, want) {
+			t.Errorf("OpenRouter tools = %s, want the breakpoint on the last tool", got)
+		}
+		if openaiReq.Tools[0].CacheControl != nil {
+			t.Errorf("unmarked tool cache_control = %v, want none", openaiReq.Tools[0].CacheControl)
+		}
+
+		plain, _ := ConvertRequest(req, &config.Config{OpenAIBaseURL: "https://api.openai.com/v1"})
+		if body, _ := json.Marshal(plain.Tools); strings.Contains(string(body), "cache_control") {
+			t.Errorf("OpenAI tools = %s, want cache_control stripped", body)
+		}
+	})
+}
+
+// TestClampTemperature tests that temperatures are clamped into the provider's range
+func TestClampTemperature(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         *config.Config
+		temperature float64
+		want        float64
+	}{
+		{"out of range for a 0-1 provider", &config.Config{OpenAIBaseURL: "https://gateway.example.com/v1"}, 1.5, 1},
+		{"in range for a 0-1 provider", &config.Config{OpenAIBaseURL: "https://gateway.example.com/v1"}, 0.7, 0.7},
+		{"in range for OpenAI", &config.Config{OpenAIBaseURL: "https://api.openai.com/v1"}, 1.5, 1.5},
+		{"TEMPERATURE_RANGE override", &config.Config{OpenAIBaseURL: "https://api.openai.com/v1", TemperatureRange: &[2]float64{0.2, 1}}, 0, 0.2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			temperature := tt.temperature
+			req := models.ClaudeRequest{
+				Model:       "claude-3-5-haiku",
+				MaxTokens:   100,
+				Temperature: &temperature,
+				Messages:    []models.ClaudeMessage{{Role: "user", Content: "Hello"}},
+			}
+			tt.cfg.HaikuModel = "gpt-4o-mini"
+			openaiReq, err := ConvertRequest(req, tt.cfg)
+			if err != nil {
+				t.Fatalf("ConvertRequest() error = %v", err)
+			}
+			if openaiReq.Temperature == nil || *openaiReq.Temperature != tt.want {
+				t.Errorf("temperature = %v, want %v", openaiReq.Temperature, tt.want)
+			}
+			if temperature != tt.temperature {
+				t.Error("clamping modified the client's temperature")
+			}
+		})
+	}
+}
+
+// TestSystemTemplate tests that SYSTEM_TEMPLATE_<PROVIDER> wraps the client's system
+// text for the matching provider only
+func TestSystemTemplate(t *testing.T) {
+	templates := map[config.ProviderType]string{config.ProviderOllama: "{{system}}\n\nAlways answer with a tool call."}
+	req := models.ClaudeRequest{
+		Model:     "claude-3-5-haiku",
+		MaxTokens: 100,
+		System:    "You are a coding assistant.",
+		Messages:  []models.ClaudeMessage{{Role: "user", Content: "Hello"}},
+	}
+
+	tests := []struct {
+		name    string
+		baseURL string
+		want    string
+	}{
+		{"matching provider", "http://localhost:11434/v1", "You are a coding assistant.\n\nAlways answer with a tool call."},
+		{"other provider", "https://api.openai.com/v1", "You are a coding assistant."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openaiReq, err := ConvertRequest(req, &config.Config{OpenAIBaseURL: tt.baseURL, HaikuModel: "llama3", SystemTemplates: templates})
+			if err != nil {
+				t.Fatalf("ConvertRequest() error = %v", err)
+			}
+			if system := openaiReq.Messages[0]; system.Role != "system" || system.Content != tt.want {
+				t.Errorf("system message = %+v, want %q", system, tt.want)
+			}
+		})
+	}
+}
+
+// TestUnknownProviderDefaults tests the UNKNOWN_PROVIDER_DEFAULTS treatments for custom gateways
+func TestUnknownProviderDefaults(t *testing.T) {
+	stream := true
+	claudeReq := models.ClaudeRequest{
+		Model:     "claude-sonnet-4",
+		MaxTokens: 1000,
+		System:    "You are helpful",
+		Stream:    &stream,
+		Messages:  []models.ClaudeMessage{{Role: "user", Content: "Hello"}},
+	}
+
+	tests := []struct {
+		name               string
+		defaults           string
+		wantIncludeUsage   bool
+		wantReasoning      bool // OpenRouter reasoning.enabled
+		wantEffort         bool // OpenAI reasoning_effort
+		wantPromptCacheKey bool
+	}{
+		{"default is openai-compatible", "", true, false, false, false},
+		{"openai-compatible", config.UnknownProviderOpenAICompatible, true, false, false, false},
+		{"treat as openai", config.UnknownProviderTreatAsOpenAI, true, false, true, true},
+		{"treat as openrouter", config.UnknownProviderTreatAsOpenRouter, true, true, false, false},
+		{"none", config.UnknownProviderNone, false, false, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				OpenAIBaseURL:           "https://gateway.internal.example/v1",
+				SonnetModel:             "custom-model",
+				UnknownProviderDefaults: tt.defaults,
+			}
+
+			req, err := ConvertRequest(claudeReq, cfg)
+			if err != nil {
+				t.Fatalf("ConvertRequest() error = %v", err)
+			}
+
+			if includeUsage := req.StreamOptions["include_usage"] == true; includeUsage != tt.wantIncludeUsage {
+				t.Errorf("include_usage = %v, want %v", includeUsage, tt.wantIncludeUsage)
+			}
+			if hasReasoning := req.Reasoning != nil; hasReasoning != tt.wantReasoning {
+				t.Errorf("reasoning present = %v, want %v", hasReasoning, tt.wantReasoning)
+			}
+			if hasEffort := req.ReasoningEffort != ""; hasEffort != tt.wantEffort {
+				t.Errorf("reasoning_effort present = %v, want %v", hasEffort, tt.wantEffort)
+			}
+			if hasKey := req.PromptCacheKey != ""; hasKey != tt.wantPromptCacheKey {
+				t.Errorf("prompt_cache_key present = %v, want %v", hasKey, tt.wantPromptCacheKey)
+			}
+		})
+	}
+
+	t.Run("known providers are unaffected", func(t *testing.T) {
+		cfg := &config.Config{
+			OpenAIBaseURL:           "http://localhost:11434/v1",
+			UnknownProviderDefaults: config.UnknownProviderTreatAsOpenRouter,
+		}
+		if provider := cfg.RequestProvider(); provider != config.ProviderOllama {
+			t.Errorf("RequestProvider() = %v, want ollama", provider)
+		}
+	})
+}
+
 // Helper function
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || len(s) > len(substr) && (s[:len(substr)] == substr || s[len(s)-len(substr):] == substr || hasSubstr(s, substr)))
@@ -0,0 +1,275 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// ValidateRequestSchema checks a raw /v1/messages body against the Claude request
+// schema (STRICT_REQUEST_VALIDATION): required fields and their JSON types, role
+// values and the shape of each content block. Unlike decoding into
+// models.ClaudeRequest, every problem is reported with its field path. Unknown
+// fields are allowed. Returns nil when the body matches.
+func ValidateRequestSchema(body []byte) []ValidationIssue {
+	var req map[string]interface{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return []ValidationIssue{{Field: "", Message: fmt.Sprintf("request body must be a JSON object: %v", err)}}
+	}
+
+	v := &schemaValidator{}
+	if model, ok := v.string(req, "model", "model", true); ok && model == "" {
+		v.add("model", "must not be empty")
+	}
+	if maxTokens, ok := v.integer(req, "max_tokens", "max_tokens", true); ok && maxTokens < 1 {
+		v.add("max_tokens", "must be at least 1")
+	}
+	v.number(req, "temperature", "temperature", 0, 1)
+	v.number(req, "top_p", "top_p", 0, 1)
+	v.boolean(req, "stream", "stream")
+
+	if stops, ok := v.array(req, "stop_sequences", "stop_sequences", false); ok {
+		for i, stop := range stops {
+			if _, isString := stop.(string); !isString {
+				v.add(fmt.Sprintf("stop_sequences[%d]", i), "must be a string")
+			}
+		}
+	}
+
+	switch system := req["system"].(type) {
+	case nil, string:
+	case []interface{}:
+		for i, block := range system {
+			v.block(block, fmt.Sprintf("system[%d]", i), map[string]bool{"text": true})
+		}
+	default:
+		v.add("system", "must be a string or an array of text blocks")
+	}
+
+	if messages, ok := v.array(req, "messages", "messages", true); ok {
+		if len(messages) == 0 {
+			v.add("messages", "must contain at least one message")
+		}
+		for i, msg := range messages {
+			v.message(msg, fmt.Sprintf("messages[%d]", i))
+		}
+	}
+
+	if tools, ok := v.array(req, "tools", "tools", false); ok {
+		for i, tool := range tools {
+			field := fmt.Sprintf("tools[%d]", i)
+			toolMap, isObject := tool.(map[string]interface{})
+			if !isObject {
+				v.add(field, "must be an object")
+				continue
+			}
+			if name, ok := v.string(toolMap, "name", field+".name", true); ok && name == "" {
+				v.add(field+".name", "must not be empty")
+			}
+			v.object(toolMap, "input_schema", field+".input_schema", true)
+		}
+	}
+
+	if thinking, ok := v.object(req, "thinking", "thinking", false); ok {
+		switch thinking["type"] {
+		case "enabled":
+			if budget, ok := v.integer(thinking, "budget_tokens", "thinking.budget_tokens", true); ok && budget < 1024 {
+				v.add("thinking.budget_tokens", "must be at least 1024")
+			}
+		case "disabled":
+		default:
+			v.add("thinking.type", `must be "enabled" or "disabled"`)
+		}
+	}
+
+	return v.issues
+}
+
+// messageBlockTypes are the content block types allowed per message role
+var messageBlockTypes = map[string]map[string]bool{
+	"user":      {"text": true, "image": true, "document": true, "tool_result": true},
+	"assistant": {"text": true, "tool_use": true, "thinking": true, "redacted_thinking": true},
+}
+
+// toolResultBlockTypes are the block types allowed inside tool_result content
+var toolResultBlockTypes = map[string]bool{"text": true, "image": true, "document": true}
+
+// schemaValidator collects issues while walking a request body
+type schemaValidator struct {
+	issues []ValidationIssue
+}
+
+func (v *schemaValidator) add(field, format string, args ...interface{}) {
+	v.issues = append(v.issues, ValidationIssue{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// message checks one entry of messages: its role and its content blocks
+func (v *schemaValidator) message(raw interface{}, field string) {
+	msg, ok := raw.(map[string]interface{})
+	if !ok {
+		v.add(field, "must be an object")
+		return
+	}
+	role, _ := v.string(msg, "role", field+".role", true)
+	allowed, knownRole := messageBlockTypes[role]
+	if _, present := msg["role"].(string); present && !knownRole {
+		v.add(field+".role", `must be "user" or "assistant", got %q`, role)
+	}
+
+	switch content := msg["content"].(type) {
+	case string:
+	case []interface{}:
+		for i, block := range content {
+			v.block(block, fmt.Sprintf("%s.content[%d]", field, i), allowed)
+		}
+	case nil:
+		v.add(field+".content", "is required")
+	default:
+		v.add(field+".content", "must be a string or an array of content blocks")
+	}
+}
+
+// block checks a content block's type (nil allowed = any known type) and the
+// fields that type requires
+func (v *schemaValidator) block(raw interface{}, field string, allowed map[string]bool) {
+	block, ok := raw.(map[string]interface{})
+	if !ok {
+		v.add(field, "must be an object")
+		return
+	}
+	blockType, ok := v.string(block, "type", field+".type", true)
+	if !ok {
+		return
+	}
+	if allowed != nil && !allowed[blockType] {
+		v.add(field+".type", "%q blocks are not allowed here", blockType)
+		return
+	}
+
+	switch blockType {
+	case "text":
+		v.string(block, "text", field+".text", true)
+	case "image", "document":
+		if source, ok := v.object(block, "source", field+".source", true); ok {
+			v.string(source, "type", field+".source.type", true)
+		}
+	case "tool_use":
+		if id, ok := v.string(block, "id", field+".id", true); ok && id == "" {
+			v.add(field+".id", "must not be empty")
+		}
+		v.string(block, "name", field+".name", true)
+		v.object(block, "input", field+".input", true)
+	case "tool_result":
+		if id, ok := v.string(block, "tool_use_id", field+".tool_use_id", true); ok && id == "" {
+			v.add(field+".tool_use_id", "must not be empty")
+		}
+		v.boolean(block, "is_error", field+".is_error")
+		switch content := block["content"].(type) {
+		case nil, string:
+		case []interface{}:
+			for i, item := range content {
+				v.block(item, fmt.Sprintf("%s.content[%d]", field, i), toolResultBlockTypes)
+			}
+		default:
+			v.add(field+".content", "must be a string or an array of content blocks")
+		}
+	case "thinking":
+		v.string(block, "thinking", field+".thinking", true)
+	case "redacted_thinking":
+		v.string(block, "data", field+".data", true)
+	default:
+		v.add(field+".type", "unknown content block type %q", blockType)
+	}
+}
+
+// string returns obj[key] as a string, reporting a missing (when required) or
+// non-string value
+func (v *schemaValidator) string(obj map[string]interface{}, key, field string, required bool) (string, bool) {
+	raw, present := obj[key]
+	if !present || raw == nil {
+		if required {
+			v.add(field, "is required")
+		}
+		return "", false
+	}
+	s, ok := raw.(string)
+	if !ok {
+		v.add(field, "must be a string")
+	}
+	return s, ok
+}
+
+// integer returns obj[key] as an integer, reporting a missing (when required) or
+// non-integer value
+func (v *schemaValidator) integer(obj map[string]interface{}, key, field string, required bool) (int, bool) {
+	raw, present := obj[key]
+	if !present || raw == nil {
+		if required {
+			v.add(field, "is required")
+		}
+		return 0, false
+	}
+	n, ok := raw.(float64)
+	if !ok || n != math.Trunc(n) {
+		v.add(field, "must be an integer")
+		return 0, false
+	}
+	return int(n), true
+}
+
+// number reports an optional obj[key] that isn't a number within [min, max]
+func (v *schemaValidator) number(obj map[string]interface{}, key, field string, min, max float64) {
+	raw, present := obj[key]
+	if !present || raw == nil {
+		return
+	}
+	n, ok := raw.(float64)
+	if !ok {
+		v.add(field, "must be a number")
+	} else if n < min || n > max {
+		v.add(field, "must be between %g and %g", min, max)
+	}
+}
+
+// boolean reports an optional obj[key] that isn't a boolean
+func (v *schemaValidator) boolean(obj map[string]interface{}, key, field string) {
+	if raw, present := obj[key]; present && raw != nil {
+		if _, ok := raw.(bool); !ok {
+			v.add(field, "must be a boolean")
+		}
+	}
+}
+
+// array returns obj[key] as an array, reporting a missing (when required) or
+// non-array value
+func (v *schemaValidator) array(obj map[string]interface{}, key, field string, required bool) ([]interface{}, bool) {
+	raw, present := obj[key]
+	if !present || raw == nil {
+		if required {
+			v.add(field, "is required")
+		}
+		return nil, false
+	}
+	items, ok := raw.([]interface{})
+	if !ok {
+		v.add(field, "must be an array")
+	}
+	return items, ok
+}
+
+// object returns obj[key] as an object, reporting a missing (when required) or
+// non-object value
+func (v *schemaValidator) object(obj map[string]interface{}, key, field string, required bool) (map[string]interface{}, bool) {
+	raw, present := obj[key]
+	if !present || raw == nil {
+		if required {
+			v.add(field, "is required")
+		}
+		return nil, false
+	}
+	m, ok := raw.(map[string]interface{})
+	if !ok {
+		v.add(field, "must be an object")
+	}
+	return m, ok
+}
@@ -0,0 +1,104 @@
+package converter
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestValidateRequestSchema tests the field-level errors of strict request validation
+func TestValidateRequestSchema(t *testing.T) {
+	valid := `{
+		"model": "claude-sonnet-4",
+		"max_tokens": 1024,
+		"system": [{"type": "text", "text": "You are helpful", "cache_control": {"type": "ephemeral"}}],
+		"messages": [
+			{"role": "user", "content": "List the files"},
+			{"role": "assistant", "content": [
+				{"type": "thinking", "thinking": "ls", "signature": "sig"},
+				{"type": "tool_use", "id": "toolu_1", "name": "ls", "input": {}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_1", "content": [{"type": "text", "text": "a.go"}]}
+			]}
+		],
+		"tools": [{"name": "ls", "input_schema": {"type": "object"}}],
+		"thinking": {"type": "enabled", "budget_tokens": 2048},
+		"metadata": {"user_id": "unknown fields are allowed"}
+	}`
+	if issues := ValidateRequestSchema([]byte(valid)); len(issues) != 0 {
+		t.Fatalf("valid request has issues: %v", issues)
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want []string // "field: message" prefixes, in order
+	}{
+		{
+			name: "not an object",
+			body: `[1, 2]`,
+			want: []string{"request body must be a JSON object"},
+		},
+		{
+			name: "missing and mistyped top-level fields",
+			body: `{"max_tokens": "100", "temperature": 2, "stream": "yes", "messages": []}`,
+			want: []string{
+				"model: is required",
+				"max_tokens: must be an integer",
+				"temperature: must be between 0 and 1",
+				"stream: must be a boolean",
+				"messages: must contain at least one message",
+			},
+		},
+		{
+			name: "invalid role and content",
+			body: `{"model": "m", "max_tokens": 1, "messages": [{"role": "system", "content": "hi"}, {"role": "user", "content": 42}, {"role": "user"}]}`,
+			want: []string{
+				`messages[0].role: must be "user" or "assistant", got "system"`,
+				"messages[1].content: must be a string or an array of content blocks",
+				"messages[2].content: is required",
+			},
+		},
+		{
+			name: "malformed content blocks",
+			body: `{"model": "m", "max_tokens": 1, "messages": [
+				{"role": "user", "content": [{"type": "text"}, {"type": "tool_use", "id": "t", "name": "x", "input": {}}, "plain"]},
+				{"role": "assistant", "content": [{"type": "tool_use", "name": "ls", "input": "{}"}, {"type": "sparkle"}]}
+			]}`,
+			want: []string{
+				"messages[0].content[0].text: is required",
+				`messages[0].content[1].type: "tool_use" blocks are not allowed here`,
+				"messages[0].content[2]: must be an object",
+				"messages[1].content[0].id: is required",
+				"messages[1].content[0].input: must be an object",
+				`messages[1].content[1].type: "sparkle" blocks are not allowed here`,
+			},
+		},
+		{
+			name: "tool_result, tools and thinking",
+			body: `{"model": "m", "max_tokens": 1, "messages": [
+				{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "", "content": [{"type": "tool_use"}]}]}
+			], "tools": [{"name": "ls"}], "thinking": {"type": "enabled", "budget_tokens": 100}}`,
+			want: []string{
+				"messages[0].content[0].tool_use_id: must not be empty",
+				`messages[0].content[0].content[0].type: "tool_use" blocks are not allowed here`,
+				"tools[0].input_schema: is required",
+				"thinking.budget_tokens: must be at least 1024",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issues := ValidateRequestSchema([]byte(tt.body))
+			if len(issues) != len(tt.want) {
+				t.Fatalf("issues = %v, want %d: %v", issues, len(tt.want), tt.want)
+			}
+			for i, want := range tt.want {
+				if got := issues[i].String(); !strings.HasPrefix(got, want) {
+					t.Errorf("issues[%d] = %q, want %q", i, got, want)
+				}
+			}
+		})
+	}
+}
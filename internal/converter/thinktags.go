@@ -0,0 +1,152 @@
+package converter
+
+import (
+	"strings"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+	"github.com/claude-code-proxy/proxy/pkg/models"
+)
+
+const (
+	thinkOpenTag  = "<think>"
+	thinkCloseTag = "</think>"
+)
+
+// Markers around reasoning written into the text (THINKING_AS_TEXT)
+const (
+	ThinkingTextOpen  = "<thinking>\n"
+	ThinkingTextClose = "\n</thinking>\n\n"
+)
+
+// thinkingAsText folds the thinking blocks of a response into its text
+// (THINKING_AS_TEXT): the reasoning, between <thinking> tags, is prepended to the
+// first text block, or becomes a text block of its own where the thinking was.
+func thinkingAsText(blocks []models.ContentBlock) []models.ContentBlock {
+	var thinking []string
+	for _, block := range blocks {
+		if block.Type == "thinking" {
+			thinking = append(thinking, block.Thinking)
+		}
+	}
+	if len(thinking) == 0 {
+		return blocks
+	}
+
+	reasoning := ThinkingTextOpen + strings.Join(thinking, "\n\n") + ThinkingTextClose
+	var result []models.ContentBlock
+	placed := false
+	for _, block := range blocks {
+		switch {
+		case block.Type == "thinking":
+			continue
+		case block.Type == "text" && !placed:
+			block.Text = reasoning + block.Text
+			placed = true
+		case !placed:
+			result = append(result, models.ContentBlock{Type: "text", Text: strings.TrimSuffix(reasoning, "\n\n")})
+			placed = true
+		}
+		result = append(result, block)
+	}
+	if !placed {
+		result = append(result, models.ContentBlock{Type: "text", Text: strings.TrimSuffix(reasoning, "\n\n")})
+	}
+	return result
+}
+
+// ThinkTagParser splits inline <think>...</think> reasoning (as emitted by Ollama and
+// other local reasoning models that have no separate reasoning field) from the answer
+// text. Content is fed in stream chunks; tags split across chunks are handled by
+// holding back text that may be the start of a tag. Only a <think> tag at the very
+// beginning of the response (after whitespace) is treated as reasoning.
+type ThinkTagParser struct {
+	state   int // thinkUndecided, thinkInside or thinkDone
+	pending string
+}
+
+const (
+	thinkUndecided = iota
+	thinkInside
+	thinkDone
+)
+
+// NewThinkTagParser returns a parser for a response from cfg's provider. Only
+// Ollama and unknown gateways put reasoning in <think> tags; for OpenAI and
+// OpenRouter, which have reasoning fields, the content passes through as text,
+// even when it starts with a literal <think>.
+func NewThinkTagParser(cfg *config.Config) *ThinkTagParser {
+	if usesThinkTags(cfg) {
+		return &ThinkTagParser{}
+	}
+	return &ThinkTagParser{state: thinkDone}
+}
+
+// usesThinkTags reports whether cfg's provider may send inline <think> reasoning
+func usesThinkTags(cfg *config.Config) bool {
+	switch cfg.DetectProvider() {
+	case config.ProviderOllama, config.ProviderUnknown:
+		return true
+	}
+	return false
+}
+
+// Feed processes the next content chunk and returns the thinking and text parts
+// that can be emitted so far
+func (p *ThinkTagParser) Feed(chunk string) (thinking, text string) {
+	switch p.state {
+	case thinkUndecided:
+		p.pending += chunk
+		trimmed := strings.TrimLeft(p.pending, " \t\r\n")
+		if len(trimmed) < len(thinkOpenTag) && strings.HasPrefix(thinkOpenTag, trimmed) {
+			return "", "" // could still become <think>
+		}
+		if !strings.HasPrefix(trimmed, thinkOpenTag) {
+			p.state = thinkDone
+			text, p.pending = p.pending, ""
+			return "", text
+		}
+		p.state = thinkInside
+		p.pending = ""
+		return p.Feed(trimmed[len(thinkOpenTag):])
+
+	case thinkInside:
+		buf := p.pending + chunk
+		if idx := strings.Index(buf, thinkCloseTag); idx >= 0 {
+			p.state = thinkDone
+			p.pending = ""
+			return buf[:idx], strings.TrimLeft(buf[idx+len(thinkCloseTag):], "\r\n")
+		}
+		// Hold back a suffix that could be the start of </think>
+		hold := 0
+		for k := len(thinkCloseTag) - 1; k > 0; k-- {
+			if strings.HasSuffix(buf, thinkCloseTag[:k]) {
+				hold = k
+				break
+			}
+		}
+		p.pending = buf[len(buf)-hold:]
+		return buf[:len(buf)-hold], ""
+
+	default:
+		return "", chunk
+	}
+}
+
+// Flush returns anything held back once the content is complete. An unterminated
+// <think> block is returned as thinking.
+func (p *ThinkTagParser) Flush() (thinking, text string) {
+	pending := p.pending
+	p.pending = ""
+	if p.state == thinkInside {
+		return pending, ""
+	}
+	return "", pending
+}
+
+// splitThinkTags separates leading inline <think> reasoning from complete content
+func splitThinkTags(content string) (thinking, text string) {
+	var p ThinkTagParser
+	thinking, text = p.Feed(content)
+	restThinking, restText := p.Flush()
+	return thinking + restThinking, text + restText
+}
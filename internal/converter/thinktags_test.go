@@ -0,0 +1,78 @@
+package converter
+
+import (
+	"testing"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+)
+
+func TestThinkTagParser(t *testing.T) {
+	tests := []struct {
+		name         string
+		chunks       []string
+		wantThinking string
+		wantText     string
+	}{
+		{"single chunk", []string{"<think>plan</think>\n\nanswer"}, "plan", "answer"},
+		{"tags split across chunks", []string{"<thi", "nk>pl", "an</th", "ink>answer"}, "plan", "answer"},
+		{"leading whitespace", []string{"\n <think>plan</think>answer"}, "plan", "answer"},
+		{"no tags", []string{"just ", "text"}, "", "just text"},
+		{"short text that could be a tag", []string{"<t"}, "", "<t"},
+		{"tag not at start is text", []string{"see <think>x</think>"}, "", "see <think>x</think>"},
+		{"unterminated think", []string{"<think>still thinking"}, "still thinking", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p ThinkTagParser
+			var thinking, text string
+			for _, chunk := range tt.chunks {
+				th, tx := p.Feed(chunk)
+				thinking += th
+				text += tx
+			}
+			th, tx := p.Flush()
+			thinking += th
+			text += tx
+
+			if thinking != tt.wantThinking {
+				t.Errorf("thinking = %q, want %q", thinking, tt.wantThinking)
+			}
+			if text != tt.wantText {
+				t.Errorf("text = %q, want %q", text, tt.wantText)
+			}
+		})
+	}
+}
+
+// TestNewThinkTagParserProviders tests that only Ollama and unknown gateways parse <think> tags
+func TestNewThinkTagParserProviders(t *testing.T) {
+	const content = "<think>literal</think>\nanswer"
+	tests := []struct {
+		baseURL      string
+		wantThinking string
+		wantText     string
+	}{
+		{"http://localhost:11434/v1", "literal", "answer"},
+		{"https://gateway.example.com/v1", "literal", "answer"},
+		{"https://api.openai.com/v1", "", content},
+		{"https://openrouter.ai/api/v1", "", content},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.baseURL, func(t *testing.T) {
+			p := NewThinkTagParser(&config.Config{OpenAIBaseURL: tt.baseURL})
+			thinking, text := p.Feed(content)
+			th, tx := p.Flush()
+			thinking += th
+			text += tx
+
+			if thinking != tt.wantThinking {
+				t.Errorf("thinking = %q, want %q", thinking, tt.wantThinking)
+			}
+			if text != tt.wantText {
+				t.Errorf("text = %q, want %q", text, tt.wantText)
+			}
+		})
+	}
+}
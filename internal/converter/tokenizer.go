@@ -0,0 +1,179 @@
+package converter
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+// Tokenizer counts the tokens of a text segment for one encoding. The o200k and
+// cl100k encoders are tiktoken's BPE with the real ranks, bundled in the binary;
+// the heuristic is for models whose vocabulary isn't known.
+type Tokenizer interface {
+	Name() string
+	CountTokens(text string) int
+}
+
+// heuristicTokenizer is the provider-neutral ~4 characters per token estimate
+type heuristicTokenizer struct{}
+
+func (heuristicTokenizer) Name() string { return config.TokenizerHeuristic }
+
+func (heuristicTokenizer) CountTokens(text string) int {
+	return estimateTextTokens(text)
+}
+
+// bpeTokenizer counts with a tiktoken encoding. The ranks are loaded on first
+// use, from the copy embedded by tiktoken-go-loader rather than the network.
+type bpeTokenizer struct {
+	name     string
+	encoding string // tiktoken encoding name
+
+	once    sync.Once
+	encoder *tiktoken.Tiktoken // nil if the encoding failed to load
+}
+
+func (t *bpeTokenizer) Name() string { return t.name }
+
+func (t *bpeTokenizer) CountTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+	t.once.Do(func() {
+		tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+		t.encoder, _ = tiktoken.GetEncoding(t.encoding)
+	})
+	if t.encoder == nil {
+		return estimateTextTokens(text)
+	}
+	return len(t.encoder.EncodeOrdinary(text))
+}
+
+var (
+	o200kTokenizer  = &bpeTokenizer{name: config.TokenizerO200k, encoding: tiktoken.MODEL_O200K_BASE}
+	cl100kTokenizer = &bpeTokenizer{name: config.TokenizerCL100k, encoding: tiktoken.MODEL_CL100K_BASE}
+)
+
+// tokenizers are the built-in encoders selectable with TOKENIZER and TOKENIZER_MAP
+var tokenizers = map[string]Tokenizer{
+	config.TokenizerO200k:     o200kTokenizer,
+	config.TokenizerCL100k:    cl100kTokenizer,
+	config.TokenizerHeuristic: heuristicTokenizer{},
+}
+
+// modelTokenizers maps OpenAI model name prefixes to their encoding, most
+// specific first (gpt-4o before gpt-4)
+var modelTokenizers = []config.TokenizerRule{
+	{Pattern: "gpt-4o", Tokenizer: config.TokenizerO200k},
+	{Pattern: "gpt-4.1", Tokenizer: config.TokenizerO200k},
+	{Pattern: "gpt-4.5", Tokenizer: config.TokenizerO200k},
+	{Pattern: "gpt-5", Tokenizer: config.TokenizerO200k},
+	{Pattern: "gpt-oss", Tokenizer: config.TokenizerO200k},
+	{Pattern: "chatgpt-", Tokenizer: config.TokenizerO200k},
+	{Pattern: "o1", Tokenizer: config.TokenizerO200k},
+	{Pattern: "o3", Tokenizer: config.TokenizerO200k},
+	{Pattern: "o4", Tokenizer: config.TokenizerO200k},
+	{Pattern: "gpt-4", Tokenizer: config.TokenizerCL100k},
+	{Pattern: "gpt-3.5", Tokenizer: config.TokenizerCL100k},
+}
+
+// SelectTokenizer picks the tokenizer for a provider model. TOKENIZER_MAP rules
+// (substring match) win, then TOKENIZER, then the known OpenAI model families
+// (also behind a vendor prefix such as openai/), then the provider default:
+// o200k for OpenAI, the heuristic elsewhere since Ollama and OpenRouter serve
+// models with unrelated vocabularies.
+func SelectTokenizer(providerModel string, cfg *config.Config) Tokenizer {
+	model := strings.ToLower(providerModel)
+	for _, rule := range cfg.TokenizerMap {
+		if strings.Contains(model, rule.Pattern) {
+			return tokenizers[rule.Tokenizer]
+		}
+	}
+	if tokenizer, ok := tokenizers[cfg.Tokenizer]; ok {
+		return tokenizer
+	}
+
+	name := model[strings.LastIndex(model, "/")+1:]
+	for _, rule := range modelTokenizers {
+		if strings.HasPrefix(name, rule.Pattern) {
+			return tokenizers[rule.Tokenizer]
+		}
+	}
+
+	if cfg.DetectProvider() == config.ProviderOpenAI {
+		return o200kTokenizer
+	}
+	return heuristicTokenizer{}
+}
+
+// StreamTokenCounter keeps a running token count of streamed output, so usage can
+// be estimated when the provider reports none. BPE output is tokenized a line at
+// a time: tiktoken never merges across a line break followed by a non-space
+// character, so counting the lines separately matches tokenizing the
+// concatenated output, and each delta is only tokenized again until its line ends.
+type StreamTokenCounter struct {
+	tokenizer Tokenizer
+	chars     int    // characters streamed
+	pending   string // BPE: the output after the last complete line
+	tokens    int    // BPE: tokens of the complete lines
+}
+
+// NewStreamTokenCounter returns a counter using the given tokenizer
+func NewStreamTokenCounter(tokenizer Tokenizer) *StreamTokenCounter {
+	return &StreamTokenCounter{tokenizer: tokenizer}
+}
+
+// Add counts a streamed text delta
+func (c *StreamTokenCounter) Add(text string) {
+	if text == "" {
+		return
+	}
+	c.chars += len(text)
+	if _, ok := c.tokenizer.(*bpeTokenizer); !ok {
+		return
+	}
+	from := len(c.pending)
+	c.pending += text
+	if end := lineBoundary(c.pending, from); end > 0 {
+		c.tokens += c.tokenizer.CountTokens(c.pending[:end])
+		c.pending = c.pending[end:]
+	}
+}
+
+// lineBoundary returns the offset after the last line break that is followed by
+// a non-space character at or after from, or 0 when there is none
+func lineBoundary(text string, from int) int {
+	for i := len(text) - 1; i > 0 && i >= from; i-- {
+		if text[i-1] != '\n' {
+			continue
+		}
+		if r, _ := utf8.DecodeRuneInString(text[i:]); !unicode.IsSpace(r) {
+			return i
+		}
+	}
+	return 0
+}
+
+// Chars returns the characters (bytes) added since the counter was created or reset
+func (c *StreamTokenCounter) Chars() int {
+	return c.chars
+}
+
+// Tokens returns the tokens counted since the counter was created or reset. With
+// a BPE tokenizer this tokenizes the incomplete last line again on every call.
+func (c *StreamTokenCounter) Tokens() int {
+	if _, ok := c.tokenizer.(*bpeTokenizer); !ok {
+		return (c.chars + charsPerToken - 1) / charsPerToken
+	}
+	return c.tokens + c.tokenizer.CountTokens(c.pending)
+}
+
+// Reset restarts the count (after the provider reported usage)
+func (c *StreamTokenCounter) Reset() {
+	c.chars, c.pending, c.tokens = 0, "", 0
+}
@@ -0,0 +1,178 @@
+package converter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/claude-code-proxy/proxy/pkg/models"
+)
+
+// charsPerToken is the rough characters-per-token ratio of the heuristic tokenizer.
+// There is no provider-neutral tokenizer, so counts are approximate.
+const charsPerToken = 4
+
+// cacheTTL mirrors Claude's default ephemeral prompt cache lifetime
+const cacheTTL = 5 * time.Minute
+
+// TokenEstimate is an approximate input token count for a Claude request.
+// When the request carries cache_control breakpoints, the cacheable prefix is
+// split into the part expected to be read from cache (seen recently) and the
+// part expected to be written to cache.
+type TokenEstimate struct {
+	InputTokens      int
+	HasCacheMarkers  bool
+	CacheReadTokens  int
+	CacheWriteTokens int
+}
+
+// CachePrefixCache tracks recently seen cacheable prompt prefixes
+type CachePrefixCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time // prefix hash -> last seen
+}
+
+// Global cache prefix state (populated from forwarded message requests)
+var cachePrefixes = &CachePrefixCache{
+	seen: make(map[string]time.Time),
+}
+
+// cacheBreakpoint is a position in the prompt marked with cache_control
+type cacheBreakpoint struct {
+	hash   string // hash of the prompt prefix up to and including the marked block
+	tokens int    // estimated tokens in that prefix
+}
+
+// EstimateTokens estimates the input tokens of a Claude request with the given
+// tokenizer (see SelectTokenizer), splitting the cache-marked prefix into
+// read/write estimates based on previously seen prefixes.
+func EstimateTokens(claudeReq models.ClaudeRequest, tokenizer Tokenizer) TokenEstimate {
+	total, breakpoints := scanPrompt(claudeReq, tokenizer)
+	estimate := TokenEstimate{InputTokens: total}
+	if len(breakpoints) == 0 {
+		return estimate
+	}
+	estimate.HasCacheMarkers = true
+
+	// The longest recently seen breakpoint prefix is a cache hit; the rest of
+	// the prefix up to the last breakpoint would be written to cache.
+	now := time.Now()
+	cachePrefixes.mu.Lock()
+	for _, bp := range breakpoints {
+		if seenAt, ok := cachePrefixes.seen[bp.hash]; ok && now.Sub(seenAt) < cacheTTL {
+			estimate.CacheReadTokens = bp.tokens
+		}
+	}
+	cachePrefixes.mu.Unlock()
+
+	estimate.CacheWriteTokens = breakpoints[len(breakpoints)-1].tokens - estimate.CacheReadTokens
+	return estimate
+}
+
+// RecordCacheState remembers the cache-marked prefixes of a request sent upstream,
+// so later estimates can report them as cache reads.
+func RecordCacheState(claudeReq models.ClaudeRequest) {
+	// Only the prefix hashes are kept, so the tokenizer doesn't matter here
+	_, breakpoints := scanPrompt(claudeReq, heuristicTokenizer{})
+	if len(breakpoints) == 0 {
+		return
+	}
+
+	now := time.Now()
+	cachePrefixes.mu.Lock()
+	defer cachePrefixes.mu.Unlock()
+
+	// Drop expired prefixes so the map stays bounded by recent traffic
+	for hash, seenAt := range cachePrefixes.seen {
+		if now.Sub(seenAt) >= cacheTTL {
+			delete(cachePrefixes.seen, hash)
+		}
+	}
+	for _, bp := range breakpoints {
+		cachePrefixes.seen[bp.hash] = now
+	}
+}
+
+// scanPrompt walks the prompt in Claude's cache order (tools, system, messages),
+// returning the total estimated tokens and the cache_control breakpoints found.
+func scanPrompt(claudeReq models.ClaudeRequest, tokenizer Tokenizer) (int, []cacheBreakpoint) {
+	hasher := sha256.New()
+	var breakpoints []cacheBreakpoint
+	tokens := 0
+
+	add := func(segment string, cacheMarked bool) {
+		hasher.Write([]byte(segment))
+		hasher.Write([]byte{0}) // segment separator
+		tokens += tokenizer.CountTokens(segment)
+		if cacheMarked {
+			breakpoints = append(breakpoints, cacheBreakpoint{
+				hash:   hex.EncodeToString(hasher.Sum(nil)),
+				tokens: tokens,
+			})
+		}
+	}
+
+	for _, tool := range claudeReq.Tools {
+		schema, _ := json.Marshal(tool.InputSchema)
+		add(tool.Name+tool.Description+string(schema), tool.CacheControl != nil)
+	}
+
+	switch system := claudeReq.System.(type) {
+	case string:
+		add(system, false)
+	case []interface{}:
+		for _, block := range system {
+			addBlock(add, block)
+		}
+	}
+
+	for _, msg := range claudeReq.Messages {
+		add(msg.Role, false)
+		switch content := msg.Content.(type) {
+		case string:
+			add(content, false)
+		case []interface{}:
+			for _, block := range content {
+				addBlock(add, block)
+			}
+		}
+	}
+
+	return tokens, breakpoints
+}
+
+// addBlock adds a content block to the prompt scan, honoring its cache_control marker
+func addBlock(add func(string, bool), block interface{}) {
+	blockMap, ok := block.(map[string]interface{})
+	if !ok {
+		return
+	}
+	_, cacheMarked := blockMap["cache_control"]
+
+	var segment string
+	switch blockMap["type"] {
+	case "text":
+		segment, _ = blockMap["text"].(string)
+	case "thinking":
+		segment, _ = blockMap["thinking"].(string)
+	default:
+		// tool_use, tool_result, images, etc: count the serialized block
+		// without the marker so hashes don't depend on cache_control itself
+		copied := make(map[string]interface{}, len(blockMap))
+		for k, v := range blockMap {
+			if k != "cache_control" {
+				copied[k] = v
+			}
+		}
+		raw, _ := json.Marshal(copied)
+		segment = string(raw)
+	}
+	add(segment, cacheMarked)
+}
+
+// estimateTextTokens approximates the token count of a text segment
+func estimateTextTokens(text string) int {
+	return (len(text) + charsPerToken - 1) / charsPerToken
+}
@@ -0,0 +1,180 @@
+package converter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+	"github.com/claude-code-proxy/proxy/pkg/models"
+)
+
+// TestEstimateTokensCacheSplit tests the cache read/write split for cache-marked blocks
+func TestEstimateTokensCacheSplit(t *testing.T) {
+	ephemeral := map[string]interface{}{"type": "ephemeral"}
+	system := []interface{}{
+		map[string]interface{}{"type": "text", "text": "You are a helpful coding agent. Follow the repo conventions."},
+		map[string]interface{}{"type": "text", "text": "Project context: a Go proxy translating Claude to OpenAI.", "cache_control": ephemeral},
+	}
+	newRequest := func(question string) models.ClaudeRequest {
+		return models.ClaudeRequest{
+			Model:  "claude-sonnet-4",
+			System: system,
+			Messages: []models.ClaudeMessage{
+				{Role: "user", Content: []interface{}{
+					map[string]interface{}{"type": "text", "text": "Here is a long file to review...", "cache_control": ephemeral},
+				}},
+				{Role: "assistant", Content: "Reviewed."},
+				{Role: "user", Content: question},
+			},
+		}
+	}
+
+	t.Run("no markers means no split", func(t *testing.T) {
+		estimate := EstimateTokens(models.ClaudeRequest{
+			Messages: []models.ClaudeMessage{{Role: "user", Content: "hello world"}},
+		}, heuristicTokenizer{})
+		if estimate.HasCacheMarkers || estimate.InputTokens == 0 {
+			t.Errorf("Unexpected estimate for plain request: %+v", estimate)
+		}
+	})
+
+	t.Run("first request writes the whole prefix", func(t *testing.T) {
+		estimate := EstimateTokens(newRequest("What does it do?"), heuristicTokenizer{})
+		if !estimate.HasCacheMarkers {
+			t.Fatal("Expected cache markers to be detected")
+		}
+		if estimate.CacheReadTokens != 0 || estimate.CacheWriteTokens == 0 {
+			t.Errorf("Expected all-write split, got %+v", estimate)
+		}
+		if estimate.CacheWriteTokens >= estimate.InputTokens {
+			t.Errorf("Uncached suffix should not count as cache write: %+v", estimate)
+		}
+	})
+
+	t.Run("previously seen prefix is read", func(t *testing.T) {
+		RecordCacheState(newRequest("What does it do?"))
+
+		estimate := EstimateTokens(newRequest("A different follow-up question"), heuristicTokenizer{})
+		if estimate.CacheReadTokens == 0 || estimate.CacheWriteTokens != 0 {
+			t.Errorf("Expected all-read split, got %+v", estimate)
+		}
+	})
+
+	t.Run("partially seen prefix splits", func(t *testing.T) {
+		req := newRequest("Next question")
+		req.Messages[0].Content = []interface{}{
+			map[string]interface{}{"type": "text", "text": "A different file this time", "cache_control": ephemeral},
+		}
+
+		estimate := EstimateTokens(req, heuristicTokenizer{})
+		if estimate.CacheReadTokens == 0 || estimate.CacheWriteTokens == 0 {
+			t.Errorf("Expected system read and message write, got %+v", estimate)
+		}
+	})
+}
+
+// TestSelectTokenizer tests tokenizer selection by TOKENIZER_MAP, TOKENIZER, model family and provider
+func TestSelectTokenizer(t *testing.T) {
+	openAI := &config.Config{OpenAIBaseURL: "https://api.openai.com/v1"}
+	ollama := &config.Config{OpenAIBaseURL: "http://localhost:11434/v1"}
+	openRouter := &config.Config{OpenAIBaseURL: "https://openrouter.ai/api/v1"}
+
+	tests := []struct {
+		name  string
+		model string
+		cfg   *config.Config
+		want  string
+	}{
+		{"gpt-4o family", "gpt-4o-mini", openAI, config.TokenizerO200k},
+		{"gpt-5 family", "gpt-5", openAI, config.TokenizerO200k},
+		{"reasoning models", "o3-mini", openAI, config.TokenizerO200k},
+		{"gpt-4 family", "gpt-4-turbo", openAI, config.TokenizerCL100k},
+		{"gpt-3.5 family", "gpt-3.5-turbo", openAI, config.TokenizerCL100k},
+		{"vendor prefix", "openai/gpt-4", openRouter, config.TokenizerCL100k},
+		{"unknown OpenAI model", "custom-finetune", openAI, config.TokenizerO200k},
+		{"Ollama model", "llama3.1:8b", ollama, config.TokenizerHeuristic},
+		{"OpenRouter model", "anthropic/claude-3.5-sonnet", openRouter, config.TokenizerHeuristic},
+		{"TOKENIZER overrides the defaults", "gpt-4o", &config.Config{Tokenizer: config.TokenizerCL100k}, config.TokenizerCL100k},
+		{"TOKENIZER_MAP comes first", "qwen2.5-coder", &config.Config{
+			Tokenizer:    config.TokenizerHeuristic,
+			TokenizerMap: []config.TokenizerRule{{Pattern: "gpt", Tokenizer: config.TokenizerCL100k}, {Pattern: "qwen", Tokenizer: config.TokenizerO200k}},
+		}, config.TokenizerO200k},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SelectTokenizer(tt.model, tt.cfg).Name(); got != tt.want {
+				t.Errorf("SelectTokenizer(%q) = %s, want %s", tt.model, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestTokenizerCounts tests the BPE counts against tiktoken and the heuristic
+func TestTokenizerCounts(t *testing.T) {
+	text := "Internationalization of the configuration requires reconsidering everything. 日本語のテキストも含まれています。"
+	openAI := &config.Config{OpenAIBaseURL: "https://api.openai.com/v1"}
+
+	o200k := SelectTokenizer("gpt-4o", openAI).CountTokens(text)
+	cl100k := SelectTokenizer("gpt-4", openAI).CountTokens(text)
+	heuristic := SelectTokenizer("llama3", &config.Config{OpenAIBaseURL: "http://localhost:11434/v1"}).CountTokens(text)
+
+	if o200k >= cl100k {
+		t.Errorf("o200k = %d, want fewer tokens than cl100k (%d) for long and non-Latin words", o200k, cl100k)
+	}
+	if o200k == heuristic || cl100k == heuristic {
+		t.Errorf("o200k = %d, cl100k = %d, heuristic = %d: want distinct counts", o200k, cl100k, heuristic)
+	}
+
+	// Common short words are one token each in both BPE encodings
+	for _, tokenizer := range []Tokenizer{o200kTokenizer, cl100kTokenizer} {
+		if got := tokenizer.CountTokens("hello world, how are you?"); got != 7 {
+			t.Errorf("%s: CountTokens() = %d, want 7", tokenizer.Name(), got)
+		}
+	}
+
+	// tiktoken's own example: [83, 1609, 5963, 374, 2294, 0] in cl100k_base
+	if got := cl100kTokenizer.CountTokens("tiktoken is great!"); got != 6 {
+		t.Errorf("cl100k: CountTokens() = %d, want 6", got)
+	}
+}
+
+// TestEstimateTokensTokenizer tests that the estimate uses the given tokenizer
+func TestEstimateTokensTokenizer(t *testing.T) {
+	req := models.ClaudeRequest{
+		Messages: []models.ClaudeMessage{{Role: "user", Content: "Explain the implementation of internationalization."}},
+	}
+	heuristic := EstimateTokens(req, heuristicTokenizer{}).InputTokens
+	o200k := EstimateTokens(req, o200kTokenizer).InputTokens
+	if heuristic == o200k {
+		t.Errorf("Expected different estimates per tokenizer, both were %d", heuristic)
+	}
+}
+
+// TestStreamTokenCounter tests that the running count of streamed deltas matches
+// tokenizing the concatenated output, however the output is split
+func TestStreamTokenCounter(t *testing.T) {
+	deltas := []string{"Inter", "national", "ization isn", "'t hard.\n\n", "  Let's ", "run `go test ./...`", " in 2024", "12 — 日本", "語のテキスト\n- one", "\n- two\n\n", "Done."}
+	output := strings.Join(deltas, "")
+
+	for _, tokenizer := range []Tokenizer{o200kTokenizer, cl100kTokenizer, heuristicTokenizer{}} {
+		counter := NewStreamTokenCounter(tokenizer)
+		for _, delta := range deltas {
+			counter.Add(delta)
+		}
+		if got, want := counter.Tokens(), tokenizer.CountTokens(output); got != want {
+			t.Errorf("%s: running count = %d, want %d from the concatenated output", tokenizer.Name(), got, want)
+		}
+		if got := counter.Chars(); got != len(output) {
+			t.Errorf("%s: Chars() = %d, want %d", tokenizer.Name(), got, len(output))
+		}
+
+		// One character at a time
+		counter.Reset()
+		for _, r := range output {
+			counter.Add(string(r))
+		}
+		if got, want := counter.Tokens(), tokenizer.CountTokens(output); got != want {
+			t.Errorf("%s: per-character running count = %d, want %d", tokenizer.Name(), got, want)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package converter
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/claude-code-proxy/proxy/internal/logging"
+	"github.com/claude-code-proxy/proxy/pkg/models"
+)
+
+// orphanedToolResult is a tool_result block whose tool_use_id matches no tool_use
+// in an earlier message
+type orphanedToolResult struct {
+	Message   int // index in the Claude messages
+	Block     int // index in the message content
+	ToolUseID string
+}
+
+func (o orphanedToolResult) Error() string {
+	return fmt.Sprintf("messages[%d].content[%d]: tool_result references tool_use_id %q, which matches no earlier tool_use", o.Message, o.Block, o.ToolUseID)
+}
+
+// findOrphanedToolResults returns the tool_results that don't answer an earlier
+// tool_use, in conversation order
+func findOrphanedToolResults(claudeMessages []models.ClaudeMessage) []orphanedToolResult {
+	var orphans []orphanedToolResult
+	toolUseIDs := make(map[string]bool)
+	for i, msg := range claudeMessages {
+		// A message's own tool_use blocks (or OpenAI-shaped tool calls) only count
+		// for later messages
+		var messageToolUses []string
+		for _, toolCall := range msg.ToolCalls {
+			if toolCall.ID != "" {
+				messageToolUses = append(messageToolUses, toolCall.ID)
+			}
+		}
+		blocks, ok := msg.Content.([]interface{})
+		if !ok {
+			for _, id := range messageToolUses {
+				toolUseIDs[id] = true
+			}
+			continue
+		}
+		for j, block := range blocks {
+			blockMap, ok := block.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			switch blockMap["type"] {
+			case "tool_use":
+				if id, _ := blockMap["id"].(string); id != "" {
+					messageToolUses = append(messageToolUses, id)
+				}
+			case "tool_result":
+				if id, _ := blockMap["tool_use_id"].(string); !toolUseIDs[id] {
+					orphans = append(orphans, orphanedToolResult{Message: i, Block: j, ToolUseID: id})
+				}
+			}
+		}
+		for _, id := range messageToolUses {
+			toolUseIDs[id] = true
+		}
+	}
+	return orphans
+}
+
+// dropOrphanedToolResults returns the messages without the given orphaned
+// tool_results (VALIDATE_TOOL_PAIRS=drop), logging a warning for each. The
+// original messages are not modified.
+func dropOrphanedToolResults(claudeMessages []models.ClaudeMessage, orphans []orphanedToolResult) []models.ClaudeMessage {
+	if len(orphans) == 0 {
+		return claudeMessages
+	}
+
+	dropped := make(map[[2]int]bool, len(orphans))
+	for _, o := range orphans {
+		logging.Printf("[%s] [WARN] Dropping orphaned tool_result: %v\n", time.Now().Format("15:04:05"), o)
+		dropped[[2]int{o.Message, o.Block}] = true
+	}
+
+	result := make([]models.ClaudeMessage, len(claudeMessages))
+	copy(result, claudeMessages)
+	for i, msg := range claudeMessages {
+		blocks, ok := msg.Content.([]interface{})
+		if !ok {
+			continue
+		}
+		var kept []interface{}
+		for j, block := range blocks {
+			if !dropped[[2]int{i, j}] {
+				kept = append(kept, block)
+			}
+		}
+		if len(kept) != len(blocks) {
+			result[i].Content = kept
+		}
+	}
+	return result
+}
+
+// normalizeToolOrder moves tool_result blocks so each one is in a user message
+// directly after the assistant message holding its tool_use (NORMALIZE_TOOL_ORDER),
+// as strict backends require each tool message to follow its tool call. The
+// results of one assistant turn form their own user message, in tool_use order;
+// the other blocks of the messages they came from stay where they were, and
+// messages left empty are dropped. The original messages are not modified.
+func normalizeToolOrder(claudeMessages []models.ClaudeMessage) []models.ClaudeMessage {
+	// Where each tool_use is: message index and position within the message
+	type toolUse struct{ message, position int }
+	toolUses := make(map[string]toolUse)
+	for i, msg := range claudeMessages {
+		if msg.Role != "assistant" {
+			continue
+		}
+		// OpenAI-shaped tool calls come before the content blocks
+		for k, toolCall := range msg.ToolCalls {
+			if toolCall.ID != "" {
+				toolUses[toolCall.ID] = toolUse{i, k - len(msg.ToolCalls)}
+			}
+		}
+		blocks, _ := msg.Content.([]interface{})
+		for j, block := range blocks {
+			if blockMap, ok := block.(map[string]interface{}); ok && blockMap["type"] == "tool_use" {
+				if id, _ := blockMap["id"].(string); id != "" {
+					toolUses[id] = toolUse{i, j}
+				}
+			}
+		}
+	}
+
+	// Split every tool_result with a known tool_use from the other blocks
+	others := make([][]interface{}, len(claudeMessages))
+	results := make(map[int][]interface{}) // assistant message index -> its tool_results
+	misplaced := 0
+	for i, msg := range claudeMessages {
+		blocks, ok := msg.Content.([]interface{})
+		if !ok {
+			continue
+		}
+		for _, block := range blocks {
+			blockMap, _ := block.(map[string]interface{})
+			id, _ := blockMap["tool_use_id"].(string)
+			use, known := toolUses[id]
+			if blockMap["type"] != "tool_result" || !known {
+				others[i] = append(others[i], block)
+				continue
+			}
+			results[use.message] = append(results[use.message], block)
+			if i != use.message+1 {
+				misplaced++
+			}
+		}
+	}
+	if misplaced == 0 {
+		return claudeMessages
+	}
+	logging.Printf("[%s] [WARN] Moving %d tool_result blocks after their tool_use\n", time.Now().Format("15:04:05"), misplaced)
+
+	position := func(block interface{}) int {
+		id, _ := block.(map[string]interface{})["tool_use_id"].(string)
+		return toolUses[id].position
+	}
+
+	normalized := make([]models.ClaudeMessage, 0, len(claudeMessages)+1)
+	for i, msg := range claudeMessages {
+		if _, ok := msg.Content.([]interface{}); !ok {
+			normalized = append(normalized, msg)
+		} else if len(others[i]) > 0 {
+			msg.Content = others[i]
+			normalized = append(normalized, msg)
+		}
+
+		if answers := results[i]; len(answers) > 0 {
+			sorted := make([]interface{}, len(answers))
+			copy(sorted, answers)
+			sort.SliceStable(sorted, func(a, b int) bool { return position(sorted[a]) < position(sorted[b]) })
+			normalized = append(normalized, models.ClaudeMessage{Role: "user", Content: sorted})
+		}
+	}
+	return normalized
+}
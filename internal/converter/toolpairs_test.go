@@ -0,0 +1,169 @@
+package converter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+	"github.com/claude-code-proxy/proxy/pkg/models"
+)
+
+// orphanedToolResultRequest has one answered tool_use and one tool_result for an unknown ID
+func orphanedToolResultRequest() models.ClaudeRequest {
+	return models.ClaudeRequest{
+		Model:     "claude-sonnet-4",
+		MaxTokens: 100,
+		Messages: []models.ClaudeMessage{
+			{Role: "user", Content: "List the files"},
+			{Role: "assistant", Content: []interface{}{
+				map[string]interface{}{"type": "tool_use", "id": "toolu_1", "name": "ls", "input": map[string]interface{}{}},
+			}},
+			{Role: "user", Content: []interface{}{
+				map[string]interface{}{"type": "tool_result", "tool_use_id": "toolu_1", "content": "a.go"},
+				map[string]interface{}{"type": "tool_result", "tool_use_id": "toolu_gone", "content": "stale"},
+			}},
+		},
+	}
+}
+
+// TestOrphanedToolResults tests VALIDATE_TOOL_PAIRS handling of a tool_result without a tool_use
+func TestOrphanedToolResults(t *testing.T) {
+	toolMessages := func(openaiReq *models.OpenAIRequest) []string {
+		var ids []string
+		for _, msg := range openaiReq.Messages {
+			if msg.Role == "tool" {
+				ids = append(ids, msg.ToolCallID)
+			}
+		}
+		return ids
+	}
+
+	t.Run("off forwards every tool_result", func(t *testing.T) {
+		openaiReq, err := ConvertRequest(orphanedToolResultRequest(), &config.Config{})
+		if err != nil {
+			t.Fatalf("ConvertRequest() error = %v", err)
+		}
+		if ids := toolMessages(openaiReq); len(ids) != 2 {
+			t.Errorf("tool messages = %v, want both", ids)
+		}
+	})
+
+	t.Run("drop removes the orphan", func(t *testing.T) {
+		req := orphanedToolResultRequest()
+		openaiReq, err := ConvertRequest(req, &config.Config{ValidateToolPairs: config.ToolPairsDrop})
+		if err != nil {
+			t.Fatalf("ConvertRequest() error = %v", err)
+		}
+		if ids := toolMessages(openaiReq); len(ids) != 1 || ids[0] != "toolu_1" {
+			t.Errorf("tool messages = %v, want only toolu_1", ids)
+		}
+		if blocks := req.Messages[2].Content.([]interface{}); len(blocks) != 2 {
+			t.Error("drop modified the client's messages")
+		}
+	})
+
+	t.Run("error rejects the request", func(t *testing.T) {
+		_, err := ConvertRequest(orphanedToolResultRequest(), &config.Config{ValidateToolPairs: config.ToolPairsError})
+		if err == nil || !strings.Contains(err.Error(), `messages[2].content[1]`) || !strings.Contains(err.Error(), "toolu_gone") {
+			t.Errorf("ConvertRequest() error = %v, want one naming the orphaned tool_result", err)
+		}
+	})
+}
+
+// TestFindOrphanedToolResults tests that only earlier tool_use blocks count
+func TestFindOrphanedToolResults(t *testing.T) {
+	messages := []models.ClaudeMessage{
+		{Role: "user", Content: []interface{}{
+			map[string]interface{}{"type": "tool_result", "tool_use_id": "toolu_later"},
+		}},
+		{Role: "assistant", Content: []interface{}{
+			map[string]interface{}{"type": "text", "text": "Checking"},
+			map[string]interface{}{"type": "tool_use", "id": "toolu_later", "name": "ls"},
+		}},
+		{Role: "user", Content: []interface{}{
+			map[string]interface{}{"type": "tool_result", "tool_use_id": "toolu_later"},
+		}},
+	}
+
+	orphans := findOrphanedToolResults(messages)
+	if len(orphans) != 1 || orphans[0].Message != 0 || orphans[0].ToolUseID != "toolu_later" {
+		t.Errorf("findOrphanedToolResults() = %+v, want only the result before its tool_use", orphans)
+	}
+}
+
+// TestNormalizeToolOrder tests that misplaced tool_results are moved after their tool_use
+func TestNormalizeToolOrder(t *testing.T) {
+	req := models.ClaudeRequest{
+		Model:     "claude-sonnet-4",
+		MaxTokens: 100,
+		Messages: []models.ClaudeMessage{
+			{Role: "user", Content: "Read both files"},
+			{Role: "assistant", Content: []interface{}{
+				map[string]interface{}{"type": "tool_use", "id": "toolu_a", "name": "read", "input": map[string]interface{}{"path": "a"}},
+				map[string]interface{}{"type": "tool_use", "id": "toolu_b", "name": "read", "input": map[string]interface{}{"path": "b"}},
+			}},
+			{Role: "user", Content: []interface{}{
+				map[string]interface{}{"type": "text", "text": "Also check c"},
+			}},
+			{Role: "assistant", Content: "Will do."},
+			{Role: "user", Content: []interface{}{
+				map[string]interface{}{"type": "tool_result", "tool_use_id": "toolu_b", "content": "B"},
+				map[string]interface{}{"type": "tool_result", "tool_use_id": "toolu_a", "content": "A"},
+			}},
+		},
+	}
+
+	roles := func(openaiReq *models.OpenAIRequest) string {
+		var parts []string
+		for _, msg := range openaiReq.Messages {
+			parts = append(parts, msg.Role+":"+msg.ToolCallID)
+		}
+		return strings.Join(parts, " ")
+	}
+
+	t.Run("off keeps the client order", func(t *testing.T) {
+		openaiReq, err := ConvertRequest(req, &config.Config{})
+		if err != nil {
+			t.Fatalf("ConvertRequest() error = %v", err)
+		}
+		if got, want := roles(openaiReq), "user: assistant: user: assistant: tool:toolu_b tool:toolu_a"; got != want {
+			t.Errorf("messages = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("on moves results after their tool_use", func(t *testing.T) {
+		openaiReq, err := ConvertRequest(req, &config.Config{NormalizeToolOrder: true, ValidateToolPairs: config.ToolPairsError})
+		if err != nil {
+			t.Fatalf("ConvertRequest() error = %v", err)
+		}
+		if got, want := roles(openaiReq), "user: assistant: tool:toolu_a tool:toolu_b user: assistant:"; got != want {
+			t.Errorf("messages = %q, want %q", got, want)
+		}
+		if len(req.Messages) != 5 || len(req.Messages[4].Content.([]interface{})) != 2 {
+			t.Error("normalization modified the client's messages")
+		}
+	})
+
+	t.Run("results before their tool_use pass validation once moved", func(t *testing.T) {
+		early := models.ClaudeRequest{
+			Model:     "claude-sonnet-4",
+			MaxTokens: 100,
+			Messages: []models.ClaudeMessage{
+				{Role: "user", Content: []interface{}{
+					map[string]interface{}{"type": "tool_result", "tool_use_id": "toolu_a", "content": "A"},
+					map[string]interface{}{"type": "text", "text": "Continue"},
+				}},
+				{Role: "assistant", Content: []interface{}{
+					map[string]interface{}{"type": "tool_use", "id": "toolu_a", "name": "read", "input": map[string]interface{}{}},
+				}},
+			},
+		}
+		openaiReq, err := ConvertRequest(early, &config.Config{NormalizeToolOrder: true, ValidateToolPairs: config.ToolPairsError})
+		if err != nil {
+			t.Fatalf("ConvertRequest() error = %v", err)
+		}
+		if got, want := roles(openaiReq), "user: assistant: tool:toolu_a"; got != want {
+			t.Errorf("messages = %q, want %q", got, want)
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/claude-code-proxy/proxy/pkg/models"
+)
+
+// unknownBlockError is a message content block whose type the converter doesn't
+// know (UNKNOWN_BLOCK=error)
+type unknownBlockError struct {
+	Message int // index in the Claude messages
+	Block   int // index in the message content
+	Type    string
+}
+
+func (e unknownBlockError) Error() string {
+	return fmt.Sprintf("messages[%d].content[%d]: unsupported content block type %q", e.Message, e.Block, e.Type)
+}
+
+// isKnownBlockType reports whether a message block type is part of the Claude API
+// as the converter knows it (see messageBlockTypes). Known types it can't forward,
+// such as images, are left out as before rather than stringified.
+func isKnownBlockType(blockType interface{}) bool {
+	name, _ := blockType.(string)
+	for _, allowed := range messageBlockTypes {
+		if allowed[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// findUnknownBlock returns the first content block of an unknown type, in
+// conversation order, or nil when every block is known
+func findUnknownBlock(claudeMessages []models.ClaudeMessage) error {
+	for i, msg := range claudeMessages {
+		blocks, ok := msg.Content.([]interface{})
+		if !ok {
+			continue
+		}
+		for j, block := range blocks {
+			if blockMap, ok := block.(map[string]interface{}); ok && !isKnownBlockType(blockMap["type"]) {
+				blockType, _ := blockMap["type"].(string)
+				return unknownBlockError{Message: i, Block: j, Type: blockType}
+			}
+		}
+	}
+	return nil
+}
+
+// stringifyBlock renders an unknown block as text (UNKNOWN_BLOCK=stringify), so
+// the model still sees what the client sent
+func stringifyBlock(blockMap map[string]interface{}) string {
+	data, err := json.Marshal(blockMap)
+	if err != nil {
+		return fmt.Sprintf("%v", blockMap)
+	}
+	return string(data)
+}
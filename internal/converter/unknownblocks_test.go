@@ -0,0 +1,70 @@
+package converter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+	"github.com/claude-code-proxy/proxy/pkg/models"
+)
+
+// unknownBlockRequest has a user turn with a text block, an image and a block type
+// the converter doesn't know
+func unknownBlockRequest() models.ClaudeRequest {
+	return models.ClaudeRequest{
+		Model:     "claude-sonnet-4",
+		MaxTokens: 100,
+		Messages: []models.ClaudeMessage{
+			{Role: "user", Content: []interface{}{
+				map[string]interface{}{"type": "text", "text": "Summarize this"},
+				map[string]interface{}{"type": "image", "source": map[string]interface{}{"type": "base64", "data": "iVBOR"}},
+				map[string]interface{}{"type": "search_result", "title": "Release notes", "source": "https://example.com/notes"},
+			}},
+		},
+	}
+}
+
+// TestUnknownBlockPolicy tests UNKNOWN_BLOCK handling of a content block type the converter doesn't know
+func TestUnknownBlockPolicy(t *testing.T) {
+	userContent := func(t *testing.T, cfg *config.Config) interface{} {
+		t.Helper()
+		openaiReq, err := ConvertRequest(unknownBlockRequest(), cfg)
+		if err != nil {
+			t.Fatalf("ConvertRequest() error = %v", err)
+		}
+		return openaiReq.Messages[len(openaiReq.Messages)-1].Content
+	}
+
+	t.Run("stringify adds the block as JSON", func(t *testing.T) {
+		for _, policy := range []string{config.UnknownBlockStringify, ""} {
+			want := `Summarize this` + "\n" + `{"source":"https://example.com/notes","title":"Release notes","type":"search_result"}`
+			if got := userContent(t, &config.Config{UnknownBlock: policy}); got != want {
+				t.Errorf("UNKNOWN_BLOCK=%q content = %#v, want %#v", policy, got, want)
+			}
+		}
+	})
+
+	t.Run("drop leaves it out", func(t *testing.T) {
+		if got := userContent(t, &config.Config{UnknownBlock: config.UnknownBlockDrop}); got != "Summarize this" {
+			t.Errorf("content = %#v, want only the text", got)
+		}
+	})
+
+	t.Run("error rejects the request", func(t *testing.T) {
+		_, err := ConvertRequest(unknownBlockRequest(), &config.Config{UnknownBlock: config.UnknownBlockError})
+		if err == nil {
+			t.Fatal("Expected an error for the unknown block")
+		}
+		if !strings.Contains(err.Error(), `messages[0].content[2]: unsupported content block type "search_result"`) {
+			t.Errorf("error = %q, want the block's position and type", err)
+		}
+	})
+
+	t.Run("known blocks pass every policy", func(t *testing.T) {
+		req := unknownBlockRequest()
+		req.Messages[0].Content = req.Messages[0].Content.([]interface{})[:2]
+		if _, err := ConvertRequest(req, &config.Config{UnknownBlock: config.UnknownBlockError}); err != nil {
+			t.Errorf("ConvertRequest() error = %v, want text and image accepted", err)
+		}
+	})
+}
@@ -0,0 +1,104 @@
+package converter
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+	"github.com/claude-code-proxy/proxy/pkg/models"
+)
+
+// ValidationIssue describes a single problem found in a Claude request
+type ValidationIssue struct {
+	Field   string `json:"field"`   // JSON path of the offending field, e.g. "tools[0].name"
+	Message string `json:"message"` // Human-readable description
+}
+
+func (i ValidationIssue) String() string {
+	if i.Field == "" {
+		return i.Message
+	}
+	return i.Field + ": " + i.Message
+}
+
+// toolNamePattern matches tool names accepted by OpenAI-compatible function calling
+var toolNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// ValidateRequest checks a Claude request for problems that would make the upstream
+// call fail: missing required fields, invalid roles, malformed tools, and (when the
+// provider model list has been fetched) a mapped model the provider doesn't offer.
+// Returns nil issues when none are found, along with the converted request, which
+// is nil when conversion fails.
+func ValidateRequest(claudeReq models.ClaudeRequest, cfg *config.Config) ([]ValidationIssue, *models.OpenAIRequest) {
+	var issues []ValidationIssue
+	add := func(field, format string, args ...interface{}) {
+		issues = append(issues, ValidationIssue{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// Model
+	if claudeReq.Model == "" {
+		add("model", "model is required")
+	} else {
+		mapped := mapModel(claudeReq.Model, cfg)
+		if available, known := config.IsModelAvailable(mapped); known && !available {
+			add("model", "model %q maps to %q, which is not in the provider's model list", claudeReq.Model, mapped)
+		}
+	}
+
+	// Token limit
+	if claudeReq.MaxTokens <= 0 {
+		add("max_tokens", "max_tokens must be a positive integer")
+	}
+
+	if thinking := claudeReq.Thinking; thinking != nil && thinking.Type == "enabled" && claudeReq.MaxTokens > 0 && claudeReq.MaxTokens <= thinking.BudgetTokens {
+		add("max_tokens", "max_tokens (%d) must be greater than thinking.budget_tokens (%d)", claudeReq.MaxTokens, thinking.BudgetTokens)
+	}
+
+	// Messages
+	if len(claudeReq.Messages) == 0 {
+		add("messages", "at least one message is required")
+	}
+	for i, msg := range claudeReq.Messages {
+		if msg.Role != "user" && msg.Role != "assistant" {
+			add(fmt.Sprintf("messages[%d].role", i), "role must be \"user\" or \"assistant\", got %q", msg.Role)
+		}
+		if msg.Content == nil {
+			add(fmt.Sprintf("messages[%d].content", i), "content is required")
+		}
+	}
+	for _, orphan := range findOrphanedToolResults(claudeReq.Messages) {
+		add(fmt.Sprintf("messages[%d].content[%d].tool_use_id", orphan.Message, orphan.Block),
+			"tool_result references tool_use_id %q, which matches no earlier tool_use", orphan.ToolUseID)
+	}
+
+	// Tools
+	seen := make(map[string]int)
+	for i, tool := range claudeReq.Tools {
+		field := fmt.Sprintf("tools[%d]", i)
+		if !toolNamePattern.MatchString(tool.Name) {
+			add(field+".name", "tool name %q must be 1-64 characters of letters, digits, '_' or '-'", tool.Name)
+		}
+		if first, dup := seen[tool.Name]; dup && tool.Name != "" {
+			add(field+".name", "duplicate tool name %q (also tools[%d])", tool.Name, first)
+		} else {
+			seen[tool.Name] = i
+		}
+
+		schema, ok := tool.InputSchema.(map[string]interface{})
+		if !ok {
+			add(field+".input_schema", "input_schema must be a JSON object")
+			continue
+		}
+		if schemaType, _ := schema["type"].(string); schemaType != "object" {
+			add(field+".input_schema.type", "input_schema type must be \"object\"")
+		}
+	}
+
+	// Conversion itself
+	openaiReq, err := ConvertRequest(claudeReq, cfg)
+	if err != nil {
+		add("", "conversion failed: %v", err)
+	}
+
+	return issues, openaiReq
+}
@@ -0,0 +1,161 @@
+package converter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+	"github.com/claude-code-proxy/proxy/pkg/models"
+)
+
+// TestValidateRequest tests request validation issues and their field paths
+func TestValidateRequest(t *testing.T) {
+	cfg := &config.Config{OpenAIBaseURL: "https://api.openai.com/v1"}
+
+	validRequest := func() models.ClaudeRequest {
+		return models.ClaudeRequest{
+			Model:     "claude-sonnet-4-5-20250805",
+			MaxTokens: 1000,
+			Messages: []models.ClaudeMessage{
+				{Role: "user", Content: "Hello"},
+			},
+			Tools: []models.Tool{
+				{
+					Name:        "get_weather",
+					Description: "Get weather",
+					InputSchema: map[string]interface{}{"type": "object"},
+				},
+			},
+		}
+	}
+
+	t.Run("valid request", func(t *testing.T) {
+		issues, openaiReq := ValidateRequest(validRequest(), cfg)
+		if len(issues) != 0 {
+			t.Errorf("Expected no issues, got %v", issues)
+		}
+		if openaiReq == nil {
+			t.Error("Expected the converted request")
+		}
+	})
+
+	tests := []struct {
+		name          string
+		mutate        func(req *models.ClaudeRequest)
+		expectedField string
+	}{
+		{
+			name:          "missing model",
+			mutate:        func(req *models.ClaudeRequest) { req.Model = "" },
+			expectedField: "model",
+		},
+		{
+			name:          "zero max_tokens",
+			mutate:        func(req *models.ClaudeRequest) { req.MaxTokens = 0 },
+			expectedField: "max_tokens",
+		},
+		{
+			name: "thinking budget leaves no room to answer",
+			mutate: func(req *models.ClaudeRequest) {
+				req.Thinking = &models.Thinking{Type: "enabled", BudgetTokens: 2000}
+			},
+			expectedField: "max_tokens",
+		},
+		{
+			name:          "no messages",
+			mutate:        func(req *models.ClaudeRequest) { req.Messages = nil },
+			expectedField: "messages",
+		},
+		{
+			name: "invalid role",
+			mutate: func(req *models.ClaudeRequest) {
+				req.Messages = append(req.Messages, models.ClaudeMessage{Role: "system", Content: "x"})
+			},
+			expectedField: "messages[1].role",
+		},
+		{
+			name:          "invalid tool name",
+			mutate:        func(req *models.ClaudeRequest) { req.Tools[0].Name = "get weather!" },
+			expectedField: "tools[0].name",
+		},
+		{
+			name: "duplicate tool name",
+			mutate: func(req *models.ClaudeRequest) {
+				req.Tools = append(req.Tools, req.Tools[0])
+			},
+			expectedField: "tools[1].name",
+		},
+		{
+			name:          "non-object input_schema",
+			mutate:        func(req *models.ClaudeRequest) { req.Tools[0].InputSchema = "string" },
+			expectedField: "tools[0].input_schema",
+		},
+		{
+			name: "wrong schema type",
+			mutate: func(req *models.ClaudeRequest) {
+				req.Tools[0].InputSchema = map[string]interface{}{"type": "array"}
+			},
+			expectedField: "tools[0].input_schema.type",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := validRequest()
+			tt.mutate(&req)
+
+			issues, _ := ValidateRequest(req, cfg)
+			found := false
+			for _, issue := range issues {
+				if issue.Field == tt.expectedField {
+					found = true
+					if issue.Message == "" {
+						t.Error("Issue message should not be empty")
+					}
+				}
+			}
+			if !found {
+				t.Errorf("Expected issue for field %q, got %v", tt.expectedField, issues)
+			}
+		})
+	}
+}
+
+// TestValidateRequestUnknownModel tests the model check against a fetched provider model list
+func TestValidateRequestUnknownModel(t *testing.T) {
+	// The list includes the default tier models so other validation tests are unaffected
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data": [{"id": "gpt-5"}, {"id": "gpt-5-mini"}, {"id": "x-ai/grok-code-fast-1"}]}`))
+	}))
+	defer server.Close()
+
+	if err := (&config.Config{OpenAIBaseURL: server.URL}).FetchAvailableModels(); err != nil {
+		t.Fatalf("FetchAvailableModels() error = %v", err)
+	}
+
+	newRequest := func() models.ClaudeRequest {
+		return models.ClaudeRequest{
+			Model:     "claude-sonnet-4-5",
+			MaxTokens: 100,
+			Messages:  []models.ClaudeMessage{{Role: "user", Content: "hi"}},
+		}
+	}
+
+	t.Run("unknown mapped model reported", func(t *testing.T) {
+		issues, _ := ValidateRequest(newRequest(), &config.Config{SonnetModel: "x-ai/grok-code-fst-1"})
+		if len(issues) != 1 || issues[0].Field != "model" {
+			t.Fatalf("Expected a single model issue, got %v", issues)
+		}
+		if !strings.Contains(issues[0].Message, "x-ai/grok-code-fst-1") {
+			t.Errorf("Issue should name the mapped model, got %q", issues[0].Message)
+		}
+	})
+
+	t.Run("known mapped model accepted", func(t *testing.T) {
+		if issues, _ := ValidateRequest(newRequest(), &config.Config{SonnetModel: "x-ai/grok-code-fast-1"}); len(issues) != 0 {
+			t.Errorf("Expected no issues, got %v", issues)
+		}
+	})
+}
@@ -6,33 +6,84 @@
 package daemon
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"syscall"
+	"time"
 )
 
 const (
 	pidFile   = "/tmp/claude-code-proxy.pid"
 	healthURL = "http://localhost:8082/health"
+
+	// defaultStopGrace is how long stop waits after SIGTERM before sending SIGKILL
+	// (override with STOP_GRACE_SECONDS)
+	defaultStopGrace = 10 * time.Second
+	// stopKillWait is how long stop waits for the process to go away after SIGKILL
+	stopKillWait     = 2 * time.Second
+	stopPollInterval = 100 * time.Millisecond
+
+	// healthCheckTimeout bounds the health check, so a hung proxy can't block
+	// status, start or stop
+	healthCheckTimeout = time.Second
 )
 
+// healthClient is used for health checks
+var healthClient = &http.Client{Timeout: healthCheckTimeout}
+
 // IsRunning checks if the proxy daemon is running
 func IsRunning() bool {
-	// Try health check first
-	resp, err := http.Get(healthURL)
-	if err == nil {
-		_ = resp.Body.Close()
-		return resp.StatusCode == 200
+	// Try health check first, on the port recorded in the PID file (the default
+	// port without one)
+	info, _ := readPIDInfo()
+	if healthy, err := checkHealth(info.healthURL()); err == nil {
+		return healthy
 	}
 
-	// Fallback: check PID file
+	// Fallback (not answering, e.g. stopped or hung): check PID file
 	return isProcessRunning()
 }
 
+// checkHealth reports whether the health endpoint answers 200. An error means
+// it didn't answer within healthCheckTimeout.
+func checkHealth(url string) (bool, error) {
+	resp, err := healthClient.Get(url)
+	if err != nil {
+		return false, err
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode == 200, nil
+}
+
+// PIDInfo is the PID file content, written as JSON so status can report more
+// than the PID. Files from older versions hold only the PID as a plain integer.
+type PIDInfo struct {
+	PID       int       `json:"pid"`
+	Port      string    `json:"port,omitempty"`
+	Upstream  string    `json:"upstream,omitempty"` // Host of OPENAI_BASE_URL, never the full URL
+	StartedAt time.Time `json:"started_at,omitempty"`
+	Version   string    `json:"version,omitempty"`
+}
+
+// NewPIDInfo describes this proxy instance for the PID file. Only the host of
+// baseURL is kept so paths or credentials in it don't end up in /tmp.
+func NewPIDInfo(port, baseURL, version string) PIDInfo {
+	info := PIDInfo{Port: port, Version: version}
+	if u, err := url.Parse(baseURL); err == nil {
+		info.Upstream = u.Host
+	}
+	return info
+}
+
 // Start daemonizes the current process
-func Start() error {
+func Start(info PIDInfo) error {
 	// Already running check
 	if IsRunning() {
 		return fmt.Errorf("proxy is already running")
@@ -42,7 +93,7 @@ func Start() error {
 	cleanupPID()
 
 	// Write PID file
-	if err := writePID(); err != nil {
+	if err := writePID(info); err != nil {
 		return fmt.Errorf("failed to write PID file: %w", err)
 	}
 
@@ -69,21 +120,124 @@ func Stop() {
 		return
 	}
 
-	if err := process.Signal(syscall.SIGTERM); err != nil {
+	// A stale PID file may point at a recycled PID owned by something else
+	if err := verifyProcess(pid); err != nil {
+		fmt.Fprintf(os.Stderr, "Refusing to stop PID %d: %v\n", pid, err)
+		fmt.Fprintf(os.Stderr, "Remove %s if the proxy is no longer running\n", pidFile)
+		return
+	}
+
+	grace := stopGrace()
+	killed, err := stopProcess(process, grace, stopPollInterval)
+	if err != nil {
+		// Keep the PID file: the process may still be running
 		fmt.Fprintf(os.Stderr, "Error stopping process: %v\n", err)
 		return
 	}
 
 	cleanupPID()
+	if killed {
+		fmt.Printf("⚠️  Proxy did not exit within %s, killed (PID: %d)\n", grace, pid)
+		return
+	}
 	fmt.Println("✅ Proxy stopped")
 }
 
+// verifyProcess checks that a PID belongs to this proxy before it is signalled
+// (a variable so tests can stop mock processes)
+var verifyProcess = verifyProxyProcess
+
+// verifyProxyProcess checks via /proc/<pid>/exe that pid runs the same executable
+// as this binary. Without procfs (e.g. macOS) the check is skipped.
+func verifyProxyProcess(pid int) error {
+	target, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		if _, statErr := os.Stat("/proc/self/exe"); statErr != nil {
+			return nil
+		}
+		return fmt.Errorf("cannot verify process: %w", err)
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("cannot resolve own executable: %w", err)
+	}
+
+	// Names are compared rather than full paths so a proxy started from another
+	// copy of the binary (or one replaced by an upgrade) still matches
+	target = strings.TrimSuffix(target, " (deleted)")
+	if filepath.Base(target) != filepath.Base(self) {
+		return fmt.Errorf("process runs %s, not %s", target, filepath.Base(self))
+	}
+	return nil
+}
+
+// stopGrace returns the SIGTERM grace period from STOP_GRACE_SECONDS
+func stopGrace() time.Duration {
+	if value := os.Getenv("STOP_GRACE_SECONDS"); value != "" {
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 {
+			return time.Duration(seconds * float64(time.Second))
+		}
+		fmt.Fprintf(os.Stderr, "Invalid STOP_GRACE_SECONDS %q, using %s\n", value, defaultStopGrace)
+	}
+	return defaultStopGrace
+}
+
+// stopProcess sends SIGTERM and waits up to grace for the process to exit,
+// escalating to SIGKILL if it doesn't. Returns whether SIGKILL was needed, and
+// an error if the process could not be signalled or is still running.
+func stopProcess(process *os.Process, grace, poll time.Duration) (bool, error) {
+	if err := process.Signal(syscall.SIGTERM); err != nil {
+		if errors.Is(err, os.ErrProcessDone) {
+			return false, nil
+		}
+		return false, err
+	}
+	if waitForExit(process, grace, poll) {
+		return false, nil
+	}
+
+	if err := process.Signal(syscall.SIGKILL); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return false, fmt.Errorf("process %d ignored SIGTERM and SIGKILL failed: %w", process.Pid, err)
+	}
+	if !waitForExit(process, stopKillWait, poll) {
+		return true, fmt.Errorf("process %d still running after SIGKILL", process.Pid)
+	}
+	return true, nil
+}
+
+// waitForExit polls until the process is gone or timeout passes
+func waitForExit(process *os.Process, timeout, poll time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for processAlive(process) {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(poll)
+	}
+	return true
+}
+
 // Status prints the current daemon status
 func Status() {
 	if IsRunning() {
-		pid, _ := readPID()
-		fmt.Printf("✅ Proxy is running (PID: %d)\n", pid)
-		fmt.Printf("   Health endpoint: %s\n", healthURL)
+		info, err := readPIDInfo()
+		if err != nil {
+			fmt.Println("✅ Proxy is running")
+			fmt.Printf("   Health endpoint: %s\n", healthURL)
+			return
+		}
+		fmt.Printf("✅ Proxy is running (PID: %d)\n", info.PID)
+		if info.Version != "" {
+			fmt.Printf("   Version: %s\n", info.Version)
+		}
+		if info.Upstream != "" {
+			fmt.Printf("   Upstream: %s\n", info.Upstream)
+		}
+		if !info.StartedAt.IsZero() {
+			fmt.Printf("   Started: %s (up %s)\n", info.StartedAt.Format(time.RFC3339), time.Since(info.StartedAt).Round(time.Second))
+		}
+		fmt.Printf("   Health endpoint: %s\n", info.healthURL())
 	} else {
 		fmt.Println("❌ Proxy is not running")
 	}
@@ -91,17 +245,53 @@ func Status() {
 
 // Helper functions
 
-func writePID() error {
-	pid := os.Getpid()
-	return os.WriteFile(pidFile, []byte(strconv.Itoa(pid)), 0644)
+// writePID writes the PID file for the current process; PID and start time are filled in
+func writePID(info PIDInfo) error {
+	info.PID = os.Getpid()
+	info.StartedAt = time.Now().UTC().Truncate(time.Second)
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(pidFile, data, 0644)
 }
 
 func readPID() (int, error) {
+	info, err := readPIDInfo()
+	return info.PID, err
+}
+
+// readPIDInfo reads the PID file, accepting both the JSON format and the legacy plain PID
+func readPIDInfo() (PIDInfo, error) {
 	data, err := os.ReadFile(pidFile)
 	if err != nil {
-		return 0, err
+		return PIDInfo{}, err
+	}
+	return parsePIDInfo(data)
+}
+
+func parsePIDInfo(data []byte) (PIDInfo, error) {
+	content := strings.TrimSpace(string(data))
+	if pid, err := strconv.Atoi(content); err == nil {
+		return PIDInfo{PID: pid}, nil
+	}
+
+	var info PIDInfo
+	if err := json.Unmarshal([]byte(content), &info); err != nil {
+		return PIDInfo{}, fmt.Errorf("invalid PID file: %w", err)
+	}
+	if info.PID <= 0 {
+		return PIDInfo{}, fmt.Errorf("invalid PID file: missing pid")
 	}
-	return strconv.Atoi(string(data))
+	return info, nil
+}
+
+// healthURL is the instance's health endpoint (the default port for legacy PID files)
+func (info PIDInfo) healthURL() string {
+	if info.Port == "" {
+		return healthURL
+	}
+	return "http://localhost:" + info.Port + "/health"
 }
 
 func cleanupPID() {
@@ -119,9 +309,12 @@ func isProcessRunning() bool {
 		return false
 	}
 
-	// Send signal 0 to check if process exists
-	err = process.Signal(syscall.Signal(0))
-	return err == nil
+	return processAlive(process)
+}
+
+// processAlive reports whether the process exists (signal 0 succeeds)
+func processAlive(process *os.Process) bool {
+	return process.Signal(syscall.Signal(0)) == nil
 }
 
 // Cleanup should be called on shutdown
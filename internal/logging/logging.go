@@ -0,0 +1,71 @@
+// Package logging writes the proxy's request summaries and error lines.
+//
+// Output always goes to stdout. When LOG_FILE is configured it is also written to
+// a size-rotated file, so a detached daemon doesn't lose its logs.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+)
+
+var (
+	mu     sync.RWMutex
+	output io.Writer = os.Stdout
+)
+
+// Init configures the log output from cfg. It is a no-op when LOG_FILE is unset.
+func Init(cfg *config.Config) error {
+	if cfg.LogFile == "" {
+		return nil
+	}
+
+	fileWriter, err := NewRotatingWriter(cfg.LogFile, int64(cfg.LogFileMaxMB)*1024*1024, cfg.LogFileBackups)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	output = io.MultiWriter(os.Stdout, fileWriter)
+	mu.Unlock()
+	return nil
+}
+
+// SetOutput replaces the log output, e.g. to capture log lines in tests, and
+// returns a function restoring the previous one
+func SetOutput(w io.Writer) func() {
+	mu.Lock()
+	previous := output
+	output = w
+	mu.Unlock()
+	return func() {
+		mu.Lock()
+		output = previous
+		mu.Unlock()
+	}
+}
+
+// Writer returns the current log output, e.g. for the HTTP access logger
+func Writer() io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		mu.RLock()
+		defer mu.RUnlock()
+		return output.Write(p)
+	})
+}
+
+// Printf formats a log line and writes it to the log output
+func Printf(format string, args ...interface{}) {
+	_, _ = fmt.Fprintf(Writer(), format, args...)
+}
+
+// writerFunc adapts a function to io.Writer
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
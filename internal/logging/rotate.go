@@ -0,0 +1,92 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingWriter is an io.Writer that appends to a file and rotates it once it
+// grows past maxBytes. Rotated files are named path.1 (newest) to path.N (oldest).
+type RotatingWriter struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	backups  int
+	file     *os.File
+	size     int64
+}
+
+// NewRotatingWriter opens (or creates) the log file at path for appending
+func NewRotatingWriter(path string, maxBytes int64, backups int) (*RotatingWriter, error) {
+	w := &RotatingWriter{
+		path:     path,
+		maxBytes: maxBytes,
+		backups:  backups,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write appends p to the log file, rotating first if p would exceed the size limit
+func (w *RotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.maxBytes > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxBytes {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Close closes the current log file
+func (w *RotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
+
+// open opens the log file for appending and records its current size
+func (w *RotatingWriter) open() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+	w.file = file
+	w.size = info.Size()
+	return nil
+}
+
+// rotate shifts path.N-1 -> path.N ... path -> path.1 and starts a fresh file.
+// With no backups configured the current file is simply truncated.
+func (w *RotatingWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %w", err)
+	}
+
+	if w.backups > 0 {
+		_ = os.Remove(fmt.Sprintf("%s.%d", w.path, w.backups))
+		for i := w.backups - 1; i >= 1; i-- {
+			_ = os.Rename(fmt.Sprintf("%s.%d", w.path, i), fmt.Sprintf("%s.%d", w.path, i+1))
+		}
+		if err := os.Rename(w.path, w.path+".1"); err != nil {
+			return fmt.Errorf("failed to rotate log file: %w", err)
+		}
+	} else if err := os.Remove(w.path); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+
+	return w.open()
+}
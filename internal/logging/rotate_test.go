@@ -0,0 +1,80 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+)
+
+// TestRotatingWriter tests that lines are appended and the file rotates past the size limit
+func TestRotatingWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.log")
+
+	w, err := NewRotatingWriter(path, 64, 2)
+	if err != nil {
+		t.Fatalf("NewRotatingWriter() error = %v", err)
+	}
+	defer func() { _ = w.Close() }()
+
+	line := strings.Repeat("x", 29) + "\n" // 30 bytes, two lines fit in 64
+	for i := 0; i < 2; i++ {
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Fatal("Should not rotate below the size limit")
+	}
+
+	// Third line exceeds the limit and rotates
+	if _, err := w.Write([]byte("third\n")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	rotated, err := os.ReadFile(path + ".1")
+	if err != nil {
+		t.Fatalf("Expected rotated backup: %v", err)
+	}
+	if string(rotated) != line+line {
+		t.Errorf("Backup content = %q, want the first two lines", rotated)
+	}
+	current, _ := os.ReadFile(path)
+	if string(current) != "third\n" {
+		t.Errorf("Current log = %q, want %q", current, "third\n")
+	}
+
+	// Further rotations keep at most 2 backups
+	for i := 0; i < 6; i++ {
+		if _, err := w.Write([]byte(line + line)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if _, err := os.Stat(path + ".2"); err != nil {
+		t.Errorf("Expected second backup: %v", err)
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("Should keep no more than 2 backups")
+	}
+}
+
+// TestInitWritesLogFile tests that Printf output reaches the configured log file
+func TestInitWritesLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.log")
+	previous := output
+	defer func() { output = previous }()
+
+	if err := Init(&config.Config{LogFile: path, LogFileMaxMB: 1, LogFileBackups: 1}); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	Printf("[12:00:00] [REQ] %s model=%s\n", "https://api.openai.com/v1", "gpt-5")
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	if !strings.Contains(string(content), "[REQ] https://api.openai.com/v1 model=gpt-5") {
+		t.Errorf("Log file content = %q, want the request summary", content)
+	}
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// circuitBreaker stops sending requests to a failing provider for a cooldown period.
+// After CIRCUIT_BREAKER_THRESHOLD consecutive upstream failures (transport errors or
+// 5xx responses) the breaker opens and requests are rejected immediately with an
+// overloaded error until the cooldown elapses. The breaker is then half-open: the
+// next request is let through as a probe and the others are still rejected until
+// it finishes. Success closes the breaker, failure re-opens it.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time // zero while closed
+	probing   bool      // half-open with the probe in flight
+}
+
+// upstreamBreaker is shared by all requests to the configured provider
+var upstreamBreaker = &circuitBreaker{}
+
+// circuitOpenError is returned when a request is rejected by an open breaker
+type circuitOpenError struct {
+	RetryAfter time.Duration
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("upstream provider is failing repeatedly, circuit breaker open for another %ds", retryAfterSeconds(e.RetryAfter))
+}
+
+// allow reports whether a request may be sent, and whether it is the probe of a
+// half-open breaker, whose outcome must be passed to record. When the request is
+// rejected it returns the time remaining until the next probe may be allowed.
+func (b *circuitBreaker) allow(now time.Time) (ok, probe bool, retryAfter time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case b.openUntil.IsZero():
+		return true, false, 0
+	case now.Before(b.openUntil):
+		return false, false, b.openUntil.Sub(now)
+	case b.probing:
+		// The probe hasn't answered yet; it decides within one upstream call
+		return false, false, time.Second
+	}
+	b.probing = true
+	return true, true, 0
+}
+
+// record updates the breaker with the outcome of an upstream request. probe is
+// what allow returned for it.
+func (b *circuitBreaker) record(probe, failed bool, threshold int, cooldown time.Duration, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probing = false
+	}
+	if !failed {
+		b.failures = 0
+		b.openUntil = time.Time{}
+		b.probing = false
+		return
+	}
+
+	b.failures++
+	if b.failures >= threshold {
+		b.openUntil = now.Add(cooldown)
+	}
+}
+
+// retryAfterSeconds rounds a duration up to whole seconds for the Retry-After header
+func retryAfterSeconds(d time.Duration) int {
+	seconds := int((d + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
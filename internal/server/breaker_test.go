@@ -0,0 +1,179 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+)
+
+// TestCircuitBreakerStateTransitions tests opening, cooldown, and recovery
+func TestCircuitBreakerStateTransitions(t *testing.T) {
+	b := &circuitBreaker{}
+	now := time.Now()
+	cooldown := 10 * time.Second
+
+	b.record(false, true, 2, cooldown, now)
+	if ok, _, _ := b.allow(now); !ok {
+		t.Fatal("Breaker should stay closed below the threshold")
+	}
+
+	b.record(false, true, 2, cooldown, now)
+	ok, _, retryAfter := b.allow(now.Add(time.Second))
+	if ok {
+		t.Fatal("Breaker should open at the threshold")
+	}
+	if retryAfter != 9*time.Second {
+		t.Errorf("RetryAfter = %v, want 9s", retryAfter)
+	}
+
+	// Cooldown elapsed - probe allowed
+	ok, probe, _ := b.allow(now.Add(cooldown))
+	if !ok || !probe {
+		t.Fatal("Breaker should allow a probe after the cooldown")
+	}
+
+	// A failed probe re-opens it for another cooldown
+	b.record(probe, true, 2, cooldown, now.Add(cooldown))
+	if ok, _, _ := b.allow(now.Add(cooldown + time.Second)); ok {
+		t.Fatal("Failed probe should re-open the breaker")
+	}
+
+	// Successful probe closes the breaker
+	_, probe, _ = b.allow(now.Add(2 * cooldown))
+	b.record(probe, false, 2, cooldown, now.Add(2*cooldown))
+	b.record(false, true, 2, cooldown, now.Add(2*cooldown))
+	if ok, probe, _ := b.allow(now.Add(2 * cooldown)); !ok || probe {
+		t.Error("Success should close the breaker and reset the failure count")
+	}
+}
+
+// TestCircuitBreakerHalfOpen tests that concurrent requests after the cooldown
+// let exactly one probe through until its outcome is recorded
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	b := &circuitBreaker{}
+	now := time.Now()
+	cooldown := 10 * time.Second
+	b.record(false, true, 1, cooldown, now)
+
+	halfOpen := now.Add(cooldown)
+	var allowed, probes int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, probe, retryAfter := b.allow(halfOpen)
+			if ok {
+				atomic.AddInt32(&allowed, 1)
+			} else if retryAfter <= 0 {
+				t.Errorf("Rejected request without a retry delay")
+			}
+			if probe {
+				atomic.AddInt32(&probes, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 1 || probes != 1 {
+		t.Fatalf("allowed = %d, probes = %d, want exactly one probe", allowed, probes)
+	}
+
+	// A late failure of a request sent before the breaker opened doesn't end the probe
+	b.record(false, true, 1, cooldown, now)
+	if ok, _, _ := b.allow(halfOpen.Add(time.Second)); ok {
+		t.Error("Second request allowed while the probe is in flight")
+	}
+
+	b.record(true, false, 1, cooldown, halfOpen)
+	for i := 0; i < 3; i++ {
+		if ok, probe, _ := b.allow(halfOpen); !ok || probe {
+			t.Errorf("Request %d after a successful probe: ok = %v, probe = %v, want a closed breaker", i, ok, probe)
+		}
+	}
+}
+
+// TestCircuitBreakerOpenResponse tests that an open breaker returns 529 with Retry-After
+func TestCircuitBreakerOpenResponse(t *testing.T) {
+	upstreamBreaker = &circuitBreaker{}
+	defer func() { upstreamBreaker = &circuitBreaker{} }()
+
+	var calls int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadGateway)
+		_, _ = w.Write([]byte(`{"error":{"message":"upstream down"}}`))
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{
+		OpenAIBaseURL:             upstream.URL,
+		OpenAIAPIKey:              "test-key",
+		CircuitBreakerThreshold:   2,
+		CircuitBreakerCooldownSec: 30,
+	}
+	app := newTestApp(cfg)
+
+	// Two failures open the breaker
+	for i := 0; i < 2; i++ {
+		if resp := postJSON(t, app, "/v1/messages", testClaudeRequestBody); resp.StatusCode != 500 {
+			t.Fatalf("Request %d status = %d, want 500", i, resp.StatusCode)
+		}
+	}
+
+	resp := postJSON(t, app, "/v1/messages", testClaudeRequestBody)
+	if resp.StatusCode != 529 {
+		t.Fatalf("Status = %d, want 529", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Retry-After"); got != "30" {
+		t.Errorf("Retry-After = %q, want %q", got, "30")
+	}
+
+	var body map[string]interface{}
+	_ = json.NewDecoder(resp.Body).Decode(&body)
+	errObj, _ := body["error"].(map[string]interface{})
+	if body["type"] != "error" || errObj["type"] != "overloaded_error" {
+		t.Errorf("Unexpected error body: %v", body)
+	}
+
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("Upstream calls = %d, want 2 (open breaker must not call upstream)", got)
+	}
+
+	// Streaming requests are rejected the same way
+	streamBody := `{"model":"claude-sonnet-4","max_tokens":100,"stream":true,"messages":[{"role":"user","content":"hello"}]}`
+	if resp := postJSON(t, app, "/v1/messages", streamBody); resp.StatusCode != 529 {
+		t.Errorf("Streaming status = %d, want 529", resp.StatusCode)
+	}
+}
+
+// TestCircuitBreakerIgnoresClientErrors tests that 4xx responses don't trip the breaker
+func TestCircuitBreakerIgnoresClientErrors(t *testing.T) {
+	upstreamBreaker = &circuitBreaker{}
+	defer func() { upstreamBreaker = &circuitBreaker{} }()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":{"message":"bad request"}}`))
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{
+		OpenAIBaseURL:             upstream.URL,
+		OpenAIAPIKey:              "test-key",
+		CircuitBreakerThreshold:   1,
+		CircuitBreakerCooldownSec: 30,
+	}
+	app := newTestApp(cfg)
+
+	for i := 0; i < 3; i++ {
+		if resp := postJSON(t, app, "/v1/messages", testClaudeRequestBody); resp.StatusCode == 529 {
+			t.Fatalf("Request %d tripped the breaker on a client error", i)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+)
+
+// captureFileLimit caps the captured response written to a capture file
+const captureFileLimit = 16 * 1024 * 1024
+
+// capturePrefix names capture files: capture-<time>-<seq>.json[.gz]
+const capturePrefix = "capture-"
+
+var (
+	captureSeq atomic.Int64
+	// captureMu serializes pruning so concurrent requests don't race on the directory
+	captureMu sync.Mutex
+)
+
+// captureEnabled reports whether exchanges are written to CAPTURE_DIR
+func captureEnabled(cfg *config.Config) bool {
+	return cfg.CaptureDir != ""
+}
+
+// captureSampled draws whether a successful exchange is written to CAPTURE_DIR
+// (CAPTURE_SAMPLE_RATE); failed exchanges are written regardless
+func captureSampled(cfg *config.Config) bool {
+	return captureEnabled(cfg) && rand.Float64() < cfg.CaptureSampleRate
+}
+
+// exchangeFailed reports whether an exchange ended in an error: an HTTP error
+// status, or a stream whose transcript contains an error event
+func exchangeFailed(status int, response string) bool {
+	return status >= 400 || strings.Contains(response, "event: error\n")
+}
+
+// writeCapture writes the exchange to a file in CAPTURE_DIR (gzipped when
+// CAPTURE_COMPRESS is set), then prunes captures beyond the retention limits
+func writeCapture(cfg *config.Config, e *debugExchange) (string, error) {
+	if err := os.MkdirAll(cfg.CaptureDir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create capture dir: %w", err)
+	}
+
+	name := fmt.Sprintf("%s%s-%06d.json", capturePrefix, e.Time.Format("20060102-150405.000"), captureSeq.Add(1))
+	if cfg.CaptureCompress {
+		name += ".gz"
+	}
+	path := filepath.Join(cfg.CaptureDir, name)
+
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal capture: %w", err)
+	}
+
+	// Captures contain full prompts, so keep them private to the user
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return "", fmt.Errorf("failed to create capture file: %w", err)
+	}
+	if cfg.CaptureCompress {
+		zw := gzip.NewWriter(f)
+		_, err = zw.Write(data)
+		if closeErr := zw.Close(); err == nil {
+			err = closeErr
+		}
+	} else {
+		_, err = f.Write(data)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write capture file: %w", err)
+	}
+
+	if cfg.CaptureMaxFiles > 0 || cfg.CaptureMaxAge > 0 {
+		captureMu.Lock()
+		err = pruneCaptures(cfg.CaptureDir, cfg.CaptureMaxFiles, cfg.CaptureMaxAge, time.Now())
+		captureMu.Unlock()
+		if err != nil {
+			return path, fmt.Errorf("failed to prune captures: %w", err)
+		}
+	}
+	return path, nil
+}
+
+// pruneCaptures removes capture files older than maxAge, then the oldest files
+// beyond maxFiles (0 disables either limit). Other files in dir are left alone.
+func pruneCaptures(dir string, maxFiles int, maxAge time.Duration, now time.Time) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	type captureFile struct {
+		path    string
+		modTime time.Time
+	}
+	var files []captureFile
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, capturePrefix) ||
+			!(strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".json.gz")) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // removed concurrently
+		}
+		files = append(files, captureFile{path: filepath.Join(dir, name), modTime: info.ModTime()})
+	}
+
+	// Newest first
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+
+	for i, f := range files {
+		expired := maxAge > 0 && now.Sub(f.modTime) > maxAge
+		overLimit := maxFiles > 0 && i >= maxFiles
+		if expired || overLimit {
+			if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+)
+
+// TestCaptureCompressRoundTrip tests that gzipped capture files decode to the original exchange
+func TestCaptureCompressRoundTrip(t *testing.T) {
+	cfg := &config.Config{CaptureDir: t.TempDir(), CaptureCompress: true}
+	exchange := &debugExchange{
+		Time:          time.Now(),
+		Stream:        true,
+		Status:        200,
+		ClaudeRequest: json.RawMessage(`{"model":"claude-sonnet-4"}`),
+		OpenAIRequest: json.RawMessage(`{"model":"gpt-4o"}`),
+		Response:      strings.Repeat("event: content_block_delta\ndata: {}\n\n", 1000),
+	}
+
+	path, err := writeCapture(cfg, exchange)
+	if err != nil {
+		t.Fatalf("writeCapture() error = %v", err)
+	}
+	if !strings.HasSuffix(path, ".json.gz") {
+		t.Errorf("Compressed capture should end in .json.gz, got %s", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	info, _ := f.Stat()
+	if info.Size() >= int64(len(exchange.Response)) {
+		t.Errorf("Compressed file is %d bytes, expected smaller than the %d byte response", info.Size(), len(exchange.Response))
+	}
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("gzip.NewReader() error = %v", err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded debugExchange
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Capture is not valid JSON: %v", err)
+	}
+	var openaiReq bytes.Buffer
+	_ = json.Compact(&openaiReq, decoded.OpenAIRequest)
+	if decoded.Response != exchange.Response || openaiReq.String() != `{"model":"gpt-4o"}` || !decoded.Stream {
+		t.Errorf("Decoded capture does not match the original exchange: %+v", decoded)
+	}
+}
+
+// TestPruneCaptures tests the CAPTURE_MAX_FILES and CAPTURE_MAX_AGE retention limits
+func TestPruneCaptures(t *testing.T) {
+	now := time.Now()
+
+	setup := func(t *testing.T) string {
+		dir := t.TempDir()
+		// capture-0 is the newest, capture-4 the oldest (one hour apart)
+		for i := 0; i < 5; i++ {
+			path := filepath.Join(dir, capturePrefix+string(rune('0'+i))+".json")
+			if i%2 == 1 {
+				path += ".gz"
+			}
+			if err := os.WriteFile(path, []byte("{}"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			modTime := now.Add(-time.Duration(i) * time.Hour)
+			if err := os.Chtimes(path, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Unrelated files are never pruned
+		if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("keep"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+
+	remaining := func(t *testing.T, dir string) []string {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		return names
+	}
+
+	tests := []struct {
+		name     string
+		maxFiles int
+		maxAge   time.Duration
+		want     []string
+	}{
+		{"max files", 2, 0, []string{"capture-0.json", "capture-1.json.gz", "notes.txt"}},
+		{"max age", 0, 150 * time.Minute, []string{"capture-0.json", "capture-1.json.gz", "capture-2.json", "notes.txt"}},
+		{"both limits", 3, 90 * time.Minute, []string{"capture-0.json", "capture-1.json.gz", "notes.txt"}},
+		{"no limits", 0, 0, []string{"capture-0.json", "capture-1.json.gz", "capture-2.json", "capture-3.json.gz", "capture-4.json", "notes.txt"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setup(t)
+			if err := pruneCaptures(dir, tt.maxFiles, tt.maxAge, now); err != nil {
+				t.Fatalf("pruneCaptures() error = %v", err)
+			}
+			if got := remaining(t, dir); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Remaining files = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCaptureDirRecordsRequests tests that requests are written to CAPTURE_DIR and pruned to CAPTURE_MAX_FILES
+func TestCaptureDirRecordsRequests(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}`))
+	}))
+	defer upstream.Close()
+
+	dir := t.TempDir()
+	cfg := &config.Config{OpenAIBaseURL: upstream.URL, CaptureDir: dir, CaptureMaxFiles: 2, CaptureSampleRate: 1}
+	app := newTestApp(cfg)
+
+	for i := 0; i < 3; i++ {
+		if resp := postJSON(t, app, "/v1/messages", testClaudeRequestBody); resp.StatusCode != 200 {
+			t.Fatalf("Request %d: status %d", i, resp.StatusCode)
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, capturePrefix+"*.json"))
+	if len(files) != 2 {
+		t.Fatalf("Expected 2 capture files after pruning, got %d", len(files))
+	}
+	data, _ := os.ReadFile(files[0])
+	if !strings.Contains(string(data), `"claude-sonnet-4"`) || !strings.Contains(string(data), "msg_") {
+		t.Errorf("Capture should contain the request and response, got %s", data)
+	}
+}
+
+// TestCaptureSampleRate tests that CAPTURE_SAMPLE_RATE captures a fraction of successful
+// requests and every failed one
+func TestCaptureSampleRate(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), `"model":"broken"`) {
+			w.WriteHeader(500)
+			_, _ = w.Write([]byte(`{"error":{"message":"boom"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}`))
+	}))
+	defer upstream.Close()
+
+	dir := t.TempDir()
+	cfg := &config.Config{OpenAIBaseURL: upstream.URL, CaptureDir: dir, CaptureSampleRate: 0.1, HaikuModel: "broken"}
+	app := newTestApp(cfg)
+
+	const successes, failures = 1000, 20
+	for i := 0; i < successes; i++ {
+		postJSON(t, app, "/v1/messages", testClaudeRequestBody)
+	}
+	failing := strings.Replace(testClaudeRequestBody, "claude-sonnet-4", "claude-haiku-4", 1)
+	for i := 0; i < failures; i++ {
+		postJSON(t, app, "/v1/messages", failing)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, capturePrefix+"*.json"))
+	var captured, capturedFailures int
+	for _, file := range files {
+		data, _ := os.ReadFile(file)
+		if strings.Contains(string(data), `"status": 500`) {
+			capturedFailures++
+		} else {
+			captured++
+		}
+	}
+
+	if capturedFailures != failures {
+		t.Errorf("Captured %d failed requests, want all %d", capturedFailures, failures)
+	}
+	// Expected 100 (10%); the bounds are over 6 standard deviations wide
+	if captured < 50 || captured > 150 {
+		t.Errorf("Captured %d of %d successful requests, want about 10%%", captured, successes)
+	}
+}
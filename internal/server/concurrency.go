@@ -0,0 +1,76 @@
+package server
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// queueWaitHeader reports how long a request waited for a concurrency slot
+const queueWaitHeader = "X-Proxy-Queue-Ms"
+
+// Fiber locals set by concurrencyLimiter.middleware
+const (
+	queueWaitKey       = "proxy.queueWait"
+	concurrencySlotKey = "proxy.concurrencySlot"
+)
+
+// concurrencyLimiter caps in-flight /v1/messages requests (MAX_CONCURRENT_REQUESTS).
+// Requests over the limit queue until a slot frees up.
+type concurrencyLimiter struct {
+	slots      chan struct{}
+	waitHeader bool
+}
+
+func newConcurrencyLimiter(limit int, waitHeader bool) *concurrencyLimiter {
+	return &concurrencyLimiter{slots: make(chan struct{}, limit), waitHeader: waitHeader}
+}
+
+// middleware waits for a slot, records the wait, and releases the slot when the
+// handler returns unless the handler took it over with holdConcurrencySlot.
+func (l *concurrencyLimiter) middleware(c *fiber.Ctx) error {
+	start := time.Now()
+	l.slots <- struct{}{}
+	wait := time.Since(start)
+
+	c.Locals(queueWaitKey, wait)
+	if l.waitHeader {
+		c.Set(queueWaitHeader, strconv.FormatInt(wait.Milliseconds(), 10))
+	}
+
+	c.Locals(concurrencySlotKey, sync.OnceFunc(func() { <-l.slots }))
+	err := c.Next()
+	if release, ok := c.Locals(concurrencySlotKey).(func()); ok {
+		release()
+	}
+	return err
+}
+
+// holdConcurrencySlot hands the request's slot to the caller, who must release it.
+// Streaming uses this because the body is written after the handler returns.
+// Returns a no-op when no limiter is active.
+func holdConcurrencySlot(c *fiber.Ctx) func() {
+	release, ok := c.Locals(concurrencySlotKey).(func())
+	if !ok {
+		return func() {}
+	}
+	c.Locals(concurrencySlotKey, nil)
+	return release
+}
+
+// queueWait returns how long the request waited for a concurrency slot,
+// and false when no limiter is active.
+func queueWait(c *fiber.Ctx) (time.Duration, bool) {
+	wait, ok := c.Locals(queueWaitKey).(time.Duration)
+	return wait, ok
+}
+
+// queueLogField formats the queue_ms field appended to the [REQ] log line
+func queueLogField(wait time.Duration, limited bool) string {
+	if !limited {
+		return ""
+	}
+	return " queue_ms=" + strconv.FormatInt(wait.Milliseconds(), 10)
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+)
+
+// TestConcurrencyLimitQueueWait tests that a request queued behind a streaming one reports its wait
+func TestConcurrencyLimitQueueWait(t *testing.T) {
+	const hold = 150 * time.Millisecond
+
+	var calls atomic.Int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) > 1 {
+			// Queued non-streaming request
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"id":"x","model":"m","choices":[{"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}]}`)
+			return
+		}
+
+		// First request: start streaming, then hold the stream open
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: "+`{"choices":[{"delta":{"content":"hi"}}]}`+"\n\n")
+		w.(http.Flusher).Flush()
+		close(started)
+		<-release
+		fmt.Fprint(w, upstreamStream(`{"choices":[{"delta":{"content":"done"},"finish_reason":"stop"}]}`))
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{OpenAIBaseURL: upstream.URL, MaxConcurrentRequests: 1, QueueWaitHeader: true}
+	app := newTestApp(cfg)
+
+	send := func(stream bool) <-chan *http.Response {
+		done := make(chan *http.Response, 1)
+		body := strings.Replace(testClaudeRequestBody, `"max_tokens":100`, fmt.Sprintf(`"max_tokens":100,"stream":%t`, stream), 1)
+		go func() {
+			req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Errorf("app.Test() error = %v", err)
+			}
+			done <- resp
+		}()
+		return done
+	}
+
+	first := send(true)
+	<-started
+	second := send(false)
+	time.Sleep(hold)
+	close(release)
+
+	firstResp, secondResp := <-first, <-second
+	if firstResp == nil || secondResp == nil {
+		t.Fatal("request failed")
+	}
+
+	if got := firstResp.Header.Get(queueWaitHeader); got != "0" {
+		t.Errorf("first request %s = %q, want 0", queueWaitHeader, got)
+	}
+
+	// The stream keeps its slot until the body is done, so the second request queues
+	waitMs, err := strconv.Atoi(secondResp.Header.Get(queueWaitHeader))
+	if err != nil {
+		t.Fatalf("second request %s = %q: %v", queueWaitHeader, secondResp.Header.Get(queueWaitHeader), err)
+	}
+	if waitMs < int(hold.Milliseconds())/2 {
+		t.Errorf("second request waited %dms, want at least %dms", waitMs, hold.Milliseconds()/2)
+	}
+	if secondResp.StatusCode != 200 {
+		t.Errorf("second request status = %d, want 200", secondResp.StatusCode)
+	}
+}
+
+// TestQueueLogField tests the queue_ms suffix of the simple log line
+func TestQueueLogField(t *testing.T) {
+	if got := queueLogField(1500*time.Millisecond, true); got != " queue_ms=1500" {
+		t.Errorf("queueLogField(limited) = %q, want %q", got, " queue_ms=1500")
+	}
+	if got := queueLogField(0, false); got != "" {
+		t.Errorf("queueLogField(unlimited) = %q, want empty", got)
+	}
+}
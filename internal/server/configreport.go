@@ -0,0 +1,64 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+	"github.com/claude-code-proxy/proxy/internal/converter"
+)
+
+// PrintConfig writes the resolved configuration and the model caches the proxy
+// learns from the provider (reasoning models, provider model list) to out. The
+// caches are filled the way the daemon fills them at startup, so the report shows
+// what a running proxy would use, e.g. why a model gets max_completion_tokens.
+// Used by `claude-code-proxy config`.
+func PrintConfig(cfg *config.Config, out io.Writer) {
+	printf := func(format string, args ...interface{}) {
+		_, _ = fmt.Fprintf(out, format, args...)
+	}
+
+	printf("Claude Code Proxy configuration\n\n")
+	printf("  Provider:  %s\n", cfg.DetectProvider())
+	if len(cfg.OpenAIBaseURLs) > 1 {
+		printf("  Base URLs: %s\n", strings.Join(cfg.OpenAIBaseURLs, ", "))
+	} else {
+		printf("  Base URL:  %s\n", cfg.OpenAIBaseURL)
+	}
+	for _, tier := range selfTestTiers {
+		model := converter.MapModel(tier.claudeModel, cfg)
+		printf("  %-9s  %s (reasoning model: %t)\n", tier.name+":", model, cfg.IsReasoningModel(model))
+	}
+	features := activeFeatures(cfg)
+	if len(features) == 0 {
+		features = []string{"none"}
+	}
+	printf("  Features:  %s\n", strings.Join(features, ", "))
+
+	// Learned caches
+	reasoningErr := cfg.FetchReasoningModels()
+	modelsErr := cfg.FetchAvailableModels()
+
+	printf("\nReasoning model cache (OpenRouter):\n")
+	printCache(out, reasoningErr, config.ReasoningModels)
+	printf("\nProvider model list cache:\n")
+	printCache(out, modelsErr, config.AvailableModels)
+}
+
+// printCache lists a model cache's contents, or why it is empty
+func printCache(out io.Writer, fetchErr error, contents func() ([]string, bool)) {
+	models, populated := contents()
+	switch {
+	case fetchErr != nil:
+		_, _ = fmt.Fprintf(out, "  not fetched: %v\n", fetchErr)
+	case !populated:
+		_, _ = fmt.Fprintf(out, "  not used for this provider\n")
+	case len(models) == 0:
+		_, _ = fmt.Fprintf(out, "  empty\n")
+	default:
+		for _, model := range models {
+			_, _ = fmt.Fprintf(out, "  - %s\n", model)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+)
+
+// TestPrintConfig tests that the config report includes the learned model caches
+func TestPrintConfig(t *testing.T) {
+	upstream := newSelfTestUpstream(t, http.StatusOK)
+
+	var out bytes.Buffer
+	PrintConfig(&config.Config{OpenAIBaseURL: upstream.URL, SonnetModel: "gpt-5-mini", CompactTools: true}, &out)
+	report := out.String()
+
+	for _, want := range []string{
+		"Base URL:  " + upstream.URL,
+		"sonnet:    gpt-5-mini (reasoning model: true)",
+		"Features:  compact_tools",
+		"Reasoning model cache (OpenRouter):\n  not used for this provider",
+		"Provider model list cache:\n  - gpt-5\n  - gpt-5-mini\n",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report missing %q:\n%s", want, report)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// curlAuthPlaceholder replaces the API key in emitted curl commands; the command
+// works as-is with the key exported in the shell
+const curlAuthPlaceholder = "Bearer $OPENAI_API_KEY"
+
+// curlCommand renders an upstream request as an equivalent curl command, with the
+// Authorization header replaced by curlAuthPlaceholder
+func curlCommand(req *http.Request) string {
+	parts := []string{"curl", "-X", req.Method, shellQuote(req.URL.String())}
+
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range req.Header[name] {
+			if strings.EqualFold(name, "Authorization") {
+				parts = append(parts, "-H", `"`+name+": "+curlAuthPlaceholder+`"`)
+				continue
+			}
+			parts = append(parts, "-H", shellQuote(name+": "+value))
+		}
+	}
+
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			data, _ := io.ReadAll(body)
+			_ = body.Close()
+			if len(data) > 0 {
+				parts = append(parts, "--data-raw", shellQuote(string(data)))
+			}
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// shellQuote single-quotes s for POSIX shells
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
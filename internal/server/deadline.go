@@ -0,0 +1,45 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// deadlineHeader lets the client say how long it is willing to wait, either as an
+// RFC3339 timestamp or as a number of seconds from now
+const deadlineHeader = "x-proxy-deadline"
+
+// minDeadlineBudget is the shortest remaining time worth calling upstream for
+const minDeadlineBudget = time.Second
+
+// parseDeadline parses the x-proxy-deadline header value. An empty value means no
+// deadline (zero time). Deadlines that have passed or leave less than
+// minDeadlineBudget are rejected so no upstream call is wasted on them.
+func parseDeadline(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	var deadline time.Time
+	if secs, err := strconv.ParseFloat(value, 64); err == nil {
+		if math.IsNaN(secs) || math.IsInf(secs, 0) {
+			return time.Time{}, fmt.Errorf("invalid %s %q: expected an RFC3339 timestamp or seconds", deadlineHeader, value)
+		}
+		deadline = now.Add(time.Duration(secs * float64(time.Second)))
+	} else if t, err := time.Parse(time.RFC3339, value); err == nil {
+		deadline = t
+	} else {
+		return time.Time{}, fmt.Errorf("invalid %s %q: expected an RFC3339 timestamp or seconds", deadlineHeader, value)
+	}
+
+	if remaining := deadline.Sub(now); remaining < minDeadlineBudget {
+		if remaining <= 0 {
+			return time.Time{}, fmt.Errorf("%s has already passed", deadlineHeader)
+		}
+		return time.Time{}, fmt.Errorf("%s leaves only %v, less than the %v needed to call the provider",
+			deadlineHeader, remaining.Round(time.Millisecond), minDeadlineBudget)
+	}
+	return deadline, nil
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+)
+
+func TestParseDeadline(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		value   string
+		want    time.Time
+		wantErr string
+	}{
+		{"empty means no deadline", "", time.Time{}, ""},
+		{"seconds", "30", now.Add(30 * time.Second), ""},
+		{"fractional seconds", "1.5", now.Add(1500 * time.Millisecond), ""},
+		{"RFC3339", "2025-01-01T12:01:00Z", now.Add(time.Minute), ""},
+		{"already passed", "2025-01-01T11:59:00Z", time.Time{}, "already passed"},
+		{"too short", "0.2", time.Time{}, "leaves only 200ms"},
+		{"negative seconds", "-5", time.Time{}, "already passed"},
+		{"garbage", "soon", time.Time{}, "invalid x-proxy-deadline"},
+		{"NaN", "NaN", time.Time{}, "invalid x-proxy-deadline"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDeadline(tt.value, now)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseDeadline(%q) error = %v, want %q", tt.value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseDeadline(%q) error = %v", tt.value, err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("parseDeadline(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDeadlineHeader tests that x-proxy-deadline bounds the upstream call
+func TestDeadlineHeader(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		select {
+		case <-release:
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	app := newTestApp(&config.Config{OpenAIBaseURL: upstream.URL})
+
+	send := func(deadline string) (*http.Response, time.Duration) {
+		req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader(testClaudeRequestBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("x-proxy-deadline", deadline)
+		start := time.Now()
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("app.Test() error = %v", err)
+		}
+		return resp, time.Since(start)
+	}
+
+	t.Run("short deadline cancels upstream request", func(t *testing.T) {
+		resp, elapsed := send("1.2")
+
+		if resp.StatusCode != 504 {
+			t.Errorf("Status = %d, want 504", resp.StatusCode)
+		}
+		if elapsed < 1100*time.Millisecond || elapsed > 3*time.Second {
+			t.Errorf("Request took %v, want it cancelled at the ~1.2s deadline", elapsed)
+		}
+	})
+
+	t.Run("past deadline rejected without calling upstream", func(t *testing.T) {
+		before := calls.Load()
+		resp, _ := send(time.Now().Add(-time.Minute).Format(time.RFC3339))
+
+		if resp.StatusCode != 400 {
+			t.Errorf("Status = %d, want 400", resp.StatusCode)
+		}
+		if calls.Load() != before {
+			t.Error("Upstream should not be called for an expired deadline")
+		}
+	})
+}
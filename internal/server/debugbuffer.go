@@ -0,0 +1,192 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+	"github.com/claude-code-proxy/proxy/internal/logging"
+	"github.com/claude-code-proxy/proxy/pkg/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// debugCaptureLimit caps the captured response of a single exchange
+const debugCaptureLimit = 64 * 1024
+
+// secretPattern matches API-key-like tokens (sk-..., sk-or-v1-..., sk-ant-...)
+var secretPattern = regexp.MustCompile(`sk-[A-Za-z0-9_-]{16,}`)
+
+// debugExchange is one request/response pair kept in the debug buffer
+type debugExchange struct {
+	ID            int64           `json:"id"`
+	Time          time.Time       `json:"time"`
+	Stream        bool            `json:"stream"`
+	Status        int             `json:"status"`
+	DurationMs    int64           `json:"duration_ms"`
+	ClaudeRequest json.RawMessage `json:"claude_request"`
+	OpenAIRequest json.RawMessage `json:"openai_request"`
+	Response      string          `json:"response"` // JSON body, or the SSE transcript when streaming
+
+	sampled bool // picked for CAPTURE_DIR by CAPTURE_SAMPLE_RATE
+}
+
+// exchangeRing holds the last N exchanges in memory, evicting the oldest
+type exchangeRing struct {
+	mu      sync.Mutex
+	entries []*debugExchange
+	seq     int64
+}
+
+// debugExchanges backs GET /debug/requests
+var debugExchanges = &exchangeRing{}
+
+// debugBufferEnabled reports whether exchanges are captured. The buffer exposes full
+// request bodies, so it requires debug mode or proxy auth (ANTHROPIC_API_KEY).
+func debugBufferEnabled(cfg *config.Config) bool {
+	return cfg.DebugBufferSize > 0 && (cfg.Debug || cfg.AnthropicAPIKey != "")
+}
+
+// add appends an exchange, keeping at most size entries
+func (r *exchangeRing) add(e *debugExchange, size int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.seq++
+	e.ID = r.seq
+	r.entries = append(r.entries, e)
+	if len(r.entries) > size {
+		r.entries = r.entries[len(r.entries)-size:]
+	}
+}
+
+// recent returns the buffered exchanges, newest first
+func (r *exchangeRing) recent() []*debugExchange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	recent := make([]*debugExchange, len(r.entries))
+	for i, e := range r.entries {
+		recent[len(r.entries)-1-i] = e
+	}
+	return recent
+}
+
+// newDebugExchange starts capturing an exchange, or returns nil when neither the
+// buffer nor capture files (CAPTURE_DIR) are enabled
+func newDebugExchange(cfg *config.Config, claudeBody []byte, openaiReq *models.OpenAIRequest) *debugExchange {
+	if !debugBufferEnabled(cfg) && !captureEnabled(cfg) {
+		return nil
+	}
+
+	openaiJSON, _ := json.Marshal(openaiReq)
+	return &debugExchange{
+		Time:          time.Now(),
+		Stream:        openaiReq.Stream != nil && *openaiReq.Stream,
+		ClaudeRequest: redactJSON(claudeBody, cfg),
+		OpenAIRequest: redactJSON(openaiJSON, cfg),
+		sampled:       captureSampled(cfg),
+	}
+}
+
+// record completes the exchange, writes its capture file and adds it to the buffer.
+// Safe on a nil exchange.
+func (e *debugExchange) record(cfg *config.Config, status int, response string) {
+	if e == nil {
+		return
+	}
+	e.Status = status
+	e.DurationMs = time.Since(e.Time).Milliseconds()
+
+	if captureEnabled(cfg) && (e.sampled || exchangeFailed(status, response)) {
+		e.Response = redactSecrets(truncateCapture(response, captureFileLimit), cfg)
+		if _, err := writeCapture(cfg, e); err != nil {
+			logging.Printf("[%s] [WARN] Capture: %v\n", time.Now().Format("15:04:05"), err)
+		}
+	}
+
+	if debugBufferEnabled(cfg) {
+		e.Response = redactSecrets(truncateCapture(response, debugCaptureLimit), cfg)
+		debugExchanges.add(e, cfg.DebugBufferSize)
+	}
+}
+
+// captureLimit is how much of a streamed response to collect for the exchange
+func captureLimit(cfg *config.Config) int {
+	if captureEnabled(cfg) {
+		return captureFileLimit + 1
+	}
+	return debugCaptureLimit + 1
+}
+
+// truncateCapture cuts a captured response to limit bytes, marking the cut
+func truncateCapture(response string, limit int) string {
+	if len(response) > limit {
+		return response[:limit] + "\n[truncated]"
+	}
+	return response
+}
+
+// recordFiberResponse completes the exchange from the response already written to c
+func (e *debugExchange) recordFiberResponse(c *fiber.Ctx, cfg *config.Config) {
+	if e == nil {
+		return
+	}
+	e.record(cfg, c.Response().StatusCode(), string(c.Response().Body()))
+}
+
+// redactSecrets replaces the configured API keys and key-like tokens
+func redactSecrets(text string, cfg *config.Config) string {
+	for _, secret := range []string{cfg.OpenAIAPIKey, cfg.AnthropicAPIKey} {
+		if len(secret) >= 8 { // skip placeholders like "ollama"
+			text = strings.ReplaceAll(text, secret, "[REDACTED]")
+		}
+	}
+	return secretPattern.ReplaceAllString(text, "[REDACTED]")
+}
+
+// redactJSON redacts a JSON document; replacements happen inside string values,
+// so the result stays valid JSON
+func redactJSON(raw []byte, cfg *config.Config) json.RawMessage {
+	if !json.Valid(raw) {
+		quoted, _ := json.Marshal(redactSecrets(string(raw), cfg))
+		return quoted
+	}
+	return json.RawMessage(redactSecrets(string(raw), cfg))
+}
+
+// handleDebugRequests is the handler for GET /debug/requests
+func handleDebugRequests(c *fiber.Ctx, cfg *config.Config) error {
+	if cfg.AnthropicAPIKey != "" && c.Get("x-api-key") != cfg.AnthropicAPIKey {
+		return claudeError(c, 401, errAuthentication, "Invalid API key")
+	}
+
+	return c.JSON(fiber.Map{
+		"size":     cfg.DebugBufferSize,
+		"requests": debugExchanges.recent(),
+	})
+}
+
+// cappedBuffer collects up to limit bytes and silently drops the rest
+type cappedBuffer struct {
+	buf   bytes.Buffer
+	limit int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); room > 0 {
+		if len(p) > room {
+			b.buf.Write(p[:room])
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+func (b *cappedBuffer) String() string {
+	return b.buf.String()
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+)
+
+// TestDebugRequestBuffer tests that recent exchanges are listed and old ones evicted
+func TestDebugRequestBuffer(t *testing.T) {
+	debugExchanges = &exchangeRing{}
+	defer func() { debugExchanges = &exchangeRing{} }()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}`))
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{OpenAIBaseURL: upstream.URL, Debug: true, DebugBufferSize: 2}
+	app := newTestApp(cfg)
+
+	for i := 1; i <= 3; i++ {
+		body := fmt.Sprintf(`{"model":"claude-sonnet-4","max_tokens":100,"messages":[{"role":"user","content":"request %d sk-or-v1-abcdefghijklmnopqrstuvwxyz"}]}`, i)
+		if resp := postJSON(t, app, "/v1/messages", body); resp.StatusCode != 200 {
+			t.Fatalf("Request %d status = %d, want 200", i, resp.StatusCode)
+		}
+	}
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/debug/requests", nil), -1)
+	if err != nil {
+		t.Fatalf("app.Test() error = %v", err)
+	}
+	var listing struct {
+		Requests []debugExchange `json:"requests"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&listing); err != nil {
+		t.Fatalf("Failed to decode listing: %v", err)
+	}
+
+	if len(listing.Requests) != 2 {
+		t.Fatalf("Expected 2 buffered requests, got %d", len(listing.Requests))
+	}
+	// Newest first; request 1 was evicted
+	for i, want := range []string{"request 3", "request 2"} {
+		entry := listing.Requests[i]
+		if !strings.Contains(string(entry.ClaudeRequest), want) {
+			t.Errorf("Entry %d = %s, want %q", i, entry.ClaudeRequest, want)
+		}
+		if entry.Status != 200 || !strings.Contains(entry.Response, `"hi"`) {
+			t.Errorf("Entry %d response not captured: status=%d response=%q", i, entry.Status, entry.Response)
+		}
+		if strings.Contains(string(entry.ClaudeRequest)+string(entry.OpenAIRequest), "sk-or-v1-") {
+			t.Errorf("Entry %d still contains a secret", i)
+		}
+	}
+}
+
+// TestDebugRequestBufferStreaming tests that streamed exchanges capture the SSE transcript
+func TestDebugRequestBufferStreaming(t *testing.T) {
+	debugExchanges = &exchangeRing{}
+	defer func() { debugExchanges = &exchangeRing{} }()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte(upstreamStream(`{"choices":[{"index":0,"delta":{"content":"streamed hi"},"finish_reason":"stop"}]}`)))
+	}))
+	defer upstream.Close()
+
+	app := newTestApp(&config.Config{OpenAIBaseURL: upstream.URL, Debug: true, DebugBufferSize: 5})
+	resp := postJSON(t, app, "/v1/messages", `{"model":"claude-sonnet-4","max_tokens":100,"stream":true,"messages":[{"role":"user","content":"hello"}]}`)
+	_, _ = io.ReadAll(resp.Body)
+
+	recent := debugExchanges.recent()
+	if len(recent) != 1 || !recent[0].Stream {
+		t.Fatalf("Expected one streamed exchange, got %+v", recent)
+	}
+	if !strings.Contains(recent[0].Response, "event: message_stop") || !strings.Contains(recent[0].Response, "streamed hi") {
+		t.Errorf("SSE transcript not captured: %q", recent[0].Response)
+	}
+}
+
+// TestDebugRequestBufferDisabled tests that the endpoint requires debug mode or proxy auth
+func TestDebugRequestBufferDisabled(t *testing.T) {
+	app := newRootTestApp(&config.Config{DebugBufferSize: 20})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/debug/requests", nil), -1)
+	if err != nil {
+		t.Fatalf("app.Test() error = %v", err)
+	}
+	if resp.StatusCode != 404 {
+		t.Errorf("Status = %d, want 404 without debug mode or auth", resp.StatusCode)
+	}
+
+	// With proxy auth the key is required
+	app = newTestApp(&config.Config{DebugBufferSize: 20, AnthropicAPIKey: "proxy-secret-key"})
+	resp, err = app.Test(httptest.NewRequest("GET", "/debug/requests", nil), -1)
+	if err != nil {
+		t.Fatalf("app.Test() error = %v", err)
+	}
+	if resp.StatusCode != 401 {
+		t.Errorf("Status = %d, want 401 without x-api-key", resp.StatusCode)
+	}
+}
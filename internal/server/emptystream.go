@@ -0,0 +1,157 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+	"github.com/claude-code-proxy/proxy/internal/logging"
+	"github.com/claude-code-proxy/proxy/pkg/models"
+)
+
+// peekedStream replays the bytes read while peeking, then the rest of the body
+type peekedStream struct {
+	io.Reader
+	body io.Closer
+}
+
+func (s *peekedStream) Close() error {
+	return s.body.Close()
+}
+
+// peekStreamContent reads the upstream stream until the first chunk that carries
+// content (or an error), so the stream can be retried before anything reaches the
+// client. Returns the stream to convert, starting from the first byte, and
+// whether it completed without content.
+func peekStreamContent(body io.ReadCloser) (io.ReadCloser, bool) {
+	var peeked bytes.Buffer
+	reader := bufio.NewReader(body)
+	for {
+		line, err := reader.ReadString('\n')
+		peeked.WriteString(line)
+		data := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "data:"))
+		if data == "[DONE]" {
+			break
+		}
+		if strings.HasPrefix(line, "data:") && chunkHasContent(data) {
+			return &peekedStream{Reader: io.MultiReader(&peeked, reader), body: body}, false
+		}
+		if err != nil {
+			break
+		}
+	}
+	return &peekedStream{Reader: io.MultiReader(&peeked, reader), body: body}, true
+}
+
+// streamIDPeekTimeout bounds how long the header phase waits for the first
+// upstream chunk's id. Providers that send keep-alive comments or take long to
+// prefill would otherwise hold back the headers and message_start.
+var streamIDPeekTimeout = 100 * time.Millisecond
+
+// pendingStream is a stream whose first chunk is still being peeked in the
+// background; reads wait for the peek to finish, then replay from the first byte
+type pendingStream struct {
+	done <-chan struct{}
+	io.Reader
+}
+
+func (s *pendingStream) Read(p []byte) (int, error) {
+	<-s.done
+	return s.Reader.Read(p)
+}
+
+// peekStreamID reads the upstream stream up to its first data chunk and returns
+// that chunk's id, or "" when it has none or doesn't arrive within
+// streamIDPeekTimeout. Returns the stream to convert, starting from the first
+// byte; after a timeout the peek carries on and reads wait for it.
+func peekStreamID(body io.ReadCloser) (io.ReadCloser, string) {
+	var peeked bytes.Buffer
+	reader := bufio.NewReader(body)
+	id := ""
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			line, err := reader.ReadString('\n')
+			peeked.WriteString(line)
+			if data, ok := strings.CutPrefix(strings.TrimSpace(line), "data:"); ok {
+				var chunk struct {
+					ID string `json:"id"`
+				}
+				if json.Unmarshal([]byte(strings.TrimSpace(data)), &chunk) == nil {
+					id = chunk.ID
+				}
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	stream := &peekedStream{Reader: &pendingStream{done: done, Reader: io.MultiReader(&peeked, reader)}, body: body}
+	select {
+	case <-done:
+		return stream, id
+	case <-time.After(streamIDPeekTimeout):
+		return stream, ""
+	}
+}
+
+// chunkHasContent reports whether a stream chunk would produce a content block
+// (text, reasoning or a tool call) or is an error the converter should report
+func chunkHasContent(data string) bool {
+	var chunk map[string]interface{}
+	if json.Unmarshal([]byte(data), &chunk) != nil {
+		return false
+	}
+	if _, ok := chunk["error"]; ok {
+		return true
+	}
+	choices, _ := chunk["choices"].([]interface{})
+	for _, choiceRaw := range choices {
+		choice, _ := choiceRaw.(map[string]interface{})
+		delta, _ := choice["delta"].(map[string]interface{})
+		hasText := false
+		deltaOutput(delta, func(text string) { hasText = hasText || text != "" })
+		if hasText {
+			return true
+		}
+		if toolCalls, ok := delta["tool_calls"].([]interface{}); ok && len(toolCalls) > 0 {
+			return true
+		}
+		if details, ok := delta["reasoning_details"].([]interface{}); ok && len(details) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// retryEmptyStream re-issues a streaming request once when the upstream stream
+// completed without any content (STREAM_RETRY_EMPTY). It runs before the response
+// headers and the first event are written, so the retry is invisible to the client
+// and X-Upstream-Message-Id and the rate limit headers describe the stream it gets.
+// Returns the stream to convert and the response it belongs to: the retried one,
+// or the original (nil) when it had content or the retry failed.
+func retryEmptyStream(ctx context.Context, client *http.Client, openaiReq *models.OpenAIRequest, cfg *config.Config, body io.ReadCloser) (io.ReadCloser, *http.Response) {
+	stream, empty := peekStreamContent(body)
+	if !empty {
+		return stream, nil
+	}
+
+	logging.Printf("[%s] [WARN] Upstream stream for %s completed without content, retrying once\n",
+		time.Now().Format("15:04:05"), openaiReq.Model)
+	resp, err := doUpstreamRequest(ctx, client, openaiReq, cfg)
+	if err != nil {
+		logging.Printf("[%s] [WARN] Empty stream retry failed: %v\n", time.Now().Format("15:04:05"), err)
+		return stream, nil
+	}
+	_ = stream.Close()
+	return resp.Body, resp
+}
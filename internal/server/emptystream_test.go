@@ -0,0 +1,121 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+)
+
+// TestStreamRetryEmpty tests that an empty upstream stream is retried once when enabled
+func TestStreamRetryEmpty(t *testing.T) {
+	emptyStream := upstreamStream(
+		`{"id":"chatcmpl-empty","choices":[{"index":0,"delta":{"role":"assistant","content":""},"finish_reason":null}]}`,
+		`{"choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+	)
+	contentStream := upstreamStream(
+		`{"id":"chatcmpl-retried","choices":[{"index":0,"delta":{"content":"Hello"},"finish_reason":null}]}`,
+		`{"choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+	)
+
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		n := calls.Add(1)
+		w.Header().Set("x-ratelimit-remaining-requests", fmt.Sprint(100-n))
+		if n == 1 {
+			fmt.Fprint(w, emptyStream)
+			return
+		}
+		fmt.Fprint(w, contentStream)
+	}))
+	defer upstream.Close()
+
+	streamBody := strings.Replace(testClaudeRequestBody, `"max_tokens":100`, `"max_tokens":100,"stream":true`, 1)
+	var upstreamID, remaining string
+	stream := func(cfg *config.Config) []sseTestEvent {
+		calls.Store(0)
+		resp := postJSON(t, newTestApp(cfg), "/v1/messages", streamBody)
+		upstreamID = resp.Header.Get(upstreamMessageIDHeader)
+		remaining = resp.Header.Get("anthropic-ratelimit-requests-remaining")
+		raw, _ := io.ReadAll(resp.Body)
+		return parseSSEEvents(string(raw))
+	}
+
+	t.Run("enabled retries into the content stream", func(t *testing.T) {
+		events := stream(&config.Config{OpenAIBaseURL: upstream.URL, StreamRetryEmpty: true})
+		if n := calls.Load(); n != 2 {
+			t.Errorf("upstream called %d times, want 2", n)
+		}
+		if starts := eventsOfType(events, "message_start"); len(starts) != 1 {
+			t.Errorf("Expected one message_start, got %d", len(starts))
+		}
+		deltas := eventsOfType(events, "content_block_delta")
+		if len(deltas) != 1 || deltas[0].Data["delta"].(map[string]interface{})["text"] != "Hello" {
+			t.Errorf("Expected the retried content, got %v", deltas)
+		}
+		// The headers wait for the retry and describe the stream the client got
+		if upstreamID != "chatcmpl-retried" {
+			t.Errorf("%s = %q, want the retried stream's ID", upstreamMessageIDHeader, upstreamID)
+		}
+		if remaining != "98" {
+			t.Errorf("requests remaining = %q, want the retried response's 98", remaining)
+		}
+	})
+
+	t.Run("the debug buffer records the retried response", func(t *testing.T) {
+		debugExchanges = &exchangeRing{}
+		defer func() { debugExchanges = &exchangeRing{} }()
+
+		stream(&config.Config{OpenAIBaseURL: upstream.URL, StreamRetryEmpty: true, Debug: true, DebugBufferSize: 1})
+		recent := debugExchanges.recent()
+		if len(recent) != 1 {
+			t.Fatalf("Expected one buffered exchange, got %d", len(recent))
+		}
+		if recent[0].Status != 200 || !strings.Contains(recent[0].Response, "Hello") {
+			t.Errorf("Buffered exchange status=%d response=%q, want the retried stream", recent[0].Status, recent[0].Response)
+		}
+	})
+
+	t.Run("disabled forwards the empty stream", func(t *testing.T) {
+		events := stream(&config.Config{OpenAIBaseURL: upstream.URL})
+		if n := calls.Load(); n != 1 {
+			t.Errorf("upstream called %d times, want 1", n)
+		}
+		if deltas := eventsOfType(events, "content_block_delta"); len(deltas) != 0 {
+			t.Errorf("Expected no content, got %v", deltas)
+		}
+	})
+}
+
+// TestPeekStreamContent tests empty stream detection and that peeked bytes are replayed
+func TestPeekStreamContent(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		wantEmpty bool
+	}{
+		{"role only", upstreamStream(`{"choices":[{"index":0,"delta":{"role":"assistant"}}]}`), true},
+		{"no DONE marker", "data: {\"choices\":[{\"index\":0,\"delta\":{}}]}\n\n", true},
+		{"text", upstreamStream(`{"choices":[{"index":0,"delta":{"role":"assistant"}}]}`, `{"choices":[{"index":0,"delta":{"content":"hi"}}]}`), false},
+		{"reasoning", upstreamStream(`{"choices":[{"index":0,"delta":{"reasoning_content":"hmm"}}]}`), false},
+		{"tool call", upstreamStream(`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","function":{"name":"f"}}]}}]}`), false},
+		{"error", upstreamStream(`{"error":{"message":"overloaded"}}`), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream, empty := peekStreamContent(io.NopCloser(strings.NewReader(tt.body)))
+			if empty != tt.wantEmpty {
+				t.Errorf("empty = %v, want %v", empty, tt.wantEmpty)
+			}
+			if replayed, _ := io.ReadAll(stream); string(replayed) != tt.body {
+				t.Errorf("replayed %q, want %q", replayed, tt.body)
+			}
+		})
+	}
+}
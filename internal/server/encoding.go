@@ -0,0 +1,64 @@
+package server
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxDecodedBodySize bounds a decompressed request body, so a small compressed
+// upload can't expand without limit
+const maxDecodedBodySize = 64 << 20
+
+// decodeRequestBody replaces a gzip or deflate encoded request body
+// (Content-Encoding) with the decompressed bytes, once and within
+// maxDecodedBodySize. c.Body would otherwise inflate the body on every call,
+// without a limit, and turn a corrupt body into its error text, so c.BodyParser
+// fails with an unrelated JSON error. Bodies without a Content-Encoding, or with
+// identity, are left as they are; other encodings are rejected.
+func decodeRequestBody(c *fiber.Ctx) error {
+	encoding := strings.ToLower(strings.TrimSpace(c.Get(fiber.HeaderContentEncoding)))
+	if encoding == "" || encoding == "identity" {
+		return nil
+	}
+
+	body := c.Request().Body() // raw bytes, c.Body would decode them
+	var reader io.Reader
+	switch encoding {
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("invalid gzip request body: %w", err)
+		}
+		defer func() { _ = gz.Close() }()
+		reader = gz
+	case "deflate":
+		// deflate is zlib-wrapped per the spec, but some clients send raw deflate
+		if zr, err := zlib.NewReader(bytes.NewReader(body)); err == nil {
+			defer func() { _ = zr.Close() }()
+			reader = zr
+		} else {
+			reader = flate.NewReader(bytes.NewReader(body))
+		}
+	default:
+		return fmt.Errorf("unsupported Content-Encoding %q: use gzip or deflate", encoding)
+	}
+
+	decoded, err := io.ReadAll(io.LimitReader(reader, maxDecodedBodySize+1))
+	if err != nil {
+		return fmt.Errorf("invalid %s request body: %w", encoding, err)
+	}
+	if len(decoded) > maxDecodedBodySize {
+		return fmt.Errorf("decompressed request body exceeds %d MB", maxDecodedBodySize>>20)
+	}
+
+	c.Request().SetBody(decoded)
+	c.Request().Header.Del(fiber.HeaderContentEncoding)
+	return nil
+}
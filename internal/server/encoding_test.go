@@ -0,0 +1,89 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+	"github.com/claude-code-proxy/proxy/pkg/models"
+)
+
+// TestCompressedRequestBody tests that gzip and deflate request bodies are decoded before parsing
+func TestCompressedRequestBody(t *testing.T) {
+	var received models.OpenAIRequest
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&received)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}`))
+	}))
+	defer upstream.Close()
+	app := newTestApp(&config.Config{OpenAIBaseURL: upstream.URL})
+
+	gzipped := func(body string) []byte {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, _ = gz.Write([]byte(body))
+		_ = gz.Close()
+		return buf.Bytes()
+	}
+	deflated := func(body string) []byte {
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		_, _ = zw.Write([]byte(body))
+		_ = zw.Close()
+		return buf.Bytes()
+	}
+	post := func(path, encoding string, body []byte) *http.Response {
+		req := httptest.NewRequest("POST", path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", encoding)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("app.Test() error = %v", err)
+		}
+		return resp
+	}
+
+	for _, tt := range []struct {
+		encoding string
+		body     []byte
+	}{
+		{"gzip", gzipped(testClaudeRequestBody)},
+		{"deflate", deflated(testClaudeRequestBody)},
+	} {
+		t.Run(tt.encoding+" messages", func(t *testing.T) {
+			received = models.OpenAIRequest{}
+			if resp := post("/v1/messages", tt.encoding, tt.body); resp.StatusCode != 200 {
+				t.Fatalf("status = %d, want 200", resp.StatusCode)
+			}
+			if len(received.Messages) != 1 || received.Messages[0].Content != "hello" {
+				t.Errorf("upstream messages = %+v, want the decoded user message", received.Messages)
+			}
+		})
+	}
+
+	t.Run("gzip count_tokens", func(t *testing.T) {
+		resp := post("/v1/messages/count_tokens", "gzip", gzipped(testClaudeRequestBody))
+		var result map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&result)
+		if resp.StatusCode != 200 || result["input_tokens"] == nil {
+			t.Errorf("status = %d, body = %v, want an input_tokens count", resp.StatusCode, result)
+		}
+	})
+
+	t.Run("corrupt gzip", func(t *testing.T) {
+		resp := post("/v1/messages", "gzip", []byte(testClaudeRequestBody))
+		var result map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&result)
+		message, _ := result["error"].(map[string]interface{})["message"].(string)
+		if resp.StatusCode != 400 || !strings.Contains(message, "gzip") {
+			t.Errorf("status = %d, message = %q, want a 400 naming the gzip body", resp.StatusCode, message)
+		}
+	})
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+)
+
+// endpointSelector routes requests across equivalent upstream endpoints
+// (OPENAI_BASE_URLS). A background probe measures each endpoint's round-trip
+// time; requests go to the fastest healthy endpoint. If no probe has succeeded,
+// endpoints are used round-robin.
+type endpointSelector struct {
+	mu   sync.Mutex
+	rtt  map[string]time.Duration // last successful probe RTT per endpoint
+	next int                      // round-robin position
+}
+
+// upstreamEndpoints is shared by all requests to the configured endpoints
+var upstreamEndpoints = &endpointSelector{
+	rtt: make(map[string]time.Duration),
+}
+
+// upstreamBaseURL returns the base URL to send the next request to
+func upstreamBaseURL(cfg *config.Config) string {
+	if len(cfg.OpenAIBaseURLs) <= 1 {
+		return cfg.OpenAIBaseURL
+	}
+	return upstreamEndpoints.pick(cfg.OpenAIBaseURLs)
+}
+
+// responseEndpoint returns the base URL of the endpoint that sent resp, i.e. the
+// one the request was routed to, for logs and reports
+func responseEndpoint(resp *http.Response, cfg *config.Config) string {
+	if resp == nil || resp.Request == nil {
+		return cfg.OpenAIBaseURL
+	}
+	return strings.TrimSuffix(resp.Request.URL.String(), chatPath(cfg))
+}
+
+// pick returns the endpoint with the lowest probe RTT, or the next one round-robin
+// when none has a successful probe
+func (s *endpointSelector) pick(urls []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	best := ""
+	for _, url := range urls {
+		rtt, ok := s.rtt[url]
+		if ok && (best == "" || rtt < s.rtt[best]) {
+			best = url
+		}
+	}
+	if best != "" {
+		return best
+	}
+
+	url := urls[s.next%len(urls)]
+	s.next++
+	return url
+}
+
+// record stores a probe result; a failed probe marks the endpoint unhealthy
+func (s *endpointSelector) record(url string, rtt time.Duration, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ok {
+		s.rtt[url] = rtt
+	} else {
+		delete(s.rtt, url)
+	}
+}
+
+// probeEndpoints probes every endpoint once, concurrently
+func (s *endpointSelector) probeEndpoints(cfg *config.Config, client *http.Client) {
+	var wg sync.WaitGroup
+	for _, url := range cfg.OpenAIBaseURLs {
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			rtt, ok := probeEndpoint(url, cfg, client)
+			s.record(url, rtt, ok)
+		}(url)
+	}
+	wg.Wait()
+}
+
+// probeEndpoint measures the round-trip time of GET {url}/models.
+// Auth failures still count as reachable - latency is what matters here.
+func probeEndpoint(url string, cfg *config.Config, client *http.Client) (time.Duration, bool) {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(url, "/")+"/models", nil)
+	if err != nil {
+		return 0, false
+	}
+	if !cfg.IsLocalhost() {
+		req.Header.Set("Authorization", "Bearer "+cfg.OpenAIAPIKey)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, false
+	}
+	_ = resp.Body.Close()
+	return time.Since(start), resp.StatusCode < 500
+}
+
+// startEndpointProber probes the configured endpoints immediately and then every
+// ENDPOINT_PROBE_INTERVAL seconds until ctx is cancelled. No-op for a single endpoint.
+func startEndpointProber(ctx context.Context, cfg *config.Config) {
+	if len(cfg.OpenAIBaseURLs) <= 1 {
+		return
+	}
+
+	interval := time.Duration(cfg.EndpointProbeIntervalSec) * time.Second
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			upstreamEndpoints.probeEndpoints(cfg, client)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
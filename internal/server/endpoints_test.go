@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+	"github.com/claude-code-proxy/proxy/internal/logging"
+)
+
+// newProbedEndpoint mocks an upstream whose /models responds after delay and counts completions
+func newProbedEndpoint(t *testing.T, delay time.Duration, completions *int32) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/models":
+			time.Sleep(delay)
+			_, _ = w.Write([]byte(`{"data": []}`))
+		case "/chat/completions":
+			atomic.AddInt32(completions, 1)
+			_, _ = w.Write([]byte(`{"id":"chatcmpl-1","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// TestEndpointSelectionPrefersFaster tests that requests are routed to, and logged
+// against, the lowest-latency endpoint
+func TestEndpointSelectionPrefersFaster(t *testing.T) {
+	upstreamEndpoints = &endpointSelector{rtt: make(map[string]time.Duration)}
+	defer func() { upstreamEndpoints = &endpointSelector{rtt: make(map[string]time.Duration)} }()
+
+	var slowCalls, fastCalls int32
+	slow := newProbedEndpoint(t, 80*time.Millisecond, &slowCalls)
+	fast := newProbedEndpoint(t, 0, &fastCalls)
+
+	cfg := &config.Config{
+		OpenAIBaseURL:  slow.URL,
+		OpenAIBaseURLs: []string{slow.URL, fast.URL},
+		SimpleLog:      true,
+	}
+	upstreamEndpoints.probeEndpoints(cfg, &http.Client{Timeout: time.Second})
+
+	var logs bytes.Buffer
+	defer logging.SetOutput(&logs)()
+
+	app := newTestApp(cfg)
+	for i := 0; i < 3; i++ {
+		if resp := postJSON(t, app, "/v1/messages", testClaudeRequestBody); resp.StatusCode != 200 {
+			t.Fatalf("Request %d status = %d, want 200", i, resp.StatusCode)
+		}
+	}
+
+	if fastN, slowN := atomic.LoadInt32(&fastCalls), atomic.LoadInt32(&slowCalls); fastN != 3 || slowN != 0 {
+		t.Errorf("Expected all requests on the fast endpoint, got fast=%d slow=%d", fastN, slowN)
+	}
+	if got := strings.Count(logs.String(), "[REQ] "+fast.URL+" "); got != 3 {
+		t.Errorf("Expected 3 request log lines naming %s, got %d in %q", fast.URL, got, logs.String())
+	}
+}
+
+// TestEndpointSelectionRoundRobin tests the round-robin fallback when probes fail
+func TestEndpointSelectionRoundRobin(t *testing.T) {
+	s := &endpointSelector{rtt: make(map[string]time.Duration)}
+	urls := []string{"https://a.example/v1", "https://b.example/v1"}
+
+	if got := []string{s.pick(urls), s.pick(urls), s.pick(urls)}; got[0] != urls[0] || got[1] != urls[1] || got[2] != urls[0] {
+		t.Errorf("Expected round-robin without probe results, got %v", got)
+	}
+
+	s.record(urls[1], 20*time.Millisecond, true)
+	s.record(urls[0], 50*time.Millisecond, true)
+	if got := s.pick(urls); got != urls[1] {
+		t.Errorf("pick() = %s, want the faster %s", got, urls[1])
+	}
+
+	// A failed probe removes the endpoint from latency routing
+	s.record(urls[1], 0, false)
+	if got := s.pick(urls); got != urls[0] {
+		t.Errorf("pick() = %s, want the remaining healthy %s", got, urls[0])
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Anthropic error types (https://docs.anthropic.com/en/api/errors)
+const (
+	errInvalidRequest = "invalid_request_error"
+	errAuthentication = "authentication_error"
+	errPermission     = "permission_error"
+	errNotFound       = "not_found_error"
+	errTooLarge       = "request_too_large"
+	errRateLimit      = "rate_limit_error"
+	errAPI            = "api_error"
+	errOverloaded     = "overloaded_error"
+)
+
+// claudeErrorBody builds Anthropic's error envelope:
+// {"type":"error","error":{"type":...,"message":...}}
+func claudeErrorBody(errType, message string) fiber.Map {
+	return fiber.Map{
+		"type": "error",
+		"error": fiber.Map{
+			"type":    errType,
+			"message": message,
+		},
+	}
+}
+
+// claudeError writes an Anthropic-format error response with the given HTTP status.
+// Every handler error path goes through here so the schema can't drift.
+func claudeError(c *fiber.Ctx, status int, errType, message string) error {
+	return c.Status(status).JSON(claudeErrorBody(errType, message))
+}
+
+// claudeErrorType picks the Anthropic error type for an HTTP status,
+// for errors that only carry a status (e.g. Fiber's own *fiber.Error).
+func claudeErrorType(status int) string {
+	switch status {
+	case fiber.StatusBadRequest:
+		return errInvalidRequest
+	case fiber.StatusUnauthorized:
+		return errAuthentication
+	case fiber.StatusForbidden:
+		return errPermission
+	case fiber.StatusNotFound:
+		return errNotFound
+	case fiber.StatusRequestEntityTooLarge:
+		return errTooLarge
+	case fiber.StatusTooManyRequests:
+		return errRateLimit
+	case 529:
+		return errOverloaded
+	}
+	if status < 500 {
+		return errInvalidRequest
+	}
+	return errAPI
+}
+
+// handleFiberError is the app's ErrorHandler: errors returned from handlers or
+// raised by Fiber itself (body limit, recovered panics) get the same schema.
+func handleFiberError(c *fiber.Ctx, err error) error {
+	status := fiber.StatusInternalServerError
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		status = fiberErr.Code
+	}
+	return claudeError(c, status, claudeErrorType(status), err.Error())
+}
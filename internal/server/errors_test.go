@@ -0,0 +1,199 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+	"github.com/gofiber/fiber/v2"
+)
+
+// assertClaudeError checks resp carries exactly the canonical Anthropic error schema
+func assertClaudeError(t *testing.T, resp *http.Response, wantStatus int, wantType string) {
+	t.Helper()
+	if resp.StatusCode != wantStatus {
+		t.Errorf("status = %d, want %d", resp.StatusCode, wantStatus)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	var got map[string]interface{}
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("error body is not JSON: %v (%s)", err, body)
+	}
+	if len(got) != 2 || got["type"] != "error" {
+		t.Fatalf("error body = %s, want {\"type\":\"error\",\"error\":{...}}", body)
+	}
+	inner, ok := got["error"].(map[string]interface{})
+	if !ok || len(inner) != 2 {
+		t.Fatalf("error object = %v, want exactly type and message", got["error"])
+	}
+	if inner["type"] != wantType {
+		t.Errorf("error.type = %v, want %q", inner["type"], wantType)
+	}
+	if msg, _ := inner["message"].(string); msg == "" {
+		t.Errorf("error.message is empty")
+	}
+}
+
+// TestHandlerErrorSchema tests that each handler error path uses the canonical schema and status
+func TestHandlerErrorSchema(t *testing.T) {
+	// Upstream that accepts the TCP connection and then drops it
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	defer down.Close()
+
+	tests := []struct {
+		name       string
+		cfg        *config.Config
+		path       string
+		body       string
+		header     [2]string
+		wantStatus int
+		wantType   string
+	}{
+		{
+			name:       "invalid body",
+			cfg:        &config.Config{OpenAIBaseURL: down.URL},
+			path:       "/v1/messages",
+			body:       `{not json`,
+			wantStatus: 400,
+			wantType:   "invalid_request_error",
+		},
+		{
+			name:       "bad api key",
+			cfg:        &config.Config{OpenAIBaseURL: down.URL, AnthropicAPIKey: "secret"},
+			path:       "/v1/messages",
+			body:       testClaudeRequestBody,
+			wantStatus: 401,
+			wantType:   "authentication_error",
+		},
+		{
+			name:       "bad deadline",
+			cfg:        &config.Config{OpenAIBaseURL: down.URL},
+			path:       "/v1/messages",
+			body:       testClaudeRequestBody,
+			header:     [2]string{deadlineHeader, "soon"},
+			wantStatus: 400,
+			wantType:   "invalid_request_error",
+		},
+		{
+			name:       "bad token parameter override",
+			cfg:        &config.Config{OpenAIBaseURL: down.URL},
+			path:       "/v1/messages",
+			body:       testClaudeRequestBody,
+			header:     [2]string{tokenParameterHeader, "maybe"},
+			wantStatus: 400,
+			wantType:   "invalid_request_error",
+		},
+		{
+			name:       "upstream connection failure",
+			cfg:        &config.Config{OpenAIBaseURL: down.URL},
+			path:       "/v1/messages",
+			body:       testClaudeRequestBody,
+			wantStatus: 502,
+			wantType:   "api_error",
+		},
+		{
+			name:       "validate bad api key",
+			cfg:        &config.Config{OpenAIBaseURL: down.URL, AnthropicAPIKey: "secret"},
+			path:       "/v1/messages/validate",
+			body:       testClaudeRequestBody,
+			wantStatus: 401,
+			wantType:   "authentication_error",
+		},
+		{
+			name:       "count_tokens invalid body",
+			cfg:        &config.Config{OpenAIBaseURL: down.URL},
+			path:       "/v1/messages/count_tokens",
+			body:       `{not json`,
+			wantStatus: 400,
+			wantType:   "invalid_request_error",
+		},
+		{
+			name:       "unknown route",
+			cfg:        &config.Config{OpenAIBaseURL: down.URL},
+			path:       "/v1/nope",
+			body:       `{}`,
+			wantStatus: 404,
+			wantType:   "not_found_error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newRootTestApp(tt.cfg)
+			req := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.header[0] != "" {
+				req.Header.Set(tt.header[0], tt.header[1])
+			}
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatalf("app.Test() error = %v", err)
+			}
+			assertClaudeError(t, resp, tt.wantStatus, tt.wantType)
+		})
+	}
+}
+
+// TestClaudeErrorType tests the status to error type mapping used for Fiber errors
+func TestClaudeErrorType(t *testing.T) {
+	tests := []struct {
+		status int
+		want   string
+	}{
+		{400, "invalid_request_error"},
+		{401, "authentication_error"},
+		{403, "permission_error"},
+		{404, "not_found_error"},
+		{405, "invalid_request_error"},
+		{413, "request_too_large"},
+		{429, "rate_limit_error"},
+		{500, "api_error"},
+		{502, "api_error"},
+		{529, "overloaded_error"},
+	}
+
+	for _, tt := range tests {
+		if got := claudeErrorType(tt.status); got != tt.want {
+			t.Errorf("claudeErrorType(%d) = %q, want %q", tt.status, got, tt.want)
+		}
+	}
+}
+
+// TestFiberErrorHandler tests that errors returned to Fiber are rendered in the canonical schema
+func TestFiberErrorHandler(t *testing.T) {
+	app := fiber.New(fiber.Config{ErrorHandler: handleFiberError})
+	app.Get("/too-large", func(c *fiber.Ctx) error {
+		return fiber.ErrRequestEntityTooLarge
+	})
+	app.Get("/plain", func(c *fiber.Ctx) error {
+		return errors.New("boom")
+	})
+
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantType   string
+	}{
+		{"/too-large", 413, "request_too_large"},
+		{"/plain", 500, "api_error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			resp, err := app.Test(httptest.NewRequest("GET", tt.path, nil), -1)
+			if err != nil {
+				t.Fatalf("app.Test() error = %v", err)
+			}
+			assertClaudeError(t, resp, tt.wantStatus, tt.wantType)
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"github.com/claude-code-proxy/proxy/internal/config"
+	"github.com/gofiber/fiber/v2"
+)
+
+// optionalFeatures lists the optional behaviors reported by GET /info/features,
+// each with the check that decides from the resolved config whether it is active
+var optionalFeatures = []struct {
+	name    string
+	enabled func(cfg *config.Config) bool
+}{
+	{"passthrough", func(cfg *config.Config) bool { return cfg.PassthroughMode }},
+	{"proxy_auth", func(cfg *config.Config) bool { return cfg.AnthropicAPIKey != "" }},
+	{"multi_endpoint", func(cfg *config.Config) bool { return len(cfg.OpenAIBaseURLs) > 1 }},
+	{"fallback_model", func(cfg *config.Config) bool { return cfg.FallbackModel != "" }},
+	{"concurrency_limit", func(cfg *config.Config) bool { return cfg.MaxConcurrentRequests > 0 }},
+	{"circuit_breaker", func(cfg *config.Config) bool { return cfg.CircuitBreakerThreshold > 0 }},
+	{"prompt_cache_key", func(cfg *config.Config) bool { return cfg.PromptCacheKey != "" }},
+	{"tier_max_tokens", func(cfg *config.Config) bool {
+		return cfg.MaxTokensHaiku > 0 || cfg.MaxTokensSonnet > 0 || cfg.MaxTokensOpus > 0
+	}},
+	{"sampling_penalties", func(cfg *config.Config) bool {
+		return cfg.FrequencyPenalty != nil || cfg.PresencePenalty != nil
+	}},
+	{"finish_reason_map", func(cfg *config.Config) bool { return len(cfg.FinishReasonMap) > 0 }},
+	{"force_tool_mode", func(cfg *config.Config) bool { return cfg.ForceToolMode != "" }},
+	{"compact_tools", func(cfg *config.Config) bool { return cfg.CompactTools }},
+	{"strict_request_validation", func(cfg *config.Config) bool { return cfg.StrictRequestValidation }},
+	{"validate_tool_pairs", func(cfg *config.Config) bool { return cfg.ValidateToolPairs != "" }},
+	{"thinking_budget_conflict", func(cfg *config.Config) bool { return cfg.ThinkingBudgetConflict != "" }},
+	{"unknown_block", func(cfg *config.Config) bool {
+		return cfg.UnknownBlock == config.UnknownBlockDrop || cfg.UnknownBlock == config.UnknownBlockError
+	}},
+	{"normalize_tool_order", func(cfg *config.Config) bool { return cfg.NormalizeToolOrder }},
+	{"stream_throttle", func(cfg *config.Config) bool { return cfg.StreamThrottleMs > 0 }},
+	{"stream_flush_batch", func(cfg *config.Config) bool { return cfg.StreamFlushBatch > 1 }},
+	{"stream_incremental_usage", func(cfg *config.Config) bool { return cfg.StreamIncrementalUsage }},
+	{"eager_text_block", func(cfg *config.Config) bool { return cfg.EagerTextBlock }},
+	{"stream_retry_empty", func(cfg *config.Config) bool { return cfg.StreamRetryEmpty }},
+	{"empty_text_block_with_tools", func(cfg *config.Config) bool { return cfg.EmptyTextBlockWithTools }},
+	{"thinking_as_text", func(cfg *config.Config) bool { return cfg.ThinkingAsText }},
+	{"ollama_keep_alive", func(cfg *config.Config) bool { return cfg.OllamaKeepAlive != "" }},
+	{"max_reasoning_tokens", func(cfg *config.Config) bool { return cfg.MaxReasoningTokens > 0 }},
+	{"reasoning_effort_downgrade", func(cfg *config.Config) bool { return cfg.MaxReasoningTokens > 0 && cfg.ReasoningEffortDowngrade }},
+	{"no_tools_models", func(cfg *config.Config) bool { return len(cfg.NoToolsModels) > 0 }},
+	{"system_templates", func(cfg *config.Config) bool { return len(cfg.SystemTemplates) > 0 }},
+	{"temperature_range", func(cfg *config.Config) bool { return cfg.TemperatureRange != nil }},
+	{"token_count_cache", func(cfg *config.Config) bool { return cfg.TokenCountCacheTTL > 0 }},
+	{"debug", func(cfg *config.Config) bool { return cfg.Debug }},
+	{"debug_buffer", debugBufferEnabled},
+	{"capture", captureEnabled},
+	{"capture_compress", func(cfg *config.Config) bool { return captureEnabled(cfg) && cfg.CaptureCompress }},
+	{"otel_metrics", func(cfg *config.Config) bool { return cfg.OTelEndpoint != "" }},
+	{"tracing", func(cfg *config.Config) bool { return cfg.EnableTracing }},
+	{"simple_log", func(cfg *config.Config) bool { return cfg.SimpleLog }},
+	{"log_file", func(cfg *config.Config) bool { return cfg.LogFile != "" }},
+	{"minimal_root", func(cfg *config.Config) bool { return cfg.MinimalRoot }},
+}
+
+// activeFeatures returns the names of the optional features enabled in cfg
+func activeFeatures(cfg *config.Config) []string {
+	features := []string{}
+	for _, feature := range optionalFeatures {
+		if feature.enabled(cfg) {
+			features = append(features, feature.name)
+		}
+	}
+	return features
+}
+
+// handleFeatures is the handler for GET /info/features. Like the rest of the API it
+// requires x-api-key when ANTHROPIC_API_KEY protects the proxy.
+func handleFeatures(c *fiber.Ctx, cfg *config.Config) error {
+	if cfg.AnthropicAPIKey != "" && c.Get("x-api-key") != cfg.AnthropicAPIKey {
+		return claudeError(c, 401, errAuthentication, "Invalid API key")
+	}
+
+	return c.JSON(fiber.Map{
+		"version":  ProxyVersion,
+		"features": activeFeatures(cfg),
+	})
+}
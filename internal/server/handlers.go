@@ -2,82 +2,139 @@ package server
 
 import (
 	"bufio"
-	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/claude-code-proxy/proxy/internal/config"
 	"github.com/claude-code-proxy/proxy/internal/converter"
+	"github.com/claude-code-proxy/proxy/internal/logging"
 	"github.com/claude-code-proxy/proxy/pkg/models"
 	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// addOpenRouterHeaders adds OpenRouter-specific HTTP headers for better rate limits.
-// Sets HTTP-Referer and X-Title headers when configured, which helps with OpenRouter's
-// rate limiting and usage tracking.
-func addOpenRouterHeaders(req *http.Request, cfg *config.Config) {
-	if cfg.OpenRouterAppURL != "" {
-		req.Header.Set("HTTP-Referer", cfg.OpenRouterAppURL)
-	}
-	if cfg.OpenRouterAppName != "" {
-		req.Header.Set("X-Title", cfg.OpenRouterAppName)
-	}
-}
+// tokenParameterHeader forces max_completion_tokens (true) or max_tokens (false)
+// for a single request
+const tokenParameterHeader = "x-proxy-use-max-completion-tokens"
+
+// conversationIDHeader carries a client-chosen session identifier, forwarded as
+// the provider's cache key (prompt_cache_key, OpenRouter metadata)
+const conversationIDHeader = "x-proxy-conversation-id"
+
+// upstreamMessageIDHeader carries the provider's response ID (chatcmpl-..., gen-...),
+// as responses get the proxy's own msg_ ID. After a STREAM_RETRY_EMPTY retry it
+// names the retried stream.
+const upstreamMessageIDHeader = "X-Upstream-Message-Id"
+
+// emitCurlHeader logs the upstream request as a curl command outside debug mode
+const emitCurlHeader = "x-proxy-emit-curl"
 
 // handleMessages is the main handler for /v1/messages endpoint.
 // It parses Claude requests, converts them to OpenAI format, and routes to either
 // streaming or non-streaming handlers based on the request's stream parameter.
 func handleMessages(c *fiber.Ctx, cfg *config.Config) error {
+	// Root span of the request trace (a no-op unless ENABLE_TRACING is on). A
+	// stream ends it when the body is done, everything else when the handler returns.
+	reqSpan := startRequestSpan("POST /v1/messages", func(key string) string { return c.Get(key) })
+	defer func() {
+		if !c.Response().IsBodyStream() {
+			reqSpan.SetAttributes(attribute.Int("http.status_code", c.Response().StatusCode()))
+			reqSpan.End()
+		}
+	}()
+
+	// Stage timing for the debug breakdown (nil unless DEBUG)
+	timing := newRequestTiming(cfg.Debug)
+
+	// Compressed uploads (Content-Encoding: gzip/deflate) are decoded in place
+	if err := decodeRequestBody(c); err != nil {
+		return claudeError(c, 400, errInvalidRequest, err.Error())
+	}
+
 	// Debug: Log raw request
 	if cfg.Debug {
 		fmt.Printf("\n=== CLAUDE REQUEST ===\n%s\n===================\n", string(c.Body()))
 	}
 
+	// Opt-in schema check with field-level errors (STRICT_REQUEST_VALIDATION)
+	if cfg.StrictRequestValidation {
+		if issues := converter.ValidateRequestSchema(c.Body()); len(issues) > 0 {
+			return claudeError(c, 400, errInvalidRequest, schemaErrorMessage(issues))
+		}
+	}
+
 	// Parse Claude request
 	var claudeReq models.ClaudeRequest
 	if err := c.BodyParser(&claudeReq); err != nil {
 		// Log the error and raw body for debugging
-		fmt.Printf("[ERROR] Failed to parse request body: %v\n", err)
-		fmt.Printf("[ERROR] Raw body: %s\n", string(c.Body()))
-		return c.Status(400).JSON(fiber.Map{
-			"type": "error",
-			"error": fiber.Map{
-				"type":    "invalid_request_error",
-				"message": fmt.Sprintf("Invalid request body: %v", err),
-			},
-		})
+		logging.Printf("[ERROR] Failed to parse request body: %v\n", err)
+		logging.Printf("[ERROR] Raw body: %s\n", string(c.Body()))
+		return claudeError(c, 400, errInvalidRequest, fmt.Sprintf("Invalid request body: %v", err))
 	}
+	timing.mark(stageParsed)
 
 	// Validate API key (if configured)
 	if cfg.AnthropicAPIKey != "" {
 		apiKey := c.Get("x-api-key")
 		if apiKey != cfg.AnthropicAPIKey {
-			return c.Status(401).JSON(fiber.Map{
-				"type": "error",
-				"error": fiber.Map{
-					"type":    "authentication_error",
-					"message": "Invalid API key",
-				},
-			})
+			return claudeError(c, 401, errAuthentication, "Invalid API key")
 		}
 	}
 
+	// Client deadline - reject requests that would be abandoned before upstream answers
+	deadline, err := parseDeadline(c.Get(deadlineHeader), time.Now())
+	if err != nil {
+		return claudeError(c, 400, errInvalidRequest, err.Error())
+	}
+
+	// Request-scoped upstream settings from client headers
+	emitCurl, _ := strconv.ParseBool(c.Get(emitCurlHeader))
+	opts := upstreamOptions{Deadline: deadline, EmitCurl: emitCurl, Span: reqSpan, Timing: timing}
+
+	// anthropic-beta flags (comma-separated) can change how the request is converted
+	if beta := c.Get("anthropic-beta"); beta != "" {
+		claudeReq.Betas = strings.Split(beta, ",")
+	}
+	claudeReq.ConversationID = c.Get(conversationIDHeader)
+
+	// Reconcile the stream field with the Accept header before conversion
+	claudeReq.Stream = resolveStreamMode(c.Get("Accept"), claudeReq.Stream, cfg)
+
 	// Convert Claude request to OpenAI format
+	convertSpan := startChildSpan(reqSpan, "convert_request", trace.SpanKindInternal)
 	openaiReq, err := converter.ConvertRequest(claudeReq, cfg)
+	endSpan(convertSpan, err)
 	if err != nil {
-		return c.Status(400).JSON(fiber.Map{
-			"type": "error",
-			"error": fiber.Map{
-				"type":    "invalid_request_error",
-				"message": err.Error(),
-			},
-		})
+		return claudeError(c, 400, errInvalidRequest, err.Error())
+	}
+	reqSpan.SetAttributes(
+		attribute.String("gen_ai.system", string(cfg.RequestProvider())),
+		attribute.String("gen_ai.request.model", claudeReq.Model),
+		attribute.String("gen_ai.provider.model", openaiReq.Model),
+		attribute.Bool("proxy.stream", openaiReq.Stream != nil && *openaiReq.Stream),
+	)
+
+	// Per-request override of the token parameter choice (for debugging models that
+	// reject one or the other); the reasoning-model cache is neither used nor updated
+	if override := c.Get(tokenParameterHeader); override != "" {
+		useMaxCompletionTokens, err := strconv.ParseBool(override)
+		if err != nil {
+			return claudeError(c, 400, errInvalidRequest, fmt.Sprintf("invalid %s %q: expected true or false", tokenParameterHeader, override))
+		}
+		converter.SetTokenParameter(openaiReq, useMaxCompletionTokens)
 	}
 
+	// Lower reasoning_effort for models that went over MAX_REASONING_TOKENS
+	reasoningEfforts.apply(openaiReq, cfg)
+	timing.mark(stageConverted)
+
 	// Debug: Log converted OpenAI request
 	if cfg.Debug {
 		openaiReqJSON, _ := json.MarshalIndent(openaiReq, "", "  ")
@@ -99,24 +156,56 @@ func handleMessages(c *fiber.Ctx, cfg *config.Config) error {
 		}
 	}
 
+	// Remember cache-marked prefixes so count_tokens can estimate cache reads
+	converter.RecordCacheState(claudeReq)
+
+	// A count_tokens call for the same prompt moments ago (TOKEN_COUNT_CACHE_TTL)
+	// provides the input estimate for message_start
+	countKey := tokenCounts.key(claudeReq)
+	inputEstimate, _ := tokenCounts.get(countKey)
+
+	// Capture the exchange for GET /debug/requests and CAPTURE_DIR (nil when disabled)
+	exchange := newDebugExchange(cfg, c.Body(), openaiReq)
+
 	// Handle streaming vs non-streaming
 	if openaiReq.Stream != nil && *openaiReq.Stream {
-		return handleStreamingMessages(c, openaiReq, cfg)
+		return handleStreamingMessages(c, openaiReq, cfg, exchange, opts, countKey, inputEstimate.InputTokens)
 	}
+	defer exchange.recordFiberResponse(c, cfg)
 
 	// Track timing for simple log
 	startTime := time.Now()
 
 	// Non-streaming response
-	openaiResp, err := callOpenAI(openaiReq, cfg)
+	ctx, cancel := upstreamContext(opts)
+	defer cancel()
+	openaiResp, upstreamHeaders, endpoint, err := callOpenAI(ctx, openaiReq, cfg)
+	finishUpstreamSpan(ctx, err)
+	opts.Timing.mark(stageUpstreamDone)
+	setAnthropicRateLimitHeaders(c, upstreamHeaders)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"type": "error",
-			"error": fiber.Map{
-				"type":    "api_error",
-				"message": fmt.Sprintf("OpenAI API error: %v", err),
-			},
-		})
+		err = sendUpstreamError(c, err)
+		recordRequestMetric(requestMetric{Model: openaiReq.Model, Status: c.Response().StatusCode(), Duration: time.Since(startTime)})
+		return err
+	}
+
+	// The response carries a proxy msg_ ID; keep the upstream one for correlation
+	if openaiResp.ID != "" {
+		c.Set(upstreamMessageIDHeader, openaiResp.ID)
+	}
+
+	// Surface the service tier the upstream actually used (flex vs default)
+	if openaiResp.ServiceTier != "" {
+		c.Set("X-Upstream-Service-Tier", openaiResp.ServiceTier)
+	}
+
+	// Surface what the upstream charged for the request (OpenRouter)
+	if openaiResp.Usage.Cost != nil {
+		c.Set("X-Upstream-Cost-USD", strconv.FormatFloat(*openaiResp.Usage.Cost, 'f', -1, 64))
+	}
+
+	if details := openaiResp.Usage.CompletionTokensDetails; details != nil {
+		reasoningEfforts.record(openaiReq.Model, openaiReq.ReasoningEffort, details.ReasoningTokens, cfg)
 	}
 
 	// Debug: Log OpenAI response
@@ -133,16 +222,11 @@ func handleMessages(c *fiber.Ctx, cfg *config.Config) error {
 	}
 
 	// Convert OpenAI response to Claude format
-	claudeResp, err := converter.ConvertResponse(openaiResp, claudeReq.Model)
+	claudeResp, err := converter.ConvertResponse(openaiResp, claudeReq.Model, cfg)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"type": "error",
-			"error": fiber.Map{
-				"type":    "api_error",
-				"message": fmt.Sprintf("Response conversion error: %v", err),
-			},
-		})
+		return claudeError(c, 500, errAPI, fmt.Sprintf("Response conversion error: %v", err))
 	}
+	opts.Timing.mark(stageResponseReady)
 
 	// Debug: Log Claude response
 	if cfg.Debug {
@@ -166,114 +250,262 @@ func handleMessages(c *fiber.Ctx, cfg *config.Config) error {
 			tokensPerSec = float64(claudeResp.Usage.OutputTokens) / duration
 		}
 		timestamp := time.Now().Format("15:04:05")
-		fmt.Printf("[%s] [REQ] %s model=%s in=%d out=%d tok/s=%.1f\n",
+		wait, limited := queueWait(c)
+		logging.Printf("[%s] [REQ] %s model=%s in=%d out=%d tok/s=%.1f%s%s\n",
 			timestamp,
-			cfg.OpenAIBaseURL,
+			endpoint,
 			openaiReq.Model,
 			claudeResp.Usage.InputTokens,
 			claudeResp.Usage.OutputTokens,
-			tokensPerSec)
+			tokensPerSec,
+			costLogField(openaiResp.Usage.Cost),
+			queueLogField(wait, limited))
+	}
+
+	tokenCounts.recordInputTokens(countKey, claudeResp.Usage.InputTokens)
+
+	recordRequestMetric(requestMetric{
+		Model:        openaiReq.Model,
+		Status:       200,
+		InputTokens:  claudeResp.Usage.InputTokens,
+		OutputTokens: claudeResp.Usage.OutputTokens,
+		Duration:     time.Since(startTime),
+	})
+	setUsageAttrs(opts.Span, claudeResp.Usage.InputTokens, claudeResp.Usage.OutputTokens)
+
+	// Debug: where the time went (conversion vs upstream)
+	if breakdown := opts.Timing.serverTiming(); breakdown != "" {
+		c.Set(timingHeader, breakdown)
+		opts.Timing.log()
 	}
 
 	return c.JSON(claudeResp)
 }
 
+// resolveStreamMode reconciles the request's stream field with the Accept header.
+// A client asking for text/event-stream while leaving stream false or unset expects
+// SSE; the Accept header wins unless STREAM_PRECEDENCE=body. The opposite direction
+// is not treated as a conflict: Anthropic SDKs send "Accept: application/json" on
+// every request, streaming ones included.
+func resolveStreamMode(accept string, stream *bool, cfg *config.Config) *bool {
+	if !strings.Contains(strings.ToLower(accept), "text/event-stream") || (stream != nil && *stream) {
+		return stream
+	}
+
+	if cfg.StreamPrecedence == "body" {
+		logging.Printf("[%s] [WARN] Accept %q requests SSE but stream is not true, using stream field (STREAM_PRECEDENCE=body)\n",
+			time.Now().Format("15:04:05"), accept)
+		return stream
+	}
+
+	logging.Printf("[%s] [WARN] Accept %q requests SSE but stream is not true, streaming response\n",
+		time.Now().Format("15:04:05"), accept)
+	streaming := true
+	return &streaming
+}
+
 // handleStreamingMessages handles streaming SSE responses from the provider.
 // It forwards the OpenAI request, receives streaming chunks, and converts them to
 // Claude's SSE event format in real-time using streamOpenAIToClaude.
-func handleStreamingMessages(c *fiber.Ctx, openaiReq *models.OpenAIRequest, cfg *config.Config) error {
+//
+// The upstream request is made before the body stream starts so that upstream
+// response headers (e.g. rate limits) can still be forwarded to the client.
+// A client deadline (x-proxy-deadline) cancels the upstream request, including
+// the body stream, when it expires.
+func handleStreamingMessages(c *fiber.Ctx, openaiReq *models.OpenAIRequest, cfg *config.Config, exchange *debugExchange, opts upstreamOptions, countKey string, inputEstimate int) error {
 	// Track timing for simple log
 	startTime := time.Now()
 
-	// Set SSE headers
-	c.Set("Content-Type", "text/event-stream")
-	c.Set("Cache-Control", "no-cache")
-	c.Set("Connection", "keep-alive")
-	c.Set("X-Accel-Buffering", "no")
+	client := newUpstreamClient(300 * time.Second) // Longer timeout for streaming
 
-	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+	// Make request
+	ctx, cancel := upstreamContext(opts)
+	resp, err := doUpstreamRequest(ctx, client, openaiReq, cfg)
+	finishUpstreamSpan(ctx, err)
+	if err != nil {
+		cancel()
 		if cfg.Debug {
-			fmt.Printf("[DEBUG] StreamWriter: Starting\n")
+			fmt.Printf("[DEBUG] Streaming: Request failed: %v\n", err)
 		}
-
-		// Marshal request
-		reqBody, err := json.Marshal(openaiReq)
-		if err != nil {
-			if cfg.Debug {
-				fmt.Printf("[DEBUG] StreamWriter: Failed to marshal: %v\n", err)
-			}
-			writeSSEError(w, fmt.Sprintf("failed to marshal request: %v", err))
-			return
+		var upErr *upstreamError
+		if errors.As(err, &upErr) {
+			setAnthropicRateLimitHeaders(c, upErr.Header)
 		}
+		defer exchange.recordFiberResponse(c, cfg)
+		err = sendUpstreamError(c, err)
+		recordRequestMetric(requestMetric{Model: openaiReq.Model, Status: c.Response().StatusCode(), Duration: time.Since(startTime)})
+		return err
+	}
 
-		if cfg.Debug {
-			fmt.Printf("[DEBUG] StreamWriter: Making request to %s\n", cfg.OpenAIBaseURL+"/chat/completions")
+	if cfg.Debug {
+		fmt.Printf("[DEBUG] Streaming: Got response with status %d from %s\n", resp.StatusCode, resp.Request.URL)
+	}
+
+	// Retry a stream that ends without content before the headers go out, so they
+	// describe the stream the client gets; no event is sent before content either way
+	stream := resp.Body
+	if cfg.StreamRetryEmpty {
+		var retried *http.Response
+		if stream, retried = retryEmptyStream(ctx, client, openaiReq, cfg, resp.Body); retried != nil {
+			resp = retried
 		}
+	}
 
-		// Build API URL
-		apiURL := cfg.OpenAIBaseURL + "/chat/completions"
+	setAnthropicRateLimitHeaders(c, resp.Header)
 
-		// Create HTTP request
-		httpReq, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(reqBody))
-		if err != nil {
-			writeSSEError(w, fmt.Sprintf("failed to create request: %v", err))
-			return
-		}
+	// The upstream ID is in the stream's first chunk: wait briefly for it so it can go
+	// in a header (a slow first chunk goes without, rather than delaying message_start)
+	stream, upstreamID := peekStreamID(stream)
+	if upstreamID != "" {
+		c.Set(upstreamMessageIDHeader, upstreamID)
+	}
 
-		// Set headers
-		httpReq.Header.Set("Content-Type", "application/json")
+	// Debug: the stages so far (the full breakdown is logged when the stream ends)
+	if breakdown := opts.Timing.serverTiming(); breakdown != "" {
+		c.Set(timingHeader, breakdown)
+	}
+
+	// Set SSE headers
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
 
-		// Skip auth for Ollama (localhost) - Ollama doesn't require authentication
-		if !cfg.IsLocalhost() {
-			httpReq.Header.Set("Authorization", "Bearer "+cfg.OpenAIAPIKey)
+	// The body is streamed after this handler returns, so the stream keeps the
+	// concurrency slot (if any) until it finishes
+	release := holdConcurrencySlot(c)
+	wait, limited := queueWait(c)
+	queueLog := queueLogField(wait, limited)
+
+	// The stream owns the request span from here, see handleMessages
+	reqSpan := opts.Span
+	reqSpan.SetAttributes(attribute.Int("http.status_code", 200))
+
+	c.Context().SetBodyStreamWriter(func(bw *bufio.Writer) {
+		defer release()
+		defer cancel()
+		defer func() { _ = stream.Close() }()
+		defer reqSpan.End()
+		status, endpoint := resp.StatusCode, responseEndpoint(resp, cfg)
+
+		// Optionally pace deltas for slow terminals or demos
+		w := newSSEWriter(bw)
+		if cfg.StreamThrottleMs > 0 {
+			w = newThrottledSSEWriter(bw, time.Duration(cfg.StreamThrottleMs)*time.Millisecond)
+			defer w.Close()
+		} else if cfg.StreamFlushBatch > 1 {
+			// Or flush every few deltas for high-throughput streams
+			w = newBatchedSSEWriter(bw, cfg.StreamFlushBatch, time.Duration(cfg.StreamFlushMaxDelayMs)*time.Millisecond)
+			defer w.Close()
 		}
 
-		// OpenRouter-specific headers for better rate limits
-		if cfg.DetectProvider() == config.ProviderOpenRouter {
-			addOpenRouterHeaders(httpReq, cfg)
+		if cfg.Debug {
+			fmt.Printf("[DEBUG] StreamWriter: Starting streamOpenAIToClaude conversion\n")
 		}
 
-		client := &http.Client{
-			Timeout: 300 * time.Second, // Longer timeout for streaming
+		if exchange != nil {
+			w.capture = &cappedBuffer{limit: captureLimit(cfg)}
 		}
 
-		// Make request
-		resp, err := client.Do(httpReq)
-		if err != nil {
-			if cfg.Debug {
-				fmt.Printf("[DEBUG] StreamWriter: Request failed: %v\n", err)
-			}
-			writeSSEError(w, fmt.Sprintf("request failed: %v", err))
-			return
+		// Stream conversion
+		streamSpan := startChildSpan(reqSpan, "stream", trace.SpanKindInternal)
+		inputTokens, outputTokens, reasoningTokens := streamOpenAIToClaude(w, stream, openaiReq.Model, endpoint, cfg, startTime, queueLog, inputEstimate)
+		opts.Timing.mark(stageUpstreamDone)
+		reasoningEfforts.record(openaiReq.Model, openaiReq.ReasoningEffort, reasoningTokens, cfg)
+		opts.Timing.log()
+		tokenCounts.recordInputTokens(countKey, inputTokens)
+		setUsageAttrs(streamSpan, inputTokens, outputTokens)
+		setUsageAttrs(reqSpan, inputTokens, outputTokens)
+		streamSpan.End()
+		if exchange != nil {
+			exchange.record(cfg, status, w.capture.String())
 		}
-		defer func() { _ = resp.Body.Close() }()
 
 		if cfg.Debug {
-			fmt.Printf("[DEBUG] StreamWriter: Got response with status %d\n", resp.StatusCode)
+			fmt.Printf("[DEBUG] StreamWriter: Completed\n")
 		}
+	})
 
-		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			if cfg.Debug {
-				fmt.Printf("[DEBUG] StreamWriter: Bad status: %s\n", string(body))
+	return nil
+}
+
+// usagePaths lists where OpenAI-compatible providers put usage in streaming chunks,
+// in order of preference. Each path is a sequence of object keys; "choices" steps
+// into the first choice.
+var usagePaths = [][]string{
+	{"usage"},                     // OpenAI, OpenRouter (top-level)
+	{"x_groq", "usage"},           // Groq vendor field
+	{"choices", "usage"},          // Some gateways nest usage in the choice
+	{"choices", "delta", "usage"}, // ...or in the final delta
+}
+
+// extractStreamUsage returns the usage object from a streaming chunk, or nil if the
+// chunk carries none.
+func extractStreamUsage(chunk map[string]interface{}) map[string]interface{} {
+	for _, path := range usagePaths {
+		var current interface{} = chunk
+		for _, key := range path {
+			obj, ok := current.(map[string]interface{})
+			if !ok {
+				current = nil
+				break
 			}
-			writeSSEError(w, fmt.Sprintf("OpenAI API returned status %d: %s", resp.StatusCode, string(body)))
-			return
+			current = obj[key]
+			if key == "choices" {
+				choices, ok := current.([]interface{})
+				if !ok || len(choices) == 0 {
+					current = nil
+					break
+				}
+				current = choices[0]
+			}
+		}
+		if usage, ok := current.(map[string]interface{}); ok {
+			return usage
 		}
+	}
+	return nil
+}
 
-		if cfg.Debug {
-			fmt.Printf("[DEBUG] StreamWriter: Starting streamOpenAIToClaude conversion\n")
+// isRoleOnlyDelta reports whether a streaming delta carries only the role announcement
+func isRoleOnlyDelta(delta map[string]interface{}) bool {
+	if _, ok := delta["role"]; !ok {
+		return false
+	}
+	for key, value := range delta {
+		switch key {
+		case "role":
+		case "content":
+			if value != nil && value != "" {
+				return false
+			}
+		default:
+			if value != nil {
+				return false
+			}
 		}
+	}
+	return true
+}
 
-		// Stream conversion
-		streamOpenAIToClaude(w, resp.Body, openaiReq.Model, cfg, startTime)
+// upstreamKeepaliveTypes are the "type"/"object" values upstreams use for keepalive chunks
+var upstreamKeepaliveTypes = map[string]bool{"ping": true, "keepalive": true, "heartbeat": true}
 
-		if cfg.Debug {
-			fmt.Printf("[DEBUG] StreamWriter: Completed\n")
+// isUpstreamKeepalive reports whether an upstream SSE data payload is a ping/keepalive
+// (plain "ping", or {"type":"ping"}-style objects without choices) rather than a chunk
+func isUpstreamKeepalive(data string, chunk map[string]interface{}) bool {
+	if upstreamKeepaliveTypes[strings.ToLower(strings.TrimSpace(data))] {
+		return true
+	}
+	if chunk == nil || chunk["choices"] != nil {
+		return false
+	}
+	for _, key := range []string{"type", "object"} {
+		if value, ok := chunk[key].(string); ok && upstreamKeepaliveTypes[value] {
+			return true
 		}
-	})
-
-	return nil
+	}
+	return false
 }
 
 // ToolCallState tracks the state of a tool call during streaming
@@ -284,6 +516,71 @@ type ToolCallState struct {
 	JSONSent    bool   // Flag if we sent the JSON delta
 	ClaudeIndex int    // The content block index for Claude
 	Started     bool   // Flag if content_block_start was sent
+	Fallback    bool   // Call to the injected FORCE_TOOL_MODE reply tool (forwarded as text)
+	Omitted     bool   // Beyond MAX_RESPONSE_BLOCKS (no block sent, arguments discarded)
+}
+
+// usageTokens extracts the input and output token counts from streaming usage data
+func usageTokens(usageData map[string]interface{}) (int, int) {
+	tokens := func(key string) int {
+		switch val := usageData[key].(type) {
+		case int:
+			return val
+		case float64:
+			return int(val)
+		}
+		return 0
+	}
+	return tokens("input_tokens"), tokens("output_tokens")
+}
+
+// costLogField formats the cost field appended to the [REQ] log line when the
+// upstream reported what the request cost
+func costLogField(cost *float64) string {
+	if cost == nil {
+		return ""
+	}
+	return fmt.Sprintf(" cost=$%.6f", *cost)
+}
+
+// recordStreamMetric records a completed stream for metrics export
+func recordStreamMetric(providerModel string, usageData map[string]interface{}, startTime time.Time) {
+	if requestMetrics == nil {
+		return
+	}
+	inputTokens, outputTokens := usageTokens(usageData)
+	recordRequestMetric(requestMetric{
+		Model:        providerModel,
+		Status:       200,
+		InputTokens:  inputTokens,
+		OutputTokens: outputTokens,
+		Duration:     time.Since(startTime),
+	})
+}
+
+// incrementalUsageInterval is the number of estimated output tokens between
+// interim usage message_delta events
+const incrementalUsageInterval = 20
+
+// deltaOutput passes each generated text of a streaming delta (text, reasoning and
+// tool call arguments) to add, e.g. the running output token count
+func deltaOutput(delta map[string]interface{}, add func(text string)) {
+	add(converter.ExtractResponseText(delta["content"]))
+	for _, key := range []string{"reasoning", "reasoning_content", "thinking"} {
+		if text, ok := delta[key].(string); ok {
+			add(text)
+		}
+	}
+	if toolCalls, ok := delta["tool_calls"].([]interface{}); ok {
+		for _, tcRaw := range toolCalls {
+			tc, _ := tcRaw.(map[string]interface{})
+			if fn, ok := tc["function"].(map[string]interface{}); ok {
+				if args, ok := fn["arguments"].(string); ok {
+					add(args)
+				}
+			}
+		}
+	}
 }
 
 // streamOpenAIToClaude converts OpenAI streaming responses to Claude's SSE event format.
@@ -296,11 +593,14 @@ type ToolCallState struct {
 //   - Thinking blocks from reasoning models (OpenRouter's reasoning_details, OpenAI's reasoning_content)
 //   - Text content deltas
 //   - Tool call deltas (accumulates JSON arguments across chunks)
-//   - Token usage tracking and throughput calculation for simple log mode
+//   - Token usage tracking and throughput calculation for simple log mode, logged
+//     against endpoint, the base URL that sent the stream
 //
+// Returns the reported input and output tokens (zero when the stream failed).
 // The function maintains state to track content block indices, tool call accumulation,
 // and ensures proper event ordering for Claude Code compatibility.
-func streamOpenAIToClaude(w *bufio.Writer, reader io.Reader, providerModel string, cfg *config.Config, startTime time.Time) {
+// queueLog is appended to the simple log line (see queueLogField).
+func streamOpenAIToClaude(w *sseWriter, reader io.Reader, providerModel, endpoint string, cfg *config.Config, startTime time.Time, queueLog string, inputEstimate int) (inputTokens, outputTokens, reasoningTokens int) {
 	if cfg.Debug {
 		fmt.Printf("[DEBUG] streamOpenAIToClaude: Starting conversion\n")
 	}
@@ -308,11 +608,13 @@ func streamOpenAIToClaude(w *bufio.Writer, reader io.Reader, providerModel strin
 	scanner.Buffer(make([]byte, 64*1024), 1024*1024) // Increase buffer size
 
 	// State variables
-	messageID := fmt.Sprintf("msg_%d", time.Now().UnixNano())
-	textBlockIndex := 1                              // Text block is index 1 (thinking is 0)
-	toolBlockCounter := 2                            // Tool calls start at index 2
+	messageID := converter.NewMessageID()
+	textBlockIndex := 1   // Text block is index 1 (thinking is 0)
+	toolBlockCounter := 2 // Tool calls start at index 2
+	omittedToolCalls := 0 // Tool calls beyond MAX_RESPONSE_BLOCKS
 	currentToolCalls := make(map[int]*ToolCallState)
 	finalStopReason := "end_turn"
+	var upstreamCost *float64 // USD, from the usage chunk (OpenRouter)
 	usageData := map[string]interface{}{
 		"input_tokens":                0,
 		"output_tokens":               0,
@@ -329,6 +631,113 @@ func streamOpenAIToClaude(w *bufio.Writer, reader io.Reader, providerModel strin
 	thinkingBlockStarted := false
 	thinkingBlockHasContent := false
 	textBlockStarted := false // Track if we've sent text block_start
+	eagerTextPending := false // EAGER_TEXT_BLOCK start held until the first delta shows no reasoning
+
+	// Running output token count since the last provider usage report (with the
+	// mapped model's tokenizer), for interim usage (STREAM_INCREMENTAL_USAGE) and
+	// the final usage when the provider reports none; and the last output token
+	// count sent to the client, and the counter's characters when it was last checked
+	outputSinceUsage := converter.NewStreamTokenCounter(converter.SelectTokenizer(providerModel, cfg))
+	reportedOutputTokens := 0
+	checkedOutputChars := 0
+
+	// A stream with no choice chunks at all is a provider error, not an empty reply
+	sawChoices := false
+	providerErrMsg := ""
+
+	// Inline <think> tags (Ollama and other local reasoning models) may span chunks;
+	// for OpenAI and OpenRouter the content is never held back
+	thinkTags := converter.NewThinkTagParser(cfg)
+
+	startTextBlock := func() {
+		eagerTextPending = false
+		if textBlockStarted {
+			return
+		}
+		writeSSEEvent(w, "content_block_start", map[string]interface{}{
+			"type":  "content_block_start",
+			"index": textBlockIndex,
+			"content_block": map[string]interface{}{
+				"type": "text",
+				"text": "",
+			},
+		})
+		textBlockStarted = true
+		_ = w.Flush()
+	}
+
+	// writeTextDelta writes to the text block as is. Only emitThinking and emitText
+	// call it: any other text goes through emitText, which ends an open
+	// THINKING_AS_TEXT section first.
+	writeTextDelta := func(text string) {
+		// Send content_block_start for text block on first text delta
+		startTextBlock()
+
+		writeSSEEvent(w, "content_block_delta", map[string]interface{}{
+			"type":  "content_block_delta",
+			"index": textBlockIndex,
+			"delta": map[string]interface{}{
+				"type": "text_delta",
+				"text": text,
+			},
+		})
+		_ = w.Flush()
+	}
+
+	// THINKING_AS_TEXT: reasoning is written into the text block between <thinking>
+	// tags; thinkingInText is set while such a section is open
+	thinkingInText := false
+
+	emitThinking := func(thinking string) {
+		eagerTextPending = false // the text block opens after the thinking block
+		if cfg.ThinkingAsText {
+			if !thinkingInText {
+				writeTextDelta(converter.ThinkingTextOpen)
+				thinkingInText = true
+			}
+			writeTextDelta(thinking)
+			return
+		}
+
+		// Send content_block_start for thinking block on first thinking delta
+		if !thinkingBlockStarted {
+			writeSSEEvent(w, "content_block_start", map[string]interface{}{
+				"type":  "content_block_start",
+				"index": thinkingBlockIndex,
+				"content_block": map[string]interface{}{
+					"type":     "thinking",
+					"thinking": "",
+				},
+			})
+			thinkingBlockStarted = true
+			_ = w.Flush()
+		}
+
+		// Send thinking block delta
+		writeSSEEvent(w, "content_block_delta", map[string]interface{}{
+			"type":  "content_block_delta",
+			"index": thinkingBlockIndex,
+			"delta": map[string]interface{}{
+				"type":     "thinking_delta",
+				"thinking": thinking,
+			},
+		})
+		thinkingBlockHasContent = true
+		_ = w.Flush()
+	}
+
+	// closeThinkingText ends an open THINKING_AS_TEXT section before other content
+	closeThinkingText := func() {
+		if thinkingInText {
+			writeTextDelta(converter.ThinkingTextClose)
+			thinkingInText = false
+		}
+	}
+
+	emitText := func(text string) {
+		closeThinkingText()
+		writeTextDelta(text)
+	}
 
 	// Send initial SSE events
 	writeSSEEvent(w, "message_start", map[string]interface{}{
@@ -342,7 +751,7 @@ func streamOpenAIToClaude(w *bufio.Writer, reader io.Reader, providerModel strin
 			"stop_reason":   nil,
 			"stop_sequence": nil,
 			"usage": map[string]interface{}{
-				"input_tokens":                0,
+				"input_tokens":                inputEstimate, // 0 unless count_tokens just counted this prompt
 				"output_tokens":               0,
 				"cache_creation_input_tokens": 0,
 				"cache_read_input_tokens":     0,
@@ -360,12 +769,33 @@ func streamOpenAIToClaude(w *bufio.Writer, reader io.Reader, providerModel strin
 
 	_ = w.Flush()
 
+	// EAGER_TEXT_BLOCK: open the text block right away instead of on the first text
+	// delta. Reasoning models stay lazy so a thinking block can still come first.
+	// Ollama and unknown gateways don't say which models reason (a reasoning or
+	// thinking field, <think> tags), so there the start waits for the first delta
+	// and is dropped if that delta is reasoning.
+	if cfg.EagerTextBlock && !cfg.IsReasoningModel(providerModel) {
+		switch cfg.RequestProvider() {
+		case config.ProviderOpenAI, config.ProviderOpenRouter:
+			startTextBlock()
+		default:
+			eagerTextPending = true
+		}
+	}
+
 	// Process streaming chunks
+	upstreamEvent := "" // name from the last "event:" line, for upstreams that send named pings
 	for scanner.Scan() {
 		line := scanner.Text()
 
 		// Skip empty lines and comments
 		if line == "" || strings.HasPrefix(line, ":") {
+			upstreamEvent = ""
+			continue
+		}
+
+		if strings.HasPrefix(line, "event:") {
+			upstreamEvent = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
 			continue
 		}
 
@@ -381,8 +811,13 @@ func streamOpenAIToClaude(w *bufio.Writer, reader io.Reader, providerModel strin
 
 		dataJSON := strings.TrimPrefix(line, "data: ")
 
+		// Upstream keepalives (event: ping, data: ping, {"type":"ping"}) carry no content
 		var chunk map[string]interface{}
-		if err := json.Unmarshal([]byte(dataJSON), &chunk); err != nil {
+		_ = json.Unmarshal([]byte(dataJSON), &chunk)
+		if upstreamKeepaliveTypes[upstreamEvent] || isUpstreamKeepalive(dataJSON, chunk) {
+			continue
+		}
+		if chunk == nil {
 			continue
 		}
 
@@ -391,8 +826,8 @@ func streamOpenAIToClaude(w *bufio.Writer, reader io.Reader, providerModel strin
 			fmt.Printf("[DEBUG] Raw chunk from OpenRouter: %s\n", dataJSON)
 		}
 
-		// Handle usage data
-		if usage, ok := chunk["usage"].(map[string]interface{}); ok {
+		// Handle usage data (location varies by provider, see usagePaths)
+		if usage := extractStreamUsage(chunk); usage != nil {
 			if cfg.Debug {
 				usageJSON, _ := json.Marshal(usage)
 				fmt.Printf("[DEBUG] Received usage from OpenAI: %s\n", string(usageJSON))
@@ -412,6 +847,18 @@ func streamOpenAIToClaude(w *bufio.Writer, reader io.Reader, providerModel strin
 				"input_tokens":  inputTokens,
 				"output_tokens": outputTokens,
 			}
+			outputSinceUsage.Reset()
+			checkedOutputChars = 0
+			if cost, ok := usage["cost"].(float64); ok {
+				upstreamCost = &cost
+			}
+
+			// Reasoning share of the output, checked against MAX_REASONING_TOKENS
+			if details, ok := usage["completion_tokens_details"].(map[string]interface{}); ok {
+				if val, ok := details["reasoning_tokens"].(float64); ok {
+					reasoningTokens = int(val)
+				}
+			}
 
 			// Add cache metrics if present
 			if promptTokensDetails, ok := usage["prompt_tokens_details"].(map[string]interface{}); ok {
@@ -426,16 +873,34 @@ func streamOpenAIToClaude(w *bufio.Writer, reader io.Reader, providerModel strin
 		}
 
 		// Extract delta from choices
+		// Chunks without choices are usage-only, or an error reported mid-stream
 		choices, ok := chunk["choices"].([]interface{})
 		if !ok || len(choices) == 0 {
+			if msg := providerErrorMessage(chunk); msg != "" {
+				providerErrMsg = msg
+			}
 			continue
 		}
+		sawChoices = true
 
-		choice := choices[0].(map[string]interface{})
-		delta, ok := choice["delta"].(map[string]interface{})
+		choice, ok := choices[0].(map[string]interface{})
 		if !ok {
 			continue
 		}
+		// A final chunk may carry only finish_reason (and usage) with no delta at all;
+		// it still has to reach the finish reason handling below
+		delta, _ := choice["delta"].(map[string]interface{})
+		if delta == nil {
+			delta = map[string]interface{}{}
+		}
+
+		deltaOutput(delta, outputSinceUsage.Add)
+
+		// OpenAI's first chunk is {"role":"assistant"} (often with content ""), which carries
+		// nothing to emit - message_start was already sent, so skip it explicitly
+		if isRoleOnlyDelta(delta) && choice["finish_reason"] == nil {
+			continue
+		}
 
 		// Handle reasoning delta (thinking blocks)
 		// Support both OpenRouter and OpenAI formats:
@@ -444,28 +909,7 @@ func streamOpenAIToClaude(w *bufio.Writer, reader io.Reader, providerModel strin
 
 		// First, check for OpenAI's reasoning_content format (o1/o3 models)
 		if reasoningContent, ok := delta["reasoning_content"].(string); ok && reasoningContent != "" {
-			// Send content_block_start for thinking block on first thinking delta
-			if !thinkingBlockStarted {
-				writeSSEEvent(w, "content_block_start", map[string]interface{}{
-					"type":  "content_block_start",
-					"index": thinkingBlockIndex,
-					"content_block": map[string]interface{}{
-						"type": "thinking",
-					},
-				})
-				thinkingBlockStarted = true
-				thinkingBlockHasContent = true
-			}
-
-			// Send thinking delta
-			writeSSEEvent(w, "content_block_delta", map[string]interface{}{
-				"type":  "content_block_delta",
-				"index": thinkingBlockIndex,
-				"delta": map[string]interface{}{
-					"type": "thinking_delta",
-					"text": reasoningContent,
-				},
-			})
+			emitThinking(reasoningContent)
 		}
 
 		// Then, check for OpenRouter's reasoning_details format
@@ -493,95 +937,40 @@ func streamOpenAIToClaude(w *bufio.Writer, reader io.Reader, providerModel strin
 						}
 
 						if thinkingText != "" {
-							// Send content_block_start for thinking block on first thinking delta
-							if !thinkingBlockStarted {
-								writeSSEEvent(w, "content_block_start", map[string]interface{}{
-									"type":  "content_block_start",
-									"index": thinkingBlockIndex,
-									"content_block": map[string]interface{}{
-										"type":     "thinking",
-										"thinking": "",
-									},
-								})
-								thinkingBlockStarted = true
-								_ = w.Flush()
-							}
-
-							// Send thinking block delta
-							writeSSEEvent(w, "content_block_delta", map[string]interface{}{
-								"type":  "content_block_delta",
-								"index": thinkingBlockIndex,
-								"delta": map[string]interface{}{
-									"type":     "thinking_delta",
-									"thinking": thinkingText,
-								},
-							})
-							thinkingBlockHasContent = true
-							_ = w.Flush()
+							emitThinking(thinkingText)
 						}
 					}
 				}
 			}
 		}
 
-		// Handle reasoning field directly (simpler format from some models)
-		if reasoning, ok := delta["reasoning"].(string); ok && reasoning != "" {
-			// Send content_block_start for thinking block on first thinking delta
-			if !thinkingBlockStarted {
-				writeSSEEvent(w, "content_block_start", map[string]interface{}{
-					"type":  "content_block_start",
-					"index": thinkingBlockIndex,
-					"content_block": map[string]interface{}{
-						"type":     "thinking",
-						"thinking": "",
-					},
-				})
-				thinkingBlockStarted = true
-				_ = w.Flush()
-			}
-
-			// Send thinking block delta
-			writeSSEEvent(w, "content_block_delta", map[string]interface{}{
-				"type":  "content_block_delta",
-				"index": thinkingBlockIndex,
-				"delta": map[string]interface{}{
-					"type":     "thinking_delta",
-					"thinking": reasoning,
-				},
-			})
-			thinkingBlockHasContent = true
-			_ = w.Flush()
+		// Handle reasoning field directly (simpler format from some models and Ollama's
+		// OpenAI-compatible endpoint) or the "thinking" field of Ollama's native API
+		reasoning, _ := delta["reasoning"].(string)
+		if reasoning == "" {
+			reasoning, _ = delta["thinking"].(string)
+		}
+		if reasoning != "" {
+			emitThinking(reasoning)
 		}
 
-		// Handle text delta
-		if content, ok := delta["content"].(string); ok && content != "" {
-			// Send content_block_start for text block on first text delta
-			if !textBlockStarted {
-				writeSSEEvent(w, "content_block_start", map[string]interface{}{
-					"type":  "content_block_start",
-					"index": textBlockIndex,
-					"content_block": map[string]interface{}{
-						"type": "text",
-						"text": "",
-					},
-				})
-				textBlockStarted = true
-				_ = w.Flush()
+		// Handle text delta (a string or an array of content parts); inline <think>
+		// reasoning is split out into the thinking block
+		if content := converter.ExtractResponseText(delta["content"]); content != "" {
+			thinking, text := thinkTags.Feed(content)
+			if thinking != "" {
+				emitThinking(thinking)
+			}
+			if text != "" {
+				emitText(text)
 			}
-
-			writeSSEEvent(w, "content_block_delta", map[string]interface{}{
-				"type":  "content_block_delta",
-				"index": textBlockIndex,
-				"delta": map[string]interface{}{
-					"type": "text_delta",
-					"text": content,
-				},
-			})
-			_ = w.Flush()
 		}
 
-		// Handle tool call deltas
+		// Handle tool call deltas (after the held eager text block, if still pending)
 		if toolCallsRaw, ok := delta["tool_calls"]; ok {
+			if eagerTextPending {
+				startTextBlock()
+			}
 			// Debug: Log raw tool_calls from provider
 			if cfg.Debug {
 				toolCallsJSON, _ := json.Marshal(toolCallsRaw)
@@ -616,9 +1005,13 @@ func streamOpenAIToClaude(w *bufio.Writer, reader io.Reader, providerModel strin
 
 					toolCall := currentToolCalls[tcIndex]
 
-					// Update tool call ID if provided
-					if id, ok := tcDelta["id"].(string); ok {
+					// Update tool call ID if provided. A call whose first delta has no
+					// ID gets a synthesized one so the client can match its tool_result;
+					// once the block started its ID stays fixed.
+					if id, _ := tcDelta["id"].(string); id != "" && !toolCall.Started {
 						toolCall.ID = id
+					} else if toolCall.ID == "" {
+						toolCall.ID = converter.NewToolUseID()
 					}
 
 					// Update function name
@@ -627,8 +1020,37 @@ func streamOpenAIToClaude(w *bufio.Writer, reader io.Reader, providerModel strin
 							toolCall.Name = name
 						}
 
+						// The injected fallback reply tool is forwarded as text once its
+						// arguments are complete, so it gets no tool_use block
+						if toolCall.Name == converter.FallbackToolName && !toolCall.Started {
+							toolCall.Started = true
+							toolCall.Fallback = true
+						}
+
+						// Tool calls beyond MAX_RESPONSE_BLOCKS get no block; one slot stays
+						// free for the text block carrying the notice
+						if toolCall.ID != "" && toolCall.Name != "" && !toolCall.Started && cfg.MaxResponseBlocks > 0 {
+							blocks := toolBlockCounter - 2 + 1 // tool blocks plus the text block
+							if thinkingBlockStarted {
+								blocks++
+							}
+							if blocks >= cfg.MaxResponseBlocks {
+								toolCall.Started = true
+								toolCall.Omitted = true
+								toolCall.JSONSent = true
+								omittedToolCalls++
+							}
+						}
+
 						// Start content block when we have complete initial data
 						if toolCall.ID != "" && toolCall.Name != "" && !toolCall.Started {
+							closeThinkingText()
+
+							// Some clients expect a text block even in a tool-only reply
+							if cfg.EmptyTextBlockWithTools && !textBlockStarted && !thinkingBlockStarted {
+								startTextBlock()
+							}
+
 							toolBlockCounter++
 							claudeIndex := textBlockIndex + toolBlockCounter
 							toolCall.ClaudeIndex = claudeIndex
@@ -649,16 +1071,24 @@ func streamOpenAIToClaude(w *bufio.Writer, reader io.Reader, providerModel strin
 
 						// Handle function arguments
 						// Type assertion handles nil check, Started flag, and we process even empty strings
-						if args, ok := functionData["arguments"].(string); ok && toolCall.Started {
+						if args, ok := functionData["arguments"].(string); ok && toolCall.Started && !toolCall.Omitted {
 							// Only accumulate if args is not empty
 							if args != "" {
 								toolCall.ArgsBuffer += args
 							}
 
-							// Try to parse complete JSON and send delta when we have valid JSON
+							// Send delta once the buffer is complete JSON. Only validated, never
+							// parsed: the raw buffer is forwarded so numbers and formatting stay byte-exact
 							if toolCall.ArgsBuffer != "" {
-								var jsonTest interface{}
-								if err := json.Unmarshal([]byte(toolCall.ArgsBuffer), &jsonTest); err == nil {
+								if json.Valid([]byte(toolCall.ArgsBuffer)) {
+									// Fallback reply tool: emit its text as a text block
+									if toolCall.Fallback && !toolCall.JSONSent {
+										if text := converter.FallbackToolText(toolCall.ArgsBuffer); text != "" {
+											emitText(text)
+										}
+										toolCall.JSONSent = true
+									}
+
 									// If parsing succeeds and we haven't sent this JSON yet
 									if !toolCall.JSONSent {
 										writeSSEEvent(w, "content_block_delta", map[string]interface{}{
@@ -684,23 +1114,84 @@ func streamOpenAIToClaude(w *bufio.Writer, reader io.Reader, providerModel strin
 		// Handle finish reason
 		// NOTE: Don't break here - with stream_options.include_usage, OpenAI sends usage in a chunk AFTER finish_reason
 		if finishReason, ok := choice["finish_reason"].(string); ok && finishReason != "" {
-			switch finishReason {
-			case "length":
-				finalStopReason = "max_tokens"
-			case "tool_calls", "function_call":
-				finalStopReason = "tool_use"
-			case "stop":
-				finalStopReason = "end_turn"
-			default:
-				finalStopReason = "end_turn"
-			}
+			finalStopReason = converter.ConvertFinishReason(finishReason, cfg.FinishReasonMap)
 			// Continue processing to capture usage chunk (don't break)
 		}
+
+		// Interim usage: provider-reported output tokens plus an estimate for what
+		// has streamed since, sent every incrementalUsageInterval tokens. Estimating
+		// re-tokenizes the current line, so it runs at most once per interval of
+		// streamed characters (a token is at least one character).
+		if cfg.StreamIncrementalUsage && outputSinceUsage.Chars()-checkedOutputChars >= incrementalUsageInterval {
+			checkedOutputChars = outputSinceUsage.Chars()
+			reported, _ := usageData["output_tokens"].(int)
+			estimated := reported + outputSinceUsage.Tokens()
+			if estimated-reportedOutputTokens >= incrementalUsageInterval {
+				writeSSEEvent(w, "message_delta", map[string]interface{}{
+					"type": "message_delta",
+					"delta": map[string]interface{}{
+						"stop_reason":   nil,
+						"stop_sequence": nil,
+					},
+					"usage": map[string]interface{}{
+						"output_tokens": estimated,
+					},
+				})
+				_ = w.Flush()
+				reportedOutputTokens = estimated
+			}
+		}
+	}
+
+	// Report a choices-less stream as an error instead of an empty message
+	if !sawChoices && scanner.Err() == nil {
+		err := &noChoicesError{Detail: providerErrMsg}
+		logging.Printf("[%s] [ERROR] %v\n", time.Now().Format("15:04:05"), err)
+		writeSSEError(w, err.Error())
+		return 0, 0, 0
 	}
 
 	// Send final SSE events
 
+	// Emit any content held back while checking for a split <think> tag
+	if thinking, text := thinkTags.Flush(); thinking != "" {
+		emitThinking(thinking)
+	} else if text != "" {
+		emitText(text)
+	}
+	if omittedToolCalls > 0 {
+		logging.Printf("[%s] [WARN] Stream had %d tool calls beyond MAX_RESPONSE_BLOCKS, dropped\n",
+			time.Now().Format("15:04:05"), omittedToolCalls)
+		notice := converter.OmittedBlocksNotice(omittedToolCalls, cfg.MaxResponseBlocks)
+		if textBlockStarted {
+			notice = "\n\n" + notice
+		}
+		emitText(notice)
+	}
+
+	// tool_use needs a tool_use block: a turn that only called the fallback reply
+	// tool is a plain text reply, and some providers report tool_calls without any
+	if finalStopReason == "tool_use" {
+		realToolCalls, fallbackCalls := 0, 0
+		for _, toolData := range currentToolCalls {
+			switch {
+			case toolData.Fallback:
+				fallbackCalls++
+			case toolData.Started && !toolData.Omitted:
+				realToolCalls++
+			}
+		}
+		if realToolCalls == 0 {
+			if fallbackCalls == 0 && omittedToolCalls == 0 {
+				logging.Printf("[%s] [WARN] Provider finished with tool_calls but sent no tool calls, using end_turn\n",
+					time.Now().Format("15:04:05"))
+			}
+			finalStopReason = "end_turn"
+		}
+	}
+
 	// Send content_block_stop for text block if it was started
+	closeThinkingText()
 	if textBlockStarted {
 		writeSSEEvent(w, "content_block_stop", map[string]interface{}{
 			"type":  "content_block_stop",
@@ -709,6 +1200,26 @@ func streamOpenAIToClaude(w *bufio.Writer, reader io.Reader, providerModel strin
 		_ = w.Flush()
 	}
 
+	// If the turn was cut off by the token limit mid-tool-call, the arguments never
+	// became valid JSON and were held back. Send what we have so the partial tool_use
+	// is preserved (matching Claude's own max_tokens behavior) and the client can continue.
+	if finalStopReason == "max_tokens" {
+		for _, toolData := range currentToolCalls {
+			if toolData.Started && !toolData.Fallback && !toolData.JSONSent && toolData.ArgsBuffer != "" {
+				writeSSEEvent(w, "content_block_delta", map[string]interface{}{
+					"type":  "content_block_delta",
+					"index": toolData.ClaudeIndex,
+					"delta": map[string]interface{}{
+						"type":         "input_json_delta",
+						"partial_json": toolData.ArgsBuffer,
+					},
+				})
+				_ = w.Flush()
+				toolData.JSONSent = true
+			}
+		}
+	}
+
 	// Send content_block_stop for each tool call
 	for _, toolData := range currentToolCalls {
 		// Check both Started AND claude_index is not None
@@ -730,6 +1241,11 @@ func streamOpenAIToClaude(w *bufio.Writer, reader io.Reader, providerModel strin
 		_ = w.Flush()
 	}
 
+	// No output usage from the provider: report the running estimate instead of 0
+	if reported, _ := usageData["output_tokens"].(int); reported == 0 {
+		usageData["output_tokens"] = outputSinceUsage.Tokens()
+	}
+
 	// Debug: Check if usage data was received
 	if cfg.Debug {
 		inputTokens, _ := usageData["input_tokens"].(int)
@@ -761,24 +1277,11 @@ func streamOpenAIToClaude(w *bufio.Writer, reader io.Reader, providerModel strin
 	})
 	_ = w.Flush()
 
+	recordStreamMetric(providerModel, usageData, startTime)
+	inputTokens, outputTokens = usageTokens(usageData)
+
 	// Simple log: one-line summary
 	if cfg.SimpleLog {
-		inputTokens := 0
-		outputTokens := 0
-
-		// Try to extract tokens from various possible formats
-		if val, ok := usageData["input_tokens"].(int); ok {
-			inputTokens = val
-		} else if val, ok := usageData["input_tokens"].(float64); ok {
-			inputTokens = int(val)
-		}
-
-		if val, ok := usageData["output_tokens"].(int); ok {
-			outputTokens = val
-		} else if val, ok := usageData["output_tokens"].(float64); ok {
-			outputTokens = int(val)
-		}
-
 		// Debug: show what we actually have in usageData
 		if cfg.Debug {
 			fmt.Printf("[DEBUG] usageData: %+v\n", usageData)
@@ -792,105 +1295,104 @@ func streamOpenAIToClaude(w *bufio.Writer, reader io.Reader, providerModel strin
 		}
 
 		timestamp := time.Now().Format("15:04:05")
-		fmt.Printf("[%s] [REQ] %s model=%s in=%d out=%d tok/s=%.1f\n",
+		logging.Printf("[%s] [REQ] %s model=%s in=%d out=%d tok/s=%.1f%s%s\n",
 			timestamp,
-			cfg.OpenAIBaseURL,
+			endpoint,
 			providerModel,
 			inputTokens,
 			outputTokens,
-			tokensPerSec)
+			tokensPerSec,
+			costLogField(upstreamCost),
+			queueLog)
 	}
 
 	// Check for scanner errors
 	if err := scanner.Err(); err != nil {
 		writeSSEError(w, fmt.Sprintf("stream read error: %v", err))
 	}
+	return inputTokens, outputTokens, reasoningTokens
 }
 
-// writeSSEEvent writes a Server-Sent Event
-func writeSSEEvent(w *bufio.Writer, event string, data interface{}) {
-	dataJSON, _ := json.Marshal(data)
-	_, _ = fmt.Fprintf(w, "event: %s\n", event)
-	_, _ = fmt.Fprintf(w, "data: %s\n\n", string(dataJSON))
-}
-
-// writeSSEError writes an error event
-func writeSSEError(w *bufio.Writer, message string) {
-	writeSSEEvent(w, "error", map[string]interface{}{
-		"type": "error",
-		"error": map[string]interface{}{
-			"type":    "api_error",
-			"message": message,
-		},
-	})
-	_ = w.Flush()
+// schemaErrorMessage lists the schema issues of a rejected request in one message
+func schemaErrorMessage(issues []converter.ValidationIssue) string {
+	details := make([]string, len(issues))
+	for i, issue := range issues {
+		details[i] = issue.String()
+	}
+	return "Request does not match the Claude request schema: " + strings.Join(details, "; ")
 }
 
-// callOpenAI makes an HTTP request to the OpenAI API
-func callOpenAI(req *models.OpenAIRequest, cfg *config.Config) (*models.OpenAIResponse, error) {
-	// Marshal request to JSON
-	reqBody, err := json.Marshal(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+// handleValidate is the handler for /v1/messages/validate.
+// It runs the full conversion and all request validations without calling the
+// provider, and returns a structured report of any issues found.
+func handleValidate(c *fiber.Ctx, cfg *config.Config) error {
+	// Validate API key (if configured) - the report reveals routing details
+	if cfg.AnthropicAPIKey != "" && c.Get("x-api-key") != cfg.AnthropicAPIKey {
+		return claudeError(c, 401, errAuthentication, "Invalid API key")
 	}
 
-	// Build API URL
-	apiURL := cfg.OpenAIBaseURL + "/chat/completions"
-
-	// Create HTTP request
-	httpReq, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(reqBody))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+	if err := decodeRequestBody(c); err != nil {
+		return claudeError(c, 400, errInvalidRequest, err.Error())
 	}
-
-	// Set headers
-	httpReq.Header.Set("Content-Type", "application/json")
-
-	// Skip auth for Ollama (localhost) - Ollama doesn't require authentication
-	if !cfg.IsLocalhost() {
-		httpReq.Header.Set("Authorization", "Bearer "+cfg.OpenAIAPIKey)
+	var claudeReq models.ClaudeRequest
+	if err := c.BodyParser(&claudeReq); err != nil {
+		return c.JSON(fiber.Map{
+			"valid": false,
+			"issues": []converter.ValidationIssue{
+				{Field: "", Message: fmt.Sprintf("Invalid request body: %v", err)},
+			},
+		})
 	}
 
-	// OpenRouter-specific headers for better rate limits
-	if cfg.DetectProvider() == config.ProviderOpenRouter {
-		addOpenRouterHeaders(httpReq, cfg)
+	issues, openaiReq := converter.ValidateRequest(claudeReq, cfg)
+	report := fiber.Map{
+		"valid":  len(issues) == 0,
+		"issues": issues,
 	}
-
-	// Create HTTP client with timeout
-	client := &http.Client{
-		Timeout: 90 * time.Second,
+	if issues == nil {
+		report["issues"] = []converter.ValidationIssue{}
 	}
 
-	// Make request
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+	// Include what the request would have been sent as
+	if openaiReq != nil {
+		report["converted"] = fiber.Map{
+			"provider":      cfg.DetectProvider(),
+			"model":         openaiReq.Model,
+			"message_count": len(openaiReq.Messages),
+			"tool_count":    len(openaiReq.Tools),
+		}
 	}
-	defer func() { _ = resp.Body.Close() }()
 
-	// Read response body
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
-	}
+	return c.JSON(report)
+}
 
-	// Check for errors
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("OpenAI API returned status %d: %s", resp.StatusCode, string(respBody))
+// handleCountTokens estimates input tokens for a Claude request, including a
+// cache read/write split when the request uses cache_control breakpoints
+func handleCountTokens(c *fiber.Ctx, cfg *config.Config) error {
+	if err := decodeRequestBody(c); err != nil {
+		return claudeError(c, 400, errInvalidRequest, err.Error())
 	}
-
-	// Parse response
-	var openaiResp models.OpenAIResponse
-	if err := json.Unmarshal(respBody, &openaiResp); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
+	var claudeReq models.ClaudeRequest
+	if err := c.BodyParser(&claudeReq); err != nil {
+		return claudeError(c, 400, errInvalidRequest, fmt.Sprintf("Invalid request body: %v", err))
 	}
 
-	return &openaiResp, nil
-}
-
-func handleCountTokens(c *fiber.Ctx, cfg *config.Config) error {
-	// Simple token counting endpoint
-	return c.JSON(fiber.Map{
-		"input_tokens": 100,
-	})
+	// Approximate count with the routed model's tokenizer, unless this prompt was
+	// counted or sent moments ago (TOKEN_COUNT_CACHE_TTL); cache split is only
+	// reported for requests with cache_control markers
+	key := tokenCounts.key(claudeReq)
+	estimate, cached := tokenCounts.get(key)
+	if !cached {
+		tokenizer := converter.SelectTokenizer(converter.MapModel(claudeReq.Model, cfg), cfg)
+		estimate = converter.EstimateTokens(claudeReq, tokenizer)
+		tokenCounts.set(key, estimate)
+	}
+	resp := fiber.Map{
+		"input_tokens": estimate.InputTokens,
+	}
+	if estimate.HasCacheMarkers {
+		resp["cache_read_estimate"] = estimate.CacheReadTokens
+		resp["cache_write_estimate"] = estimate.CacheWriteTokens
+	}
+	return c.JSON(resp)
 }
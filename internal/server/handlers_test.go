@@ -1,9 +1,24 @@
 package server
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/claude-code-proxy/proxy/internal/config"
+	"github.com/claude-code-proxy/proxy/internal/converter"
+	"github.com/claude-code-proxy/proxy/internal/logging"
+	"github.com/claude-code-proxy/proxy/pkg/models"
+	"github.com/gofiber/fiber/v2"
 )
 
 // TestServerSetup tests that the server can be initialized
@@ -281,3 +296,1308 @@ func TestConfigFormatDetection(t *testing.T) {
 		t.Errorf("HaikuModel not set correctly")
 	}
 }
+
+// testClaudeRequestBody is a minimal non-streaming Claude request
+const testClaudeRequestBody = `{"model":"claude-sonnet-4","max_tokens":100,"messages":[{"role":"user","content":"hello"}]}`
+
+// newTestApp creates a Fiber app with the Claude endpoints wired to cfg
+func newTestApp(cfg *config.Config) *fiber.App {
+	app := fiber.New()
+	setupClaudeEndpoints(app, cfg)
+	return app
+}
+
+// postJSON sends a JSON POST request to the test app and returns the response
+func postJSON(t *testing.T, app *fiber.App, path, body string) *http.Response {
+	t.Helper()
+	req := httptest.NewRequest("POST", path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("app.Test() error = %v", err)
+	}
+	return resp
+}
+
+// TestUpstreamServiceTierHeader tests that the upstream-reported service_tier is surfaced as a header
+func TestUpstreamServiceTierHeader(t *testing.T) {
+	tests := []struct {
+		name         string
+		upstreamBody string
+		expectedTier string
+	}{
+		{
+			name:         "flex tier reported",
+			upstreamBody: `{"id":"chatcmpl-1","service_tier":"flex","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":1}}`,
+			expectedTier: "flex",
+		},
+		{
+			name:         "default tier reported",
+			upstreamBody: `{"id":"chatcmpl-2","service_tier":"default","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":1}}`,
+			expectedTier: "default",
+		},
+		{
+			name:         "no tier reported",
+			upstreamBody: `{"id":"chatcmpl-3","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":1}}`,
+			expectedTier: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(tt.upstreamBody))
+			}))
+			defer upstream.Close()
+
+			cfg := &config.Config{
+				OpenAIBaseURL: upstream.URL,
+				OpenAIAPIKey:  "test-key",
+			}
+
+			resp := postJSON(t, newTestApp(cfg), "/v1/messages", testClaudeRequestBody)
+
+			if resp.StatusCode != 200 {
+				t.Fatalf("Status = %d, want 200", resp.StatusCode)
+			}
+
+			if got := resp.Header.Get("X-Upstream-Service-Tier"); got != tt.expectedTier {
+				t.Errorf("X-Upstream-Service-Tier = %q, want %q", got, tt.expectedTier)
+			}
+		})
+	}
+}
+
+// TestUpstreamCost tests that the cost OpenRouter reports in usage reaches the
+// X-Upstream-Cost-USD header and the simple log
+func TestUpstreamCost(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req["stream"] == true {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte(upstreamStream(
+				`{"choices":[{"index":0,"delta":{"content":"hi"},"finish_reason":"stop"}]}`,
+				`{"choices":[],"usage":{"prompt_tokens":5,"completion_tokens":1,"cost":0.00042}}`,
+			)))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"gen-1","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":1,"cost":0.0012}}`))
+	}))
+	defer upstream.Close()
+
+	var logs bytes.Buffer
+	defer logging.SetOutput(&logs)()
+	cfg := &config.Config{OpenAIBaseURL: upstream.URL, SimpleLog: true}
+
+	t.Run("non-streaming", func(t *testing.T) {
+		logs.Reset()
+		resp := postJSON(t, newTestApp(cfg), "/v1/messages", testClaudeRequestBody)
+		if got := resp.Header.Get("X-Upstream-Cost-USD"); got != "0.0012" {
+			t.Errorf("X-Upstream-Cost-USD = %q, want %q", got, "0.0012")
+		}
+		if !strings.Contains(logs.String(), " cost=$0.001200") {
+			t.Errorf("log = %q, want the cost field", logs.String())
+		}
+	})
+
+	t.Run("streaming", func(t *testing.T) {
+		logs.Reset()
+		streamBody := strings.Replace(testClaudeRequestBody, `"max_tokens":100`, `"max_tokens":100,"stream":true`, 1)
+		resp := postJSON(t, newTestApp(cfg), "/v1/messages", streamBody)
+		_, _ = io.ReadAll(resp.Body)
+		if !strings.Contains(logs.String(), " cost=$0.000420") {
+			t.Errorf("log = %q, want the cost field", logs.String())
+		}
+	})
+}
+
+// TestMessageID tests that both paths return a proxy msg_ ID and the upstream ID in a header
+func TestMessageID(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req["stream"] == true {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte(upstreamStream(
+				`{"id":"gen-stream-1","choices":[{"index":0,"delta":{"content":"hi"},"finish_reason":"stop"}]}`,
+			)))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-123","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}`))
+	}))
+	defer upstream.Close()
+	cfg := &config.Config{OpenAIBaseURL: upstream.URL}
+
+	t.Run("non-streaming", func(t *testing.T) {
+		resp := postJSON(t, newTestApp(cfg), "/v1/messages", testClaudeRequestBody)
+		var claudeResp models.ClaudeResponse
+		_ = json.NewDecoder(resp.Body).Decode(&claudeResp)
+		if !strings.HasPrefix(claudeResp.ID, "msg_") {
+			t.Errorf("id = %q, want a msg_ ID", claudeResp.ID)
+		}
+		if got := resp.Header.Get(upstreamMessageIDHeader); got != "chatcmpl-123" {
+			t.Errorf("%s = %q, want chatcmpl-123", upstreamMessageIDHeader, got)
+		}
+	})
+
+	t.Run("streaming", func(t *testing.T) {
+		streamBody := strings.Replace(testClaudeRequestBody, `"max_tokens":100`, `"max_tokens":100,"stream":true`, 1)
+		resp := postJSON(t, newTestApp(cfg), "/v1/messages", streamBody)
+		raw, _ := io.ReadAll(resp.Body)
+		starts := eventsOfType(parseSSEEvents(string(raw)), "message_start")
+		if len(starts) != 1 {
+			t.Fatalf("Expected 1 message_start, got %d", len(starts))
+		}
+		if id, _ := starts[0].Data["message"].(map[string]interface{})["id"].(string); !strings.HasPrefix(id, "msg_") {
+			t.Errorf("message_start id = %q, want a msg_ ID", id)
+		}
+		if got := resp.Header.Get(upstreamMessageIDHeader); got != "gen-stream-1" {
+			t.Errorf("%s = %q, want gen-stream-1", upstreamMessageIDHeader, got)
+		}
+	})
+
+	t.Run("slow first chunk", func(t *testing.T) {
+		// The upstream holds its first chunk until the client has seen message_start
+		release := make(chan struct{})
+		var releaseOnce sync.Once
+		releaseUpstream := func() { releaseOnce.Do(func() { close(release) }) }
+		defer releaseUpstream()
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte(": PROCESSING\n\n"))
+			w.(http.Flusher).Flush()
+			select {
+			case <-release:
+			case <-time.After(5 * time.Second):
+			}
+			_, _ = w.Write([]byte(upstreamStream(`{"id":"gen-slow","choices":[{"index":0,"delta":{"content":"hi"},"finish_reason":"stop"}]}`)))
+		}))
+		defer slow.Close()
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("net.Listen() error = %v", err)
+		}
+		app := fiber.New(fiber.Config{DisableStartupMessage: true})
+		setupClaudeEndpoints(app, &config.Config{OpenAIBaseURL: slow.URL})
+		go func() { _ = app.Listener(ln) }()
+		defer func() { _ = app.Shutdown() }()
+
+		start := time.Now()
+		streamBody := strings.Replace(testClaudeRequestBody, `"max_tokens":100`, `"max_tokens":100,"stream":true`, 1)
+		resp, err := http.Post("http://"+ln.Addr().String()+"/v1/messages", "application/json", strings.NewReader(streamBody))
+		if err != nil {
+			t.Fatalf("POST error = %v", err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+
+		reader := bufio.NewReader(resp.Body)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("stream ended before message_start: %v", err)
+			}
+			if strings.TrimSpace(line) == "event: message_start" {
+				break
+			}
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("message_start took %v, want it before the upstream's first chunk", elapsed)
+		}
+		if got := resp.Header.Get(upstreamMessageIDHeader); got != "" {
+			t.Errorf("%s = %q, want none when the first chunk is late", upstreamMessageIDHeader, got)
+		}
+
+		releaseUpstream()
+		rest, _ := io.ReadAll(reader)
+		if !strings.Contains(string(rest), `"text":"hi"`) {
+			t.Errorf("rest of stream = %s, want the delayed text", rest)
+		}
+	})
+}
+
+// newTestOpenAIRequest creates a minimal OpenAI request for the given model
+func newTestOpenAIRequest(model string) *models.OpenAIRequest {
+	return &models.OpenAIRequest{
+		Model: model,
+		Messages: []models.OpenAIMessage{
+			{Role: "user", Content: "hello"},
+		},
+	}
+}
+
+// sseTestEvent is a parsed SSE event from the proxy's output
+type sseTestEvent struct {
+	Event string
+	Data  map[string]interface{}
+}
+
+// upstreamStream builds an OpenAI SSE body from data payloads, terminated by [DONE]
+func upstreamStream(chunks ...string) string {
+	var sb strings.Builder
+	for _, chunk := range chunks {
+		sb.WriteString("data: " + chunk + "\n\n")
+	}
+	sb.WriteString("data: [DONE]\n\n")
+	return sb.String()
+}
+
+// convertTestStream runs streamOpenAIToClaude over the given upstream SSE body
+func convertTestStream(cfg *config.Config, upstreamBody string) []sseTestEvent {
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	streamOpenAIToClaude(newSSEWriter(bw), strings.NewReader(upstreamBody), "test-model", "", cfg, time.Now(), "", 0)
+	_ = bw.Flush()
+	return parseSSEEvents(buf.String())
+}
+
+// parseSSEEvents parses "event:/data:" pairs from raw SSE output
+func parseSSEEvents(raw string) []sseTestEvent {
+	var events []sseTestEvent
+	for _, block := range strings.Split(raw, "\n\n") {
+		lines := strings.Split(strings.TrimSpace(block), "\n")
+		if len(lines) < 2 {
+			continue
+		}
+		event := sseTestEvent{Event: strings.TrimPrefix(lines[0], "event: ")}
+		_ = json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data: ")), &event.Data)
+		events = append(events, event)
+	}
+	return events
+}
+
+// eventsOfType returns the events with the given event name
+func eventsOfType(events []sseTestEvent, name string) []sseTestEvent {
+	var matched []sseTestEvent
+	for _, e := range events {
+		if e.Event == name {
+			matched = append(matched, e)
+		}
+	}
+	return matched
+}
+
+// TestStreamingRoleOnlyDelta tests that OpenAI's role-only first chunk doesn't start a content block
+func TestStreamingRoleOnlyDelta(t *testing.T) {
+	cfg := &config.Config{}
+
+	t.Run("role-only chunk emits no block", func(t *testing.T) {
+		events := convertTestStream(cfg, upstreamStream(
+			`{"choices":[{"index":0,"delta":{"role":"assistant","content":""},"finish_reason":null}]}`,
+			`{"choices":[{"index":0,"delta":{"role":"assistant"},"finish_reason":null}]}`,
+			`{"choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+		))
+
+		if starts := eventsOfType(events, "content_block_start"); len(starts) != 0 {
+			t.Errorf("Expected no content_block_start, got %d", len(starts))
+		}
+		if len(eventsOfType(events, "message_start")) != 1 || len(eventsOfType(events, "message_stop")) != 1 {
+			t.Error("Expected message_start and message_stop to still be emitted")
+		}
+	})
+
+	t.Run("role chunk followed by content", func(t *testing.T) {
+		events := convertTestStream(cfg, upstreamStream(
+			`{"choices":[{"index":0,"delta":{"role":"assistant","content":""},"finish_reason":null}]}`,
+			`{"choices":[{"index":0,"delta":{"content":"Hello"},"finish_reason":null}]}`,
+			`{"choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+		))
+
+		starts := eventsOfType(events, "content_block_start")
+		if len(starts) != 1 {
+			t.Fatalf("Expected exactly 1 content_block_start, got %d", len(starts))
+		}
+		block := starts[0].Data["content_block"].(map[string]interface{})
+		if block["type"] != "text" {
+			t.Errorf("Block type = %v, want text", block["type"])
+		}
+	})
+
+	t.Run("role with content in the same chunk", func(t *testing.T) {
+		events := convertTestStream(cfg, upstreamStream(
+			`{"choices":[{"index":0,"delta":{"role":"assistant","content":"Hi"},"finish_reason":null}]}`,
+		))
+
+		if deltas := eventsOfType(events, "content_block_delta"); len(deltas) != 1 {
+			t.Errorf("Expected content in the role chunk to be streamed, got %d deltas", len(deltas))
+		}
+	})
+}
+
+// TestStreamingUsageLocations tests that usage is captured regardless of where the provider puts it
+func TestStreamingUsageLocations(t *testing.T) {
+	tests := []struct {
+		name       string
+		usageChunk string
+	}{
+		{
+			name:       "top-level usage",
+			usageChunk: `{"choices":[],"usage":{"prompt_tokens":11,"completion_tokens":7}}`,
+		},
+		{
+			name:       "x_groq vendor field",
+			usageChunk: `{"choices":[{"index":0,"delta":{},"finish_reason":null}],"x_groq":{"usage":{"prompt_tokens":11,"completion_tokens":7}}}`,
+		},
+		{
+			name:       "nested in choice",
+			usageChunk: `{"choices":[{"index":0,"delta":{},"finish_reason":"stop","usage":{"prompt_tokens":11,"completion_tokens":7}}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := convertTestStream(&config.Config{}, upstreamStream(
+				`{"choices":[{"index":0,"delta":{"content":"Hi"},"finish_reason":null}]}`,
+				`{"choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+				tt.usageChunk,
+			))
+
+			deltas := eventsOfType(events, "message_delta")
+			if len(deltas) != 1 {
+				t.Fatalf("Expected 1 message_delta, got %d", len(deltas))
+			}
+			usage := deltas[0].Data["usage"].(map[string]interface{})
+			if usage["input_tokens"] != float64(11) {
+				t.Errorf("input_tokens = %v, want 11", usage["input_tokens"])
+			}
+			if usage["output_tokens"] != float64(7) {
+				t.Errorf("output_tokens = %v, want 7", usage["output_tokens"])
+			}
+		})
+	}
+}
+
+// TestValidateEndpoint tests the dry-run validation endpoint
+func TestValidateEndpoint(t *testing.T) {
+	// Any upstream call is a failure - validation must not contact the provider
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Validate endpoint must not call the upstream provider")
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{OpenAIBaseURL: upstream.URL}
+	app := newTestApp(cfg)
+
+	decode := func(resp *http.Response) map[string]interface{} {
+		var report map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+			t.Fatalf("Failed to decode report: %v", err)
+		}
+		return report
+	}
+
+	t.Run("valid request", func(t *testing.T) {
+		report := decode(postJSON(t, app, "/v1/messages/validate", testClaudeRequestBody))
+
+		if report["valid"] != true {
+			t.Errorf("valid = %v, want true (issues: %v)", report["valid"], report["issues"])
+		}
+		converted, ok := report["converted"].(map[string]interface{})
+		if !ok || converted["model"] != "gpt-5" {
+			t.Errorf("Expected converted summary with mapped model, got %v", report["converted"])
+		}
+	})
+
+	t.Run("invalid request", func(t *testing.T) {
+		body := `{"model":"claude-sonnet-4","max_tokens":0,"messages":[{"role":"robot","content":"hi"}],
+			"tools":[{"name":"bad name","input_schema":{"type":"object"}}]}`
+		report := decode(postJSON(t, app, "/v1/messages/validate", body))
+
+		if report["valid"] != false {
+			t.Fatal("valid = true, want false")
+		}
+
+		fields := map[string]bool{}
+		for _, raw := range report["issues"].([]interface{}) {
+			fields[raw.(map[string]interface{})["field"].(string)] = true
+		}
+		for _, want := range []string{"max_tokens", "messages[0].role", "tools[0].name"} {
+			if !fields[want] {
+				t.Errorf("Expected issue for %q, got %v", want, report["issues"])
+			}
+		}
+	})
+
+	t.Run("malformed body", func(t *testing.T) {
+		report := decode(postJSON(t, app, "/v1/messages/validate", `{"model":`))
+		if report["valid"] != false {
+			t.Error("Malformed body should be reported as invalid")
+		}
+	})
+}
+
+// TestStrictRequestValidation tests that STRICT_REQUEST_VALIDATION rejects malformed bodies before conversion
+func TestStrictRequestValidation(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":1}}`))
+	}))
+	defer upstream.Close()
+
+	body := `{"model":"claude-sonnet-4","max_tokens":100,"messages":[{"role":"user","content":[{"type":"text","txt":"hi"}]}]}`
+
+	t.Run("enabled rejects with the field", func(t *testing.T) {
+		resp := postJSON(t, newTestApp(&config.Config{OpenAIBaseURL: upstream.URL, StrictRequestValidation: true}), "/v1/messages", body)
+		var errResp map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&errResp)
+		message, _ := errResp["error"].(map[string]interface{})["message"].(string)
+		if resp.StatusCode != 400 || !strings.Contains(message, "messages[0].content[0].text: is required") {
+			t.Errorf("status %d, message %q; want 400 naming messages[0].content[0].text", resp.StatusCode, message)
+		}
+	})
+
+	t.Run("enabled accepts a valid body", func(t *testing.T) {
+		resp := postJSON(t, newTestApp(&config.Config{OpenAIBaseURL: upstream.URL, StrictRequestValidation: true}), "/v1/messages", testClaudeRequestBody)
+		if resp.StatusCode != 200 {
+			t.Errorf("status = %d, want 200", resp.StatusCode)
+		}
+	})
+
+	t.Run("disabled forwards the body", func(t *testing.T) {
+		resp := postJSON(t, newTestApp(&config.Config{OpenAIBaseURL: upstream.URL}), "/v1/messages", body)
+		if resp.StatusCode != 200 {
+			t.Errorf("status = %d, want 200", resp.StatusCode)
+		}
+	})
+}
+
+// TestStreamingOutputTokenEstimate tests the running output token count used when
+// the provider reports no usage
+func TestStreamingOutputTokenEstimate(t *testing.T) {
+	parts := []string{"Internation", "alization of the config", "uration isn't hard"}
+	var chunks []string
+	for _, part := range parts {
+		chunks = append(chunks, `{"choices":[{"index":0,"delta":{"content":"`+part+`"},"finish_reason":null}]}`)
+	}
+	chunks = append(chunks, `{"choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`)
+
+	cfg := &config.Config{OpenAIBaseURL: "https://api.openai.com/v1"}
+	deltas := eventsOfType(convertTestStream(cfg, upstreamStream(chunks...)), "message_delta")
+	if len(deltas) != 1 {
+		t.Fatalf("Expected 1 message_delta, got %d", len(deltas))
+	}
+	want := converter.SelectTokenizer("test-model", cfg).CountTokens(strings.Join(parts, ""))
+	if tokens := deltas[0].Data["usage"].(map[string]interface{})["output_tokens"]; tokens != float64(want) {
+		t.Errorf("output_tokens = %v, want %d from tokenizing the whole output", tokens, want)
+	}
+}
+
+// TestStreamingMaxResponseBlocks tests that tool calls beyond MAX_RESPONSE_BLOCKS are
+// dropped from the stream with a notice
+func TestStreamingMaxResponseBlocks(t *testing.T) {
+	var chunks []string
+	for i := 0; i < 5; i++ {
+		chunks = append(chunks, fmt.Sprintf(`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":%d,"id":"call_%d","type":"function","function":{"name":"read","arguments":"{}"}}]},"finish_reason":null}]}`, i, i))
+	}
+	chunks = append(chunks, `{"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`)
+	events := convertTestStream(&config.Config{MaxResponseBlocks: 3}, upstreamStream(chunks...))
+
+	var types []string
+	for _, start := range eventsOfType(events, "content_block_start") {
+		types = append(types, start.Data["content_block"].(map[string]interface{})["type"].(string))
+	}
+	if got := strings.Join(types, ","); got != "tool_use,tool_use,text" {
+		t.Errorf("content blocks = %s, want tool_use,tool_use,text", got)
+	}
+	if stops := eventsOfType(events, "content_block_stop"); len(stops) != 3 {
+		t.Errorf("Expected 3 content_block_stop, got %d", len(stops))
+	}
+
+	var text string
+	for _, delta := range eventsOfType(events, "content_block_delta") {
+		if d := delta.Data["delta"].(map[string]interface{}); d["type"] == "text_delta" {
+			text += d["text"].(string)
+		}
+	}
+	if text != converter.OmittedBlocksNotice(3, 3) {
+		t.Errorf("text = %q, want the omitted blocks notice", text)
+	}
+	final := eventsOfType(events, "message_delta")
+	if reason := final[len(final)-1].Data["delta"].(map[string]interface{})["stop_reason"]; reason != "tool_use" {
+		t.Errorf("stop_reason = %v, want tool_use", reason)
+	}
+}
+
+// TestStreamingMaxTokensDuringToolCall tests that a turn cut off mid-tool-call keeps the partial tool_use
+func TestStreamingMaxTokensDuringToolCall(t *testing.T) {
+	events := convertTestStream(&config.Config{}, upstreamStream(
+		`{"choices":[{"index":0,"delta":{"role":"assistant"},"finish_reason":null}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"write_file","arguments":""}}]},"finish_reason":null}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"path\":\"main.go\",\"content\":\"package ma"}}]},"finish_reason":null}]}`,
+		`{"choices":[{"index":0,"delta":{},"finish_reason":"length"}]}`,
+	))
+
+	starts := eventsOfType(events, "content_block_start")
+	if len(starts) != 1 {
+		t.Fatalf("Expected 1 content_block_start, got %d", len(starts))
+	}
+	block := starts[0].Data["content_block"].(map[string]interface{})
+	if block["type"] != "tool_use" || block["id"] != "call_1" {
+		t.Errorf("Expected tool_use block for call_1, got %v", block)
+	}
+
+	deltas := eventsOfType(events, "content_block_delta")
+	if len(deltas) != 1 {
+		t.Fatalf("Expected the partial arguments in 1 content_block_delta, got %d", len(deltas))
+	}
+	delta := deltas[0].Data["delta"].(map[string]interface{})
+	if delta["type"] != "input_json_delta" || delta["partial_json"] != `{"path":"main.go","content":"package ma` {
+		t.Errorf("Unexpected partial tool delta: %v", delta)
+	}
+
+	if len(eventsOfType(events, "content_block_stop")) != 1 {
+		t.Error("Expected the partial tool_use block to be closed")
+	}
+
+	messageDeltas := eventsOfType(events, "message_delta")
+	if len(messageDeltas) != 1 {
+		t.Fatalf("Expected 1 message_delta, got %d", len(messageDeltas))
+	}
+	if reason := messageDeltas[0].Data["delta"].(map[string]interface{})["stop_reason"]; reason != "max_tokens" {
+		t.Errorf("stop_reason = %v, want max_tokens", reason)
+	}
+}
+
+// TestCountTokensCacheEstimate tests the count_tokens cache split reporting
+func TestCountTokensCacheEstimate(t *testing.T) {
+	app := newTestApp(&config.Config{})
+
+	decode := func(resp *http.Response) map[string]interface{} {
+		var result map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return result
+	}
+
+	t.Run("plain request reports only the total", func(t *testing.T) {
+		result := decode(postJSON(t, app, "/v1/messages/count_tokens", testClaudeRequestBody))
+		if _, ok := result["input_tokens"].(float64); !ok {
+			t.Errorf("Expected input_tokens, got %v", result)
+		}
+		if _, ok := result["cache_write_estimate"]; ok {
+			t.Error("Cache estimates should be omitted without cache_control markers")
+		}
+	})
+
+	t.Run("cache-marked blocks produce a split", func(t *testing.T) {
+		body := `{"model":"claude-sonnet-4","max_tokens":100,
+			"system":[{"type":"text","text":"Count tokens test system prompt with a cache breakpoint","cache_control":{"type":"ephemeral"}}],
+			"messages":[{"role":"user","content":"hello"}]}`
+		result := decode(postJSON(t, app, "/v1/messages/count_tokens", body))
+
+		total, _ := result["input_tokens"].(float64)
+		write, _ := result["cache_write_estimate"].(float64)
+		read, ok := result["cache_read_estimate"].(float64)
+		if !ok || read != 0 {
+			t.Errorf("cache_read_estimate = %v, want 0 for an unseen prefix", result["cache_read_estimate"])
+		}
+		if write == 0 || write >= total {
+			t.Errorf("Expected cache write estimate below the total, got write=%v total=%v", write, total)
+		}
+	})
+}
+
+// TestResolveStreamMode tests reconciling the stream field with the Accept header
+func TestResolveStreamMode(t *testing.T) {
+	yes, no := true, false
+
+	tests := []struct {
+		name       string
+		accept     string
+		stream     *bool
+		precedence string
+		expected   bool
+	}{
+		{"no accept header keeps stream field", "", &yes, "accept", true},
+		{"json accept with stream true still streams", "application/json", &yes, "accept", true},
+		{"json accept with stream false", "application/json", &no, "accept", false},
+		{"sse accept with stream true", "text/event-stream", &yes, "accept", true},
+		{"sse accept overrides stream false", "text/event-stream", &no, "accept", true},
+		{"sse accept overrides missing stream", "text/event-stream", nil, "accept", true},
+		{"sse accept case-insensitive", "Text/Event-Stream", &no, "accept", true},
+		{"body precedence keeps stream false", "text/event-stream", &no, "body", false},
+		{"body precedence keeps missing stream", "text/event-stream", nil, "body", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := resolveStreamMode(tt.accept, tt.stream, &config.Config{StreamPrecedence: tt.precedence})
+			if got := result != nil && *result; got != tt.expected {
+				t.Errorf("resolveStreamMode() streaming = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestAcceptHeaderStartsStream tests that an SSE Accept header streams a stream:false request
+func TestAcceptHeaderStartsStream(t *testing.T) {
+	var upstreamStreamed bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req models.OpenAIRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		upstreamStreamed = req.Stream != nil && *req.Stream
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte(upstreamStream(`{"choices":[{"index":0,"delta":{"content":"hi"},"finish_reason":"stop"}]}`)))
+	}))
+	defer upstream.Close()
+
+	app := newTestApp(&config.Config{OpenAIBaseURL: upstream.URL, StreamPrecedence: "accept"})
+
+	body := `{"model":"claude-sonnet-4","max_tokens":100,"stream":false,"messages":[{"role":"user","content":"hello"}]}`
+	req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("app.Test() error = %v", err)
+	}
+
+	if !upstreamStreamed {
+		t.Error("Expected the upstream request to be streamed")
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Errorf("Content-Type = %q, want text/event-stream", ct)
+	}
+}
+
+// TestStreamingFallbackTool tests that a streamed fallback reply tool call is forwarded as text
+func TestStreamingFallbackTool(t *testing.T) {
+	events := convertTestStream(&config.Config{}, upstreamStream(
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"`+converter.FallbackToolName+`","arguments":""}}]},"finish_reason":null}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"text\":\"All "}}]},"finish_reason":null}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"done.\"}"}}]},"finish_reason":null}]}`,
+		`{"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+	))
+
+	starts := eventsOfType(events, "content_block_start")
+	if len(starts) != 1 || starts[0].Data["content_block"].(map[string]interface{})["type"] != "text" {
+		t.Fatalf("Expected a single text block, got %v", starts)
+	}
+	deltas := eventsOfType(events, "content_block_delta")
+	if len(deltas) != 1 || deltas[0].Data["delta"].(map[string]interface{})["text"] != "All done." {
+		t.Errorf("Expected the reply text as one text delta, got %v", deltas)
+	}
+	messageDelta := eventsOfType(events, "message_delta")[0]
+	if reason := messageDelta.Data["delta"].(map[string]interface{})["stop_reason"]; reason != "end_turn" {
+		t.Errorf("stop_reason = %v, want end_turn", reason)
+	}
+}
+
+// TestStreamingFallbackToolThinkingAsText tests that THINKING_AS_TEXT closes the
+// reasoning section before a fallback reply tool's text
+func TestStreamingFallbackToolThinkingAsText(t *testing.T) {
+	events := convertTestStream(&config.Config{ForceToolMode: "fallback", ThinkingAsText: true}, upstreamStream(
+		`{"choices":[{"index":0,"delta":{"reasoning_content":"Nothing to call."},"finish_reason":null}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"`+converter.FallbackToolName+`","arguments":""}}]},"finish_reason":null}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"text\":\"All done.\"}"}}]},"finish_reason":null}]}`,
+		`{"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+	))
+
+	var text string
+	for _, e := range eventsOfType(events, "content_block_delta") {
+		text += e.Data["delta"].(map[string]interface{})["text"].(string)
+	}
+	if want := "<thinking>\nNothing to call.\n</thinking>\n\nAll done."; text != want {
+		t.Errorf("text = %q, want %q", text, want)
+	}
+}
+
+// TestStreamingToolCallsWithoutTools tests that finish_reason tool_calls without any
+// tool call deltas is reported as end_turn
+func TestStreamingToolCallsWithoutTools(t *testing.T) {
+	events := convertTestStream(&config.Config{}, upstreamStream(
+		`{"choices":[{"index":0,"delta":{"content":"Let me check."},"finish_reason":null}]}`,
+		`{"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+	))
+
+	for _, start := range eventsOfType(events, "content_block_start") {
+		if blockType := start.Data["content_block"].(map[string]interface{})["type"]; blockType != "text" {
+			t.Errorf("Unexpected %v block", blockType)
+		}
+	}
+	messageDelta := eventsOfType(events, "message_delta")[0]
+	if reason := messageDelta.Data["delta"].(map[string]interface{})["stop_reason"]; reason != "end_turn" {
+		t.Errorf("stop_reason = %v, want end_turn", reason)
+	}
+}
+
+// TestStreamingMissingToolCallIDs tests that streamed tool calls without an ID get unique tool_use IDs
+func TestStreamingMissingToolCallIDs(t *testing.T) {
+	events := convertTestStream(&config.Config{}, upstreamStream(
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"type":"function","function":{"name":"read_file","arguments":""}}]},"finish_reason":null}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"","function":{"arguments":"{\"path\":\"a\"}"}}]},"finish_reason":null}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"type":"function","function":{"name":"list_dir","arguments":"{}"}}]},"finish_reason":null}]}`,
+		`{"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+	))
+
+	starts := eventsOfType(events, "content_block_start")
+	if len(starts) != 2 {
+		t.Fatalf("Expected 2 tool_use blocks, got %v", starts)
+	}
+	ids := map[string]bool{}
+	for _, start := range starts {
+		id, _ := start.Data["content_block"].(map[string]interface{})["id"].(string)
+		if !strings.HasPrefix(id, "toolu_") {
+			t.Errorf("tool_use id = %q, want a synthesized toolu_ ID", id)
+		}
+		ids[id] = true
+	}
+	if len(ids) != 2 {
+		t.Errorf("Expected unique IDs, got %v", ids)
+	}
+	if deltas := eventsOfType(events, "content_block_delta"); len(deltas) != 2 {
+		t.Errorf("Expected both tool calls' arguments, got %v", deltas)
+	}
+	messageDelta := eventsOfType(events, "message_delta")[0]
+	if reason := messageDelta.Data["delta"].(map[string]interface{})["stop_reason"]; reason != "tool_use" {
+		t.Errorf("stop_reason = %v, want tool_use", reason)
+	}
+}
+
+// TestStreamingEmptyTextBlockWithTools tests the optional empty text block in tool-only replies
+func TestStreamingEmptyTextBlockWithTools(t *testing.T) {
+	body := upstreamStream(
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"ls","arguments":"{}"}}]},"finish_reason":null}]}`,
+		`{"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+	)
+	blockTypes := func(events []sseTestEvent) []interface{} {
+		var types []interface{}
+		for _, start := range eventsOfType(events, "content_block_start") {
+			types = append(types, start.Data["content_block"].(map[string]interface{})["type"])
+		}
+		return types
+	}
+
+	t.Run("disabled sends only the tool block", func(t *testing.T) {
+		types := blockTypes(convertTestStream(&config.Config{}, body))
+		if len(types) != 1 || types[0] != "tool_use" {
+			t.Errorf("content blocks = %v, want [tool_use]", types)
+		}
+	})
+
+	t.Run("enabled adds an empty text block first", func(t *testing.T) {
+		events := convertTestStream(&config.Config{EmptyTextBlockWithTools: true}, body)
+		types := blockTypes(events)
+		if len(types) != 2 || types[0] != "text" || types[1] != "tool_use" {
+			t.Fatalf("content blocks = %v, want [text tool_use]", types)
+		}
+		for _, delta := range eventsOfType(events, "content_block_delta") {
+			if delta.Data["delta"].(map[string]interface{})["type"] == "text_delta" {
+				t.Errorf("Unexpected text delta %v", delta.Data)
+			}
+		}
+		if stops := eventsOfType(events, "content_block_stop"); len(stops) != 2 {
+			t.Errorf("Expected both blocks to be stopped, got %d stops", len(stops))
+		}
+	})
+}
+
+// TestStreamingThinkingAsText tests THINKING_AS_TEXT against the default thinking block
+func TestStreamingThinkingAsText(t *testing.T) {
+	body := upstreamStream(
+		`{"choices":[{"index":0,"delta":{"reasoning_content":"2+2"},"finish_reason":null}]}`,
+		`{"choices":[{"index":0,"delta":{"reasoning_content":"=4"},"finish_reason":null}]}`,
+		`{"choices":[{"index":0,"delta":{"content":"The answer is 4."},"finish_reason":"stop"}]}`,
+	)
+
+	// blockText concatenates the deltas per content block type
+	blockText := func(events []sseTestEvent) map[string]string {
+		text := make(map[string]string)
+		for _, e := range eventsOfType(events, "content_block_delta") {
+			delta := e.Data["delta"].(map[string]interface{})
+			switch delta["type"] {
+			case "thinking_delta":
+				text["thinking"] += delta["thinking"].(string)
+			case "text_delta":
+				text["text"] += delta["text"].(string)
+			}
+		}
+		return text
+	}
+
+	t.Run("default streams a thinking block", func(t *testing.T) {
+		got := blockText(convertTestStream(&config.Config{}, body))
+		if got["thinking"] != "2+2=4" || got["text"] != "The answer is 4." {
+			t.Errorf("blocks = %q, want thinking 2+2=4 and the answer", got)
+		}
+	})
+
+	t.Run("enabled streams the reasoning as text", func(t *testing.T) {
+		events := convertTestStream(&config.Config{ThinkingAsText: true}, body)
+		for _, e := range eventsOfType(events, "content_block_start") {
+			if blockType := e.Data["content_block"].(map[string]interface{})["type"]; blockType != "text" {
+				t.Errorf("Unexpected %v block", blockType)
+			}
+		}
+		want := "<thinking>\n2+2=4\n</thinking>\n\nThe answer is 4."
+		if got := blockText(events); got["thinking"] != "" || got["text"] != want {
+			t.Errorf("blocks = %q, want text %q", got, want)
+		}
+	})
+}
+
+// TestStreamingIncrementalUsage tests interim usage message_delta events
+func TestStreamingIncrementalUsage(t *testing.T) {
+	chunk := `{"choices":[{"index":0,"delta":{"content":"` + strings.Repeat("word ", 20) + `"},"finish_reason":null}]}`
+	body := upstreamStream(
+		chunk, chunk, chunk, chunk,
+		`{"choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+		`{"choices":[],"usage":{"prompt_tokens":12,"completion_tokens":90}}`,
+	)
+
+	t.Run("disabled sends one message_delta", func(t *testing.T) {
+		if deltas := eventsOfType(convertTestStream(&config.Config{}, body), "message_delta"); len(deltas) != 1 {
+			t.Errorf("Expected 1 message_delta, got %d", len(deltas))
+		}
+	})
+
+	t.Run("enabled sends interim usage", func(t *testing.T) {
+		deltas := eventsOfType(convertTestStream(&config.Config{StreamIncrementalUsage: true}, body), "message_delta")
+		if len(deltas) < 3 {
+			t.Fatalf("Expected multiple usage-bearing message_delta events, got %d", len(deltas))
+		}
+
+		previous := 0.0
+		for _, d := range deltas[:len(deltas)-1] {
+			if reason := d.Data["delta"].(map[string]interface{})["stop_reason"]; reason != nil {
+				t.Errorf("Interim message_delta stop_reason = %v, want null", reason)
+			}
+			tokens := d.Data["usage"].(map[string]interface{})["output_tokens"].(float64)
+			if tokens <= previous {
+				t.Errorf("Interim output_tokens should increase, got %v after %v", tokens, previous)
+			}
+			previous = tokens
+		}
+
+		final := deltas[len(deltas)-1]
+		if reason := final.Data["delta"].(map[string]interface{})["stop_reason"]; reason != "end_turn" {
+			t.Errorf("Final stop_reason = %v, want end_turn", reason)
+		}
+		if tokens := final.Data["usage"].(map[string]interface{})["output_tokens"]; tokens != 90.0 {
+			t.Errorf("Final output_tokens = %v, want the provider-reported 90", tokens)
+		}
+	})
+}
+
+// TestStreamingToolArgumentsByteExact tests that streamed tool arguments are forwarded unmodified
+func TestStreamingToolArgumentsByteExact(t *testing.T) {
+	// Large integer, high-precision decimal and unusual spacing must all survive
+	args := `{"order_id": 1234567890123456789,  "amount":0.1000000000000000055511151231257827, "ids":[9007199254740993]}`
+	first, second := args[:30], args[30:]
+
+	quote := func(s string) string {
+		b, _ := json.Marshal(s)
+		return string(b)
+	}
+	events := convertTestStream(&config.Config{}, upstreamStream(
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_order","arguments":""}}]},"finish_reason":null}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":`+quote(first)+`}}]},"finish_reason":null}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":`+quote(second)+`}}]},"finish_reason":null}]}`,
+		`{"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+	))
+
+	deltas := eventsOfType(events, "content_block_delta")
+	if len(deltas) != 1 {
+		t.Fatalf("Expected 1 input_json_delta, got %d", len(deltas))
+	}
+	if got := deltas[0].Data["delta"].(map[string]interface{})["partial_json"]; got != args {
+		t.Errorf("partial_json = %v, want byte-exact %s", got, args)
+	}
+}
+
+// TestStreamingOllamaThinking tests that Ollama's streamed reasoning produces a thinking block
+func TestStreamingOllamaThinking(t *testing.T) {
+	cfg := &config.Config{}
+
+	tests := []struct {
+		name   string
+		chunks []string
+	}{
+		{"native thinking field", []string{
+			`{"choices":[{"index":0,"delta":{"thinking":"2+2"},"finish_reason":null}]}`,
+			`{"choices":[{"index":0,"delta":{"thinking":"=4"},"finish_reason":null}]}`,
+			`{"choices":[{"index":0,"delta":{"content":"4"},"finish_reason":"stop"}]}`,
+		}},
+		{"compat reasoning field", []string{
+			`{"choices":[{"index":0,"delta":{"reasoning":"2+2=4"},"finish_reason":null}]}`,
+			`{"choices":[{"index":0,"delta":{"content":"4"},"finish_reason":"stop"}]}`,
+		}},
+		{"inline think tags split across chunks", []string{
+			`{"choices":[{"index":0,"delta":{"content":"<thi"},"finish_reason":null}]}`,
+			`{"choices":[{"index":0,"delta":{"content":"nk>2+2"},"finish_reason":null}]}`,
+			`{"choices":[{"index":0,"delta":{"content":"=4</think>"},"finish_reason":null}]}`,
+			`{"choices":[{"index":0,"delta":{"content":"4"},"finish_reason":"stop"}]}`,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := convertTestStream(cfg, upstreamStream(tt.chunks...))
+
+			starts := eventsOfType(events, "content_block_start")
+			if len(starts) != 2 {
+				t.Fatalf("Expected thinking and text block starts, got %d", len(starts))
+			}
+			if block := starts[0].Data["content_block"].(map[string]interface{}); block["type"] != "thinking" {
+				t.Errorf("First block type = %v, want thinking", block["type"])
+			}
+
+			var thinking, text string
+			for _, e := range eventsOfType(events, "content_block_delta") {
+				delta := e.Data["delta"].(map[string]interface{})
+				switch delta["type"] {
+				case "thinking_delta":
+					thinking += delta["thinking"].(string)
+				case "text_delta":
+					text += delta["text"].(string)
+				}
+			}
+			if thinking != "2+2=4" {
+				t.Errorf("thinking = %q, want 2+2=4", thinking)
+			}
+			if text != "4" {
+				t.Errorf("text = %q, want 4", text)
+			}
+			if stops := eventsOfType(events, "content_block_stop"); len(stops) != 2 {
+				t.Errorf("Expected 2 content_block_stop events, got %d", len(stops))
+			}
+		})
+	}
+}
+
+// TestStreamingCustomFinishReason tests that FINISH_REASON_MAP applies to streamed finish reasons
+func TestStreamingCustomFinishReason(t *testing.T) {
+	cfg := &config.Config{FinishReasonMap: map[string]string{"max_length": "max_tokens", "safety": "refusal"}}
+
+	tests := []struct {
+		finishReason string
+		want         string
+	}{
+		{"max_length", "max_tokens"},
+		{"safety", "refusal"},
+		{"length", "max_tokens"},
+		{"eos", "end_turn"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.finishReason, func(t *testing.T) {
+			events := convertTestStream(cfg, upstreamStream(
+				`{"choices":[{"index":0,"delta":{"content":"Hi"},"finish_reason":null}]}`,
+				`{"choices":[{"index":0,"delta":{},"finish_reason":"`+tt.finishReason+`"}]}`,
+			))
+
+			messageDeltas := eventsOfType(events, "message_delta")
+			if len(messageDeltas) != 1 {
+				t.Fatalf("Expected 1 message_delta, got %d", len(messageDeltas))
+			}
+			if reason := messageDeltas[0].Data["delta"].(map[string]interface{})["stop_reason"]; reason != tt.want {
+				t.Errorf("stop_reason = %v, want %s", reason, tt.want)
+			}
+		})
+	}
+}
+
+// TestStreamingSkipsUpstreamPings tests that upstream keepalive lines are not treated as content
+func TestStreamingSkipsUpstreamPings(t *testing.T) {
+	cfg := &config.Config{StreamIncrementalUsage: true}
+
+	body := "event: ping\ndata: {\"type\":\"ping\",\"usage\":{\"completion_tokens\":999}}\n\n" +
+		"data: ping\n\n" +
+		`data: {"choices":[{"index":0,"delta":{"content":"Hel"},"finish_reason":null}]}` + "\n\n" +
+		`data: {"type":"ping"}` + "\n\n" +
+		`data: {"object":"keepalive","usage":{"prompt_tokens":1,"completion_tokens":500}}` + "\n\n" +
+		`data: {"choices":[{"index":0,"delta":{"content":"lo"},"finish_reason":null}]}` + "\n\n" +
+		`data: {"choices":[{"index":0,"delta":{},"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":2}}` + "\n\n" +
+		"data: [DONE]\n\n"
+
+	events := convertTestStream(cfg, body)
+
+	if errs := eventsOfType(events, "error"); len(errs) != 0 {
+		t.Fatalf("Pings should not produce errors, got %v", errs)
+	}
+	var text string
+	for _, e := range eventsOfType(events, "content_block_delta") {
+		text += e.Data["delta"].(map[string]interface{})["text"].(string)
+	}
+	if text != "Hello" {
+		t.Errorf("text = %q, want Hello", text)
+	}
+
+	messageDeltas := eventsOfType(events, "message_delta")
+	final := messageDeltas[len(messageDeltas)-1]
+	if tokens := final.Data["usage"].(map[string]interface{})["output_tokens"]; tokens != float64(2) {
+		t.Errorf("output_tokens = %v, want 2 (ping usage must be ignored)", tokens)
+	}
+	for _, e := range messageDeltas {
+		if tokens, _ := e.Data["usage"].(map[string]interface{})["output_tokens"].(float64); tokens >= 500 {
+			t.Errorf("Interim usage picked up a ping's usage: %v", tokens)
+		}
+	}
+}
+
+// TestStreamingOnlyPingsIsError tests that a stream of only keepalives is still reported as an error
+func TestStreamingOnlyPingsIsError(t *testing.T) {
+	events := convertTestStream(&config.Config{}, "data: {\"type\":\"ping\"}\n\ndata: ping\n\ndata: [DONE]\n\n")
+
+	if errs := eventsOfType(events, "error"); len(errs) != 1 {
+		t.Errorf("Expected 1 error event for a stream without chunks, got %d", len(errs))
+	}
+}
+
+// TestTokenParameterHeader tests that x-proxy-use-max-completion-tokens overrides the
+// token parameter for one request without changing the reasoning-model decision
+func TestTokenParameterHeader(t *testing.T) {
+	var lastBody map[string]interface{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastBody = nil
+		_ = json.NewDecoder(r.Body).Decode(&lastBody)
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}`))
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{OpenAIBaseURL: upstream.URL, SonnetModel: "gpt-5"} // gpt-5 uses max_completion_tokens
+	app := newTestApp(cfg)
+
+	send := func(override string) *http.Response {
+		req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader(testClaudeRequestBody))
+		req.Header.Set("Content-Type", "application/json")
+		if override != "" {
+			req.Header.Set("x-proxy-use-max-completion-tokens", override)
+		}
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("app.Test() error = %v", err)
+		}
+		return resp
+	}
+	tokenParams := func() (interface{}, interface{}) {
+		return lastBody["max_tokens"], lastBody["max_completion_tokens"]
+	}
+
+	if resp := send("false"); resp.StatusCode != 200 {
+		t.Fatalf("Status = %d, want 200", resp.StatusCode)
+	}
+	if maxTokens, maxCompletion := tokenParams(); maxTokens != float64(100) || maxCompletion != nil {
+		t.Errorf("With header false: max_tokens = %v, max_completion_tokens = %v, want 100 and absent", maxTokens, maxCompletion)
+	}
+
+	// The next request without the header uses the computed choice again
+	send("")
+	if maxTokens, maxCompletion := tokenParams(); maxTokens != nil || maxCompletion != float64(100) {
+		t.Errorf("Without header: max_tokens = %v, max_completion_tokens = %v, want absent and 100", maxTokens, maxCompletion)
+	}
+	if !cfg.IsReasoningModel("gpt-5") {
+		t.Error("The override must not change the reasoning-model decision")
+	}
+
+	// Forcing max_completion_tokens on a non-reasoning model
+	cfg.SonnetModel = "gpt-4o"
+	send("true")
+	if maxTokens, maxCompletion := tokenParams(); maxTokens != nil || maxCompletion != float64(100) {
+		t.Errorf("With header true: max_tokens = %v, max_completion_tokens = %v, want absent and 100", maxTokens, maxCompletion)
+	}
+
+	if resp := send("maybe"); resp.StatusCode != 400 {
+		t.Errorf("Invalid header value: status = %d, want 400", resp.StatusCode)
+	}
+}
+
+// TestStreamingFinishReasonChunks tests chunks that combine the final content with
+// finish_reason, and finish_reason chunks without a delta
+func TestStreamingFinishReasonChunks(t *testing.T) {
+	cfg := &config.Config{}
+
+	streamText := func(events []sseTestEvent) string {
+		var text string
+		for _, e := range eventsOfType(events, "content_block_delta") {
+			if delta := e.Data["delta"].(map[string]interface{}); delta["type"] == "text_delta" {
+				text += delta["text"].(string)
+			}
+		}
+		return text
+	}
+	final := func(t *testing.T, events []sseTestEvent) map[string]interface{} {
+		t.Helper()
+		messageDeltas := eventsOfType(events, "message_delta")
+		if len(messageDeltas) != 1 {
+			t.Fatalf("Expected 1 message_delta, got %d", len(messageDeltas))
+		}
+		return messageDeltas[0].Data
+	}
+
+	t.Run("content and finish_reason in one chunk", func(t *testing.T) {
+		events := convertTestStream(cfg, upstreamStream(
+			`{"choices":[{"index":0,"delta":{"content":"Hello"},"finish_reason":null}]}`,
+			`{"choices":[{"index":0,"delta":{"content":" world"},"finish_reason":"length"}]}`,
+			`{"choices":[],"usage":{"prompt_tokens":5,"completion_tokens":2}}`,
+		))
+
+		if text := streamText(events); text != "Hello world" {
+			t.Errorf("text = %q, want %q", text, "Hello world")
+		}
+		data := final(t, events)
+		if reason := data["delta"].(map[string]interface{})["stop_reason"]; reason != "max_tokens" {
+			t.Errorf("stop_reason = %v, want max_tokens", reason)
+		}
+		if tokens := data["usage"].(map[string]interface{})["output_tokens"]; tokens != float64(2) {
+			t.Errorf("output_tokens = %v, want 2 from the trailing usage chunk", tokens)
+		}
+	})
+
+	t.Run("finish_reason and usage without delta", func(t *testing.T) {
+		events := convertTestStream(cfg, upstreamStream(
+			`{"choices":[{"index":0,"delta":{"content":"Hi"},"finish_reason":null}]}`,
+			`{"choices":[{"index":0,"finish_reason":"length"}],"usage":{"prompt_tokens":5,"completion_tokens":1}}`,
+		))
+
+		if text := streamText(events); text != "Hi" {
+			t.Errorf("text = %q, want Hi", text)
+		}
+		data := final(t, events)
+		if reason := data["delta"].(map[string]interface{})["stop_reason"]; reason != "max_tokens" {
+			t.Errorf("stop_reason = %v, want max_tokens", reason)
+		}
+		if tokens := data["usage"].(map[string]interface{})["output_tokens"]; tokens != float64(1) {
+			t.Errorf("output_tokens = %v, want 1", tokens)
+		}
+	})
+
+	t.Run("tool call and finish_reason in one chunk", func(t *testing.T) {
+		events := convertTestStream(cfg, upstreamStream(
+			`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"bash","arguments":"{\"command\":\"ls\"}"}}]},"finish_reason":"tool_calls"}]}`,
+		))
+
+		var args string
+		for _, e := range eventsOfType(events, "content_block_delta") {
+			if delta := e.Data["delta"].(map[string]interface{}); delta["type"] == "input_json_delta" {
+				args += delta["partial_json"].(string)
+			}
+		}
+		if args != `{"command":"ls"}` {
+			t.Errorf("tool arguments = %q, want the complete arguments", args)
+		}
+		if reason := final(t, events)["delta"].(map[string]interface{})["stop_reason"]; reason != "tool_use" {
+			t.Errorf("stop_reason = %v, want tool_use", reason)
+		}
+	})
+}
+
+// TestStreamingEagerTextBlock tests EAGER_TEXT_BLOCK against the default lazy text block start
+func TestStreamingEagerTextBlock(t *testing.T) {
+	textChunks := upstreamStream(
+		`{"choices":[{"index":0,"delta":{"content":"Hello"},"finish_reason":"stop"}]}`,
+	)
+	reasoningChunks := upstreamStream(
+		`{"choices":[{"index":0,"delta":{"reasoning_content":"thinking..."},"finish_reason":null}]}`,
+		`{"choices":[{"index":0,"delta":{"content":"Hello"},"finish_reason":"stop"}]}`,
+	)
+	ollamaReasoningChunks := upstreamStream(
+		`{"choices":[{"index":0,"delta":{"role":"assistant","content":""},"finish_reason":null}]}`,
+		`{"choices":[{"index":0,"delta":{"content":"","reasoning":"thinking..."},"finish_reason":null}]}`,
+		`{"choices":[{"index":0,"delta":{"content":"Hello"},"finish_reason":"stop"}]}`,
+	)
+	ollamaThinkTagChunks := upstreamStream(
+		`{"choices":[{"index":0,"delta":{"content":"<thi"},"finish_reason":null}]}`,
+		`{"choices":[{"index":0,"delta":{"content":"nk>thinking...</think>Hello"},"finish_reason":"stop"}]}`,
+	)
+	const openAI, ollama = "https://api.openai.com/v1", "http://localhost:11434/v1"
+
+	convert := func(cfg *config.Config, model, body string) []sseTestEvent {
+		var buf bytes.Buffer
+		bw := bufio.NewWriter(&buf)
+		streamOpenAIToClaude(newSSEWriter(bw), strings.NewReader(body), model, "", cfg, time.Now(), "", 0)
+		_ = bw.Flush()
+		return parseSSEEvents(buf.String())
+	}
+
+	// eventTypes lists event names, with block types for content_block_start
+	eventTypes := func(events []sseTestEvent) []string {
+		var types []string
+		for _, e := range events {
+			name := e.Event
+			if e.Event == "content_block_start" {
+				name += ":" + e.Data["content_block"].(map[string]interface{})["type"].(string)
+			}
+			types = append(types, name)
+		}
+		return types
+	}
+
+	thinkingFirst := []string{"message_start", "ping", "content_block_start:thinking", "content_block_delta", "content_block_start:text", "content_block_delta"}
+	tests := []struct {
+		name    string
+		eager   bool
+		baseURL string
+		model   string
+		body    string
+		want    []string
+	}{
+		{"lazy", false, openAI, "test-model", textChunks,
+			[]string{"message_start", "ping", "content_block_start:text", "content_block_delta"}},
+		{"eager", true, openAI, "test-model", textChunks,
+			[]string{"message_start", "ping", "content_block_start:text", "content_block_delta"}},
+		{"eager with reasoning model keeps thinking first", true, openAI, "gpt-5", reasoningChunks, thinkingFirst},
+		{"eager Ollama text", true, ollama, "llama3", textChunks,
+			[]string{"message_start", "ping", "content_block_start:text", "content_block_delta"}},
+		{"eager Ollama reasoning field keeps thinking first", true, ollama, "qwen3", ollamaReasoningChunks, thinkingFirst},
+		{"eager Ollama think tags keep thinking first", true, ollama, "qwen3", ollamaThinkTagChunks, thinkingFirst},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{EagerTextBlock: tt.eager, OpenAIBaseURL: tt.baseURL}
+			got := eventTypes(convert(cfg, tt.model, tt.body))
+			if len(got) < len(tt.want) || strings.Join(got[:len(tt.want)], ",") != strings.Join(tt.want, ",") {
+				t.Errorf("events = %v, want prefix %v", got, tt.want)
+			}
+		})
+	}
+
+	// The difference shows before any upstream chunk arrives: eager mode has
+	// already opened the text block when only message_start has been sent
+	for _, eager := range []bool{false, true} {
+		cfg := &config.Config{EagerTextBlock: eager, OpenAIBaseURL: openAI}
+		events := convert(cfg, "test-model", "")
+		starts := eventsOfType(events, "content_block_start")
+		if eager && (len(starts) != 1 || starts[0].Data["index"] != float64(1)) {
+			t.Errorf("eager: content_block_start before any delta = %v, want text block at index 1", starts)
+		}
+		if !eager && len(starts) != 0 {
+			t.Errorf("lazy: content_block_start before any delta = %v, want none", starts)
+		}
+	}
+}
+
+// TestStreamingArrayContentDelta tests that delta.content sent as an array of parts is streamed
+func TestStreamingArrayContentDelta(t *testing.T) {
+	cfg := &config.Config{}
+	events := convertTestStream(cfg, upstreamStream(
+		`{"choices":[{"index":0,"delta":{"role":"assistant","content":[{"type":"text","text":"Hello"}]},"finish_reason":null}]}`,
+		`{"choices":[{"index":0,"delta":{"content":[{"type":"output_text","text":", "},{"type":"text","text":"world"}]},"finish_reason":null}]}`,
+		`{"choices":[{"index":0,"delta":{"content":[{"type":"image_url","image_url":{"url":"data:image/png;base64,AAAA"}}]},"finish_reason":null}]}`,
+		`{"choices":[{"index":0,"delta":{"content":"!"},"finish_reason":"stop"}]}`,
+	))
+
+	var text strings.Builder
+	for _, e := range eventsOfType(events, "content_block_delta") {
+		delta := e.Data["delta"].(map[string]interface{})
+		if delta["type"] == "text_delta" {
+			text.WriteString(delta["text"].(string))
+		}
+	}
+
+	if want := "Hello, world[image_url output omitted]!"; text.String() != want {
+		t.Errorf("Streamed text = %q, want %q", text.String(), want)
+	}
+	if starts := eventsOfType(events, "content_block_start"); len(starts) != 1 {
+		t.Errorf("Expected a single text block, got %d block starts", len(starts))
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// idleTracker records when the last request arrived (IDLE_SHUTDOWN_MINUTES)
+type idleTracker struct {
+	lastRequest atomic.Int64 // unix nanoseconds
+}
+
+func newIdleTracker(now time.Time) *idleTracker {
+	t := &idleTracker{}
+	t.lastRequest.Store(now.UnixNano())
+	return t
+}
+
+// middleware marks every incoming request as activity
+func (t *idleTracker) middleware(c *fiber.Ctx) error {
+	t.lastRequest.Store(time.Now().UnixNano())
+	return c.Next()
+}
+
+// idleFor returns how long no request has arrived
+func (t *idleTracker) idleFor(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, t.lastRequest.Load()))
+}
+
+// watchIdle calls onIdle once no request has arrived for timeout, checking every
+// interval. Returns when onIdle ran or ctx is cancelled.
+func watchIdle(ctx context.Context, t *idleTracker, timeout, interval time.Duration, onIdle func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if t.idleFor(now) >= timeout {
+				onIdle()
+				return
+			}
+		}
+	}
+}
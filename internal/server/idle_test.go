@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestIdleShutdown tests that the idle watcher fires only after the timeout passes without traffic
+func TestIdleShutdown(t *testing.T) {
+	const timeout = 150 * time.Millisecond
+
+	idle := newIdleTracker(time.Now())
+	app := fiber.New()
+	app.Use(idle.middleware)
+	app.Get("/health", func(c *fiber.Ctx) error { return c.SendString("ok") })
+
+	fired := make(chan time.Time, 1)
+	go watchIdle(context.Background(), idle, timeout, 10*time.Millisecond, func() {
+		fired <- time.Now()
+	})
+
+	// Traffic for twice the timeout keeps the proxy alive
+	var lastRequest time.Time
+	for end := time.Now().Add(2 * timeout); time.Now().Before(end); time.Sleep(30 * time.Millisecond) {
+		if _, err := app.Test(httptest.NewRequest("GET", "/health", nil), -1); err != nil {
+			t.Fatalf("app.Test() error = %v", err)
+		}
+		lastRequest = time.Now()
+		select {
+		case <-fired:
+			t.Fatal("Idle shutdown fired while requests were arriving")
+		default:
+		}
+	}
+
+	select {
+	case at := <-fired:
+		if idleFor := at.Sub(lastRequest); idleFor < timeout {
+			t.Errorf("Shutdown after %v idle, want at least %v", idleFor, timeout)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Idle shutdown did not fire after the timeout")
+	}
+}
+
+// TestIdleWatcherStopsOnCancel tests that cancelling the context stops the watcher without firing
+func TestIdleWatcherStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	fired := false
+	go func() {
+		watchIdle(ctx, newIdleTracker(time.Now()), time.Hour, 5*time.Millisecond, func() { fired = true })
+		close(done)
+	}()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("watchIdle did not return after cancel")
+	}
+	if fired {
+		t.Error("onIdle should not run when cancelled")
+	}
+}
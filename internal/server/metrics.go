@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Metric names, shared by every exporter
+const (
+	metricRequests        = "proxy.requests"
+	metricTokens          = "proxy.tokens"
+	metricRequestDuration = "proxy.request.duration"
+)
+
+// durationBuckets are the request duration histogram bounds in seconds
+var durationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// requestMetric describes one finished /v1/messages request
+type requestMetric struct {
+	Model        string
+	Status       int
+	InputTokens  int
+	OutputTokens int
+	Duration     time.Duration
+}
+
+// requestMetrics are the instruments for metrics export, nil when no exporter is
+// configured so recording costs nothing by default
+var requestMetrics *requestInstruments
+
+// recordRequestMetric records a finished request if metrics export is enabled
+func recordRequestMetric(m requestMetric) {
+	if requestMetrics != nil {
+		requestMetrics.record(m)
+	}
+}
+
+// requestInstruments are the OpenTelemetry instruments requests are recorded
+// with. The meter provider aggregates them; cumulative since it was created.
+type requestInstruments struct {
+	requests  metric.Int64Counter
+	tokens    metric.Int64Counter
+	durations metric.Float64Histogram
+}
+
+func newRequestInstruments(provider metric.MeterProvider) (*requestInstruments, error) {
+	meter := provider.Meter(otlpServiceName, metric.WithInstrumentationVersion(ProxyVersion))
+
+	requests, err := meter.Int64Counter(metricRequests,
+		metric.WithDescription("Messages requests handled"), metric.WithUnit("{request}"))
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := meter.Int64Counter(metricTokens,
+		metric.WithDescription("Tokens reported by the provider"), metric.WithUnit("{token}"))
+	if err != nil {
+		return nil, err
+	}
+	durations, err := meter.Float64Histogram(metricRequestDuration,
+		metric.WithDescription("Messages request duration"), metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(durationBuckets...))
+	if err != nil {
+		return nil, err
+	}
+	return &requestInstruments{requests: requests, tokens: tokens, durations: durations}, nil
+}
+
+func (i *requestInstruments) record(m requestMetric) {
+	ctx := context.Background()
+	model := attribute.String("model", m.Model)
+
+	i.requests.Add(ctx, 1, metric.WithAttributes(model, attribute.String("status", statusClass(m.Status))))
+	if m.InputTokens > 0 {
+		i.tokens.Add(ctx, int64(m.InputTokens), metric.WithAttributes(model, attribute.String("type", "input")))
+	}
+	if m.OutputTokens > 0 {
+		i.tokens.Add(ctx, int64(m.OutputTokens), metric.WithAttributes(model, attribute.String("type", "output")))
+	}
+	i.durations.Record(ctx, m.Duration.Seconds(), metric.WithAttributes(model))
+}
+
+// statusClass keeps the status attribute low-cardinality ("2xx", "5xx", ...)
+func statusClass(status int) string {
+	return strconv.Itoa(status/100) + "xx"
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// collectMetrics installs requestMetrics on a meter provider read by a manual
+// reader, and returns a function collecting what has been recorded so far
+func collectMetrics(t *testing.T) func() map[string]metricdata.Metrics {
+	t.Helper()
+	reader := sdkmetric.NewManualReader()
+	instruments, err := newRequestInstruments(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	if err != nil {
+		t.Fatalf("newRequestInstruments() error = %v", err)
+	}
+	requestMetrics = instruments
+	t.Cleanup(func() { requestMetrics = nil })
+
+	return func() map[string]metricdata.Metrics {
+		var rm metricdata.ResourceMetrics
+		if err := reader.Collect(context.Background(), &rm); err != nil {
+			t.Fatalf("Collect() error = %v", err)
+		}
+		metrics := map[string]metricdata.Metrics{}
+		for _, scope := range rm.ScopeMetrics {
+			for _, m := range scope.Metrics {
+				metrics[m.Name] = m
+			}
+		}
+		return metrics
+	}
+}
+
+// attrKey joins the values of a data point's attributes for table lookups
+func attrKey(set attribute.Set, keys ...attribute.Key) string {
+	var values []string
+	for _, key := range keys {
+		value, _ := set.Value(key)
+		values = append(values, value.AsString())
+	}
+	return strings.Join(values, " ")
+}
+
+// TestMetricsRecorded tests that messages requests are recorded and exported
+func TestMetricsRecorded(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case strings.Contains(string(body), `"model":"broken"`):
+			w.WriteHeader(500)
+			fmt.Fprint(w, `{"error":{"message":"boom"}}`)
+		case strings.Contains(string(body), `"stream":true`):
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, upstreamStream(
+				`{"choices":[{"index":0,"delta":{"content":"hi"},"finish_reason":"stop"}]}`,
+				`{"choices":[],"usage":{"prompt_tokens":7,"completion_tokens":3}}`,
+			))
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"id":"x","model":"m","choices":[{"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":5}}`)
+		}
+	}))
+	defer upstream.Close()
+
+	collect := collectMetrics(t)
+
+	cfg := &config.Config{OpenAIBaseURL: upstream.URL, SonnetModel: "good", HaikuModel: "broken"}
+	app := newTestApp(cfg)
+
+	postJSON(t, app, "/v1/messages", testClaudeRequestBody)
+	postJSON(t, app, "/v1/messages", strings.Replace(testClaudeRequestBody, `"max_tokens":100`, `"max_tokens":100,"stream":true`, 1))
+	postJSON(t, app, "/v1/messages", strings.Replace(testClaudeRequestBody, "claude-sonnet-4", "claude-haiku-4", 1))
+
+	metrics := collect()
+
+	sum, ok := metrics[metricRequests].Data.(metricdata.Sum[int64])
+	if !ok || !sum.IsMonotonic || sum.Temporality != metricdata.CumulativeTemporality {
+		t.Fatalf("%s = %#v, want a cumulative monotonic counter", metricRequests, metrics[metricRequests].Data)
+	}
+	requests := map[string]int64{}
+	for _, p := range sum.DataPoints {
+		requests[attrKey(p.Attributes, "model", "status")] = p.Value
+	}
+	if requests["good 2xx"] != 2 || requests["broken 5xx"] != 1 || len(requests) != 2 {
+		t.Errorf("requests = %v, want 2 successful for good and 1 failed for broken", requests)
+	}
+
+	tokens := map[string]int64{}
+	for _, p := range metrics[metricTokens].Data.(metricdata.Sum[int64]).DataPoints {
+		tokens[attrKey(p.Attributes, "model", "type")] = p.Value
+	}
+	if tokens["good input"] != 17 || tokens["good output"] != 8 {
+		t.Errorf("tokens = %v, want good input 17 and output 8", tokens)
+	}
+
+	histogram := metrics[metricRequestDuration].Data.(metricdata.Histogram[float64])
+	if len(histogram.DataPoints) != 2 {
+		t.Errorf("histogram data points = %d, want one per model", len(histogram.DataPoints))
+	}
+	for _, p := range histogram.DataPoints {
+		model := attrKey(p.Attributes, "model")
+		if want := requests[model+" 2xx"] + requests[model+" 5xx"]; p.Count != uint64(want) {
+			t.Errorf("histogram %s count = %d, want %d", model, p.Count, want)
+		}
+		if len(p.Bounds) != len(durationBuckets) {
+			t.Errorf("histogram %s bounds = %v, want %v", model, p.Bounds, durationBuckets)
+		}
+	}
+}
+
+// TestMetricsDisabled tests that recording without an exporter is a no-op
+func TestMetricsDisabled(t *testing.T) {
+	requestMetrics = nil
+	recordRequestMetric(requestMetric{Model: "m", Status: 200})
+	recordStreamMetric("m", map[string]interface{}{"input_tokens": 1}, time.Now())
+}
+
+// TestMetricsExport tests that startMetricsExport sends the recorded metrics to
+// the collector's /v1/metrics when it is shut down
+func TestMetricsExport(t *testing.T) {
+	type export struct {
+		path, contentType string
+		body              []byte
+	}
+	exports := make(chan export, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		select {
+		case exports <- export{r.URL.Path, r.Header.Get("Content-Type"), body}:
+		default:
+		}
+	}))
+	defer collector.Close()
+	defer func() { requestMetrics = nil }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := startMetricsExport(ctx, collector.URL+"/", time.Hour); err != nil {
+		t.Fatalf("startMetricsExport() error = %v", err)
+	}
+	recordRequestMetric(requestMetric{Model: "m", Status: 200, InputTokens: 10, OutputTokens: 4, Duration: 300 * time.Millisecond})
+	cancel() // the final export on shutdown
+
+	select {
+	case got := <-exports:
+		if got.path != "/v1/metrics" || got.contentType != "application/x-protobuf" {
+			t.Errorf("export to %s (%s), want protobuf to /v1/metrics", got.path, got.contentType)
+		}
+		for _, want := range []string{metricRequests, metricTokens, metricRequestDuration, otlpServiceName} {
+			if !bytes.Contains(got.body, []byte(want)) {
+				t.Errorf("export is missing %q", want)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No export reached the collector on shutdown")
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/claude-code-proxy/proxy/internal/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// otlpServiceName is the service.name resource attribute of exported metrics and spans
+const otlpServiceName = "claude-code-proxy"
+
+// defaultOTLPEndpoint is the standard local OTLP/HTTP collector address, used for
+// tracing when OTEL_EXPORTER_OTLP_ENDPOINT is unset
+const defaultOTLPEndpoint = "http://localhost:4318"
+
+// startMetricsExport sets up requestMetrics with an SDK meter provider that
+// exports to <endpoint>/v1/metrics every interval. The provider is shut down,
+// flushing the last interval, when ctx is cancelled.
+func startMetricsExport(ctx context.Context, endpoint string, interval time.Duration) error {
+	exporter, err := otlpmetrichttp.New(ctx, otlpmetrichttp.WithEndpointURL(otlpSignalURL(endpoint, "/v1/metrics")))
+	if err != nil {
+		return err
+	}
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithResource(otelResource()),
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter, sdkmetric.WithInterval(interval))),
+	)
+	instruments, err := newRequestInstruments(provider)
+	if err != nil {
+		return err
+	}
+	requestMetrics = instruments
+
+	shutdownOnDone(ctx, "Metrics export", provider.Shutdown)
+	return nil
+}
+
+// shutdownOnDone runs an SDK provider's shutdown, which flushes what it still
+// holds, once ctx is cancelled
+func shutdownOnDone(ctx context.Context, what string, shutdown func(context.Context) error) {
+	go func() {
+		<-ctx.Done()
+		final, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdown(final); err != nil {
+			logging.Printf("[%s] [WARN] %s failed: %v\n", time.Now().Format("15:04:05"), what, err)
+		}
+	}()
+}
+
+// otlpSignalURL is the OTLP/HTTP URL of one signal under OTEL_EXPORTER_OTLP_ENDPOINT
+func otlpSignalURL(endpoint, path string) string {
+	return strings.TrimSuffix(endpoint, "/") + path
+}
+
+// otelResource describes this proxy as the resource of SDK-exported telemetry
+func otelResource() *resource.Resource {
+	return resource.NewSchemaless(
+		attribute.String("service.name", otlpServiceName),
+		attribute.String("service.version", ProxyVersion),
+	)
+}
+
+// logOTelErrors sends errors of the OpenTelemetry SDK, such as failed periodic
+// exports, to the proxy log
+func logOTelErrors() {
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logging.Printf("[%s] [WARN] OpenTelemetry export failed: %v\n", time.Now().Format("15:04:05"), err)
+	}))
+}
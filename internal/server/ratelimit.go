@@ -0,0 +1,103 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// rateLimitHeaderMapping maps an upstream rate-limit header to its Anthropic equivalent.
+// Claude Code reads the anthropic-ratelimit-* headers to pace itself, so we synthesize
+// them from whatever the provider reports.
+type rateLimitHeaderMapping struct {
+	upstream  string
+	anthropic string
+	isReset   bool // Value is a reset time that must be converted to RFC 3339
+}
+
+// rateLimitHeaderMappings lists the known upstream header formats.
+// Earlier entries win when several upstream headers map to the same Anthropic header.
+var rateLimitHeaderMappings = []rateLimitHeaderMapping{
+	// OpenAI format (reset values are durations like "6m0s" or "20ms")
+	{upstream: "x-ratelimit-limit-requests", anthropic: "anthropic-ratelimit-requests-limit"},
+	{upstream: "x-ratelimit-remaining-requests", anthropic: "anthropic-ratelimit-requests-remaining"},
+	{upstream: "x-ratelimit-reset-requests", anthropic: "anthropic-ratelimit-requests-reset", isReset: true},
+	{upstream: "x-ratelimit-limit-tokens", anthropic: "anthropic-ratelimit-tokens-limit"},
+	{upstream: "x-ratelimit-remaining-tokens", anthropic: "anthropic-ratelimit-tokens-remaining"},
+	{upstream: "x-ratelimit-reset-tokens", anthropic: "anthropic-ratelimit-tokens-reset", isReset: true},
+
+	// OpenRouter format (request-based limits, reset is a unix timestamp in milliseconds)
+	{upstream: "x-ratelimit-limit", anthropic: "anthropic-ratelimit-requests-limit"},
+	{upstream: "x-ratelimit-remaining", anthropic: "anthropic-ratelimit-requests-remaining"},
+	{upstream: "x-ratelimit-reset", anthropic: "anthropic-ratelimit-requests-reset", isReset: true},
+}
+
+// setAnthropicRateLimitHeaders translates upstream rate-limit headers into
+// Anthropic-named headers on the proxy response. Retry-After is forwarded as-is.
+func setAnthropicRateLimitHeaders(c *fiber.Ctx, upstream http.Header) {
+	for name, value := range anthropicRateLimitHeaders(upstream, time.Now()) {
+		c.Set(name, value)
+	}
+}
+
+// anthropicRateLimitHeaders computes the Anthropic rate-limit headers for the given
+// upstream headers. Unparseable reset values are skipped rather than guessed.
+func anthropicRateLimitHeaders(upstream http.Header, now time.Time) map[string]string {
+	headers := make(map[string]string)
+	if upstream == nil {
+		return headers
+	}
+
+	for _, m := range rateLimitHeaderMappings {
+		value := upstream.Get(m.upstream)
+		if value == "" {
+			continue
+		}
+		if _, exists := headers[m.anthropic]; exists {
+			continue
+		}
+
+		if m.isReset {
+			resetAt, ok := parseRateLimitReset(value, now)
+			if !ok {
+				continue
+			}
+			value = resetAt.UTC().Format(time.RFC3339)
+		}
+		headers[m.anthropic] = value
+	}
+
+	if retryAfter := upstream.Get("Retry-After"); retryAfter != "" {
+		headers["retry-after"] = retryAfter
+	}
+
+	return headers
+}
+
+// parseRateLimitReset converts an upstream reset value into an absolute time.
+// Supports Go-style durations ("1s", "6m0s"), unix timestamps in seconds or
+// milliseconds, plain second offsets, and RFC 3339 timestamps.
+func parseRateLimitReset(value string, now time.Time) (time.Time, bool) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(d), true
+	}
+
+	if n, err := strconv.ParseFloat(value, 64); err == nil {
+		switch {
+		case n > 1e12: // Unix milliseconds
+			return time.UnixMilli(int64(n)), true
+		case n > 1e9: // Unix seconds
+			return time.Unix(int64(n), 0), true
+		default: // Seconds from now
+			return now.Add(time.Duration(n * float64(time.Second))), true
+		}
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, true
+	}
+
+	return time.Time{}, false
+}
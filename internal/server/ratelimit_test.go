@@ -0,0 +1,139 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+)
+
+// TestAnthropicRateLimitHeaders tests mapping upstream rate-limit headers to Anthropic names
+func TestAnthropicRateLimitHeaders(t *testing.T) {
+	now := time.Date(2025, 11, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("OpenAI headers", func(t *testing.T) {
+		upstream := http.Header{}
+		upstream.Set("x-ratelimit-limit-requests", "500")
+		upstream.Set("x-ratelimit-remaining-requests", "499")
+		upstream.Set("x-ratelimit-reset-requests", "120ms")
+		upstream.Set("x-ratelimit-limit-tokens", "30000")
+		upstream.Set("x-ratelimit-remaining-tokens", "29000")
+		upstream.Set("x-ratelimit-reset-tokens", "6m0s")
+
+		got := anthropicRateLimitHeaders(upstream, now)
+
+		expected := map[string]string{
+			"anthropic-ratelimit-requests-limit":     "500",
+			"anthropic-ratelimit-requests-remaining": "499",
+			"anthropic-ratelimit-requests-reset":     "2025-11-01T12:00:00Z",
+			"anthropic-ratelimit-tokens-limit":       "30000",
+			"anthropic-ratelimit-tokens-remaining":   "29000",
+			"anthropic-ratelimit-tokens-reset":       "2025-11-01T12:06:00Z",
+		}
+		for name, want := range expected {
+			if got[name] != want {
+				t.Errorf("%s = %q, want %q", name, got[name], want)
+			}
+		}
+	})
+
+	t.Run("OpenRouter headers", func(t *testing.T) {
+		upstream := http.Header{}
+		upstream.Set("X-RateLimit-Limit", "20")
+		upstream.Set("X-RateLimit-Remaining", "3")
+		upstream.Set("X-RateLimit-Reset", "1761998460000") // 2025-11-01T12:01:00Z in ms
+
+		got := anthropicRateLimitHeaders(upstream, now)
+
+		if got["anthropic-ratelimit-requests-limit"] != "20" {
+			t.Errorf("requests-limit = %q, want %q", got["anthropic-ratelimit-requests-limit"], "20")
+		}
+		if got["anthropic-ratelimit-requests-remaining"] != "3" {
+			t.Errorf("requests-remaining = %q, want %q", got["anthropic-ratelimit-requests-remaining"], "3")
+		}
+		if got["anthropic-ratelimit-requests-reset"] != "2025-11-01T12:01:00Z" {
+			t.Errorf("requests-reset = %q, want %q", got["anthropic-ratelimit-requests-reset"], "2025-11-01T12:01:00Z")
+		}
+		if _, ok := got["anthropic-ratelimit-tokens-limit"]; ok {
+			t.Error("tokens-limit should not be set when upstream reports no token limits")
+		}
+	})
+
+	t.Run("Retry-After forwarded", func(t *testing.T) {
+		upstream := http.Header{}
+		upstream.Set("Retry-After", "30")
+
+		got := anthropicRateLimitHeaders(upstream, now)
+		if got["retry-after"] != "30" {
+			t.Errorf("retry-after = %q, want %q", got["retry-after"], "30")
+		}
+	})
+
+	t.Run("unparseable reset skipped", func(t *testing.T) {
+		upstream := http.Header{}
+		upstream.Set("x-ratelimit-reset-requests", "soon")
+
+		got := anthropicRateLimitHeaders(upstream, now)
+		if _, ok := got["anthropic-ratelimit-requests-reset"]; ok {
+			t.Error("Unparseable reset value should be skipped")
+		}
+	})
+
+	t.Run("nil headers", func(t *testing.T) {
+		if got := anthropicRateLimitHeaders(nil, now); len(got) != 0 {
+			t.Errorf("Expected no headers, got %v", got)
+		}
+	})
+}
+
+// TestRateLimitHeadersOnProxyResponse tests that the handler emits the mapped headers
+func TestRateLimitHeadersOnProxyResponse(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("x-ratelimit-limit-requests", "500")
+		w.Header().Set("x-ratelimit-remaining-tokens", "12345")
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}`))
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{
+		OpenAIBaseURL: upstream.URL,
+		OpenAIAPIKey:  "test-key",
+	}
+
+	resp := postJSON(t, newTestApp(cfg), "/v1/messages", testClaudeRequestBody)
+
+	if got := resp.Header.Get("anthropic-ratelimit-requests-limit"); got != "500" {
+		t.Errorf("anthropic-ratelimit-requests-limit = %q, want %q", got, "500")
+	}
+	if got := resp.Header.Get("anthropic-ratelimit-tokens-remaining"); got != "12345" {
+		t.Errorf("anthropic-ratelimit-tokens-remaining = %q, want %q", got, "12345")
+	}
+}
+
+// TestRateLimitHeadersOnStreamingResponse tests that streaming responses also carry the mapped headers
+func TestRateLimitHeadersOnStreamingResponse(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("x-ratelimit-remaining-requests", "42")
+		_, _ = w.Write([]byte("data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hi\"}}]}\n\ndata: [DONE]\n\n"))
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{
+		OpenAIBaseURL: upstream.URL,
+		OpenAIAPIKey:  "test-key",
+	}
+
+	body := `{"model":"claude-sonnet-4","max_tokens":100,"stream":true,"messages":[{"role":"user","content":"hello"}]}`
+	resp := postJSON(t, newTestApp(cfg), "/v1/messages", body)
+
+	if got := resp.Header.Get("anthropic-ratelimit-requests-remaining"); got != "42" {
+		t.Errorf("anthropic-ratelimit-requests-remaining = %q, want %q", got, "42")
+	}
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want %q", got, "text/event-stream")
+	}
+}
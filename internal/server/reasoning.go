@@ -0,0 +1,76 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+	"github.com/claude-code-proxy/proxy/internal/logging"
+	"github.com/claude-code-proxy/proxy/pkg/models"
+)
+
+// reasoningEffortLevels are OpenAI's reasoning_effort values, lowest first
+var reasoningEffortLevels = []string{"minimal", "low", "medium", "high"}
+
+// reasoningBudget enforces MAX_REASONING_TOKENS. Over-budget responses are
+// logged and, with REASONING_EFFORT_DOWNGRADE, lower the effort later requests
+// to the same model are sent with. Downgrades last until the proxy restarts.
+type reasoningBudget struct {
+	mu     sync.Mutex
+	effort map[string]string // provider model -> downgraded reasoning_effort
+}
+
+// reasoningEfforts is shared by all requests to the configured provider
+var reasoningEfforts = &reasoningBudget{}
+
+// apply lowers req's reasoning_effort to the model's downgraded effort, if any.
+// Requests without a reasoning_effort are left alone.
+func (b *reasoningBudget) apply(req *models.OpenAIRequest, cfg *config.Config) {
+	if cfg.MaxReasoningTokens <= 0 || !cfg.ReasoningEffortDowngrade || req.ReasoningEffort == "" {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if effort, ok := b.effort[req.Model]; ok && effortLevel(effort) < effortLevel(req.ReasoningEffort) {
+		req.ReasoningEffort = effort
+	}
+}
+
+// record checks a response's reasoning tokens against the budget. effort is the
+// reasoning_effort the request was sent with (empty when none was sent).
+func (b *reasoningBudget) record(model, effort string, reasoningTokens int, cfg *config.Config) {
+	if cfg.MaxReasoningTokens <= 0 || reasoningTokens <= cfg.MaxReasoningTokens {
+		return
+	}
+	timestamp := time.Now().Format("15:04:05")
+	logging.Printf("[%s] [WARN] model=%s used %d reasoning tokens, over MAX_REASONING_TOKENS=%d\n",
+		timestamp, model, reasoningTokens, cfg.MaxReasoningTokens)
+
+	level := effortLevel(effort)
+	if !cfg.ReasoningEffortDowngrade || level <= 0 {
+		return
+	}
+	lower := reasoningEffortLevels[level-1]
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if current, ok := b.effort[model]; ok && effortLevel(current) <= level-1 {
+		return
+	}
+	if b.effort == nil {
+		b.effort = make(map[string]string)
+	}
+	b.effort[model] = lower
+	logging.Printf("[%s] [WARN] model=%s reasoning_effort lowered from %s to %s for later requests\n",
+		timestamp, model, effort, lower)
+}
+
+// effortLevel returns the index of a reasoning_effort in reasoningEffortLevels,
+// or -1 for an empty or unknown effort
+func effortLevel(effort string) int {
+	for i, level := range reasoningEffortLevels {
+		if level == effort {
+			return i
+		}
+	}
+	return -1
+}
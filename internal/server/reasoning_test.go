@@ -0,0 +1,93 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+	"github.com/claude-code-proxy/proxy/internal/logging"
+	"github.com/claude-code-proxy/proxy/pkg/models"
+)
+
+// TestReasoningBudget tests that MAX_REASONING_TOKENS warns on over-budget
+// responses and, with REASONING_EFFORT_DOWNGRADE, lowers later efforts
+func TestReasoningBudget(t *testing.T) {
+	var logs bytes.Buffer
+	defer logging.SetOutput(&logs)()
+
+	effortAfter := func(b *reasoningBudget, cfg *config.Config, model, effort string) string {
+		req := &models.OpenAIRequest{Model: model, ReasoningEffort: effort}
+		b.apply(req, cfg)
+		return req.ReasoningEffort
+	}
+
+	t.Run("warning only", func(t *testing.T) {
+		logs.Reset()
+		b := &reasoningBudget{}
+		cfg := &config.Config{MaxReasoningTokens: 1000}
+
+		b.record("gpt-5", "medium", 1000, cfg)
+		if logs.Len() != 0 {
+			t.Errorf("At the budget should not warn, got %q", logs.String())
+		}
+		b.record("gpt-5", "medium", 4000, cfg)
+		if !strings.Contains(logs.String(), "[WARN] model=gpt-5 used 4000 reasoning tokens, over MAX_REASONING_TOKENS=1000") {
+			t.Errorf("Expected an over-budget warning, got %q", logs.String())
+		}
+		if got := effortAfter(b, cfg, "gpt-5", "medium"); got != "medium" {
+			t.Errorf("Effort without downgrade = %q, want medium", got)
+		}
+	})
+
+	t.Run("downgrade", func(t *testing.T) {
+		logs.Reset()
+		b := &reasoningBudget{}
+		cfg := &config.Config{MaxReasoningTokens: 1000, ReasoningEffortDowngrade: true}
+
+		b.record("gpt-5", "medium", 4000, cfg)
+		if !strings.Contains(logs.String(), "reasoning_effort lowered from medium to low") {
+			t.Errorf("Expected the downgrade to be logged, got %q", logs.String())
+		}
+		if got := effortAfter(b, cfg, "gpt-5", "medium"); got != "low" {
+			t.Errorf("Effort after one over-budget response = %q, want low", got)
+		}
+		if got := effortAfter(b, cfg, "gpt-5-mini", "medium"); got != "medium" {
+			t.Errorf("Other models keep their effort, got %q", got)
+		}
+		if got := effortAfter(b, cfg, "gpt-5", ""); got != "" {
+			t.Errorf("Requests without an effort stay without one, got %q", got)
+		}
+		if got := effortAfter(b, cfg, "gpt-5", "minimal"); got != "minimal" {
+			t.Errorf("A lower requested effort is never raised, got %q", got)
+		}
+
+		// Further overruns keep lowering it, down to minimal
+		b.record("gpt-5", "low", 4000, cfg)
+		b.record("gpt-5", "minimal", 4000, cfg)
+		if got := effortAfter(b, cfg, "gpt-5", "high"); got != "minimal" {
+			t.Errorf("Effort after repeated overruns = %q, want minimal", got)
+		}
+
+		// A concurrent request still sent at the old effort doesn't raise it back
+		b.record("gpt-5", "medium", 4000, cfg)
+		if got := effortAfter(b, cfg, "gpt-5", "high"); got != "minimal" {
+			t.Errorf("Effort after a stale overrun = %q, want minimal", got)
+		}
+	})
+
+	t.Run("streaming usage", func(t *testing.T) {
+		body := upstreamStream(
+			`{"choices":[{"index":0,"delta":{"content":"hi"},"finish_reason":"stop"}]}`,
+			`{"choices":[],"usage":{"prompt_tokens":5,"completion_tokens":3000,"completion_tokens_details":{"reasoning_tokens":2990}}}`,
+		)
+		bw := bufio.NewWriter(io.Discard)
+		_, _, reasoningTokens := streamOpenAIToClaude(newSSEWriter(bw), strings.NewReader(body), "test-model", "", &config.Config{}, time.Now(), "", 0)
+		if reasoningTokens != 2990 {
+			t.Errorf("reasoning tokens = %d, want 2990", reasoningTokens)
+		}
+	})
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+	"github.com/claude-code-proxy/proxy/internal/converter"
+	"github.com/claude-code-proxy/proxy/pkg/models"
+)
+
+// selfTestTiers are the Claude model tiers whose mapped provider models must exist
+var selfTestTiers = []struct {
+	name        string
+	setting     string
+	claudeModel string
+}{
+	{"opus", "ANTHROPIC_DEFAULT_OPUS_MODEL", "claude-opus-4"},
+	{"sonnet", "ANTHROPIC_DEFAULT_SONNET_MODEL", "claude-sonnet-4"},
+	{"haiku", "ANTHROPIC_DEFAULT_HAIKU_MODEL", "claude-haiku-4"},
+}
+
+// RunSelfTest checks that the proxy is set up correctly: the provider is reachable,
+// the API key is accepted, and the tier models exist. It writes a pass/fail report
+// to out and returns true if every check passed. Used by `claude-code-proxy test`.
+func RunSelfTest(cfg *config.Config, out io.Writer) bool {
+	passed := true
+	report := func(ok bool, check, format string, args ...interface{}) {
+		status := "✅"
+		if !ok {
+			status = "❌"
+			passed = false
+		}
+		_, _ = fmt.Fprintf(out, "  %s %-12s %s\n", status, check, fmt.Sprintf(format, args...))
+	}
+
+	_, _ = fmt.Fprintf(out, "Claude Code Proxy self-test\n\n")
+	if len(cfg.OpenAIBaseURLs) > 1 {
+		report(true, "Config", "provider=%s base_urls=%s", cfg.DetectProvider(), strings.Join(cfg.OpenAIBaseURLs, ","))
+	} else {
+		report(true, "Config", "provider=%s base_url=%s", cfg.DetectProvider(), cfg.OpenAIBaseURL)
+	}
+
+	// Models list - reachability and (for hosted providers) auth; with OPENAI_BASE_URLS
+	// only the first endpoint is asked, as all of them serve the same provider
+	if err := cfg.FetchAvailableModels(); err != nil {
+		report(false, "Models list", "%v", err)
+	} else {
+		report(true, "Models list", "fetched from %s/models", cfg.OpenAIBaseURL)
+
+		var missing []string
+		for _, tier := range selfTestTiers {
+			model := converter.MapModel(tier.claudeModel, cfg)
+			if available, _ := config.IsModelAvailable(model); !available {
+				missing = append(missing, fmt.Sprintf("%s=%s (%s)", tier.setting, model, tier.name))
+			}
+		}
+		if len(missing) > 0 {
+			report(false, "Tier models", "not in the provider's model list: %v", missing)
+		} else {
+			report(true, "Tier models", "all tier models available")
+		}
+	}
+
+	// Tiny completion - confirms auth and that the provider serves the haiku tier
+	model := converter.MapModel("claude-haiku-4", cfg)
+	start := time.Now()
+	if endpoint, err := selfTestCompletion(cfg); err != nil {
+		report(false, "Completion", "%s: %s", model, describeSelfTestError(err))
+	} else {
+		report(true, "Completion", "%s responded in %dms from %s", model, time.Since(start).Milliseconds(), endpoint)
+	}
+
+	if passed {
+		_, _ = fmt.Fprintf(out, "\nResult: PASS - ready for Claude Code\n")
+	} else {
+		_, _ = fmt.Fprintf(out, "\nResult: FAIL\n")
+	}
+	return passed
+}
+
+// selfTestCompletion sends a minimal non-streaming request through the normal conversion
+// path and returns the base URL of the endpoint that answered
+func selfTestCompletion(cfg *config.Config) (string, error) {
+	openaiReq, err := converter.ConvertRequest(models.ClaudeRequest{
+		Model:     "claude-haiku-4",
+		MaxTokens: 16,
+		Messages:  []models.ClaudeMessage{{Role: "user", Content: "ping"}},
+	}, cfg)
+	if err != nil {
+		return "", err
+	}
+
+	_, _, endpoint, err := callOpenAI(context.Background(), openaiReq, cfg)
+	return endpoint, err
+}
+
+// describeSelfTestError turns an upstream error into a short diagnostic hint
+func describeSelfTestError(err error) string {
+	var upErr *upstreamError
+	if errors.As(err, &upErr) {
+		switch {
+		case upErr.StatusCode == http.StatusUnauthorized || upErr.StatusCode == http.StatusForbidden:
+			return fmt.Sprintf("authentication failed (status %d) - check OPENAI_API_KEY", upErr.StatusCode)
+		case upErr.isModelNotFound():
+			return fmt.Sprintf("model not found (status %d) - check the tier model settings", upErr.StatusCode)
+		}
+		return err.Error()
+	}
+
+	var tErr *transportError
+	if errors.As(err, &tErr) {
+		return fmt.Sprintf("provider unreachable - check OPENAI_BASE_URL (%v)", tErr.Err)
+	}
+	return err.Error()
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+)
+
+// newSelfTestUpstream mocks a provider exposing /models and /chat/completions
+func newSelfTestUpstream(t *testing.T, completionStatus int) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/models":
+			_, _ = w.Write([]byte(`{"data": [{"id": "gpt-5"}, {"id": "gpt-5-mini"}]}`))
+		case "/chat/completions":
+			w.WriteHeader(completionStatus)
+			if completionStatus == http.StatusOK {
+				_, _ = w.Write([]byte(`{"id":"chatcmpl-1","choices":[{"index":0,"message":{"role":"assistant","content":"pong"},"finish_reason":"stop"}]}`))
+			} else {
+				_, _ = w.Write([]byte(`{"error":{"message":"invalid api key"}}`))
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// TestRunSelfTest tests the self-test report against a mocked upstream
+func TestRunSelfTest(t *testing.T) {
+	t.Run("pass", func(t *testing.T) {
+		upstream := newSelfTestUpstream(t, http.StatusOK)
+
+		var out bytes.Buffer
+		if !RunSelfTest(&config.Config{OpenAIBaseURL: upstream.URL}, &out) {
+			t.Fatalf("Expected self-test to pass, report:\n%s", out.String())
+		}
+		if !strings.Contains(out.String(), "Result: PASS") {
+			t.Errorf("Report should end with PASS, got:\n%s", out.String())
+		}
+	})
+
+	t.Run("missing tier model fails", func(t *testing.T) {
+		upstream := newSelfTestUpstream(t, http.StatusOK)
+
+		var out bytes.Buffer
+		cfg := &config.Config{OpenAIBaseURL: upstream.URL, SonnetModel: "gpt-5-typo"}
+		if RunSelfTest(cfg, &out) {
+			t.Fatalf("Expected self-test to fail, report:\n%s", out.String())
+		}
+		if !strings.Contains(out.String(), "ANTHROPIC_DEFAULT_SONNET_MODEL=gpt-5-typo") {
+			t.Errorf("Report should name the missing model, got:\n%s", out.String())
+		}
+	})
+
+	t.Run("rejected key fails", func(t *testing.T) {
+		upstream := newSelfTestUpstream(t, http.StatusUnauthorized)
+
+		var out bytes.Buffer
+		if RunSelfTest(&config.Config{OpenAIBaseURL: upstream.URL}, &out) {
+			t.Fatalf("Expected self-test to fail, report:\n%s", out.String())
+		}
+		if !strings.Contains(out.String(), "authentication failed") {
+			t.Errorf("Report should explain the auth failure, got:\n%s", out.String())
+		}
+	})
+
+	t.Run("unreachable provider fails", func(t *testing.T) {
+		upstream := newSelfTestUpstream(t, http.StatusOK)
+		upstream.Close()
+
+		var out bytes.Buffer
+		if RunSelfTest(&config.Config{OpenAIBaseURL: upstream.URL}, &out) {
+			t.Fatalf("Expected self-test to fail, report:\n%s", out.String())
+		}
+		if !strings.Contains(out.String(), "provider unreachable") {
+			t.Errorf("Report should explain the connection failure, got:\n%s", out.String())
+		}
+	})
+}
@@ -8,14 +8,20 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/claude-code-proxy/proxy/internal/config"
 	"github.com/claude-code-proxy/proxy/internal/converter"
 	"github.com/claude-code-proxy/proxy/internal/daemon"
+	"github.com/claude-code-proxy/proxy/internal/logging"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
@@ -27,12 +33,20 @@ const (
 	ProxyVersion = "1.0.0"
 )
 
+// configWatchInterval is how often --watch polls the config files
+const configWatchInterval = time.Second
+
+// ErrRestart is returned by Start when --watch saw a config file change;
+// the caller should restart the process to pick up the new config.
+var ErrRestart = errors.New("config changed, restart requested")
+
 // Start initializes and starts the HTTP server
 func Start(cfg *config.Config) error {
 	app := fiber.New(fiber.Config{
 		DisableStartupMessage: true,
 		ServerHeader:          "Claude-Code-Proxy",
 		AppName:               "Claude Code Proxy v" + ProxyVersion,
+		ErrorHandler:          handleFiberError,
 	})
 
 	// Middleware
@@ -47,9 +61,16 @@ func Start(cfg *config.Config) error {
 	if cfg.SimpleLog {
 		app.Use(logger.New(logger.Config{
 			Format: "[${time}] ${status} - ${latency} ${method} ${path}\n",
+			Output: logging.Writer(),
 		}))
 	}
 
+	// Idle shutdown - any request counts as activity
+	idle := newIdleTracker(time.Now())
+	if cfg.IdleShutdownMinutes > 0 {
+		app.Use(idle.middleware)
+	}
+
 	// Health check endpoint
 	app.Get("/health", func(c *fiber.Ctx) error {
 		return c.JSON(fiber.Map{
@@ -60,46 +81,111 @@ func Start(cfg *config.Config) error {
 
 	// Root endpoint - proxy info
 	app.Get("/", func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{
-			"message": "Claude Code Proxy",
-			"version": ProxyVersion,
-			"status":  "running",
-			"config": fiber.Map{
-				"openai_base_url": cfg.OpenAIBaseURL,
-				"routing_mode":    getRoutingMode(cfg),
-				"opus_model":      getOpusModel(cfg),
-				"sonnet_model":    getSonnetModel(cfg),
-				"haiku_model":     getHaikuModel(cfg),
-			},
-			"endpoints": fiber.Map{
-				"health":       "/health",
-				"messages":     "/v1/messages",
-				"count_tokens": "/v1/messages/count_tokens",
-			},
-		})
+		return handleRoot(c, cfg)
 	})
 
 	// Claude API endpoints
 	setupClaudeEndpoints(app, cfg)
 
-	// Graceful shutdown
+	// Claude-shaped 404 for everything else (must be registered last)
+	setupNotFoundHandler(app)
+
+	// Shared upstream connection pool, optionally pre-warmed with DNS caching
+	upstreamTransport = newUpstreamTransport(cfg)
+
+	// Latency probes for OPENAI_BASE_URLS (no-op with a single endpoint)
+	probeCtx, stopProbes := context.WithCancel(context.Background())
+	startEndpointProber(probeCtx, cfg)
+
+	// Token counts shared by count_tokens and messages (TOKEN_COUNT_CACHE_TTL)
+	if cfg.TokenCountCacheTTL > 0 && cfg.TokenCountCacheSize > 0 {
+		tokenCounts = newTokenCountCache(cfg.TokenCountCacheTTL, cfg.TokenCountCacheSize)
+	}
+
+	// OTLP metrics export (off unless OTEL_EXPORTER_OTLP_ENDPOINT is set)
+	if cfg.OTelEndpoint != "" && cfg.OTelMetricInterval > 0 {
+		logOTelErrors()
+		if err := startMetricsExport(probeCtx, cfg.OTelEndpoint, cfg.OTelMetricInterval); err != nil {
+			logging.Printf("[%s] [WARN] Metrics export disabled: %v\n", time.Now().Format("15:04:05"), err)
+		}
+	}
+
+	// Request tracing (ENABLE_TRACING), exported to the same OTLP collector
+	if cfg.EnableTracing {
+		endpoint := cfg.OTelEndpoint
+		if endpoint == "" {
+			endpoint = defaultOTLPEndpoint
+		}
+		logOTelErrors()
+		if err := startTracing(probeCtx, endpoint); err != nil {
+			logging.Printf("[%s] [WARN] Tracing disabled: %v\n", time.Now().Format("15:04:05"), err)
+		}
+	}
+
+	if cfg.PrewarmConnections > 0 && !cfg.PassthroughMode {
+		go func() {
+			warmed := prewarmConnections(probeCtx, cfg, newUpstreamClient(10*time.Second), cfg.PrewarmConnections)
+			if cfg.Debug {
+				fmt.Printf("[DEBUG] Pre-warmed %d upstream connections\n", warmed)
+			}
+		}()
+	}
+
+	// Graceful shutdown (on signal or after IDLE_SHUTDOWN_MINUTES without requests).
+	// app.Shutdown waits for in-flight requests, including open streams.
+	var shutdownOnce sync.Once
+	var restart atomic.Bool
+	shutdown := func(reason string) {
+		shutdownOnce.Do(func() {
+			fmt.Println(reason)
+			stopProbes()
+			daemon.Cleanup()
+			_ = app.Shutdown()
+		})
+	}
+
 	go func() {
 		sigChan := make(chan os.Signal, 1)
 		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 		<-sigChan
-		fmt.Println("\n🛑 Shutting down...")
-		daemon.Cleanup()
-		_ = app.Shutdown()
+		shutdown("\n🛑 Shutting down...")
 	}()
 
+	if cfg.IdleShutdownMinutes > 0 {
+		timeout := time.Duration(cfg.IdleShutdownMinutes) * time.Minute
+		go watchIdle(probeCtx, idle, timeout, time.Minute, func() {
+			shutdown(fmt.Sprintf("💤 No requests for %d minutes, shutting down...", cfg.IdleShutdownMinutes))
+		})
+	}
+
+	// --watch: any config change restarts the proxy (Host/Port included). The
+	// caller re-executes as soon as Listen returns, so in-flight streams are cut off
+	if cfg.WatchConfig {
+		go config.Watch(probeCtx, config.Locations(), configWatchInterval, func(path string) {
+			restart.Store(true)
+			shutdown(fmt.Sprintf("🔄 %s changed, restarting...", path))
+		})
+	}
+
 	// Start server
 	addr := fmt.Sprintf("%s:%s", cfg.Host, cfg.Port)
 	fmt.Printf("✅ Proxy running at http://localhost:%s\n", cfg.Port)
 
+	if cfg.IdleShutdownMinutes > 0 {
+		fmt.Printf("   Idle shutdown: after %d minutes without requests\n", cfg.IdleShutdownMinutes)
+	}
+
 	if cfg.PassthroughMode {
 		fmt.Printf("   Mode: PASSTHROUGH (direct to Anthropic API)\n")
 	} else {
-		fmt.Printf("   Mode: Conversion (via %s)\n", cfg.OpenAIBaseURL)
+		if len(cfg.OpenAIBaseURLs) > 1 {
+			fmt.Printf("   Mode: Conversion (via %d endpoints, lowest-latency routing)\n", len(cfg.OpenAIBaseURLs))
+			for _, url := range cfg.OpenAIBaseURLs {
+				fmt.Printf("     - %s\n", url)
+			}
+		} else {
+			fmt.Printf("   Mode: Conversion (via %s)\n", cfg.OpenAIBaseURL)
+		}
 		fmt.Printf("   Model Routing: %s\n", getRoutingMode(cfg))
 
 		// Show actual model mappings
@@ -117,7 +203,17 @@ func Start(cfg *config.Config) error {
 		}
 	}
 
-	return app.Listen(addr)
+	if cfg.WatchConfig {
+		fmt.Printf("   Watching config files for changes\n")
+	}
+
+	if err := app.Listen(addr); err != nil {
+		return err
+	}
+	if restart.Load() {
+		return ErrRestart
+	}
+	return nil
 }
 
 func getRoutingMode(cfg *config.Config) string {
@@ -148,14 +244,82 @@ func getHaikuModel(cfg *config.Config) string {
 	return converter.DefaultHaikuModel + " (pattern-based)"
 }
 
+// handleRoot returns proxy info. With MINIMAL_ROOT only name, version and status
+// are returned, so the provider base URL and model routing aren't exposed.
+func handleRoot(c *fiber.Ctx, cfg *config.Config) error {
+	if cfg.MinimalRoot {
+		return c.JSON(fiber.Map{
+			"message": "Claude Code Proxy",
+			"version": ProxyVersion,
+			"status":  "running",
+		})
+	}
+
+	configInfo := fiber.Map{
+		"openai_base_url": cfg.OpenAIBaseURL,
+		"routing_mode":    getRoutingMode(cfg),
+		"opus_model":      getOpusModel(cfg),
+		"sonnet_model":    getSonnetModel(cfg),
+		"haiku_model":     getHaikuModel(cfg),
+	}
+	// With OPENAI_BASE_URLS, openai_base_url is only the first (primary) endpoint
+	if len(cfg.OpenAIBaseURLs) > 1 {
+		configInfo["openai_base_urls"] = cfg.OpenAIBaseURLs
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Claude Code Proxy",
+		"version": ProxyVersion,
+		"status":  "running",
+		"config":  configInfo,
+		"endpoints": fiber.Map{
+			"health":       "/health",
+			"messages":     "/v1/messages",
+			"count_tokens": "/v1/messages/count_tokens",
+			"validate":     "/v1/messages/validate",
+			"features":     "/info/features",
+		},
+	})
+}
+
+// setupNotFoundHandler replaces Fiber's default 404 page with a Claude-shaped error
+func setupNotFoundHandler(app *fiber.App) {
+	app.Use(func(c *fiber.Ctx) error {
+		return claudeError(c, 404, errNotFound, fmt.Sprintf("Not found: %s %s", c.Method(), c.Path()))
+	})
+}
+
 func setupClaudeEndpoints(app *fiber.App, cfg *config.Config) {
-	// Messages endpoint - main Claude API
-	app.Post("/v1/messages", func(c *fiber.Ctx) error {
+	// Messages endpoint - main Claude API, optionally behind a concurrency limit
+	messages := []fiber.Handler{}
+	if cfg.MaxConcurrentRequests > 0 {
+		limiter := newConcurrencyLimiter(cfg.MaxConcurrentRequests, cfg.QueueWaitHeader)
+		messages = append(messages, limiter.middleware)
+	}
+	messages = append(messages, func(c *fiber.Ctx) error {
 		return handleMessages(c, cfg)
 	})
+	app.Post("/v1/messages", messages...)
 
 	// Token counting endpoint
 	app.Post("/v1/messages/count_tokens", func(c *fiber.Ctx) error {
 		return handleCountTokens(c, cfg)
 	})
+
+	// Dry-run validation endpoint (never calls the provider)
+	app.Post("/v1/messages/validate", func(c *fiber.Ctx) error {
+		return handleValidate(c, cfg)
+	})
+
+	// Optional features active in the resolved config
+	app.Get("/info/features", func(c *fiber.Ctx) error {
+		return handleFeatures(c, cfg)
+	})
+
+	// Recent request/response pairs for debugging (debug mode or proxy auth only)
+	if debugBufferEnabled(cfg) {
+		app.Get("/debug/requests", func(c *fiber.Ctx) error {
+			return handleDebugRequests(c, cfg)
+		})
+	}
 }
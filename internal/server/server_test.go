@@ -0,0 +1,152 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+	"github.com/gofiber/fiber/v2"
+)
+
+// newRootTestApp builds an app with the root, Claude and not-found routes wired like Start
+func newRootTestApp(cfg *config.Config) *fiber.App {
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		return handleRoot(c, cfg)
+	})
+	setupClaudeEndpoints(app, cfg)
+	setupNotFoundHandler(app)
+	return app
+}
+
+// TestRootEndpoint tests the full and minimal root responses
+func TestRootEndpoint(t *testing.T) {
+	tests := []struct {
+		name        string
+		minimalRoot bool
+		wantConfig  bool
+	}{
+		{"full root includes config", false, true},
+		{"minimal root hides config", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newRootTestApp(&config.Config{OpenAIBaseURL: "https://secret-host.example/v1", MinimalRoot: tt.minimalRoot})
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/", nil), -1)
+			if err != nil {
+				t.Fatalf("app.Test() error = %v", err)
+			}
+
+			var body map[string]interface{}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatalf("Failed to decode root response: %v", err)
+			}
+
+			if body["status"] != "running" || body["version"] != ProxyVersion {
+				t.Errorf("Expected status and version, got %v", body)
+			}
+			if _, hasConfig := body["config"]; hasConfig != tt.wantConfig {
+				t.Errorf("config present = %v, want %v", hasConfig, tt.wantConfig)
+			}
+			if tt.minimalRoot && len(body) != 3 {
+				t.Errorf("Minimal root should only have message/version/status, got %v", body)
+			}
+		})
+	}
+}
+
+// TestNotFoundHandler tests that unknown routes return a Claude-shaped not_found_error
+func TestNotFoundHandler(t *testing.T) {
+	app := newRootTestApp(&config.Config{})
+
+	for _, path := range []string{"/v1/unknown", "/v2/messages", "/admin"} {
+		t.Run(path, func(t *testing.T) {
+			resp, err := app.Test(httptest.NewRequest("POST", path, nil), -1)
+			if err != nil {
+				t.Fatalf("app.Test() error = %v", err)
+			}
+			if resp.StatusCode != 404 {
+				t.Errorf("Status = %d, want 404", resp.StatusCode)
+			}
+
+			var body struct {
+				Type  string `json:"type"`
+				Error struct {
+					Type    string `json:"type"`
+					Message string `json:"message"`
+				} `json:"error"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatalf("Failed to decode 404 response: %v", err)
+			}
+			if body.Type != "error" || body.Error.Type != "not_found_error" {
+				t.Errorf("Expected not_found_error, got %+v", body)
+			}
+		})
+	}
+
+	// Known routes are unaffected
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil), -1)
+	if err != nil {
+		t.Fatalf("app.Test() error = %v", err)
+	}
+	if resp.StatusCode != 200 {
+		t.Errorf("Root status = %d, want 200", resp.StatusCode)
+	}
+}
+
+// TestFeaturesEndpoint tests that enabled optional features are reported and disabled ones are not
+func TestFeaturesEndpoint(t *testing.T) {
+	cfg := &config.Config{
+		OpenAIBaseURL:           "https://api.openai.com/v1",
+		CircuitBreakerThreshold: 5,
+		CompactTools:            true,
+		MaxTokensHaiku:          1024,
+		CaptureDir:              t.TempDir(),
+	}
+	app := newRootTestApp(cfg)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/info/features", nil), -1)
+	if err != nil {
+		t.Fatalf("app.Test() error = %v", err)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatalf("Status = %d, want 200", resp.StatusCode)
+	}
+
+	var body struct {
+		Features []string `json:"features"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode features response: %v", err)
+	}
+
+	active := make(map[string]bool)
+	for _, f := range body.Features {
+		active[f] = true
+	}
+	for _, want := range []string{"circuit_breaker", "compact_tools", "tier_max_tokens", "capture"} {
+		if !active[want] {
+			t.Errorf("Expected %q to be reported, got %v", want, body.Features)
+		}
+	}
+	for _, unwanted := range []string{"passthrough", "proxy_auth", "capture_compress", "debug_buffer", "stream_throttle"} {
+		if active[unwanted] {
+			t.Errorf("Disabled feature %q should not be reported, got %v", unwanted, body.Features)
+		}
+	}
+
+	t.Run("requires API key when proxy auth is enabled", func(t *testing.T) {
+		app := newRootTestApp(&config.Config{AnthropicAPIKey: "proxy-secret"})
+		resp, err := app.Test(httptest.NewRequest("GET", "/info/features", nil), -1)
+		if err != nil {
+			t.Fatalf("app.Test() error = %v", err)
+		}
+		if resp.StatusCode != 401 {
+			t.Errorf("Status = %d, want 401", resp.StatusCode)
+		}
+	})
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// sseWriter serializes writes to the client's SSE stream.
+// The streaming loop and background writers (e.g. keepalive pings) share the same
+// bufio.Writer, so every event is written under a mutex to keep events from interleaving.
+//
+// When created with a throttle, events are queued and written by a pacing goroutine
+// that waits between content deltas. The queue is unbounded so the upstream read
+// loop never blocks on pacing; Close must be called to drain it.
+//
+// When created with a flush batch, Flush only reaches the client every batch calls,
+// or once the oldest unflushed event has waited maxDelay; Close flushes the rest.
+type sseWriter struct {
+	mu sync.Mutex
+	w  *bufio.Writer
+
+	// Throttling (nil queue means events are written directly)
+	throttle time.Duration
+	queue    [][]byte
+	notify   chan struct{}
+	closed   bool
+	done     chan struct{}
+
+	// Flush batching (batch <= 1 flushes on every Flush call)
+	batch    int
+	maxDelay time.Duration
+	pending  int         // Flush calls since the last real flush
+	timer    *time.Timer // flushes pending events after maxDelay
+
+	// Optional copy of every event written (debug request buffer)
+	capture *cappedBuffer
+}
+
+// newSSEWriter wraps a bufio.Writer for safe concurrent event writes
+func newSSEWriter(w *bufio.Writer) *sseWriter {
+	return &sseWriter{w: w}
+}
+
+// newThrottledSSEWriter wraps a bufio.Writer and paces content deltas by the given delay
+func newThrottledSSEWriter(w *bufio.Writer, throttle time.Duration) *sseWriter {
+	s := &sseWriter{
+		w:        w,
+		throttle: throttle,
+		queue:    [][]byte{},
+		notify:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	go s.pace()
+	return s
+}
+
+// newBatchedSSEWriter wraps a bufio.Writer and flushes every batch Flush calls,
+// or after maxDelay at the latest
+func newBatchedSSEWriter(w *bufio.Writer, batch int, maxDelay time.Duration) *sseWriter {
+	return &sseWriter{w: w, batch: batch, maxDelay: maxDelay}
+}
+
+// Flush flushes buffered events to the client.
+// In throttled mode the pacing goroutine flushes after every event, so this is a no-op.
+// In batched mode it flushes once batch calls are pending, and otherwise makes sure
+// the pending events are flushed within maxDelay.
+func (s *sseWriter) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.queue != nil {
+		return nil
+	}
+	if s.batch <= 1 || s.closed {
+		return s.w.Flush()
+	}
+
+	s.pending++
+	if s.pending < s.batch {
+		if s.timer == nil {
+			s.timer = time.AfterFunc(s.maxDelay, s.flushPending)
+		}
+		return nil
+	}
+	return s.flushLocked()
+}
+
+// flushPending flushes the events the batch is holding (maxDelay timer)
+func (s *sseWriter) flushPending() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending > 0 && !s.closed {
+		_ = s.flushLocked()
+	}
+}
+
+// flushLocked flushes and resets the batch; s.mu must be held
+func (s *sseWriter) flushLocked() error {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.pending = 0
+	return s.w.Flush()
+}
+
+// Close drains any queued events, or flushes the events a batch is holding. Safe
+// to call on any writer, more than once.
+func (s *sseWriter) Close() {
+	s.mu.Lock()
+	if s.batch > 1 {
+		if !s.closed && s.pending > 0 {
+			_ = s.flushLocked()
+		}
+		s.closed = true
+		s.mu.Unlock()
+		return
+	}
+	if s.queue == nil || s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	s.signal()
+	<-s.done
+}
+
+// signal wakes the pacing goroutine without blocking
+func (s *sseWriter) signal() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// pace writes queued events, sleeping after each content delta
+func (s *sseWriter) pace() {
+	defer close(s.done)
+	for {
+		s.mu.Lock()
+		if len(s.queue) == 0 {
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return
+			}
+			<-s.notify
+			continue
+		}
+		event := s.queue[0]
+		s.queue = s.queue[1:]
+		_, _ = s.w.Write(event)
+		_ = s.w.Flush()
+		s.mu.Unlock()
+
+		if bytes.HasPrefix(event, []byte("event: content_block_delta\n")) {
+			time.Sleep(s.throttle)
+		}
+	}
+}
+
+// writeSSEEvent writes a Server-Sent Event
+func writeSSEEvent(w *sseWriter, event string, data interface{}) {
+	dataJSON, _ := json.Marshal(data)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.capture != nil {
+		_, _ = fmt.Fprintf(w.capture, "event: %s\ndata: %s\n\n", event, string(dataJSON))
+	}
+
+	if w.queue != nil {
+		w.queue = append(w.queue, []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", event, string(dataJSON))))
+		w.signal()
+		return
+	}
+
+	_, _ = fmt.Fprintf(w.w, "event: %s\n", event)
+	_, _ = fmt.Fprintf(w.w, "data: %s\n\n", string(dataJSON))
+}
+
+// writeSSEError writes an error event
+func writeSSEError(w *sseWriter, message string) {
+	writeSSEEvent(w, "error", claudeErrorBody(errAPI, message))
+	_ = w.Flush()
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+)
+
+// TestSSEWriterConcurrentWrites tests that concurrent event writers never interleave events.
+// Run with -race to also catch unsynchronized access to the underlying writer.
+func TestSSEWriterConcurrentWrites(t *testing.T) {
+	var buf bytes.Buffer
+	w := newSSEWriter(bufio.NewWriter(&buf))
+
+	const writers = 8
+	const eventsPerWriter = 200
+
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(writer int) {
+			defer wg.Done()
+			for j := 0; j < eventsPerWriter; j++ {
+				writeSSEEvent(w, "content_block_delta", map[string]interface{}{
+					"type":   "content_block_delta",
+					"writer": writer,
+					"seq":    j,
+					"text":   strings.Repeat("x", 64),
+				})
+				if j%10 == 0 {
+					_ = w.Flush()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	_ = w.Flush()
+
+	// Every event must be exactly an "event:" line followed by a valid "data:" line
+	events := strings.Split(strings.TrimSuffix(buf.String(), "\n\n"), "\n\n")
+	if len(events) != writers*eventsPerWriter {
+		t.Fatalf("Event count = %d, want %d", len(events), writers*eventsPerWriter)
+	}
+
+	for i, event := range events {
+		lines := strings.Split(event, "\n")
+		if len(lines) != 2 {
+			t.Fatalf("Event %d has %d lines, want 2: %q", i, len(lines), event)
+		}
+		if lines[0] != "event: content_block_delta" {
+			t.Fatalf("Event %d has corrupted event line: %q", i, lines[0])
+		}
+		if !strings.HasPrefix(lines[1], "data: ") {
+			t.Fatalf("Event %d has corrupted data line: %q", i, lines[1])
+		}
+		var data map[string]interface{}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data: ")), &data); err != nil {
+			t.Fatalf("Event %d data is not valid JSON: %v", i, err)
+		}
+	}
+}
+
+// TestWriteSSEError tests that error events use Claude's error shape and are flushed
+func TestWriteSSEError(t *testing.T) {
+	var buf bytes.Buffer
+	w := newSSEWriter(bufio.NewWriter(&buf))
+
+	writeSSEError(w, "something broke")
+
+	output := buf.String()
+	if !strings.HasPrefix(output, "event: error\n") {
+		t.Errorf("Expected error event, got %q", output)
+	}
+	if !strings.Contains(output, `"type":"api_error"`) {
+		t.Errorf("Expected api_error type, got %q", output)
+	}
+	if !strings.Contains(output, `"message":"something broke"`) {
+		t.Errorf("Expected error message, got %q", output)
+	}
+}
+
+// TestThrottledSSEWriter tests that throttling paces deltas without dropping events
+func TestThrottledSSEWriter(t *testing.T) {
+	const deltas = 5
+	const throttle = 20 * time.Millisecond
+
+	var chunks []string
+	for i := 0; i < deltas; i++ {
+		chunks = append(chunks, `{"choices":[{"index":0,"delta":{"content":"word "}}]}`)
+	}
+	input := upstreamStream(chunks...)
+	cfg := &config.Config{}
+
+	// Unthrottled baseline
+	baseline := convertTestStream(cfg, input)
+
+	// Throttled run
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	w := newThrottledSSEWriter(bw, throttle)
+
+	start := time.Now()
+	streamOpenAIToClaude(w, strings.NewReader(input), "test-model", "", cfg, start, "", 0)
+	w.Close()
+	elapsed := time.Since(start)
+
+	throttled := parseSSEEvents(buf.String())
+
+	if len(throttled) != len(baseline) {
+		t.Fatalf("Throttled event count = %d, want %d", len(throttled), len(baseline))
+	}
+	for i := range baseline {
+		if throttled[i].Event != baseline[i].Event {
+			t.Errorf("Event %d = %q, want %q", i, throttled[i].Event, baseline[i].Event)
+		}
+	}
+
+	if minimum := (deltas - 1) * throttle; elapsed < minimum {
+		t.Errorf("Throttled stream took %v, want at least %v", elapsed, minimum)
+	}
+}
+
+// TestThrottledSSEWriterCloseIdempotent tests that Close can be called on any writer
+func TestThrottledSSEWriterCloseIdempotent(t *testing.T) {
+	var buf bytes.Buffer
+	w := newThrottledSSEWriter(bufio.NewWriter(&buf), time.Millisecond)
+	writeSSEEvent(w, "ping", map[string]interface{}{"type": "ping"})
+	w.Close()
+	w.Close()
+
+	if !strings.Contains(buf.String(), "event: ping") {
+		t.Errorf("Expected queued event to be drained, got %q", buf.String())
+	}
+
+	// Unthrottled writers ignore Close
+	newSSEWriter(bufio.NewWriter(&buf)).Close()
+}
+
+// flushCounter counts the writes reaching the client, one per bufio flush
+type flushCounter struct {
+	mu     sync.Mutex
+	writes int
+	buf    bytes.Buffer
+}
+
+func (f *flushCounter) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writes++
+	return f.buf.Write(p)
+}
+
+func (f *flushCounter) counts() (int, string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.writes, f.buf.String()
+}
+
+// TestBatchedSSEWriter tests that STREAM_FLUSH_BATCH reduces flushes without holding
+// output back longer than the max delay
+func TestBatchedSSEWriter(t *testing.T) {
+	const deltas = 40
+	var chunks []string
+	for i := 0; i < deltas; i++ {
+		chunks = append(chunks, `{"choices":[{"index":0,"delta":{"content":"word "}}]}`)
+	}
+	input := upstreamStream(chunks...)
+
+	stream := func(newWriter func(*bufio.Writer) *sseWriter) (int, []sseTestEvent) {
+		var client flushCounter
+		w := newWriter(bufio.NewWriterSize(&client, 1<<20))
+		streamOpenAIToClaude(w, strings.NewReader(input), "test-model", "", &config.Config{}, time.Now(), "", 0)
+		w.Close()
+		writes, out := client.counts()
+		return writes, parseSSEEvents(out)
+	}
+
+	perDelta, baseline := stream(newSSEWriter)
+	batched, events := stream(func(bw *bufio.Writer) *sseWriter { return newBatchedSSEWriter(bw, 8, time.Minute) })
+	if batched*4 > perDelta {
+		t.Errorf("batched stream flushed %d times, want well under the %d flushes per delta", batched, perDelta)
+	}
+	if len(events) != len(baseline) {
+		t.Errorf("batched event count = %d, want %d", len(events), len(baseline))
+	}
+
+	t.Run("max delay flushes a partial batch", func(t *testing.T) {
+		const maxDelay = 20 * time.Millisecond
+		var client flushCounter
+		w := newBatchedSSEWriter(bufio.NewWriterSize(&client, 1<<20), 8, maxDelay)
+		defer w.Close()
+
+		writeSSEEvent(w, "ping", map[string]interface{}{"type": "ping"})
+		_ = w.Flush()
+		if writes, _ := client.counts(); writes != 0 {
+			t.Fatalf("Expected the first event to wait for its batch, got %d flushes", writes)
+		}
+
+		deadline := time.Now().Add(10 * maxDelay)
+		for time.Now().Before(deadline) {
+			if _, out := client.counts(); strings.Contains(out, "event: ping") {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Errorf("Event not flushed within %v (max delay %v)", 10*maxDelay, maxDelay)
+	})
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Request stages timed in debug mode, in the order they complete
+const (
+	stageParsed        = iota // request body parsed
+	stageConverted            // converted to the OpenAI request
+	stageFirstByte            // first upstream response byte
+	stageUpstreamDone         // upstream response read (stream finished)
+	stageResponseReady        // converted back to the Claude response
+	numStages
+)
+
+// timingHeader carries the breakdown as a Server-Timing header, which browser
+// devtools and most HTTP debugging tools display
+const timingHeader = "Server-Timing"
+
+// requestTiming records when each stage of a /v1/messages request completed
+// (DEBUG mode). Times come from time.Now, so durations use the monotonic clock.
+// Methods are no-ops on a nil timing.
+type requestTiming struct {
+	mu    sync.Mutex
+	start time.Time
+	at    [numStages]time.Time
+}
+
+// timingEntry is one measured span of a request
+type timingEntry struct {
+	Name     string
+	Duration time.Duration
+}
+
+// newRequestTiming starts timing a request when debug mode is on
+func newRequestTiming(debug bool) *requestTiming {
+	if !debug {
+		return nil
+	}
+	return &requestTiming{start: time.Now()}
+}
+
+// mark records that a stage completed now; only the first mark counts, so a
+// retried upstream call keeps the first response's timing
+func (t *requestTiming) mark(stage int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.at[stage].IsZero() {
+		t.at[stage] = time.Now()
+	}
+}
+
+// trace returns ctx with a client trace marking the first upstream response byte
+func (t *requestTiming) trace(ctx context.Context) context.Context {
+	if t == nil {
+		return ctx
+	}
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotFirstResponseByte: func() { t.mark(stageFirstByte) },
+	})
+}
+
+// breakdown returns the measured spans: parse, convert, upstream_ttfb and
+// upstream (both from the end of conversion) and response_convert. Spans whose
+// stages weren't reached are left out.
+func (t *requestTiming) breakdown() []timingEntry {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	at := func(stage int) time.Time {
+		if stage < 0 {
+			return t.start
+		}
+		return t.at[stage]
+	}
+	spans := []struct {
+		name     string
+		from, to int
+	}{
+		{"parse", -1, stageParsed},
+		{"convert", stageParsed, stageConverted},
+		{"upstream_ttfb", stageConverted, stageFirstByte},
+		{"upstream", stageConverted, stageUpstreamDone},
+		{"response_convert", stageUpstreamDone, stageResponseReady},
+	}
+	var entries []timingEntry
+	for _, s := range spans {
+		if from, to := at(s.from), at(s.to); !from.IsZero() && !to.IsZero() {
+			entries = append(entries, timingEntry{Name: s.name, Duration: to.Sub(from)})
+		}
+	}
+	return entries
+}
+
+// serverTiming formats the breakdown as a Server-Timing header value
+// ("parse;dur=0.052, convert;dur=0.210, ..." in milliseconds)
+func (t *requestTiming) serverTiming() string {
+	var parts []string
+	for _, e := range t.breakdown() {
+		parts = append(parts, fmt.Sprintf("%s;dur=%.3f", e.Name, float64(e.Duration.Microseconds())/1000))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// log prints the breakdown as a debug line
+func (t *requestTiming) log() {
+	entries := t.breakdown()
+	if len(entries) == 0 {
+		return
+	}
+	parts := make([]string, len(entries))
+	for i, e := range entries {
+		parts[i] = fmt.Sprintf("%s=%s", e.Name, e.Duration.Round(time.Microsecond))
+	}
+	fmt.Printf("[DEBUG] Timing: %s\n", strings.Join(parts, " "))
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+)
+
+// parseServerTiming returns the names and durations (ms) of a Server-Timing value
+func parseServerTiming(t *testing.T, value string) ([]string, map[string]float64) {
+	t.Helper()
+	var names []string
+	durations := make(map[string]float64)
+	for _, part := range strings.Split(value, ", ") {
+		name, dur, ok := strings.Cut(part, ";dur=")
+		ms, err := strconv.ParseFloat(dur, 64)
+		if !ok || err != nil {
+			t.Fatalf("malformed Server-Timing entry %q in %q", part, value)
+		}
+		names = append(names, name)
+		durations[name] = ms
+	}
+	return names, durations
+}
+
+// TestRequestTiming tests the debug-mode timing breakdown of both handler paths
+func TestRequestTiming(t *testing.T) {
+	const bodyDelay = 30 * time.Millisecond
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), `"stream":true`) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte(upstreamStream(`{"choices":[{"index":0,"delta":{"content":"hi"},"finish_reason":"stop"}]}`)))
+			return
+		}
+		// Headers first, then the body after a delay, so TTFB < total
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(bodyDelay)
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}`))
+	}))
+	defer upstream.Close()
+
+	t.Run("non-streaming", func(t *testing.T) {
+		resp := postJSON(t, newTestApp(&config.Config{OpenAIBaseURL: upstream.URL, Debug: true}), "/v1/messages", testClaudeRequestBody)
+		names, ms := parseServerTiming(t, resp.Header.Get(timingHeader))
+		if got, want := strings.Join(names, ","), "parse,convert,upstream_ttfb,upstream,response_convert"; got != want {
+			t.Fatalf("stages = %s, want %s", got, want)
+		}
+		for name, d := range ms {
+			if d < 0 {
+				t.Errorf("%s = %vms, want >= 0", name, d)
+			}
+		}
+		if ms["upstream_ttfb"] > ms["upstream"] {
+			t.Errorf("upstream_ttfb = %vms, want <= upstream (%vms)", ms["upstream_ttfb"], ms["upstream"])
+		}
+		if ms["upstream"] < float64(bodyDelay.Milliseconds()) {
+			t.Errorf("upstream = %vms, want at least the %v body delay", ms["upstream"], bodyDelay)
+		}
+	})
+
+	t.Run("streaming", func(t *testing.T) {
+		streamBody := strings.Replace(testClaudeRequestBody, `"max_tokens":100`, `"max_tokens":100,"stream":true`, 1)
+		resp := postJSON(t, newTestApp(&config.Config{OpenAIBaseURL: upstream.URL, Debug: true}), "/v1/messages", streamBody)
+		_, _ = io.ReadAll(resp.Body)
+		names, _ := parseServerTiming(t, resp.Header.Get(timingHeader))
+		if got, want := strings.Join(names, ","), "parse,convert,upstream_ttfb"; got != want {
+			t.Errorf("stages = %s, want %s (known when the headers are sent)", got, want)
+		}
+	})
+
+	t.Run("off without debug", func(t *testing.T) {
+		resp := postJSON(t, newTestApp(&config.Config{OpenAIBaseURL: upstream.URL}), "/v1/messages", testClaudeRequestBody)
+		if got := resp.Header.Get(timingHeader); got != "" {
+			t.Errorf("%s = %q, want none outside debug mode", timingHeader, got)
+		}
+	})
+}
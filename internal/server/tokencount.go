@@ -0,0 +1,107 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/claude-code-proxy/proxy/internal/converter"
+	"github.com/claude-code-proxy/proxy/pkg/models"
+)
+
+// tokenCountCache remembers recent input token counts by prompt (TOKEN_COUNT_CACHE_TTL).
+// Claude Code calls count_tokens right before sending the same prompt to
+// /v1/messages: the messages request reuses the estimate for message_start, and
+// records the provider's exact count for a count_tokens call on the same prompt.
+// Methods are no-ops on a nil cache.
+type tokenCountCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	entries map[string]tokenCountEntry
+}
+
+// tokenCountEntry is a cached count and when it stops being reused
+type tokenCountEntry struct {
+	estimate converter.TokenEstimate
+	expires  time.Time
+}
+
+// tokenCounts is the process-wide cache (nil when TOKEN_COUNT_CACHE_TTL is 0)
+var tokenCounts *tokenCountCache
+
+func newTokenCountCache(ttl time.Duration, size int) *tokenCountCache {
+	return &tokenCountCache{ttl: ttl, size: size, entries: make(map[string]tokenCountEntry)}
+}
+
+// key hashes the parts of a request that are counted, so a count_tokens body and
+// the messages body with the same prompt (plus max_tokens, stream, ...) share a key
+func (c *tokenCountCache) key(claudeReq models.ClaudeRequest) string {
+	if c == nil {
+		return ""
+	}
+	prompt, err := json.Marshal(struct {
+		Model    string                 `json:"model"`
+		System   interface{}            `json:"system"`
+		Messages []models.ClaudeMessage `json:"messages"`
+		Tools    []models.Tool          `json:"tools"`
+	}{claudeReq.Model, claudeReq.System, claudeReq.Messages, claudeReq.Tools})
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(prompt)
+	return hex.EncodeToString(sum[:])
+}
+
+// get returns the cached count for a key, if it hasn't expired
+func (c *tokenCountCache) get(key string) (converter.TokenEstimate, bool) {
+	if c == nil || key == "" {
+		return converter.TokenEstimate{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return converter.TokenEstimate{}, false
+	}
+	return entry.estimate, true
+}
+
+// set caches a count for a key, evicting expired entries (then the one closest to
+// expiring) when the cache is full
+func (c *tokenCountCache) set(key string, estimate converter.TokenEstimate) {
+	if c == nil || key == "" {
+		return
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.entries[key]; !exists && len(c.entries) >= c.size {
+		oldest := ""
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			} else if oldest == "" || entry.expires.Before(c.entries[oldest].expires) {
+				oldest = k
+			}
+		}
+		if len(c.entries) >= c.size {
+			delete(c.entries, oldest)
+		}
+	}
+	c.entries[key] = tokenCountEntry{estimate: estimate, expires: now.Add(c.ttl)}
+}
+
+// recordInputTokens caches the input tokens the provider reported for a prompt,
+// keeping the cache read/write split of an earlier estimate
+func (c *tokenCountCache) recordInputTokens(key string, inputTokens int) {
+	if inputTokens <= 0 {
+		return
+	}
+	estimate, _ := c.get(key)
+	estimate.InputTokens = inputTokens
+	c.set(key, estimate)
+}
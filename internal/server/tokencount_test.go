@@ -0,0 +1,101 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+	"github.com/claude-code-proxy/proxy/internal/converter"
+	"github.com/claude-code-proxy/proxy/pkg/models"
+)
+
+// TestTokenCountCache tests that count_tokens and an identical messages request share a count
+func TestTokenCountCache(t *testing.T) {
+	tokenCounts = newTokenCountCache(time.Minute, 16)
+	defer func() { tokenCounts = nil }()
+
+	usage := ""
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		chunks := []string{`{"choices":[{"index":0,"delta":{"content":"Hi"},"finish_reason":"stop"}]}`}
+		if usage != "" {
+			chunks = append(chunks, `{"choices":[],"usage":`+usage+`}`)
+		}
+		fmt.Fprint(w, upstreamStream(chunks...))
+	}))
+	defer upstream.Close()
+	app := newTestApp(&config.Config{OpenAIBaseURL: upstream.URL})
+
+	countTokens := func(body string) int {
+		var result map[string]interface{}
+		_ = json.NewDecoder(postJSON(t, app, "/v1/messages/count_tokens", body).Body).Decode(&result)
+		tokens, _ := result["input_tokens"].(float64)
+		return int(tokens)
+	}
+	messageStartTokens := func(body string) int {
+		body = strings.Replace(body, `"max_tokens":100`, `"max_tokens":100,"stream":true`, 1)
+		raw, _ := io.ReadAll(postJSON(t, app, "/v1/messages", body).Body)
+		starts := eventsOfType(parseSSEEvents(string(raw)), "message_start")
+		if len(starts) != 1 {
+			t.Fatalf("Expected one message_start, got %d", len(starts))
+		}
+		tokens, _ := starts[0].Data["message"].(map[string]interface{})["usage"].(map[string]interface{})["input_tokens"].(float64)
+		return int(tokens)
+	}
+
+	t.Run("messages reuses the count_tokens count", func(t *testing.T) {
+		counted := countTokens(testClaudeRequestBody)
+		if counted == 0 {
+			t.Fatal("Expected a non-zero count")
+		}
+		if got := messageStartTokens(testClaudeRequestBody); got != counted {
+			t.Errorf("message_start input_tokens = %d, want the counted %d", got, counted)
+		}
+	})
+
+	t.Run("count_tokens reuses the provider's count", func(t *testing.T) {
+		usage = `{"prompt_tokens":1234,"completion_tokens":1}`
+		defer func() { usage = "" }()
+		body := strings.Replace(testClaudeRequestBody, "claude-sonnet-4", "claude-haiku-4", 1)
+		if got := messageStartTokens(body); got != 0 {
+			t.Errorf("message_start input_tokens = %d for an uncounted prompt, want 0", got)
+		}
+		if got := countTokens(body); got != 1234 {
+			t.Errorf("count_tokens = %d, want the provider's 1234", got)
+		}
+	})
+}
+
+// TestTokenCountCacheBounds tests expiry and the size limit
+func TestTokenCountCacheBounds(t *testing.T) {
+	cache := newTokenCountCache(time.Minute, 2)
+	keys := make([]string, 3)
+	for i := range keys {
+		keys[i] = cache.key(models.ClaudeRequest{Model: fmt.Sprintf("model-%d", i)})
+		cache.set(keys[i], converter.TokenEstimate{InputTokens: i + 1})
+	}
+	if _, ok := cache.get(keys[0]); ok {
+		t.Error("Expected the oldest entry to be evicted")
+	}
+	if estimate, ok := cache.get(keys[2]); !ok || estimate.InputTokens != 3 {
+		t.Errorf("get() = %+v, %v; want the newest entry", estimate, ok)
+	}
+
+	expired := newTokenCountCache(-time.Second, 2)
+	expired.set("key", converter.TokenEstimate{InputTokens: 1})
+	if _, ok := expired.get("key"); ok {
+		t.Error("Expected an expired entry to be ignored")
+	}
+
+	var disabled *tokenCountCache
+	disabled.set(disabled.key(models.ClaudeRequest{}), converter.TokenEstimate{InputTokens: 1})
+	if _, ok := disabled.get(""); ok {
+		t.Error("Expected a nil cache to hold nothing")
+	}
+}
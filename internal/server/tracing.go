@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// traceparentHeader is the W3C Trace Context header, read from clients and sent upstream
+const traceparentHeader = "traceparent"
+
+// spanExportInterval is how often finished spans are exported
+const spanExportInterval = 5 * time.Second
+
+// maxPendingSpans bounds the spans buffered between exports; extra spans are dropped
+const maxPendingSpans = 2048
+
+// tracePropagator reads and writes W3C traceparent/tracestate headers
+var tracePropagator = propagation.TraceContext{}
+
+// requestTracer creates the request spans (ENABLE_TRACING). It is a no-op tracer
+// while tracing is off, so call sites need no checks.
+var requestTracer trace.Tracer = noop.NewTracerProvider().Tracer(otlpServiceName)
+
+// startTracing points requestTracer at an SDK tracer provider that batches spans
+// to <endpoint>/v1/traces. The provider is shut down, flushing queued spans, when
+// ctx is cancelled.
+func startTracing(ctx context.Context, endpoint string) error {
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(otlpSignalURL(endpoint, "/v1/traces")))
+	if err != nil {
+		return err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithResource(otelResource()),
+		sdktrace.WithBatcher(exporter,
+			sdktrace.WithBatchTimeout(spanExportInterval),
+			sdktrace.WithMaxQueueSize(maxPendingSpans)),
+	)
+	requestTracer = provider.Tracer(otlpServiceName, trace.WithInstrumentationVersion(ProxyVersion))
+	shutdownOnDone(ctx, "Span export", provider.Shutdown)
+	return nil
+}
+
+// startRequestSpan starts the root span of an incoming request, continuing the
+// trace from valid trace context headers. header looks up a request header.
+func startRequestSpan(name string, header func(key string) string) trace.Span {
+	carrier := propagation.MapCarrier{}
+	for _, key := range tracePropagator.Fields() {
+		if value := header(key); value != "" {
+			carrier.Set(key, value)
+		}
+	}
+	ctx := tracePropagator.Extract(context.Background(), carrier)
+	_, s := requestTracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer))
+	return s
+}
+
+// startChildSpan starts a span below parent
+func startChildSpan(parent trace.Span, name string, kind trace.SpanKind) trace.Span {
+	_, s := requestTracer.Start(trace.ContextWithSpan(context.Background(), parent), name, trace.WithSpanKind(kind))
+	return s
+}
+
+// endSpan ends a span, marking it as failed when err is set
+func endSpan(s trace.Span, err error) {
+	if err != nil {
+		s.RecordError(err)
+		s.SetStatus(codes.Error, err.Error())
+	}
+	s.End()
+}
+
+// setUsageAttrs records token usage on a span
+func setUsageAttrs(s trace.Span, inputTokens, outputTokens int) {
+	s.SetAttributes(
+		attribute.Int("gen_ai.usage.input_tokens", inputTokens),
+		attribute.Int("gen_ai.usage.output_tokens", outputTokens),
+	)
+}
+
+// finishUpstreamSpan ends the upstream span carried by an upstreamContext
+func finishUpstreamSpan(ctx context.Context, err error) {
+	endSpan(trace.SpanFromContext(ctx), err)
+}
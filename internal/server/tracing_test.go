@@ -0,0 +1,244 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const testTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+// tracedSpans enables tracing with an in-memory exporter, runs fn and returns
+// the spans ended so far by name. fn can wait for a span with ended.
+func tracedSpans(t *testing.T, fn func(ended func(name string) bool)) map[string]tracetest.SpanStub {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	requestTracer = provider.Tracer(otlpServiceName)
+	defer func() { requestTracer = noop.NewTracerProvider().Tracer(otlpServiceName) }()
+
+	byName := func() map[string]tracetest.SpanStub {
+		spans := map[string]tracetest.SpanStub{}
+		for _, s := range exporter.GetSpans() {
+			spans[s.Name] = s
+		}
+		return spans
+	}
+	fn(func(name string) bool {
+		_, ok := byName()[name]
+		return ok
+	})
+	return byName()
+}
+
+// spanAttrs returns a span's attributes by key
+func spanAttrs(s tracetest.SpanStub) map[string]interface{} {
+	attrs := map[string]interface{}{}
+	for _, kv := range s.Attributes {
+		attrs[string(kv.Key)] = kv.Value.AsInterface()
+	}
+	return attrs
+}
+
+// TestTracingSpans tests the request span tree, its attributes and traceparent propagation
+func TestTracingSpans(t *testing.T) {
+	var upstreamTraceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamTraceparent = r.Header.Get(traceparentHeader)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"x","model":"m","choices":[{"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":5}}`)
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{OpenAIBaseURL: upstream.URL, SonnetModel: "provider-model"}
+	app := newTestApp(cfg)
+
+	spans := tracedSpans(t, func(func(string) bool) {
+		req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader(testClaudeRequestBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(traceparentHeader, testTraceparent)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		_, _ = io.ReadAll(resp.Body)
+		if resp.StatusCode != 200 {
+			t.Fatalf("status = %d, want 200", resp.StatusCode)
+		}
+	})
+
+	root, ok := spans["POST /v1/messages"]
+	if !ok {
+		t.Fatalf("no request span, got %v", spans)
+	}
+	if got := root.SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace id = %s, want the incoming one", got)
+	}
+	if got := root.Parent.SpanID().String(); got != "00f067aa0ba902b7" || !root.Parent.IsRemote() {
+		t.Errorf("parent span id = %s, want the incoming one", got)
+	}
+	if root.SpanKind != trace.SpanKindServer {
+		t.Errorf("request span kind = %v, want server", root.SpanKind)
+	}
+
+	wantAttrs := map[string]interface{}{
+		"gen_ai.system":              "ollama", // localhost upstream
+		"gen_ai.request.model":       "claude-sonnet-4",
+		"gen_ai.provider.model":      "provider-model",
+		"gen_ai.usage.input_tokens":  int64(10),
+		"gen_ai.usage.output_tokens": int64(5),
+		"proxy.stream":               false,
+		"http.status_code":           int64(200),
+	}
+	attrs := spanAttrs(root)
+	for key, want := range wantAttrs {
+		if got := attrs[key]; got != want {
+			t.Errorf("attribute %s = %v, want %v", key, got, want)
+		}
+	}
+
+	for _, name := range []string{"convert_request", "upstream"} {
+		child, ok := spans[name]
+		if !ok {
+			t.Errorf("no %s span", name)
+			continue
+		}
+		if child.Parent.TraceID() != root.SpanContext.TraceID() || child.Parent.SpanID() != root.SpanContext.SpanID() {
+			t.Errorf("%s span is not a child of the request span", name)
+		}
+	}
+
+	if upstreamSpan, ok := spans["upstream"]; ok {
+		want := "00-" + upstreamSpan.SpanContext.TraceID().String() + "-" + upstreamSpan.SpanContext.SpanID().String() + "-01"
+		if upstreamTraceparent != want {
+			t.Errorf("upstream traceparent = %q, want %q", upstreamTraceparent, want)
+		}
+		if upstreamSpan.SpanKind != trace.SpanKindClient {
+			t.Errorf("upstream span kind = %v, want client", upstreamSpan.SpanKind)
+		}
+	}
+}
+
+// TestTracingStreamSpan tests that a stream finishes the request span with its token usage
+func TestTracingStreamSpan(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, upstreamStream(
+			`{"choices":[{"index":0,"delta":{"content":"hi"},"finish_reason":"stop"}]}`,
+			`{"choices":[],"usage":{"prompt_tokens":7,"completion_tokens":3}}`,
+		))
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{OpenAIBaseURL: upstream.URL, SonnetModel: "provider-model"}
+	app := newTestApp(cfg)
+
+	spans := tracedSpans(t, func(ended func(string) bool) {
+		postJSON(t, app, "/v1/messages", strings.Replace(testClaudeRequestBody, `"max_tokens":100`, `"max_tokens":100,"stream":true`, 1))
+		// The body writer ends the request span after the last event is written
+		deadline := time.Now().Add(time.Second)
+		for !ended("POST /v1/messages") && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+	})
+
+	root, rootOK := spans["POST /v1/messages"]
+	stream, streamOK := spans["stream"]
+	if !rootOK || !streamOK {
+		t.Fatalf("spans = %v, want request and stream spans", spans)
+	}
+	if stream.Parent.SpanID() != root.SpanContext.SpanID() {
+		t.Error("stream span is not a child of the request span")
+	}
+	for _, s := range []tracetest.SpanStub{root, stream} {
+		if attrs := spanAttrs(s); attrs["gen_ai.usage.input_tokens"] != int64(7) || attrs["gen_ai.usage.output_tokens"] != int64(3) {
+			t.Errorf("%s usage attributes = %v, want 7 in and 3 out", s.Name, attrs)
+		}
+	}
+	if spanAttrs(root)["proxy.stream"] != true {
+		t.Error("request span not marked as streaming")
+	}
+}
+
+// TestTracingDisabled tests that spans are no-ops when tracing is off
+func TestTracingDisabled(t *testing.T) {
+	s := startRequestSpan("test", func(string) string { return testTraceparent })
+	if s.IsRecording() {
+		t.Fatal("startRequestSpan() returned a recording span with tracing off")
+	}
+	endSpan(startChildSpan(s, "child", trace.SpanKindInternal), nil)
+	setUsageAttrs(s, 1, 2)
+	s.End()
+	ctx, cancel := upstreamContext(upstreamOptions{Span: s})
+	defer cancel()
+	finishUpstreamSpan(ctx, nil)
+}
+
+// TestIncomingTraceparent tests that only a valid W3C traceparent is continued
+func TestIncomingTraceparent(t *testing.T) {
+	tests := []struct {
+		value string
+		ok    bool
+	}{
+		{testTraceparent, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		spans := tracedSpans(t, func(func(string) bool) {
+			startRequestSpan("test", func(key string) string {
+				if key == traceparentHeader {
+					return tt.value
+				}
+				return ""
+			}).End()
+		})
+		if continued := spans["test"].Parent.IsValid(); continued != tt.ok {
+			t.Errorf("traceparent %q continued = %v, want %v", tt.value, continued, tt.ok)
+		}
+	}
+}
+
+// TestTracingExport tests that startTracing sends ended spans to the collector's
+// /v1/traces when it is shut down
+func TestTracingExport(t *testing.T) {
+	paths := make(chan string, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case paths <- r.URL.Path:
+		default:
+		}
+	}))
+	defer collector.Close()
+	defer func() { requestTracer = noop.NewTracerProvider().Tracer(otlpServiceName) }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := startTracing(ctx, collector.URL); err != nil {
+		t.Fatalf("startTracing() error = %v", err)
+	}
+	startRequestSpan("test", func(string) string { return "" }).End()
+	cancel() // the final export on shutdown
+
+	select {
+	case path := <-paths:
+		if path != "/v1/traces" {
+			t.Errorf("export to %s, want /v1/traces", path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No spans reached the collector on shutdown")
+	}
+}
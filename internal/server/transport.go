@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+)
+
+// dnsCacheTTL is how long resolved upstream addresses are reused
+const dnsCacheTTL = 5 * time.Minute
+
+// upstreamMaxIdleConnsPerHost keeps enough idle connections for bursts of
+// concurrent subagent requests (net/http's default is 2)
+const upstreamMaxIdleConnsPerHost = 16
+
+// upstreamTransport is shared by all upstream requests so connections are pooled.
+// Start replaces it with one configured from the loaded config.
+var upstreamTransport = newUpstreamTransport(&config.Config{})
+
+// newUpstreamClient returns a client on the shared upstream transport
+func newUpstreamClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: upstreamTransport}
+}
+
+// newUpstreamTransport builds the upstream transport. With PREWARM_CONNECTIONS,
+// host names are resolved through a DNS cache so bursts skip repeated lookups.
+func newUpstreamTransport(cfg *config.Config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = upstreamMaxIdleConnsPerHost
+	if cfg.PrewarmConnections > 0 {
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+		cache := &dnsCache{ttl: dnsCacheTTL, lookup: net.DefaultResolver.LookupHost}
+		transport.DialContext = cache.dialContext(dialer)
+	}
+	return transport
+}
+
+// dnsCache caches host lookups for dnsCache.ttl
+type dnsCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	lookup  func(ctx context.Context, host string) ([]string, error)
+	entries map[string]dnsCacheEntry
+}
+
+type dnsCacheEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+// resolve returns the cached addresses for host, looking them up when missing or expired
+func (d *dnsCache) resolve(ctx context.Context, host string) ([]string, error) {
+	d.mu.Lock()
+	entry, ok := d.entries[host]
+	d.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.addrs, nil
+	}
+
+	addrs, err := d.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	if d.entries == nil {
+		d.entries = make(map[string]dnsCacheEntry)
+	}
+	d.entries[host] = dnsCacheEntry{addrs: addrs, expires: time.Now().Add(d.ttl)}
+	d.mu.Unlock()
+	return addrs, nil
+}
+
+// dialContext returns a DialContext that resolves host names through the cache
+// and tries each address in turn
+func (d *dnsCache) dialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+
+		addrs, err := d.resolve(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		var lastErr error
+		for _, ip := range addrs {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+		if lastErr == nil {
+			lastErr = fmt.Errorf("no addresses for %s", host)
+		}
+		return nil, lastErr
+	}
+}
+
+// prewarmConnections opens n idle connections to each upstream endpoint by sending
+// n concurrent GET {url}/models requests and draining the responses, so the first
+// burst of real requests skips DNS, TCP and TLS setup. Returns the number of
+// requests that completed.
+func prewarmConnections(ctx context.Context, cfg *config.Config, client *http.Client, n int) int {
+	urls := cfg.OpenAIBaseURLs
+	if len(urls) == 0 {
+		urls = []string{cfg.OpenAIBaseURL}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	warmed := 0
+	for _, url := range urls {
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(url string) {
+				defer wg.Done()
+				req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(url, "/")+"/models", nil)
+				if err != nil {
+					return
+				}
+				if !cfg.IsLocalhost() {
+					req.Header.Set("Authorization", "Bearer "+cfg.OpenAIAPIKey)
+				}
+				resp, err := client.Do(req)
+				if err != nil {
+					return
+				}
+				// Drain so the connection goes back to the idle pool
+				_, _ = io.Copy(io.Discard, resp.Body)
+				_ = resp.Body.Close()
+
+				mu.Lock()
+				warmed++
+				mu.Unlock()
+			}(url)
+		}
+	}
+	wg.Wait()
+	return warmed
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+)
+
+// newConnCountingServer returns a TLS test server that counts new connections
+func newConnCountingServer(newConns *atomic.Int32) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data":[]}`))
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			newConns.Add(1)
+		}
+	}
+	server.StartTLS()
+	return server
+}
+
+// testUpstreamClient returns a client on a fresh upstream transport that trusts server
+func testUpstreamClient(server *httptest.Server, cfg *config.Config) *http.Client {
+	transport := newUpstreamTransport(cfg)
+	transport.TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig
+	return &http.Client{Timeout: 5 * time.Second, Transport: transport}
+}
+
+// TestPrewarmConnections tests that pre-warming establishes idle connections that later requests reuse
+func TestPrewarmConnections(t *testing.T) {
+	var newConns atomic.Int32
+	server := newConnCountingServer(&newConns)
+	defer server.Close()
+
+	cfg := &config.Config{OpenAIBaseURL: server.URL, OpenAIAPIKey: "test-key", PrewarmConnections: 3}
+	client := testUpstreamClient(server, cfg)
+
+	if warmed := prewarmConnections(context.Background(), cfg, client, 3); warmed != 3 {
+		t.Fatalf("prewarmConnections() = %d, want 3", warmed)
+	}
+	if got := newConns.Load(); got != 3 {
+		t.Fatalf("Expected 3 connections after pre-warming, got %d", got)
+	}
+
+	// A burst of 3 concurrent requests reuses the idle connections
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(server.URL + "/models")
+			if err != nil {
+				t.Errorf("Get() error = %v", err)
+				return
+			}
+			_ = resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+
+	if got := newConns.Load(); got != 3 {
+		t.Errorf("Burst opened new connections: %d total, want 3", got)
+	}
+}
+
+// TestDNSCache tests that lookups are cached until the TTL expires
+func TestDNSCache(t *testing.T) {
+	var lookups atomic.Int32
+	cache := &dnsCache{
+		ttl: time.Hour,
+		lookup: func(ctx context.Context, host string) ([]string, error) {
+			lookups.Add(1)
+			return []string{"127.0.0.1"}, nil
+		},
+	}
+
+	for i := 0; i < 5; i++ {
+		addrs, err := cache.resolve(context.Background(), "api.example.com")
+		if err != nil || len(addrs) != 1 || addrs[0] != "127.0.0.1" {
+			t.Fatalf("resolve() = %v, %v", addrs, err)
+		}
+	}
+	if got := lookups.Load(); got != 1 {
+		t.Errorf("Expected 1 lookup for repeated resolves, got %d", got)
+	}
+
+	cache.ttl = 0
+	cache.entries = nil
+	_, _ = cache.resolve(context.Background(), "api.example.com")
+	_, _ = cache.resolve(context.Background(), "api.example.com")
+	if got := lookups.Load(); got != 3 {
+		t.Errorf("Expired entries should be looked up again: %d lookups, want 3", got)
+	}
+
+	// The cached dialer connects through the cached address
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	cache.ttl = time.Hour
+	conn, err := cache.dialContext(&net.Dialer{Timeout: time.Second})(context.Background(), "tcp", net.JoinHostPort("api.example.com", port))
+	if err != nil {
+		t.Fatalf("dialContext() error = %v", err)
+	}
+	_ = conn.Close()
+}
+
+// BenchmarkBurstLatency measures a burst of 8 concurrent requests on a cold
+// transport versus one pre-warmed with PREWARM_CONNECTIONS=8 (TLS upstream)
+func BenchmarkBurstLatency(b *testing.B) {
+	var newConns atomic.Int32
+	server := newConnCountingServer(&newConns)
+	defer server.Close()
+
+	const burst = 8
+	cfg := &config.Config{OpenAIBaseURL: server.URL, OpenAIAPIKey: "test-key", PrewarmConnections: burst}
+
+	run := func(b *testing.B, prewarm bool) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			client := testUpstreamClient(server, cfg)
+			if prewarm {
+				prewarmConnections(context.Background(), cfg, client, burst)
+			}
+			b.StartTimer()
+
+			var wg sync.WaitGroup
+			for j := 0; j < burst; j++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if resp, err := client.Get(server.URL + "/models"); err == nil {
+						_ = resp.Body.Close()
+					}
+				}()
+			}
+			wg.Wait()
+
+			b.StopTimer()
+			client.CloseIdleConnections()
+			b.StartTimer()
+		}
+	}
+
+	b.Run("cold", func(b *testing.B) { run(b, false) })
+	b.Run("prewarmed", func(b *testing.B) { run(b, true) })
+}
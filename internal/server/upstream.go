@@ -0,0 +1,426 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+	"github.com/claude-code-proxy/proxy/internal/converter"
+	"github.com/claude-code-proxy/proxy/internal/logging"
+	"github.com/claude-code-proxy/proxy/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// upstreamError is returned when the provider responds with a non-200 status.
+// The body and headers are kept so callers can classify the failure.
+type upstreamError struct {
+	StatusCode int
+	Body       string
+	Header     http.Header
+}
+
+func (e *upstreamError) Error() string {
+	return fmt.Sprintf("OpenAI API returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// isModelNotFound reports whether the provider rejected the request because the
+// model doesn't exist. Providers disagree on the status (OpenAI and Ollama use 404,
+// OpenRouter uses 400), so the error body is inspected as well.
+func (e *upstreamError) isModelNotFound() bool {
+	if e.StatusCode != http.StatusNotFound && e.StatusCode != http.StatusBadRequest {
+		return false
+	}
+
+	body := strings.ToLower(e.Body)
+	return strings.Contains(body, "model_not_found") ||
+		strings.Contains(body, "not a valid model") ||
+		(strings.Contains(body, "model") &&
+			(strings.Contains(body, "does not exist") || strings.Contains(body, "not found")))
+}
+
+// defaultOverloadRetryAfter is the backoff sent with an overloaded error when
+// the provider doesn't say how long to wait
+const defaultOverloadRetryAfter = 5 * time.Second
+
+// isOverloaded reports whether the provider is temporarily out of capacity, as
+// opposed to failing: a 503 or 529, or another 5xx whose body says it is
+// overloaded (OpenAI sends "The engine is currently overloaded"). 429 is a rate
+// limit, not overload, and is left alone.
+func (e *upstreamError) isOverloaded() bool {
+	if e.StatusCode == http.StatusServiceUnavailable || e.StatusCode == 529 {
+		return true
+	}
+	if e.StatusCode < 500 {
+		return false
+	}
+
+	body := strings.ToLower(e.Body)
+	return strings.Contains(body, "overloaded") || strings.Contains(body, "over capacity")
+}
+
+// retryAfter returns how long the provider asked clients to wait, from
+// retry-after-ms or Retry-After (seconds or an HTTP date), or
+// defaultOverloadRetryAfter when it didn't say
+func (e *upstreamError) retryAfter(now time.Time) time.Duration {
+	if ms, err := strconv.ParseFloat(e.Header.Get("retry-after-ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	value := e.Header.Get("Retry-After")
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return defaultOverloadRetryAfter
+}
+
+// overloadMessage describes an overloaded provider, with its own message when
+// the body has one
+func (e *upstreamError) overloadMessage() string {
+	var body map[string]interface{}
+	if json.Unmarshal([]byte(e.Body), &body) == nil {
+		if msg := providerErrorMessage(body); msg != "" {
+			return fmt.Sprintf("upstream provider is overloaded (status %d): %s", e.StatusCode, msg)
+		}
+	}
+	return fmt.Sprintf("upstream provider is overloaded (status %d)", e.StatusCode)
+}
+
+// noChoicesError is returned when the provider answers 200 but the body has no
+// choices - usually an error reported as success. Detail holds any error message
+// found in the body. This is distinct from a genuine empty completion, which
+// still has a choice (with empty content).
+type noChoicesError struct {
+	Detail string
+}
+
+func (e *noChoicesError) Error() string {
+	if e.Detail != "" {
+		return fmt.Sprintf("provider returned a response without choices: %s", e.Detail)
+	}
+	return "provider returned a response without choices"
+}
+
+// providerErrorMessage extracts an error message from a provider response body,
+// checking {"error": {"message": ...}}, {"error": "..."} and {"message": ...}
+func providerErrorMessage(body map[string]interface{}) string {
+	switch errField := body["error"].(type) {
+	case map[string]interface{}:
+		if msg, ok := errField["message"].(string); ok && msg != "" {
+			return msg
+		}
+	case string:
+		if errField != "" {
+			return errField
+		}
+	}
+	if msg, ok := body["message"].(string); ok {
+		return msg
+	}
+	return ""
+}
+
+// transportError is returned when no response was received from the provider at all.
+// Timeouts and connection failures need different fixes, so the message says which it was.
+type transportError struct {
+	Timeout bool
+	URL     string
+	Err     error
+}
+
+func (e *transportError) Error() string {
+	if e.Timeout {
+		return fmt.Sprintf("request to %s timed out - the provider is responding too slowly; retry, use a faster model, or reduce max_tokens (%v)", e.URL, e.Err)
+	}
+	return fmt.Sprintf("could not connect to %s - check OPENAI_BASE_URL and that the provider is reachable (%v)", e.URL, e.Err)
+}
+
+func (e *transportError) Unwrap() error {
+	return e.Err
+}
+
+// newTransportError classifies a client.Do error as a timeout or a connection failure
+func newTransportError(url string, err error) *transportError {
+	timeout := errors.Is(err, context.DeadlineExceeded)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		timeout = true
+	}
+	return &transportError{Timeout: timeout, URL: url, Err: err}
+}
+
+// addOpenRouterHeaders adds OpenRouter-specific HTTP headers for better rate limits.
+// Sets HTTP-Referer and X-Title headers when configured, which helps with OpenRouter's
+// rate limiting and usage tracking.
+func addOpenRouterHeaders(req *http.Request, cfg *config.Config) {
+	if cfg.OpenRouterAppURL != "" {
+		req.Header.Set("HTTP-Referer", cfg.OpenRouterAppURL)
+	}
+	if cfg.OpenRouterAppName != "" {
+		req.Header.Set("X-Title", cfg.OpenRouterAppName)
+	}
+}
+
+// upstreamOptions are request-scoped settings for the upstream call, taken from
+// client headers
+type upstreamOptions struct {
+	Deadline time.Time      // x-proxy-deadline (zero = none)
+	EmitCurl bool           // x-proxy-emit-curl
+	Span     trace.Span     // request span to trace the upstream call under
+	Timing   *requestTiming // debug-mode stage timing (nil = off)
+}
+
+// emitCurlKey marks a context whose upstream request is logged as a curl command
+type emitCurlKey struct{}
+
+// upstreamContext returns the context for the upstream call, bounded by
+// opts.Deadline when one was given. With tracing on it carries the upstream
+// span, which finishUpstreamSpan ends once the provider has answered.
+func upstreamContext(opts upstreamOptions) (context.Context, context.CancelFunc) {
+	ctx := context.Background()
+	if opts.EmitCurl {
+		ctx = context.WithValue(ctx, emitCurlKey{}, true)
+	}
+	ctx, _ = requestTracer.Start(trace.ContextWithSpan(ctx, opts.Span), "upstream", trace.WithSpanKind(trace.SpanKindClient))
+	ctx = opts.Timing.trace(ctx)
+	if opts.Deadline.IsZero() {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, opts.Deadline)
+}
+
+// chatPath returns the chat completions path appended to the base URL
+// (OPENAI_CHAT_PATH), with a leading slash
+func chatPath(cfg *config.Config) string {
+	path := strings.TrimSpace(cfg.OpenAIChatPath)
+	if path == "" {
+		return "/chat/completions"
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+// newUpstreamRequest builds the HTTP request for the provider's chat completions endpoint.
+// Shared by the streaming and non-streaming paths so both send identical headers.
+func newUpstreamRequest(ctx context.Context, req *models.OpenAIRequest, cfg *config.Config) (*http.Request, error) {
+	// Marshal request to JSON
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// Build API URL (lowest-latency endpoint when OPENAI_BASE_URLS is set)
+	apiURL := upstreamBaseURL(cfg) + chatPath(cfg)
+
+	// Create HTTP request
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Set headers
+	httpReq.Header.Set("Content-Type", "application/json")
+	tracePropagator.Inject(ctx, propagation.HeaderCarrier(httpReq.Header))
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("http.url", apiURL),
+		attribute.String("gen_ai.request.model", req.Model),
+	)
+
+	// Skip auth for Ollama (localhost) - Ollama doesn't require authentication
+	if !cfg.IsLocalhost() {
+		httpReq.Header.Set("Authorization", "Bearer "+cfg.OpenAIAPIKey)
+	}
+
+	// OpenRouter-specific headers for better rate limits
+	if cfg.DetectProvider() == config.ProviderOpenRouter {
+		addOpenRouterHeaders(httpReq, cfg)
+	}
+
+	return httpReq, nil
+}
+
+// doUpstreamRequest sends the request to the provider and returns the successful response.
+// Non-200 responses are consumed and returned as *upstreamError. When the provider reports
+// that the mapped model doesn't exist and FALLBACK_MODEL is configured, the request is
+// retried once with the fallback model (req.Model is updated to reflect the substitution).
+//
+// When the circuit breaker is enabled and open, the request is rejected with a
+// *circuitOpenError without contacting the provider. The request is cancelled when
+// ctx expires (x-proxy-deadline).
+func doUpstreamRequest(ctx context.Context, client *http.Client, req *models.OpenAIRequest, cfg *config.Config) (*http.Response, error) {
+	probe := false
+	if cfg.CircuitBreakerThreshold > 0 {
+		ok, isProbe, retryAfter := upstreamBreaker.allow(time.Now())
+		if !ok {
+			return nil, &circuitOpenError{RetryAfter: retryAfter}
+		}
+		probe = isProbe
+	}
+
+	resp, err := sendUpstreamRequest(ctx, client, req, cfg)
+
+	var upErr *upstreamError
+	if err != nil && errors.As(err, &upErr) && upErr.isModelNotFound() &&
+		cfg.FallbackModel != "" && cfg.FallbackModel != req.Model {
+		logging.Printf("[%s] [WARN] Model %q not found upstream, retrying with fallback model %q\n",
+			time.Now().Format("15:04:05"), req.Model, cfg.FallbackModel)
+		applyFallbackModel(req, cfg)
+		resp, err = sendUpstreamRequest(ctx, client, req, cfg)
+	}
+
+	if cfg.CircuitBreakerThreshold > 0 {
+		failed := err != nil && (!errors.As(err, &upErr) || upErr.StatusCode >= 500)
+		upstreamBreaker.record(probe, failed, cfg.CircuitBreakerThreshold,
+			time.Duration(cfg.CircuitBreakerCooldownSec)*time.Second, time.Now())
+	}
+
+	return resp, err
+}
+
+// sendUpstreamRequest performs a single request against the provider
+func sendUpstreamRequest(ctx context.Context, client *http.Client, req *models.OpenAIRequest, cfg *config.Config) (*http.Response, error) {
+	httpReq, err := newUpstreamRequest(ctx, req, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reproducible upstream call for debugging provider-side issues
+	if cfg.Debug || ctx.Value(emitCurlKey{}) != nil {
+		logging.Printf("[%s] [CURL] %s\n", time.Now().Format("15:04:05"), curlCommand(httpReq))
+	}
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, newTransportError(httpReq.URL.String(), err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		return nil, &upstreamError{
+			StatusCode: resp.StatusCode,
+			Body:       string(body),
+			Header:     resp.Header,
+		}
+	}
+
+	return resp, nil
+}
+
+// applyFallbackModel switches the request to the fallback model, moving the token
+// limit to the parameter the fallback model expects (max_tokens vs max_completion_tokens)
+// and, for a reasoning fallback, removing the parameters reasoning models reject.
+// A non-reasoning fallback drops reasoning_effort, which those models reject.
+func applyFallbackModel(req *models.OpenAIRequest, cfg *config.Config) {
+	req.Model = cfg.FallbackModel
+	reasoning := cfg.IsReasoningModel(req.Model)
+	if !reasoning {
+		req.ReasoningEffort = ""
+	}
+
+	maxTokens := req.MaxTokens
+	if req.MaxCompletionTokens > 0 {
+		maxTokens = req.MaxCompletionTokens
+	}
+	if maxTokens == 0 {
+		return
+	}
+
+	req.MaxTokens = maxTokens
+	if reasoning {
+		converter.CleanReasoningRequest(req)
+	} else {
+		req.MaxCompletionTokens = 0
+	}
+}
+
+// sendUpstreamError writes the Claude error response for a failed upstream call.
+// An open circuit breaker maps to Anthropic's overloaded_error (HTTP 529) with a
+// Retry-After header so Claude Code backs off instead of retrying immediately, as
+// does an overloaded provider (503, or a 5xx saying so), with the provider's own
+// Retry-After when it sent one. Timeouts return 504 and connection failures 502 so they can be told apart.
+func sendUpstreamError(c *fiber.Ctx, err error) error {
+	logging.Printf("[%s] [ERROR] %v\n", time.Now().Format("15:04:05"), err)
+
+	var openErr *circuitOpenError
+	if errors.As(err, &openErr) {
+		c.Set("Retry-After", strconv.Itoa(retryAfterSeconds(openErr.RetryAfter)))
+		return claudeError(c, 529, errOverloaded, err.Error())
+	}
+
+	var upErr *upstreamError
+	if errors.As(err, &upErr) && upErr.isOverloaded() {
+		c.Set("Retry-After", strconv.Itoa(retryAfterSeconds(upErr.retryAfter(time.Now()))))
+		return claudeError(c, 529, errOverloaded, upErr.overloadMessage())
+	}
+
+	status := 500
+	var transErr *transportError
+	var noChoicesErr *noChoicesError
+	if errors.As(err, &transErr) {
+		status = 502
+		if transErr.Timeout {
+			status = 504
+		}
+	} else if errors.As(err, &noChoicesErr) {
+		status = 502
+	}
+
+	return claudeError(c, status, errAPI, fmt.Sprintf("OpenAI API error: %v", err))
+}
+
+// callOpenAI makes an HTTP request to the OpenAI API.
+// Returns the parsed response along with the upstream response headers and the
+// base URL of the endpoint that answered (see responseEndpoint).
+func callOpenAI(ctx context.Context, req *models.OpenAIRequest, cfg *config.Config) (*models.OpenAIResponse, http.Header, string, error) {
+	// Create HTTP client with timeout
+	client := newUpstreamClient(90 * time.Second)
+
+	// Make request
+	resp, err := doUpstreamRequest(ctx, client, req, cfg)
+	if err != nil {
+		var upErr *upstreamError
+		if errors.As(err, &upErr) {
+			return nil, upErr.Header, "", err
+		}
+		return nil, nil, "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	endpoint := responseEndpoint(resp, cfg)
+
+	// Read response body
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.Header, endpoint, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	// Parse response
+	var openaiResp models.OpenAIResponse
+	if err := json.Unmarshal(respBody, &openaiResp); err != nil {
+		return nil, resp.Header, endpoint, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	// A 200 without choices is an error in disguise, not an empty completion
+	if len(openaiResp.Choices) == 0 {
+		var body map[string]interface{}
+		_ = json.Unmarshal(respBody, &body)
+		return nil, resp.Header, endpoint, &noChoicesError{Detail: providerErrorMessage(body)}
+	}
+
+	return &openaiResp, resp.Header, endpoint, nil
+}
@@ -0,0 +1,462 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/claude-code-proxy/proxy/internal/config"
+	"github.com/claude-code-proxy/proxy/internal/logging"
+	"github.com/claude-code-proxy/proxy/pkg/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestIsModelNotFound tests classification of provider model-not-found errors
+func TestIsModelNotFound(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		expected bool
+	}{
+		{
+			name:     "OpenAI model_not_found",
+			status:   404,
+			body:     `{"error":{"message":"The model 'gpt-9' does not exist","type":"invalid_request_error","code":"model_not_found"}}`,
+			expected: true,
+		},
+		{
+			name:     "OpenRouter invalid model ID",
+			status:   400,
+			body:     `{"error":{"message":"x-ai/grok-typo is not a valid model ID","code":400}}`,
+			expected: true,
+		},
+		{
+			name:     "Ollama model not found",
+			status:   404,
+			body:     `{"error":"model \"qwen9\" not found, try pulling it first"}`,
+			expected: true,
+		},
+		{
+			name:     "other 400 error",
+			status:   400,
+			body:     `{"error":{"message":"max_tokens is too large"}}`,
+			expected: false,
+		},
+		{
+			name:     "server error mentioning model",
+			status:   500,
+			body:     `{"error":{"message":"model not found"}}`,
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := &upstreamError{StatusCode: tt.status, Body: tt.body}
+			if got := err.isModelNotFound(); got != tt.expected {
+				t.Errorf("isModelNotFound() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestFallbackModelOnModelNotFound tests that a model-not-found error is retried with FALLBACK_MODEL
+func TestFallbackModelOnModelNotFound(t *testing.T) {
+	var mu sync.Mutex
+	var requestedModels []string
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		model, _ := req["model"].(string)
+
+		mu.Lock()
+		requestedModels = append(requestedModels, model)
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if model == "bogus-model" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":{"message":"The model 'bogus-model' does not exist","code":"model_not_found"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","choices":[{"index":0,"message":{"role":"assistant","content":"from fallback"},"finish_reason":"stop"}]}`))
+	}))
+	defer upstream.Close()
+
+	t.Run("fallback succeeds", func(t *testing.T) {
+		requestedModels = nil
+		var logs bytes.Buffer
+		defer logging.SetOutput(&logs)()
+		cfg := &config.Config{
+			OpenAIBaseURL: upstream.URL,
+			OpenAIAPIKey:  "test-key",
+			SonnetModel:   "bogus-model",
+			FallbackModel: "good-model",
+		}
+
+		resp := postJSON(t, newTestApp(cfg), "/v1/messages", testClaudeRequestBody)
+		if resp.StatusCode != 200 {
+			t.Fatalf("Status = %d, want 200", resp.StatusCode)
+		}
+
+		var claudeResp map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&claudeResp)
+		content, _ := claudeResp["content"].([]interface{})
+		if len(content) != 1 || content[0].(map[string]interface{})["text"] != "from fallback" {
+			t.Errorf("Unexpected content: %v", claudeResp["content"])
+		}
+
+		if len(requestedModels) != 2 || requestedModels[0] != "bogus-model" || requestedModels[1] != "good-model" {
+			t.Errorf("Requested models = %v, want [bogus-model good-model]", requestedModels)
+		}
+		if !strings.Contains(logs.String(), `] [WARN] Model "bogus-model" not found upstream, retrying with fallback model "good-model"`) {
+			t.Errorf("Expected a timestamped fallback warning in the log, got %q", logs.String())
+		}
+	})
+
+	t.Run("no fallback configured", func(t *testing.T) {
+		requestedModels = nil
+		cfg := &config.Config{
+			OpenAIBaseURL: upstream.URL,
+			OpenAIAPIKey:  "test-key",
+			SonnetModel:   "bogus-model",
+		}
+
+		resp := postJSON(t, newTestApp(cfg), "/v1/messages", testClaudeRequestBody)
+		if resp.StatusCode == 200 {
+			t.Fatalf("Expected error status without fallback model")
+		}
+		if len(requestedModels) != 1 {
+			t.Errorf("Expected a single upstream attempt, got %v", requestedModels)
+		}
+	})
+
+	t.Run("streaming fallback succeeds", func(t *testing.T) {
+		requestedModels = nil
+		cfg := &config.Config{
+			OpenAIBaseURL: upstream.URL,
+			OpenAIAPIKey:  "test-key",
+			SonnetModel:   "bogus-model",
+			FallbackModel: "good-model",
+		}
+
+		body := `{"model":"claude-sonnet-4","max_tokens":100,"stream":true,"messages":[{"role":"user","content":"hello"}]}`
+		resp := postJSON(t, newTestApp(cfg), "/v1/messages", body)
+		if resp.StatusCode != 200 {
+			t.Fatalf("Status = %d, want 200", resp.StatusCode)
+		}
+		if len(requestedModels) != 2 || requestedModels[1] != "good-model" {
+			t.Errorf("Requested models = %v, want fallback on second attempt", requestedModels)
+		}
+	})
+}
+
+// TestApplyFallbackModelTokenParameter tests that the token limit follows the fallback model's
+// parameter and that a non-reasoning fallback drops reasoning_effort
+func TestApplyFallbackModelTokenParameter(t *testing.T) {
+	cfg := &config.Config{FallbackModel: "gpt-4o"}
+	req := newTestOpenAIRequest("gpt-5")
+	req.MaxCompletionTokens = 500
+	req.ReasoningEffort = "medium"
+
+	applyFallbackModel(req, cfg)
+
+	if req.Model != "gpt-4o" {
+		t.Errorf("Model = %q, want %q", req.Model, "gpt-4o")
+	}
+	if req.MaxTokens != 500 || req.MaxCompletionTokens != 0 {
+		t.Errorf("MaxTokens = %d, MaxCompletionTokens = %d, want 500/0", req.MaxTokens, req.MaxCompletionTokens)
+	}
+	if req.ReasoningEffort != "" {
+		t.Errorf("ReasoningEffort = %q, want it cleared for a non-reasoning fallback", req.ReasoningEffort)
+	}
+
+	// A reasoning fallback keeps the effort and moves the limit to max_completion_tokens
+	cfg.FallbackModel = "o3"
+	req = newTestOpenAIRequest("gpt-5")
+	req.MaxCompletionTokens = 500
+	req.ReasoningEffort = "medium"
+
+	applyFallbackModel(req, cfg)
+
+	if req.ReasoningEffort != "medium" {
+		t.Errorf("ReasoningEffort = %q, want medium kept for a reasoning fallback", req.ReasoningEffort)
+	}
+	if req.MaxTokens != 0 || req.MaxCompletionTokens != 500 {
+		t.Errorf("MaxTokens = %d, MaxCompletionTokens = %d, want 0/500", req.MaxTokens, req.MaxCompletionTokens)
+	}
+}
+
+// TestOpenAIChatPath tests that OPENAI_CHAT_PATH is used in the outbound URL of both call paths
+func TestOpenAIChatPath(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		var req map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req["stream"] == true {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte(upstreamStream(`{"choices":[{"index":0,"delta":{"content":"hi"},"finish_reason":"stop"}]}`)))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}`))
+	}))
+	defer upstream.Close()
+
+	app := newTestApp(&config.Config{OpenAIBaseURL: upstream.URL, OpenAIChatPath: "/v1/chat/completions"})
+	streamBody := strings.Replace(testClaudeRequestBody, `"max_tokens":100`, `"max_tokens":100,"stream":true`, 1)
+	for _, body := range []string{testClaudeRequestBody, streamBody} {
+		resp := postJSON(t, app, "/v1/messages", body)
+		_, _ = io.ReadAll(resp.Body)
+	}
+
+	if len(paths) != 2 || paths[0] != "/v1/chat/completions" || paths[1] != "/v1/chat/completions" {
+		t.Errorf("upstream paths = %v, want /v1/chat/completions for both requests", paths)
+	}
+	if got := chatPath(&config.Config{}); got != "/chat/completions" {
+		t.Errorf("default chatPath() = %q, want /chat/completions", got)
+	}
+}
+
+// TestTransportErrorClassification tests that timeouts and connection failures produce distinct errors
+func TestTransportErrorClassification(t *testing.T) {
+	t.Run("timeout", func(t *testing.T) {
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+		}))
+		defer slow.Close()
+
+		cfg := &config.Config{OpenAIBaseURL: slow.URL}
+		client := &http.Client{Timeout: 20 * time.Millisecond}
+
+		_, err := sendUpstreamRequest(context.Background(), client, newTestOpenAIRequest("gpt-4o"), cfg)
+
+		var transErr *transportError
+		if !errors.As(err, &transErr) {
+			t.Fatalf("Expected *transportError, got %T: %v", err, err)
+		}
+		if !transErr.Timeout {
+			t.Error("Expected timeout classification")
+		}
+		if !strings.Contains(err.Error(), "timed out") {
+			t.Errorf("Timeout message should say it timed out, got %q", err.Error())
+		}
+	})
+
+	t.Run("connection refused", func(t *testing.T) {
+		closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		closedURL := closed.URL
+		closed.Close()
+
+		cfg := &config.Config{OpenAIBaseURL: closedURL}
+		_, err := sendUpstreamRequest(context.Background(), &http.Client{Timeout: time.Second}, newTestOpenAIRequest("gpt-4o"), cfg)
+
+		var transErr *transportError
+		if !errors.As(err, &transErr) {
+			t.Fatalf("Expected *transportError, got %T: %v", err, err)
+		}
+		if transErr.Timeout {
+			t.Error("Connection refusal should not be classified as timeout")
+		}
+		if !strings.Contains(err.Error(), "could not connect") || !strings.Contains(err.Error(), "OPENAI_BASE_URL") {
+			t.Errorf("Connection message should point at the base URL, got %q", err.Error())
+		}
+	})
+}
+
+// TestTransportErrorResponseStatus tests the Claude error status for each transport failure
+func TestTransportErrorResponseStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{"timeout", &transportError{Timeout: true, URL: "http://x", Err: context.DeadlineExceeded}, 504},
+		{"connection failure", &transportError{URL: "http://x", Err: errors.New("connection refused")}, 502},
+		{"other error", errors.New("boom"), 500},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Get("/", func(c *fiber.Ctx) error { return sendUpstreamError(c, tt.err) })
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/", nil), -1)
+			if err != nil {
+				t.Fatalf("app.Test() error = %v", err)
+			}
+			if resp.StatusCode != tt.expected {
+				t.Errorf("Status = %d, want %d", resp.StatusCode, tt.expected)
+			}
+		})
+	}
+}
+
+// TestUpstreamOverloaded tests that an overloaded provider maps to Anthropic's 529
+// overloaded_error with a Retry-After, while other 5xx stay api_error
+func TestUpstreamOverloaded(t *testing.T) {
+	tests := []struct {
+		name           string
+		status         int
+		body           string
+		retryAfter     string
+		wantStatus     int
+		wantType       string
+		wantRetryAfter string
+	}{
+		{"503 overloaded", 503, `{"error":{"message":"The engine is currently overloaded, please try again later","type":"server_error"}}`, "", 529, "overloaded_error", "5"},
+		{"503 with retry-after", 503, `{"error":{"message":"Service unavailable"}}`, "12", 529, "overloaded_error", "12"},
+		{"500 saying overloaded", 500, `{"error":{"message":"Model is overloaded"}}`, "", 529, "overloaded_error", "5"},
+		{"generic 500", 500, `{"error":{"message":"internal error"}}`, "", 500, "api_error", ""},
+		{"502", 502, `bad gateway`, "", 500, "api_error", ""},
+	}
+
+	for _, tt := range tests {
+		for _, stream := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s stream=%v", tt.name, stream), func(t *testing.T) {
+				upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if tt.retryAfter != "" {
+						w.Header().Set("Retry-After", tt.retryAfter)
+					}
+					w.WriteHeader(tt.status)
+					_, _ = w.Write([]byte(tt.body))
+				}))
+				defer upstream.Close()
+
+				body := testClaudeRequestBody
+				if stream {
+					body = strings.Replace(body, `"max_tokens":100`, `"max_tokens":100,"stream":true`, 1)
+				}
+				resp := postJSON(t, newTestApp(&config.Config{OpenAIBaseURL: upstream.URL}), "/v1/messages", body)
+				if got := resp.Header.Get("Retry-After"); got != tt.wantRetryAfter {
+					t.Errorf("Retry-After = %q, want %q", got, tt.wantRetryAfter)
+				}
+				assertClaudeError(t, resp, tt.wantStatus, tt.wantType)
+			})
+		}
+	}
+}
+
+// TestNoChoicesResponse tests that a 200 response without choices becomes a clear error
+func TestNoChoicesResponse(t *testing.T) {
+	t.Run("non-streaming", func(t *testing.T) {
+		tests := []struct {
+			name       string
+			body       string
+			wantStatus int
+			wantDetail string
+		}{
+			{"null choices with error", `{"choices":null,"error":{"message":"Upstream model crashed"}}`, 502, "Upstream model crashed"},
+			{"missing choices with message", `{"message":"quota exhausted"}`, 502, "quota exhausted"},
+			{"empty choices", `{"id":"x","choices":[]}`, 502, "without choices"},
+			{"genuine empty completion", `{"id":"x","choices":[{"index":0,"message":{"role":"assistant","content":""},"finish_reason":"stop"}]}`, 200, ""},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					_, _ = w.Write([]byte(tt.body))
+				}))
+				defer upstream.Close()
+
+				resp := postJSON(t, newTestApp(&config.Config{OpenAIBaseURL: upstream.URL}), "/v1/messages", testClaudeRequestBody)
+				if resp.StatusCode != tt.wantStatus {
+					t.Fatalf("Status = %d, want %d", resp.StatusCode, tt.wantStatus)
+				}
+				if tt.wantDetail == "" {
+					return
+				}
+
+				var body struct {
+					Error struct {
+						Type    string `json:"type"`
+						Message string `json:"message"`
+					} `json:"error"`
+				}
+				if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+					t.Fatalf("Failed to decode error: %v", err)
+				}
+				if body.Error.Type != "api_error" || !strings.Contains(body.Error.Message, tt.wantDetail) {
+					t.Errorf("Error = %+v, want api_error containing %q", body.Error, tt.wantDetail)
+				}
+			})
+		}
+	})
+
+	t.Run("streaming", func(t *testing.T) {
+		events := convertTestStream(&config.Config{}, upstreamStream(
+			`{"error":{"message":"Upstream model crashed"}}`,
+		))
+
+		errorEvents := eventsOfType(events, "error")
+		if len(errorEvents) != 1 {
+			t.Fatalf("Expected 1 error event, got %d", len(errorEvents))
+		}
+		msg := errorEvents[0].Data["error"].(map[string]interface{})["message"].(string)
+		if !strings.Contains(msg, "Upstream model crashed") {
+			t.Errorf("Error message = %q, want the provider detail", msg)
+		}
+		if len(eventsOfType(events, "message_stop")) != 0 {
+			t.Error("A choices-less stream should not end as a normal message")
+		}
+	})
+
+	t.Run("streaming genuine empty completion", func(t *testing.T) {
+		events := convertTestStream(&config.Config{}, upstreamStream(
+			`{"choices":[{"index":0,"delta":{"role":"assistant","content":""},"finish_reason":null}]}`,
+			`{"choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+		))
+		if len(eventsOfType(events, "error")) != 0 || len(eventsOfType(events, "message_stop")) != 1 {
+			t.Error("An empty completion should end normally without an error")
+		}
+	})
+}
+
+// TestCurlCommand tests that the emitted curl reproduces the upstream request with the key redacted
+func TestCurlCommand(t *testing.T) {
+	cfg := &config.Config{OpenAIBaseURL: "https://api.openai.com/v1", OpenAIAPIKey: "sk-proj-secret-key-1234567890"}
+	req := newTestOpenAIRequest("gpt-4o")
+	req.Messages = []models.OpenAIMessage{{Role: "user", Content: "it's a test"}}
+
+	httpReq, err := newUpstreamRequest(context.Background(), req, cfg)
+	if err != nil {
+		t.Fatalf("newUpstreamRequest() error = %v", err)
+	}
+	curl := curlCommand(httpReq)
+
+	for _, want := range []string{
+		"curl -X POST 'https://api.openai.com/v1/chat/completions'",
+		`-H "Authorization: Bearer $OPENAI_API_KEY"`,
+		"-H 'Content-Type: application/json'",
+		`--data-raw '{"model":"gpt-4o"`,
+		`it'\''s a test`, // single quotes are shell-escaped
+	} {
+		if !strings.Contains(curl, want) {
+			t.Errorf("curl command missing %q:\n%s", want, curl)
+		}
+	}
+	if strings.Contains(curl, cfg.OpenAIAPIKey) {
+		t.Errorf("curl command leaks the API key:\n%s", curl)
+	}
+
+	// The request body is still readable for the actual call
+	body, _ := io.ReadAll(httpReq.Body)
+	if !strings.Contains(string(body), `"gpt-4o"`) {
+		t.Errorf("Request body consumed by curlCommand, got %q", body)
+	}
+}
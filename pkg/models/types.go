@@ -4,6 +4,9 @@ package models
 type ClaudeMessage struct {
 	Role    string      `json:"role"`
 	Content interface{} `json:"content"` // Can be string or []ContentBlock
+	// ToolCalls is an OpenAI-shaped assistant turn's tool calls, for clients that
+	// replay OpenAI history ({"content":null,"tool_calls":[...]}) as-is
+	ToolCalls []OpenAIToolCall `json:"tool_calls,omitempty"`
 }
 
 // ContentBlock represents a content block in Claude format
@@ -28,13 +31,27 @@ type ClaudeRequest struct {
 	Stream        *bool           `json:"stream,omitempty"`
 	System        interface{}     `json:"system,omitempty"` // Can be string OR array of content blocks
 	Tools         []Tool          `json:"tools,omitempty"`
+	Thinking      *Thinking       `json:"thinking,omitempty"` // Extended thinking; its budget counts toward max_tokens
+
+	// Betas holds the anthropic-beta header flags (set by the handler, not part of the body)
+	Betas []string `json:"-"`
+	// ConversationID is the x-proxy-conversation-id header (set by the handler), a
+	// client-chosen session identifier forwarded as the provider's cache key
+	ConversationID string `json:"-"`
+}
+
+// Thinking is the extended thinking configuration of a Claude request
+type Thinking struct {
+	Type         string `json:"type"` // "enabled" or "disabled"
+	BudgetTokens int    `json:"budget_tokens,omitempty"`
 }
 
 // Tool represents a function/tool definition
 type Tool struct {
-	Name        string      `json:"name"`
-	Description string      `json:"description"`
-	InputSchema interface{} `json:"input_schema"`
+	Name         string      `json:"name"`
+	Description  string      `json:"description"`
+	InputSchema  interface{} `json:"input_schema"`
+	CacheControl interface{} `json:"cache_control,omitempty"` // Prompt caching breakpoint (forwarded to OpenRouter only)
 }
 
 // OpenAIMessage represents a message in OpenAI format
@@ -44,6 +61,8 @@ type OpenAIMessage struct {
 	ToolCalls        []OpenAIToolCall `json:"tool_calls,omitempty"`
 	ToolCallID       string           `json:"tool_call_id,omitempty"`
 	ReasoningDetails []interface{}    `json:"reasoning_details,omitempty"` // OpenRouter reasoning
+	Reasoning        string           `json:"reasoning,omitempty"`         // Ollama (OpenAI-compatible endpoint) reasoning
+	Thinking         string           `json:"thinking,omitempty"`          // Ollama native API reasoning
 }
 
 // OpenAIToolCall represents a tool call in OpenAI format
@@ -64,6 +83,10 @@ type OpenAIRequest struct {
 	MaxCompletionTokens int                    `json:"max_completion_tokens,omitempty"`
 	Temperature         *float64               `json:"temperature,omitempty"`
 	TopP                *float64               `json:"top_p,omitempty"`
+	PresencePenalty     *float64               `json:"presence_penalty,omitempty"`
+	FrequencyPenalty    *float64               `json:"frequency_penalty,omitempty"`
+	Logprobs            *bool                  `json:"logprobs,omitempty"`
+	TopLogprobs         *int                   `json:"top_logprobs,omitempty"`
 	Stop                []string               `json:"stop,omitempty"`
 	Stream              *bool                  `json:"stream,omitempty"`
 	StreamOptions       map[string]interface{} `json:"stream_options,omitempty"`   // OpenAI standard
@@ -71,7 +94,10 @@ type OpenAIRequest struct {
 	Reasoning           map[string]interface{} `json:"reasoning,omitempty"`        // OpenRouter reasoning tokens
 	ReasoningEffort     string                 `json:"reasoning_effort,omitempty"` // OpenAI Chat Completions reasoning (GPT-5 models)
 	Tools               []OpenAITool           `json:"tools,omitempty"`
-	ToolChoice          interface{}            `json:"tool_choice,omitempty"` // Force tool usage: "auto", "required", or specific tool
+	ToolChoice          interface{}            `json:"tool_choice,omitempty"`      // Force tool usage: "auto", "required", or specific tool
+	PromptCacheKey      string                 `json:"prompt_cache_key,omitempty"` // OpenAI prompt caching hint
+	Metadata            map[string]interface{} `json:"metadata,omitempty"`         // OpenRouter request metadata (conversation_id)
+	KeepAlive           interface{}            `json:"keep_alive,omitempty"`       // Ollama: duration string or seconds
 }
 
 // OpenAITool represents a tool in OpenAI format
@@ -82,6 +108,7 @@ type OpenAITool struct {
 		Description string      `json:"description"`
 		Parameters  interface{} `json:"parameters"`
 	} `json:"function"`
+	CacheControl interface{} `json:"cache_control,omitempty"` // OpenRouter: caches the tools up to and including this one
 }
 
 // ClaudeResponse represents the Claude API response
@@ -104,12 +131,13 @@ type Usage struct {
 
 // OpenAIResponse represents the OpenAI API response
 type OpenAIResponse struct {
-	ID      string         `json:"id"`
-	Object  string         `json:"object"`
-	Created int64          `json:"created"`
-	Model   string         `json:"model"`
-	Choices []OpenAIChoice `json:"choices"`
-	Usage   OpenAIUsage    `json:"usage"`
+	ID          string         `json:"id"`
+	Object      string         `json:"object"`
+	Created     int64          `json:"created"`
+	Model       string         `json:"model"`
+	Choices     []OpenAIChoice `json:"choices"`
+	Usage       OpenAIUsage    `json:"usage"`
+	ServiceTier string         `json:"service_tier,omitempty"` // Tier that actually served the request (e.g. "default", "flex")
 }
 
 // OpenAIChoice represents a choice in the OpenAI response
@@ -124,4 +152,13 @@ type OpenAIUsage struct {
 	PromptTokens     int `json:"prompt_tokens"`
 	CompletionTokens int `json:"completion_tokens"`
 	TotalTokens      int `json:"total_tokens"`
+	// Cost is the request's price in USD, reported by OpenRouter
+	Cost *float64 `json:"cost,omitempty"`
+	// CompletionTokensDetails breaks down completion_tokens (reasoning models)
+	CompletionTokensDetails *CompletionTokensDetails `json:"completion_tokens_details,omitempty"`
+}
+
+// CompletionTokensDetails holds the reasoning share of the completion tokens
+type CompletionTokensDetails struct {
+	ReasoningTokens int `json:"reasoning_tokens"`
 }